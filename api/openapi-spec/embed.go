@@ -0,0 +1,9 @@
+// Package openapispec embeds the OpenAPI specification of this service, so it can be served and requests checked against it.
+package openapispec
+
+import (
+	_ "embed"
+)
+
+//go:embed openapi.yaml
+var Yaml []byte
@@ -0,0 +1,45 @@
+// Command fakepaygate serves a fake Nexi Paygate api, see package fakepaygate.
+//
+// Point the adapter's service.nexi_downstream at it, using the same merchant id and api key.
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	auzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
+	"github.com/eurofurence/reg-paygate-adapter/internal/fakepaygate"
+)
+
+func main() {
+	address := flag.String("address", ":9098", "address to listen on")
+	publicUrl := flag.String("public-url", "http://localhost:9098", "base url under which browsers reach this server, for the hosted payment page")
+	merchantId := flag.String("merchant-id", "mymerchant", "merchant id expected in Basic auth")
+	apiKey := flag.String("api-key", "mydemosecret", "api key expected in Basic auth")
+	webhook := flag.String("webhook", "", "if set, call this webhook instead of the one given when creating a session")
+	flag.Parse()
+
+	aulogging.DefaultRequestIdValue = "00000000"
+	auzerolog.SetupPlaintextLogging()
+
+	fake := fakepaygate.New(fakepaygate.Options{
+		PublicUrl:       *publicUrl,
+		MerchantId:      *merchantId,
+		ApiKey:          *apiKey,
+		WebhookOverride: *webhook,
+	})
+
+	server := &http.Server{
+		Addr:              *address,
+		Handler:           fake.Router(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	aulogging.Logger.NoCtx().Info().Printf("fakepaygate listening on %s, hosted payment page at %s/hpp/", *address, *publicUrl)
+	if err := server.ListenAndServe(); err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("fakepaygate stopped: %s", err.Error())
+		os.Exit(1)
+	}
+}
@@ -22,18 +22,52 @@ type HealthReportDto struct {
 	Status string `json:"status"`
 }
 
+// ReadinessReportDto tells whether this instance can serve requests, and why not
+type ReadinessReportDto struct {
+	// ready or not_ready. Not ready if any required component is down.
+	Status string `json:"status"`
+	// How this instance is configured.
+	Mode ReadinessModeDto `json:"mode"`
+	// The state of the database and the downstream services, by name.
+	Components map[string]ReadinessComponentDto `json:"components"`
+}
+
+// ReadinessModeDto describes the configuration mode
+type ReadinessModeDto struct {
+	// live if Nexi is called, simulator if the local paylink simulator is used.
+	Nexi string `json:"nexi"`
+	// Whether requests to Nexi are flagged as simulation.
+	SimulationMode bool `json:"simulation_mode"`
+	// The database in use: mysql, postgres, sqlite, inmemory.
+	Database string `json:"database"`
+}
+
+// ReadinessComponentDto is the state of one dependency
+type ReadinessComponentDto struct {
+	// up, down, or mock if an in-memory simulator is used instead.
+	Status string `json:"status"`
+	// Whether this instance is not ready while the component is down.
+	Required bool `json:"required"`
+	// Why the component is considered down, or other details.
+	Message string `json:"message,omitempty"`
+}
+
 // PaymentLinkRequestDto struct for addPaymentLink request
 type PaymentLinkRequestDto struct {
 	// The reference ID for this payment, as generated by the Payment service
 	ReferenceId string `json:"reference_id"`
 	// The badge number of the attendee. Will be used to build appropriate description, referenceId, etc.
 	DebitorId uint64 `json:"debitor_id"`
-	// The page title to be shown on the payment page.
-	AmountDue int32 `json:"amount_due"`
-	// Only used in responses. The total amount paid. TODO - is this Cents or Euros?
+	// The amount to bill for in the smallest denomination.
+	AmountDue int64 `json:"amount_due"`
+	// The currency to use, 3-letter code
 	Currency string `json:"currency"`
 	// The applicable VAT, in percent.
 	VatRate float64 `json:"vat_rate"`
+	// Optional. Where to send the attendee after a successful payment, must match the configured redirect allowlist.
+	SuccessUrl string `json:"success_url,omitempty"`
+	// Optional. Where to send the attendee after a cancelled or failed payment, must match the configured redirect allowlist.
+	CancelUrl string `json:"cancel_url,omitempty"`
 }
 
 // PaymentLinkDto struct for addPaymentLink response
@@ -58,6 +92,46 @@ type PaymentLinkDto struct {
 	Link string `json:"link"`
 }
 
+// PaymentLinkRecordDto is our own record of a payment link, for listing them
+type PaymentLinkRecordDto struct {
+	// Internal reference number for this payment process.
+	ReferenceId string `json:"reference_id"`
+	// The badge number of the attendee.
+	DebitorId uint64 `json:"debitor_id"`
+	// The amount to bill for in the smallest denomination.
+	AmountDue int64 `json:"amount_due"`
+	// The currency to use, 3-letter code
+	Currency string `json:"currency"`
+	// The applicable VAT, in percent.
+	VatRate float64 `json:"vat_rate"`
+	// The payment link.
+	Link string `json:"link"`
+	// The time at which the payment link was created, RFC3339.
+	CreatedAt string `json:"created_at"`
+	// Paygate payment id, once known.
+	PaymentId string `json:"payment_id,omitempty"`
+	// Last known status from Paygate, from the webhook or the latest query. Empty until we have heard of the payment.
+	Status string `json:"status,omitempty"`
+	// The time at which status was last updated, RFC3339.
+	StatusUpdatedAt string `json:"status_updated_at,omitempty"`
+	// Subject of the bearer token that created the payment link, if it supplied one.
+	Subject string `json:"subject,omitempty"`
+	// Name of the api client that created the payment link, if it supplied an api key.
+	Client string `json:"client,omitempty"`
+}
+
+// PaymentLinkListDto is a page of payment link records
+type PaymentLinkListDto struct {
+	// The payment links on this page, in ascending order of creation.
+	Paylinks []PaymentLinkRecordDto `json:"paylinks"`
+	// The total number of payment links matching the query, across all pages.
+	Total int64 `json:"total"`
+	// The page number, starting at 1.
+	Page int `json:"page"`
+	// The maximum number of payment links per page.
+	PageSize int `json:"page_size"`
+}
+
 // PaymentDto struct for getPaymentByRefId response
 type PaymentDto struct {
 	// Paygate payment id
@@ -77,3 +151,33 @@ type PaymentDto struct {
 	// PaymentMethod as received from Paygate, CARD, GOOGLEPAY, APPLEPAY, ...
 	PaymentMethod string `json:"payment_method"`
 }
+
+// CaptureRequestDto struct for capturePaymentByRefId request, the body is optional
+type CaptureRequestDto struct {
+	// The amount to capture in the smallest denomination. Captures everything that was authorized and not captured yet if missing.
+	Amount *int64 `json:"amount,omitempty"`
+}
+
+// RefundRequestDto struct for refundPaymentByRefId request, the body is optional
+type RefundRequestDto struct {
+	// The amount to refund in the smallest denomination. Refunds everything that was captured and not refunded yet if missing.
+	Amount *int64 `json:"amount,omitempty"`
+}
+
+// PaymentEventDto is sent on the payment event stream whenever a webhook or status check has updated the transaction for the payment
+type PaymentEventDto struct {
+	// Id of the event, sent as the id of the server-sent event rather than in the data. 0 if it cannot be resumed from.
+	EventId uint `json:"-"`
+	// Internal reference number for this payment process.
+	ReferenceId string `json:"reference_id"`
+	// Paygate payment id, if known.
+	PaymentId string `json:"payment_id,omitempty"`
+	// Status as verified at Paygate. OK, AUTHORIZED, FAILED, ...
+	Status string `json:"status"`
+	// ResponseCode as received from Paygate, if known. 00000000 for success.
+	ResponseCode string `json:"response_code,omitempty"`
+	// What told us about the status: webhook, status-check, or record for the last known status sent when the stream is opened.
+	Source string `json:"source"`
+	// The time at which the status was processed, RFC3339.
+	Timestamp string `json:"timestamp"`
+}
@@ -0,0 +1,97 @@
+package nexiapi
+
+// ProtocolEntryDto is a single entry of the payment protocol
+type ProtocolEntryDto struct {
+	// Database id of the entry, ascending in order of creation.
+	Id uint `json:"id"`
+	// The time at which the entry was written, RFC3339.
+	CreatedAt string `json:"created_at"`
+	// Internal reference number of the payment process, empty for raw webhook requests.
+	ReferenceId string `json:"reference_id"`
+	// Paygate payment id, if known.
+	ApiId string `json:"api_id,omitempty"`
+	// Kind of entry: raw, success, pending, warning, error.
+	Kind string `json:"kind"`
+	// Short description of what happened.
+	Message string `json:"message"`
+	// Additional information, usually key=value pairs or a json message.
+	Details string `json:"details,omitempty"`
+	// Request id of the request that caused this entry.
+	RequestId string `json:"request_id,omitempty"`
+	// Subject of the bearer token of the request that caused this entry, if it supplied one.
+	Subject string `json:"subject,omitempty"`
+	// Name of the api client whose request caused this entry, if it supplied an api key.
+	Client string `json:"client,omitempty"`
+	// Payment amount in the smallest currency unit, if the entry concerns a payment.
+	Amount *int64 `json:"amount,omitempty"`
+	// ISO currency code of the amount.
+	Currency string `json:"currency,omitempty"`
+	// Payment status as reported by the Nexi API.
+	UpstreamStatus string `json:"upstream_status,omitempty"`
+	// Payment status as reported by the webhook.
+	WebhookStatus string `json:"webhook_status,omitempty"`
+	// Transaction status in the payment service.
+	TransactionStatus string `json:"transaction_status,omitempty"`
+	// Payment method as reported by Nexi, e.g. CARD.
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// The operation on the payment this entry records: create, capture, refund or cancel.
+	Operation string `json:"operation,omitempty"`
+}
+
+// ProtocolEntryListDto is one page of protocol entries
+type ProtocolEntryListDto struct {
+	// The protocol entries on this page, in ascending order of creation.
+	Entries []ProtocolEntryDto `json:"entries"`
+	// The total number of entries matching the query, across all pages.
+	Total int64 `json:"total"`
+	// The page number, starting at 1.
+	Page int `json:"page"`
+	// The maximum number of entries per page.
+	PageSize int `json:"page_size"`
+}
+
+// ProtocolExportEntryDto is a single line of a protocol export in JSON Lines format
+type ProtocolExportEntryDto struct {
+	ProtocolEntryDto
+	// Details split into their values, if they are in key=value format.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// PaymentStatsDto aggregates the payment protocol over a range of days
+type PaymentStatsDto struct {
+	// The first day included, in format YYYY-MM-DD.
+	From string `json:"from"`
+	// The last day included, in format YYYY-MM-DD.
+	To string `json:"to"`
+	// The time zone that determines where days begin and end.
+	Timezone string `json:"timezone"`
+	// One group per day, payment method and currency that has any activity, sorted in that order.
+	Groups []PaymentStatsGroupDto `json:"groups"`
+}
+
+// PaymentStatsGroupDto holds the statistics for one day, payment method and currency
+type PaymentStatsGroupDto struct {
+	// The day, in format YYYY-MM-DD.
+	Day string `json:"day"`
+	// The payment method as reported by Nexi, empty if unknown. Always empty for created paylinks.
+	PaymentMethod string `json:"payment_method"`
+	// The currency, 3-letter code.
+	Currency string `json:"currency"`
+	// Paylinks created.
+	Created PaymentStatsValueDto `json:"created"`
+	// Payments confirmed, that is transactions set to valid.
+	Confirmed PaymentStatsValueDto `json:"confirmed"`
+	// Payments reported by Nexi with a status other than success or refund, and payments cancelled through this adapter.
+	Declined PaymentStatsValueDto `json:"declined"`
+	// Refunds made through this adapter or reported by Nexi.
+	Refunded PaymentStatsValueDto `json:"refunded"`
+	// Payments left in pending state for manual review.
+	Pending PaymentStatsValueDto `json:"pending"`
+}
+
+// PaymentStatsValueDto is a number of events and their total amount
+type PaymentStatsValueDto struct {
+	Count int64 `json:"count"`
+	// The sum of the amounts, in the smallest denomination.
+	Volume int64 `json:"volume"`
+}
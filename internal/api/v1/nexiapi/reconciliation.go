@@ -0,0 +1,57 @@
+package nexiapi
+
+// ReconciliationReportDto is the result of reconciling a Nexi settlement file
+type ReconciliationReportDto struct {
+	// Counts and totals over all lines of the settlement file.
+	Summary ReconciliationSummaryDto `json:"summary"`
+	// The lines that need attention, that is all lines that are unmatched, mismatched or fee only, in file order.
+	Lines []ReconciliationLineDto `json:"lines"`
+}
+
+// ReconciliationSummaryDto summarizes a reconciliation
+type ReconciliationSummaryDto struct {
+	// The number of transaction lines in the settlement file.
+	Total int `json:"total"`
+	// Lines that match our bookings.
+	Matched int `json:"matched"`
+	// Lines for which no booking could be found.
+	Unmatched int `json:"unmatched"`
+	// Lines that differ from our booking.
+	Mismatched int `json:"mismatched"`
+	// Lines that only contain fees.
+	FeeOnly int `json:"fee_only"`
+	// The settled amounts per currency in the smallest denomination, refunds and chargebacks subtracted.
+	Amounts map[string]int64 `json:"amounts"`
+	// The fees per currency in the smallest denomination.
+	Fees map[string]int64 `json:"fees"`
+}
+
+// ReconciliationLineDto is one line of a settlement file and the result of matching it to our bookings
+type ReconciliationLineDto struct {
+	// Line number in the settlement file, starting at 1 for the header.
+	Line int `json:"line"`
+	// Internal reference number of the payment process (aka transId).
+	ReferenceId string `json:"reference_id"`
+	// Paygate payment id, if listed.
+	PayId string `json:"pay_id,omitempty"`
+	// Kind of settlement line: capture, refund, chargeback, fee, or the unrecognized value from the file.
+	Type string `json:"type"`
+	// The settled amount in the smallest denomination, as listed.
+	Amount int64 `json:"amount"`
+	// The currency of amount and fee, 3-letter code.
+	Currency string `json:"currency"`
+	// The fee in the smallest denomination, as listed.
+	Fee int64 `json:"fee"`
+	// The settlement date, as listed.
+	Date string `json:"date,omitempty"`
+	// One of matched, unmatched, mismatched, fee_only.
+	Result string `json:"result"`
+	// Human readable reasons for the result.
+	Problems []string `json:"problems,omitempty"`
+	// Status of the booking in the payment service, if found.
+	BookingStatus string `json:"booking_status,omitempty"`
+	// Amount of the booking in the payment service in the smallest denomination, if found.
+	BookingAmount *int64 `json:"booking_amount,omitempty"`
+	// Currency of the booking in the payment service, if found.
+	BookingCurrency string `json:"booking_currency,omitempty"`
+}
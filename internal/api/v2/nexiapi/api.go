@@ -0,0 +1,104 @@
+package nexiapi
+
+// PaylinkDto merges our record of a payment link with the payment state at Paygate
+type PaylinkDto struct {
+	// Internal reference number for this payment process.
+	ReferenceId string `json:"reference_id"`
+	// The badge number of the attendee.
+	DebitorId uint64 `json:"debitor_id"`
+	// Last known status from Paygate, OK, AUTHORIZED, FAILED, ... Empty until we have heard of the payment.
+	Status string `json:"status,omitempty"`
+	// ResponseCode as received from Paygate, 00000000 for success. Only known once Paygate has been queried.
+	ResponseCode string `json:"response_code,omitempty"`
+	// Paygate payment id, once known.
+	PaymentId string `json:"payment_id,omitempty"`
+	// PaymentMethod as received from Paygate, CARD, GOOGLEPAY, APPLEPAY, ... Only known once Paygate has been queried.
+	PaymentMethod string `json:"payment_method,omitempty"`
+	// The amounts, all in the smallest denomination.
+	Amount PaylinkAmountDto `json:"amount"`
+	// What the attendee pays for.
+	LineItems []PaylinkLineItemDto `json:"line_items"`
+	// The amounts by VAT rate.
+	VatBreakdown []PaylinkVatDto `json:"vat_breakdown"`
+	// The time at which the payment link was created, RFC3339. Empty for payment links created before we kept records.
+	CreatedAt string `json:"created_at,omitempty"`
+	// The time after which the payment link can no longer be used, RFC3339, if Paygate reports one.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// The status changes recorded in the protocol, oldest first. Not included in lists.
+	StatusHistory []PaylinkStatusChangeDto `json:"status_history,omitempty"`
+	// Related resources and the actions available for this payment link, by relation name.
+	Links map[string]LinkDto `json:"_links"`
+}
+
+// PaylinkAmountDto holds the amounts of a payment link, in the smallest denomination
+type PaylinkAmountDto struct {
+	// The currency, 3-letter code.
+	Currency string `json:"currency"`
+	// The amount to bill for, including VAT.
+	Due int64 `json:"due"`
+	// The amount captured so far. Only known once Paygate has been queried.
+	Captured int64 `json:"captured"`
+	// The amount refunded so far. Only known once Paygate has been queried.
+	Refunded int64 `json:"refunded"`
+}
+
+// PaylinkLineItemDto is a single item the attendee pays for
+type PaylinkLineItemDto struct {
+	// The name of the item, as shown on the payment page.
+	Name string `json:"name"`
+	// How many of the item are paid for.
+	Quantity int64 `json:"quantity"`
+	// The price including VAT, for all of them.
+	GrossAmount int64 `json:"gross_amount"`
+	// The price without VAT, for all of them.
+	NetAmount int64 `json:"net_amount"`
+	// The VAT included in the price.
+	VatAmount int64 `json:"vat_amount"`
+	// The applicable VAT, in percent.
+	VatRate float64 `json:"vat_rate"`
+}
+
+// PaylinkVatDto sums up the line items with the same VAT rate
+type PaylinkVatDto struct {
+	// The VAT rate, in percent.
+	VatRate float64 `json:"vat_rate"`
+	// The sum of the prices without VAT.
+	NetAmount int64 `json:"net_amount"`
+	// The sum of the VAT.
+	VatAmount int64 `json:"vat_amount"`
+	// The sum of the prices including VAT.
+	GrossAmount int64 `json:"gross_amount"`
+}
+
+// PaylinkStatusChangeDto is a change in the Paygate status of a payment
+type PaylinkStatusChangeDto struct {
+	// The Paygate status from this time on.
+	Status string `json:"status"`
+	// What told us about the status: webhook, or query for status checks and lookups.
+	Source string `json:"source"`
+	// The time at which we learned about the status, RFC3339.
+	Timestamp string `json:"timestamp"`
+}
+
+// LinkDto points to a related resource or an action
+type LinkDto struct {
+	// The url, absolute path or absolute url for links to other sites.
+	Href string `json:"href"`
+	// The http method to use.
+	Method string `json:"method"`
+}
+
+// PaylinkListDto is a page of payment links
+type PaylinkListDto struct {
+	// The payment links on this page, in ascending order of creation. Their state is as last recorded,
+	// Paygate is not queried for lists.
+	Paylinks []PaylinkDto `json:"paylinks"`
+	// The total number of payment links matching the query, across all pages.
+	Total int64 `json:"total"`
+	// The page number, starting at 1.
+	Page int `json:"page"`
+	// The maximum number of payment links per page.
+	PageSize int `json:"page_size"`
+	// Links to this page and its neighbours.
+	Links map[string]LinkDto `json:"_links"`
+}
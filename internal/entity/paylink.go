@@ -0,0 +1,25 @@
+package entity
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Paylink is our own record of a payment link created at Paygate, so they can be listed without
+// knowing their reference ids. Paylinks created before these records were kept are recovered from the
+// protocol by migration 0011, as far as it still has them with their amount.
+type Paylink struct {
+	gorm.Model
+	ReferenceId string     `gorm:"size:80;NOT NULL;uniqueIndex:nexi_paylink_ref_id_idx"`
+	DebitorId   uint64     `gorm:"NOT NULL;index:nexi_paylink_debitor_id_idx"`
+	Amount      int64      `gorm:"NOT NULL"` // amount due, in the smallest currency unit
+	Currency    string     `gorm:"size:3;NOT NULL"`
+	VatRate     float64    `gorm:"NOT NULL"`
+	Link        string     `gorm:"size:2048"` // the hosted payment page
+	ApiId       string     `gorm:"size:80"`   // Paygate payment id, once we have heard of the payment
+	Status      string     `gorm:"size:32"`   // last known Paygate status, empty until we have heard of the payment
+	StatusAt    *time.Time // when Status was last updated
+	Subject     string     `gorm:"size:255"` // optional, the subject of the bearer token that created the paylink
+	Client      string     `gorm:"size:64"`  // optional, the name of the api client that created the paylink
+}
@@ -1,17 +1,60 @@
 package entity
 
 import (
+	"slices"
+
 	"gorm.io/gorm"
 )
 
-// configured sizes are for mysql, since version 5 mysql counts characters, not bytes
+// configured sizes are in characters, which is what both mysql (since version 5) and postgres count.
+// The schema itself is defined by the sql migrations in database/gormdb/migrations, keep them in sync.
 
 type ProtocolEntry struct {
 	gorm.Model
-	ReferenceId string `gorm:"type:varchar(80) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;NOT NULL;index:nexi_ref_id_idx"`
+	ReferenceId string `gorm:"size:80;NOT NULL;index:nexi_ref_id_idx"`
 	ApiId       string
-	Kind        string `gorm:"type:varchar(8) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci;NOT NULL"`
-	Message     string `gorm:"type:varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Details     string `gorm:"type:longtext CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`   // usually: json message
-	RequestId   string `gorm:"type:varchar(8) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"` // optional
+	Kind        ProtocolKind `gorm:"size:8;NOT NULL"`
+	Message     string       `gorm:"size:255"`
+	Details     string       // usually: json message, unlimited size (longtext in mysql, text in postgres)
+	RequestId   string       `gorm:"size:8"`                 // optional
+	Subject     string       `gorm:"size:255"`               // optional, the subject of the bearer token of the request that caused this entry
+	Client      string       `gorm:"size:64"`                // optional, the name of the api client whose request caused this entry
+	Anonymized  bool         `gorm:"NOT NULL;default:false"` // personal data removed from Details
+
+	// structured payment data, all optional. For entries written by older versions, migrations 0009, 0010 and 0012
+	// fill in Amount, Currency, Operation and the statuses the payment statistics need, everything else remains in Details only.
+
+	Amount            *int64 // in the smallest currency unit
+	Currency          string `gorm:"size:3"`
+	UpstreamStatus    string `gorm:"size:32"` // payment status as reported by the Nexi API
+	WebhookStatus     string `gorm:"size:32"` // payment status as reported by the webhook
+	TransactionStatus string `gorm:"size:32"` // transaction status in the payment service
+	PaymentMethod     string `gorm:"size:32"` // e.g. CARD, as reported by Nexi
+	Operation         string `gorm:"size:16"` // the operation on the payment this entry records, see the Operation constants
+}
+
+// operations on payments that we perform at Paygate. Entries about webhooks and status checks have no operation.
+const (
+	OperationCreate  = "create"  // a paylink was created
+	OperationCapture = "capture" // an authorized payment was captured
+	OperationRefund  = "refund"  // a captured payment was refunded
+	OperationCancel  = "cancel"  // an authorized payment was cancelled
+)
+
+// ProtocolKind classifies protocol entries.
+type ProtocolKind string
+
+const (
+	KindRaw     ProtocolKind = "raw"     // unprocessed incoming request
+	KindSuccess ProtocolKind = "success" // an operation completed normally
+	KindPending ProtocolKind = "pending" // a payment is not yet complete
+	KindWarning ProtocolKind = "warning" // something unusual that did not stop processing
+	KindError   ProtocolKind = "error"   // an operation failed
+)
+
+// ProtocolKinds lists all valid protocol kinds. Migration 0008 checks them in the database, keep it in sync.
+var ProtocolKinds = []ProtocolKind{KindRaw, KindSuccess, KindPending, KindWarning, KindError}
+
+func (k ProtocolKind) Valid() bool {
+	return slices.Contains(ProtocolKinds, k)
 }
@@ -0,0 +1,360 @@
+// Package fakepaygate implements a stand-in for the Nexi Paygate REST api, for integration tests and local setups.
+//
+// Unlike the simulator in simulatorctl, it runs as a separate server, so the adapter talks to it through its
+// real http client. Payments are only kept in memory. Attendees pay on a hosted payment page, after which the
+// webhook given when creating the session is called, just like Paygate does.
+package fakepaygate
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	StatusOk         = "OK"
+	StatusAuthorized = "AUTHORIZED"
+	StatusFailed     = "FAILED"
+	StatusCancelled  = "CANCELLED"
+
+	responseCodeSuccess  = "00000000"
+	responseCodeDeclined = "99000001" // not a real Paygate code, easy to spot in logs
+)
+
+type Options struct {
+	// PublicUrl is where the adapter and the browser reach this server, used for the hosted payment page links.
+	PublicUrl string
+	// MerchantId and ApiKey are the Basic auth credentials the api expects.
+	MerchantId string
+	ApiKey     string
+	// WebhookOverride, if set, is called instead of the webhook given when creating a session.
+	WebhookOverride string
+}
+
+type FakePaygate struct {
+	options    Options
+	now        func() time.Time
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	sequence  uint64
+	payments  map[string]*payment // by payment id
+	byTransId map[string]*payment
+}
+
+type payment struct {
+	payId     string
+	session   nexi.NexiCreateCheckoutSessionRequest
+	createdAt time.Time
+
+	// all empty or zero until the attendee has used the hosted payment page
+	status       string
+	responseCode string
+	method       string
+	captured     int64
+	refunded     int64
+}
+
+type amountRequest struct {
+	Amount nexi.NexiAmount `json:"amount"`
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func New(options Options) *FakePaygate {
+	return &FakePaygate{
+		options:    options,
+		now:        time.Now,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		payments:   make(map[string]*payment),
+		byTransId:  make(map[string]*payment),
+	}
+}
+
+func (f *FakePaygate) Router() http.Handler {
+	server := chi.NewRouter()
+	server.Route("/payments", func(api chi.Router) {
+		api.Use(f.basicAuth)
+		api.Post("/sessions", f.createSessionHandler)
+		api.Get("/", f.queryPaymentsHandler)
+		api.Get("/getByTransId/{transId}", f.getByTransIdHandler)
+		api.Post("/{payId}/captures", f.captureHandler)
+		api.Post("/{payId}/refunds", f.refundHandler)
+		api.Post("/{payId}/reversals", f.cancelHandler)
+	})
+	server.Get("/hpp/{payId}", f.paymentPageHandler)
+	server.Post("/hpp/{payId}", f.paymentPageSubmitHandler)
+	return server
+}
+
+func (f *FakePaygate) basicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merchantId, apiKey, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(merchantId), []byte(f.options.MerchantId)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(apiKey), []byte(f.options.ApiKey)) != 1 {
+			aulogging.Logger.Ctx(r.Context()).Warn().Printf("fakepaygate rejected %s %s with missing or wrong credentials", r.Method, r.URL.Path)
+			w.Header().Set(headers.WWWAuthenticate, `Basic realm="fakepaygate"`)
+			writeJson(w, http.StatusUnauthorized, errorResponse{Message: "missing or wrong credentials"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *FakePaygate) createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	request := nexi.NexiCreateCheckoutSessionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJson(w, http.StatusBadRequest, errorResponse{Message: "invalid json: " + err.Error()})
+		return
+	}
+	if request.TransId == "" || request.Amount.Value <= 0 || len(request.Amount.Currency) != 3 {
+		writeJson(w, http.StatusBadRequest, errorResponse{Message: "transId, amount.value and amount.currency are required"})
+		return
+	}
+
+	f.mu.Lock()
+	f.sequence++
+	p := &payment{
+		payId:     fmt.Sprintf("%032x", f.sequence),
+		session:   request,
+		createdAt: f.now(),
+	}
+	f.payments[p.payId] = p
+	// a new session for the same transId replaces the old one, as far as getByTransId is concerned
+	f.byTransId[request.TransId] = p
+	f.mu.Unlock()
+
+	aulogging.Logger.Ctx(r.Context()).Info().Printf("fakepaygate created session payId=%s transId=%s amount=%d %s", p.payId, request.TransId, request.Amount.Value, request.Amount.Currency)
+	writeJson(w, http.StatusCreated, nexi.NexiCreateCheckoutSessionResponse{
+		Links: nexi.NexiCheckoutSessionResponseLinks{
+			Redirect: &nexi.RedirectLink{
+				Href: f.options.PublicUrl + "/hpp/" + p.payId,
+				Type: http.MethodGet,
+			},
+		},
+	})
+}
+
+func (f *FakePaygate) getByTransIdHandler(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.byTransId[chi.URLParam(r, "transId")]
+	if !ok || p.status == "" {
+		// like Paygate, only payments are found, not sessions that have not been used yet
+		writeJson(w, http.StatusNotFound, errorResponse{Message: "payment not found"})
+		return
+	}
+	writeJson(w, http.StatusOK, p.queryResponse())
+}
+
+// queryPaymentsHandler lists the payments made in a time range, given as RFC3339 in createdAfter (inclusive)
+// and createdBefore (exclusive).
+func (f *FakePaygate) queryPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	after, before := time.Time{}, time.Time{}
+	var err error
+	if value := r.URL.Query().Get("createdAfter"); value != "" {
+		if after, err = time.Parse(time.RFC3339, value); err != nil {
+			writeJson(w, http.StatusBadRequest, errorResponse{Message: "createdAfter must be RFC3339"})
+			return
+		}
+	}
+	if value := r.URL.Query().Get("createdBefore"); value != "" {
+		if before, err = time.Parse(time.RFC3339, value); err != nil {
+			writeJson(w, http.StatusBadRequest, errorResponse{Message: "createdBefore must be RFC3339"})
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := make([]nexi.NexiPaymentQueryResponse, 0)
+	for sequence := uint64(1); sequence <= f.sequence; sequence++ {
+		p := f.payments[fmt.Sprintf("%032x", sequence)]
+		if p.status == "" || p.createdAt.Before(after) || (!before.IsZero() && !p.createdAt.Before(before)) {
+			continue
+		}
+		result = append(result, p.queryResponse())
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+func (f *FakePaygate) captureHandler(w http.ResponseWriter, r *http.Request) {
+	f.changePayment(w, r, true, func(p *payment, amount int64) string {
+		if p.status != StatusAuthorized {
+			return "only authorized payments can be captured"
+		}
+		if amount > p.session.Amount.Value-p.captured {
+			return "cannot capture more than was authorized"
+		}
+		p.captured += amount
+		if p.captured == p.session.Amount.Value {
+			p.status = StatusOk
+		}
+		return ""
+	})
+}
+
+func (f *FakePaygate) refundHandler(w http.ResponseWriter, r *http.Request) {
+	f.changePayment(w, r, true, func(p *payment, amount int64) string {
+		if amount > p.captured-p.refunded {
+			return "cannot refund more than was captured"
+		}
+		p.refunded += amount
+		return ""
+	})
+}
+
+func (f *FakePaygate) cancelHandler(w http.ResponseWriter, r *http.Request) {
+	f.changePayment(w, r, false, func(p *payment, _ int64) string {
+		if p.status != StatusAuthorized || p.captured > 0 {
+			return "only authorized payments that have not been captured can be cancelled"
+		}
+		p.status = StatusCancelled
+		return ""
+	})
+}
+
+// changePayment applies change to the payment, which returns why the change is not possible, if it is not.
+//
+// The webhook is called if the status has changed.
+func (f *FakePaygate) changePayment(w http.ResponseWriter, r *http.Request, needsAmount bool, change func(p *payment, amount int64) string) {
+	amount := int64(0)
+	if needsAmount {
+		request := amountRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Amount.Value <= 0 {
+			writeJson(w, http.StatusBadRequest, errorResponse{Message: "amount.value must be a positive integer"})
+			return
+		}
+		amount = request.Amount.Value
+	}
+
+	f.mu.Lock()
+	p, ok := f.payments[chi.URLParam(r, "payId")]
+	if !ok || p.status == "" {
+		f.mu.Unlock()
+		writeJson(w, http.StatusNotFound, errorResponse{Message: "payment not found"})
+		return
+	}
+	statusBefore := p.status
+	if reason := change(p, amount); reason != "" {
+		f.mu.Unlock()
+		writeJson(w, http.StatusConflict, errorResponse{Message: reason})
+		return
+	}
+	response := p.queryResponse()
+	webhookUrl := f.webhookUrl(p)
+	f.mu.Unlock()
+
+	aulogging.Logger.Ctx(r.Context()).Info().Printf("fakepaygate %s payId=%s amount=%d status=%s", r.URL.Path, response.PayId, amount, response.Status)
+	if response.Status != statusBefore {
+		f.callWebhook(r.Context(), webhookUrl, response)
+	}
+	writeJson(w, http.StatusOK, response)
+}
+
+func (f *FakePaygate) webhookUrl(p *payment) string {
+	if f.options.WebhookOverride != "" {
+		return f.options.WebhookOverride
+	}
+	return p.session.Urls.Webhook
+}
+
+// callWebhook notifies the adapter, failures are only logged, like Paygate we do not retry.
+//
+// Must be called without holding the lock, because the adapter queries the payment before it answers.
+func (f *FakePaygate) callWebhook(ctx context.Context, webhookUrl string, payment nexi.NexiPaymentQueryResponse) {
+	if webhookUrl == "" {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("fakepaygate has no webhook to call for payId=%s", payment.PayId)
+		return
+	}
+
+	event := nexiapi.WebhookDto{
+		PayId:               payment.PayId,
+		TransId:             payment.TransId,
+		Status:              payment.Status,
+		ResponseCode:        payment.ResponseCode,
+		ResponseDescription: payment.ResponseDescription,
+		Amount: nexiapi.WebhookAmount{
+			Value:    payment.Amount.Value,
+			Currency: payment.Amount.Currency,
+		},
+		PaymentMethods: nexiapi.WebhookPaymentMethod{
+			Type: payment.PaymentMethods.Type,
+		},
+		CreationDate: f.now().UTC().Format(time.RFC3339),
+	}
+	body, _ := json.Marshal(event)
+
+	request, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("fakepaygate cannot call webhook for payId=%s: %s", payment.PayId, err.Error())
+		return
+	}
+	request.Header.Set(headers.ContentType, media.ContentTypeApplicationJson)
+	response, err := f.httpClient.Do(request)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("fakepaygate failed to call webhook for payId=%s: %s", payment.PayId, err.Error())
+		return
+	}
+	_ = response.Body.Close()
+	aulogging.Logger.Ctx(ctx).Info().Printf("fakepaygate called webhook for payId=%s status=%s, got status %d", payment.PayId, payment.Status, response.StatusCode)
+}
+
+func (p *payment) queryResponse() nexi.NexiPaymentQueryResponse {
+	description := "Transaction successful"
+	if p.responseCode != responseCodeSuccess {
+		description = "Declined by fakepaygate"
+	}
+	// copies, the response may be used after the lock on the fake has been released
+	captured, refunded := p.captured, p.refunded
+	return nexi.NexiPaymentQueryResponse{
+		PayId:               p.payId,
+		TransId:             p.session.TransId,
+		Status:              p.status,
+		ResponseCode:        p.responseCode,
+		ResponseDescription: description,
+		Amount: &nexi.NexiAmountResponse{
+			Value:         p.session.Amount.Value,
+			Currency:      p.session.Amount.Currency,
+			TaxTotal:      p.session.Amount.TaxTotal,
+			NetItemTotal:  p.session.Amount.NetItemTotal,
+			CapturedValue: &captured,
+			RefundedValue: &refunded,
+		},
+		Language:       p.session.Language,
+		CaptureMethod:  p.session.CaptureMethod,
+		Order:          p.session.Order,
+		SimulationMode: p.session.SimulationMode,
+		URLs: &nexi.NexiPaymentUrlsResponse{
+			Return:  p.session.Urls.Return,
+			Cancel:  p.session.Urls.Cancel,
+			Webhook: p.session.Urls.Webhook,
+		},
+		StatementDescriptor: p.session.StatementDescriptor,
+		ExpirationTime:      p.session.ExpirationTime,
+		PaymentMethods:      &nexi.NexiPaymentMethodsResponse{Type: p.method},
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
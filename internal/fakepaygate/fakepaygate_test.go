@@ -0,0 +1,158 @@
+package fakepaygate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/stretchr/testify/require"
+)
+
+type tstWebhookRecorder struct {
+	mu     sync.Mutex
+	events []nexiapi.WebhookDto
+}
+
+func (rec *tstWebhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := nexiapi.WebhookDto{}
+	_ = json.NewDecoder(r.Body).Decode(&event)
+	rec.mu.Lock()
+	rec.events = append(rec.events, event)
+	rec.mu.Unlock()
+}
+
+func (rec *tstWebhookRecorder) statuses() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	result := make([]string, 0)
+	for _, event := range rec.events {
+		result = append(result, event.Status)
+	}
+	return result
+}
+
+func tstSetup(t *testing.T) (*httptest.Server, *tstWebhookRecorder) {
+	aulogging.SetupNoLoggerForTesting()
+	recorder := &tstWebhookRecorder{}
+	webhook := httptest.NewServer(recorder)
+	t.Cleanup(webhook.Close)
+
+	fake := httptest.NewServer(nil)
+	t.Cleanup(fake.Close)
+	fake.Config.Handler = New(Options{
+		PublicUrl:       fake.URL,
+		MerchantId:      "mymerchant",
+		ApiKey:          "mydemosecret",
+		WebhookOverride: webhook.URL,
+	}).Router()
+	return fake, recorder
+}
+
+func tstRequest(t *testing.T, method string, target string, body any, response any) int {
+	var reader *strings.Reader
+	if body != nil {
+		rendered, err := json.Marshal(body)
+		require.Nil(t, err)
+		reader = strings.NewReader(string(rendered))
+	} else {
+		reader = strings.NewReader("")
+	}
+	request, err := http.NewRequest(method, target, reader)
+	require.Nil(t, err)
+	request.SetBasicAuth("mymerchant", "mydemosecret")
+	resp, err := http.DefaultClient.Do(request)
+	require.Nil(t, err)
+	defer resp.Body.Close()
+	if response != nil {
+		_ = json.NewDecoder(resp.Body).Decode(response)
+	}
+	return resp.StatusCode
+}
+
+func tstCreateAndPay(t *testing.T, fake *httptest.Server, captureMethod string) string {
+	session := nexi.NexiCreateCheckoutSessionRequest{
+		TransId: "EF1995-000001-221216-122218-4132",
+		Amount:  nexi.NexiAmount{Value: 390, Currency: "EUR"},
+	}
+	if captureMethod != "" {
+		session.CaptureMethod = &nexi.NexiCaptureMethod{Type: captureMethod}
+	}
+	created := nexi.NexiCreateCheckoutSessionResponse{}
+	require.Equal(t, http.StatusCreated, tstRequest(t, http.MethodPost, fake.URL+"/payments/sessions", session, &created))
+	require.NotNil(t, created.Links.Redirect)
+
+	resp, err := http.PostForm(created.Links.Redirect.Href, url.Values{"action": {ActionPay}})
+	require.Nil(t, err)
+	_ = resp.Body.Close()
+	return strings.TrimPrefix(created.Links.Redirect.Href, fake.URL+"/hpp/")
+}
+
+func TestBasicAuth(t *testing.T) {
+	fake, _ := tstSetup(t)
+
+	resp, err := http.Get(fake.URL + "/payments/getByTransId/EF1995-000001-221216-122218-4132")
+	require.Nil(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, `Basic realm="fakepaygate"`, resp.Header.Get("WWW-Authenticate"))
+}
+
+func TestPayAutomaticCapture(t *testing.T) {
+	fake, recorder := tstSetup(t)
+
+	require.Equal(t, http.StatusNotFound, tstRequest(t, http.MethodGet, fake.URL+"/payments/getByTransId/EF1995-000001-221216-122218-4132", nil, nil))
+	tstCreateAndPay(t, fake, "")
+
+	payment := nexi.NexiPaymentQueryResponse{}
+	require.Equal(t, http.StatusOK, tstRequest(t, http.MethodGet, fake.URL+"/payments/getByTransId/EF1995-000001-221216-122218-4132", nil, &payment))
+	require.Equal(t, StatusOk, payment.Status)
+	require.Equal(t, int64(390), *payment.Amount.CapturedValue)
+	require.Equal(t, []string{StatusOk}, recorder.statuses())
+}
+
+func TestManualCaptureAndRefund(t *testing.T) {
+	fake, recorder := tstSetup(t)
+	payId := tstCreateAndPay(t, fake, captureManual)
+
+	payment := nexi.NexiPaymentQueryResponse{}
+	require.Equal(t, http.StatusConflict, tstRequest(t, http.MethodPost, fake.URL+"/payments/"+payId+"/refunds", amountRequest{Amount: nexi.NexiAmount{Value: 100}}, nil))
+	require.Equal(t, http.StatusOK, tstRequest(t, http.MethodPost, fake.URL+"/payments/"+payId+"/captures", amountRequest{Amount: nexi.NexiAmount{Value: 390}}, &payment))
+	require.Equal(t, StatusOk, payment.Status)
+	require.Equal(t, http.StatusOK, tstRequest(t, http.MethodPost, fake.URL+"/payments/"+payId+"/refunds", amountRequest{Amount: nexi.NexiAmount{Value: 100}}, &payment))
+	require.Equal(t, int64(100), *payment.Amount.RefundedValue)
+	require.Equal(t, http.StatusConflict, tstRequest(t, http.MethodPost, fake.URL+"/payments/"+payId+"/refunds", amountRequest{Amount: nexi.NexiAmount{Value: 300}}, nil))
+
+	require.Equal(t, []string{StatusAuthorized, StatusOk}, recorder.statuses())
+}
+
+func TestCancel(t *testing.T) {
+	fake, recorder := tstSetup(t)
+	payId := tstCreateAndPay(t, fake, captureManual)
+
+	payment := nexi.NexiPaymentQueryResponse{}
+	require.Equal(t, http.StatusOK, tstRequest(t, http.MethodPost, fake.URL+"/payments/"+payId+"/reversals", nil, &payment))
+	require.Equal(t, StatusCancelled, payment.Status)
+	require.Equal(t, http.StatusConflict, tstRequest(t, http.MethodPost, fake.URL+"/payments/"+payId+"/captures", amountRequest{Amount: nexi.NexiAmount{Value: 390}}, nil))
+
+	require.Equal(t, []string{StatusAuthorized, StatusCancelled}, recorder.statuses())
+}
+
+func TestQueryPayments(t *testing.T) {
+	fake, _ := tstSetup(t)
+	tstCreateAndPay(t, fake, "")
+
+	payments := make([]nexi.NexiPaymentQueryResponse, 0)
+	require.Equal(t, http.StatusOK, tstRequest(t, http.MethodGet, fake.URL+"/payments?createdAfter=2000-01-01T00:00:00Z", nil, &payments))
+	require.Len(t, payments, 1)
+	require.Equal(t, "EF1995-000001-221216-122218-4132", payments[0].TransId)
+
+	require.Equal(t, http.StatusOK, tstRequest(t, http.MethodGet, fake.URL+"/payments?createdBefore=2000-01-01T00:00:00Z", nil, &payments))
+	require.Len(t, payments, 0)
+}
@@ -0,0 +1,127 @@
+package fakepaygate
+
+import (
+	"html/template"
+	"net/http"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+// the hosted payment page, where the attendee decides how the payment ends
+
+const (
+	ActionPay     = "pay"
+	ActionDecline = "decline"
+	ActionCancel  = "cancel"
+
+	paymentMethodCard = "CARD"
+	captureManual     = "MANUAL"
+)
+
+var paymentPage = template.Must(template.New("hpp").Parse(`<!DOCTYPE html>
+<html>
+<head><title>fakepaygate - {{ .TransId }}</title></head>
+<body>
+<h1>fakepaygate</h1>
+<p>Payment {{ .TransId }} over {{ .Value }} (smallest denomination) {{ .Currency }}.</p>
+{{ if .Status }}<p>Status: {{ .Status }}</p>{{ end }}
+<form method="post">
+<button type="submit" name="action" value="pay">Pay</button>
+<button type="submit" name="action" value="decline">Decline</button>
+<button type="submit" name="action" value="cancel">Cancel</button>
+</form>
+</body>
+</html>
+`))
+
+type paymentPageData struct {
+	TransId  string
+	Value    int64
+	Currency string
+	Status   string
+}
+
+func (f *FakePaygate) paymentPageHandler(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	p, ok := f.payments[chi.URLParam(r, "payId")]
+	if !ok {
+		f.mu.Unlock()
+		http.Error(w, "no such payment session", http.StatusNotFound)
+		return
+	}
+	data := paymentPageData{
+		TransId:  p.session.TransId,
+		Value:    p.session.Amount.Value,
+		Currency: p.session.Amount.Currency,
+		Status:   p.status,
+	}
+	f.mu.Unlock()
+
+	w.Header().Set(headers.ContentType, "text/html; charset=utf-8")
+	_ = paymentPage.Execute(w, data)
+}
+
+// paymentPageSubmitHandler ends the payment as chosen on the page, calls the webhook, and sends the browser
+// back to the return or cancel url.
+//
+// Payments that already succeeded are not changed again, so reloading the page is harmless.
+func (f *FakePaygate) paymentPageSubmitHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.PostFormValue("action")
+	if action != ActionPay && action != ActionDecline && action != ActionCancel {
+		http.Error(w, "action must be one of pay, decline, cancel", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	p, ok := f.payments[chi.URLParam(r, "payId")]
+	if !ok {
+		f.mu.Unlock()
+		http.Error(w, "no such payment session", http.StatusNotFound)
+		return
+	}
+	redirect := p.session.Urls.Cancel
+	changed := false
+	if p.status != StatusOk && p.status != StatusAuthorized {
+		switch action {
+		case ActionPay:
+			p.method = paymentMethodCard
+			p.responseCode = responseCodeSuccess
+			if p.session.CaptureMethod != nil && p.session.CaptureMethod.Type == captureManual {
+				p.status = StatusAuthorized
+			} else {
+				p.status = StatusOk
+				p.captured = p.session.Amount.Value
+			}
+			changed = true
+		case ActionDecline:
+			p.method = paymentMethodCard
+			p.responseCode = responseCodeDeclined
+			p.status = StatusFailed
+			changed = true
+		}
+	}
+	if p.status == StatusOk || p.status == StatusAuthorized {
+		redirect = p.session.Urls.Return
+	}
+	var response nexi.NexiPaymentQueryResponse
+	if changed {
+		response = p.queryResponse()
+	}
+	webhookUrl := f.webhookUrl(p)
+	f.mu.Unlock()
+
+	if changed {
+		aulogging.Logger.Ctx(r.Context()).Info().Printf("fakepaygate payment page %s payId=%s status=%s", action, response.PayId, response.Status)
+		f.callWebhook(r.Context(), webhookUrl, response)
+	}
+
+	if redirect == "" {
+		w.Header().Set(headers.ContentType, "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("payment finished, the session has no url to return to\n"))
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
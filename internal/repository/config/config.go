@@ -3,7 +3,12 @@
 package config
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
 	"strings"
 	"time"
 )
@@ -37,6 +42,10 @@ func ServerIdleTimeout() time.Duration {
 	return time.Second * time.Duration(Configuration().Server.IdleTimeout)
 }
 
+func ServerOpenApiValidation() OpenApiValidation {
+	return Configuration().Server.OpenApiValidation
+}
+
 func DatabaseUse() DatabaseType {
 	return Configuration().Database.Use
 }
@@ -47,10 +56,85 @@ func DatabaseMysqlConnectString() string {
 		c.Database + "?" + strings.Join(c.Parameters, "&")
 }
 
+func DatabasePostgresConnectString() string {
+	c := Configuration().Database
+	return "postgres://" + url.UserPassword(c.Username, c.Password).String() + "@" +
+		c.Database + "?" + strings.Join(c.Parameters, "&")
+}
+
+func DatabaseSqliteConnectString() string {
+	c := Configuration().Database
+	if len(c.Parameters) == 0 {
+		return c.Database
+	}
+	return c.Database + "?" + strings.Join(c.Parameters, "&")
+}
+
+func DatabaseInmemoryMaxEntries() int {
+	return Configuration().Database.MaxEntries
+}
+
 func MigrateDatabase() bool {
 	return dbMigrate
 }
 
+func MigrateDatabaseDownSteps() int {
+	return dbMigrateDown
+}
+
+func MigrationStatusAndExit() bool {
+	return dbMigrateStatus
+}
+
+func ApplyRetentionAndExit() bool {
+	return applyRetention
+}
+
+// ProtocolExportFile is set if the protocol should be exported instead of running the service.
+func ProtocolExportFile() string {
+	return exportFile
+}
+
+func ProtocolExportFormat() string {
+	return exportFormat
+}
+
+func ProtocolExportCreatedAfter() string {
+	return exportCreatedAfter
+}
+
+func ProtocolExportCreatedBefore() string {
+	return exportCreatedBefore
+}
+
+func ProtocolExportReferenceIdPrefix() string {
+	return exportRefIdPrefix
+}
+
+func ProtocolExportKinds() []string {
+	if exportKinds == "" {
+		return nil
+	}
+	return strings.Split(exportKinds, ",")
+}
+
+// ReconcileSettlementFile is set if a settlement file should be reconciled instead of running the service.
+func ReconcileSettlementFile() string {
+	return reconcileFile
+}
+
+func ReconcileReportFile() string {
+	return reconcileReportFile
+}
+
+func ProtocolRetention() RetentionConfig {
+	return Configuration().Database.Retention
+}
+
+func ProtocolRetentionInterval() time.Duration {
+	return time.Minute * time.Duration(Configuration().Database.Retention.IntervalMinutes)
+}
+
 func LoggingSeverity() string {
 	return Configuration().Logging.Severity
 }
@@ -59,6 +143,15 @@ func LogFullRequests() bool {
 	return Configuration().Logging.FullRequests
 }
 
+func LogRedactPaths() []string {
+	return Configuration().Logging.RedactPaths
+}
+
+// DefaultRedactPaths lists the json paths with personal data in Paygate payloads, used if logging.redact_paths is not set.
+func DefaultRedactPaths() []string {
+	return slices.Clone(defaultRedactPaths)
+}
+
 func ErrorNotifyMail() string {
 	return Configuration().Logging.ErrorNotifyMail
 }
@@ -67,6 +160,85 @@ func FixedApiToken() string {
 	return Configuration().Security.Fixed.Api
 }
 
+// FixedTokenClientName is the client name used for requests authenticated by the fixed api token.
+const FixedTokenClientName = "fixed-token"
+
+// ApiClientForKey returns the name of the client an api key belongs to, or false if the key is not valid.
+func ApiClientForKey(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	security := Configuration().Security
+	if security.Fixed.Api != "" && subtle.ConstantTimeCompare([]byte(key), []byte(security.Fixed.Api)) == 1 {
+		return FixedTokenClientName, true
+	}
+	for _, apiKey := range security.ApiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey.Key)) == 1 {
+			return apiKey.Name, true
+		}
+	}
+	return "", false
+}
+
+// ApiClientScopes returns the permissions of a client, nil if the client is unknown.
+func ApiClientScopes(name string) []Permission {
+	if name == FixedTokenClientName {
+		return AllPermissions
+	}
+	for _, apiKey := range Configuration().Security.ApiKeys {
+		if apiKey.Name == name {
+			return apiKey.Scopes
+		}
+	}
+	return nil
+}
+
+func OidcEnabled() bool {
+	return Configuration().Security.Oidc.JwksURL != "" || len(Configuration().Security.Oidc.TokenPublicKeys) > 0
+}
+
+func OidcJwksURL() string {
+	return Configuration().Security.Oidc.JwksURL
+}
+
+func OidcJwksCacheDuration() time.Duration {
+	return time.Duration(Configuration().Security.Oidc.JwksCacheMinutes) * time.Minute
+}
+
+func OidcTokenPublicKeys() []string {
+	return Configuration().Security.Oidc.TokenPublicKeys
+}
+
+func OidcIssuer() string {
+	return Configuration().Security.Oidc.Issuer
+}
+
+func OidcAudience() string {
+	return Configuration().Security.Oidc.Audience
+}
+
+func OidcRolesClaim() string {
+	return Configuration().Security.Oidc.RolesClaim
+}
+
+// OidcRolePermissions returns the permissions granted by a role, nil if the role grants nothing.
+func OidcRolePermissions(role string) []Permission {
+	return Configuration().Security.Oidc.RolePermissions[role]
+}
+
+func RateLimits() RateLimitsConfig {
+	return Configuration().Security.RateLimits
+}
+
+func TrustedProxies() []netip.Prefix {
+	return Configuration().Security.trustedProxyRanges
+}
+
+// WebhookAllowedIps returns the ranges the webhooks may be called from, nil if they may be called from anywhere.
+func WebhookAllowedIps() []netip.Prefix {
+	return Configuration().Security.webhookAllowedIpRanges
+}
+
 func IsCorsDisabled() bool {
 	return Configuration().Security.Cors.DisableCors
 }
@@ -83,6 +255,10 @@ func PaymentServiceBaseUrl() string {
 	return Configuration().Service.PaymentService
 }
 
+func ReadinessPings() bool {
+	return Configuration().Service.ReadinessPings
+}
+
 func NexiDownstreamBaseUrl() string {
 	return Configuration().Service.NexiDownstream
 }
@@ -127,6 +303,46 @@ func FailureRedirect() string {
 	return Configuration().Service.FailureRedirect
 }
 
+// RedirectAllowed is true if target is an absolute http(s) url that matches one of the
+// entries in service.redirect_allowlist, both in host and path prefix.
+func RedirectAllowed(target string) bool {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.User != nil || parsed.Host == "" {
+		return false
+	}
+	targetPath := path.Clean("/" + parsed.Path)
+	for _, entry := range Configuration().Service.RedirectAllowlist {
+		allowed, ok := parseRedirectAllowlistEntry(entry)
+		if !ok || !strings.EqualFold(parsed.Host, allowed.Host) {
+			continue
+		}
+		if allowed.Path == "" || targetPath == allowed.Path || strings.HasPrefix(targetPath, allowed.Path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func ReturnCheckTimeout() time.Duration {
+	return time.Second * time.Duration(Configuration().Service.ReturnCheckTimeout)
+}
+
 func TermsURL() string {
 	return Configuration().Service.TermsURL
 }
+
+func TracingEnabled() bool {
+	return Configuration().Tracing.Enabled
+}
+
+func TracingEndpoint() string {
+	return Configuration().Tracing.Endpoint
+}
+
+func TracingHeaders() map[string]string {
+	return Configuration().Tracing.Headers
+}
+
+func TracingSampleRatio() float64 {
+	return Configuration().Tracing.SampleRatio
+}
@@ -17,6 +17,17 @@ var (
 	configurationLock     *sync.RWMutex
 	configurationFilename string
 	dbMigrate             bool
+	dbMigrateDown         int
+	dbMigrateStatus       bool
+	applyRetention        bool
+	exportFile            string
+	exportFormat          string
+	exportCreatedAfter    string
+	exportCreatedBefore   string
+	exportRefIdPrefix     string
+	exportKinds           string
+	reconcileFile         string
+	reconcileReportFile   string
 	ecsLogging            bool
 )
 
@@ -31,6 +42,17 @@ func init() {
 
 	flag.StringVar(&configurationFilename, "config", "", "config file path")
 	flag.BoolVar(&dbMigrate, "migrate-database", false, "migrate database on startup")
+	flag.IntVar(&dbMigrateDown, "migrate-down", 0, "roll back this many of the most recent database migrations, then exit")
+	flag.BoolVar(&dbMigrateStatus, "migrate-status", false, "show database migration status, then exit")
+	flag.BoolVar(&applyRetention, "apply-retention", false, "apply protocol retention policy once, then exit")
+	flag.StringVar(&exportFile, "export-protocol", "", "export the protocol to this file, then exit")
+	flag.StringVar(&exportFormat, "export-format", "csv", "protocol export format: csv or jsonl")
+	flag.StringVar(&exportCreatedAfter, "export-created-after", "", "only export protocol entries created at or after this RFC3339 time")
+	flag.StringVar(&exportCreatedBefore, "export-created-before", "", "only export protocol entries created before this RFC3339 time")
+	flag.StringVar(&exportRefIdPrefix, "export-reference-id-prefix", "", "only export protocol entries whose reference id starts with this prefix, e.g. EF2024")
+	flag.StringVar(&exportKinds, "export-kinds", "", "only export protocol entries of these comma separated kinds")
+	flag.StringVar(&reconcileFile, "reconcile-settlement", "", "reconcile this Nexi settlement csv file against the payment service, then exit")
+	flag.StringVar(&reconcileReportFile, "reconcile-report", "reconciliation-report.csv", "write the reconciliation report to this csv file")
 	flag.BoolVar(&ecsLogging, "ecs-json-logging", false, "switch to structured json logging")
 }
 
@@ -58,6 +80,7 @@ func parseAndOverwriteConfig(yamlFile []byte, logPrintf func(format string, v ..
 	validateSecurityConfiguration(errs, newConfigurationData.Security)
 	validateLoggingConfiguration(errs, newConfigurationData.Logging)
 	validateInvoiceConfiguration(errs, newConfigurationData.Invoice)
+	validateTracingConfiguration(errs, newConfigurationData.Tracing)
 
 	if len(errs) != 0 {
 		var keys []string
@@ -74,6 +97,11 @@ func parseAndOverwriteConfig(yamlFile []byte, logPrintf func(format string, v ..
 		return errors.New("configuration validation error")
 	}
 
+	newConfigurationData.Security.trustedProxyRanges = parseCidrs(newConfigurationData.Security.TrustedProxies)
+	if len(newConfigurationData.Security.WebhookAllowedIps) > 0 {
+		newConfigurationData.Security.webhookAllowedIpRanges = parseCidrs(newConfigurationData.Security.WebhookAllowedIps)
+	}
+
 	configurationLock.Lock()
 	defer configurationLock.Unlock()
 
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"testing"
 
 	"github.com/eurofurence/reg-paygate-adapter/docs"
@@ -53,6 +54,7 @@ service:
   nexi_downstream: 'another invalid url'
 server:
   port: 14
+  openapi_validation: loose
 logging:
   severity: FELINE
 `
@@ -61,13 +63,14 @@ logging:
 	require.NotNil(t, err, "expected an error")
 	require.Equal(t, err.Error(), "configuration validation error", "unexpected error message")
 	require.EqualValues(t, []string{
-		"configuration error: database.use: must be one of mysql, inmemory",
+		"configuration error: database.use: must be one of mysql, postgres, sqlite, inmemory",
 		"configuration error: invoice.description: invoice.description field must be at least 1 and at most 256 characters long",
 		"configuration error: invoice.purpose: invoice.purpose field must be at least 1 and at most 256 characters long",
 		"configuration error: invoice.title: invoice.title field must be at least 1 and at most 256 characters long",
 		"configuration error: logging.severity: must be one of DEBUG, INFO, WARN, ERROR",
 		"configuration error: security.fixed.api: security.fixed.api field must be at least 16 and at most 256 characters long",
 		"configuration error: security.fixed.webhook: security.fixed.webhook field must be at least 8 and at most 64 characters long",
+		"configuration error: server.openapi_validation: must be one of off, log, strict",
 		"configuration error: server.port: server.port field must be an integer at least 1024 and at most 65535",
 		"configuration error: service.nexi_api_key: service.nexi_api_key field must be at least 1 and at most 256 characters long",
 		"configuration error: service.nexi_downstream: base url must be empty (enables local simulator) or start with http:// or https:// and may not end in a /",
@@ -102,4 +105,352 @@ invoice:
 	require.Nil(t, err, "expected no error")
 	require.Equal(t, uint16(8080), Configuration().Server.Port, "unexpected value for server.port")
 	require.Equal(t, "INFO", Configuration().Logging.Severity, "unexpected value for logging.severity")
+	require.Equal(t, 3, Configuration().Service.ReturnCheckTimeout, "unexpected value for service.return_check_timeout_seconds")
+	require.False(t, Configuration().Tracing.Enabled, "unexpected value for tracing.enabled")
+	require.Equal(t, 1.0, Configuration().Tracing.SampleRatio, "unexpected value for tracing.sample_ratio")
+	require.Equal(t, 60, Configuration().Security.Oidc.JwksCacheMinutes, "unexpected value for security.oidc.jwks_cache_minutes")
+	require.Equal(t, "groups", Configuration().Security.Oidc.RolesClaim, "unexpected value for security.oidc.roles_claim")
+	require.False(t, OidcEnabled(), "bearer tokens should be disabled by default")
+	require.Equal(t, RateLimitConfig{Disable: true, RequestsPerMinute: 120, Burst: 30}, Configuration().Security.RateLimits.Webhook, "unexpected value for security.rate_limits.webhook")
+	require.Equal(t, RateLimitConfig{RequestsPerMinute: 600, Burst: 100}, Configuration().Security.RateLimits.Api, "unexpected value for security.rate_limits.api")
+	require.Equal(t, RateLimitConfig{RequestsPerMinute: 6, Burst: 3}, Configuration().Security.RateLimits.Return, "unexpected value for security.rate_limits.return")
+}
+
+func TestParseAndOverwriteWebhookRateLimit(t *testing.T) {
+	docs.Description("the webhook rate limit is switched on by configuring its rate")
+	webhookLimitYaml := `# yaml with a webhook rate limit
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+  rate_limits:
+    webhook:
+      requests_per_minute: 300
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(webhookLimitYaml), tstLogRecorder)
+	require.Nil(t, err, "expected no error")
+	require.Equal(t, RateLimitConfig{RequestsPerMinute: 300, Burst: 30}, Configuration().Security.RateLimits.Webhook, "unexpected value for security.rate_limits.webhook")
+}
+
+func TestParseAndOverwriteParsesRanges(t *testing.T) {
+	docs.Description("ip ranges are parsed once when the configuration is loaded")
+	rangesYaml := `# yaml with ip ranges
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+  trusted_proxies:
+    - 10.0.0.0/8
+  webhook_allowed_ips:
+    - 192.0.2.0/24
+    - 198.51.100.7
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(rangesYaml), tstLogRecorder)
+	require.Nil(t, err, "expected no error")
+	require.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, TrustedProxies())
+	require.Equal(t, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("198.51.100.7/32")}, WebhookAllowedIps())
+}
+
+func TestParseAndOverwriteConfigValidationErrorsRetention(t *testing.T) {
+	docs.Description("check that an inconsistent retention policy leads to validation errors")
+	wrongConfigYaml := `# yaml with retention validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+database:
+  use: inmemory
+  retention:
+    anonymize_raw_after_days: 90
+    delete_raw_after_days: 30
+    delete_errors_after_days: 20
+    delete_after_days: -1
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: database.retention.anonymize_raw_after_days: must be less than delete_raw_after_days, or raw entries are deleted before they are anonymized",
+		"configuration error: database.retention.delete_after_days: database.retention.delete_after_days field must be an integer at least 0 and at most 36500",
+		"configuration error: database.retention.delete_errors_after_days: must be at least delete_raw_after_days, error entries must be kept longer than raw entries",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigValidationErrorsRedactPaths(t *testing.T) {
+	docs.Description("check that malformed redaction paths lead to validation errors")
+	wrongConfigYaml := `# yaml with redact path validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+database:
+  use: inmemory
+logging:
+  redact_paths:
+    - 'customerInfo.email'
+    - '$.customerInfo..phone'
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: logging.redact_paths: invalid path '$.customerInfo..phone', must be json keys or * separated by dots, e.g. customerInfo.email",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigValidationErrorsTracing(t *testing.T) {
+	docs.Description("check that enabling tracing without a valid collector url leads to validation errors")
+	wrongConfigYaml := `# yaml with tracing validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+tracing:
+  enabled: true
+  sample_ratio: 1.5
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: tracing.endpoint: collector base url must start with http:// or https:// and may not end in a /",
+		"configuration error: tracing.sample_ratio: must be a number between 0 and 1",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigValidationErrorsOidc(t *testing.T) {
+	docs.Description("check that invalid bearer token settings lead to validation errors")
+	wrongConfigYaml := `# yaml with oidc validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+  oidc:
+    jwks_url: 'https://idp.example.com/jwks/'
+    jwks_cache_minutes: -5
+    token_public_keys_PEM:
+      - 'not a key'
+    role_permissions:
+      admin:
+        - status-check
+        - delete-everything
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: security.oidc.jwks_cache_minutes: security.oidc.jwks_cache_minutes field must be an integer at least 1 and at most 10080",
+		"configuration error: security.oidc.jwks_url: must be empty or start with http:// or https:// and may not end in a /",
+		"configuration error: security.oidc.role_permissions: role admin: unknown permission 'delete-everything', must be one of paylink:create, paylink:read, status-check, capture, refund, cancel, protocol:read",
+		"configuration error: security.oidc.token_public_keys_PEM: must be public keys in PEM format, starting with -----BEGIN PUBLIC KEY-----",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigValidationErrorsApiKeys(t *testing.T) {
+	docs.Description("check that invalid api keys lead to validation errors")
+	wrongConfigYaml := `# yaml with api key validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+  api_keys:
+    - name: 'Regsys'
+      key: 'regsys-testing-key-abc'
+      scopes:
+        - paylink:create
+        - paylink:delete
+    - name: 'admin-tool'
+      key: 'fixed-testing-token-abc'
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: security.api_keys.key: admin-tool: key is also used by another client",
+		"configuration error: security.api_keys.name: invalid name 'Regsys', must be lowercase letters, digits and dashes, and not fixed-token",
+		"configuration error: security.api_keys.scopes: Regsys: unknown permission 'paylink:delete', must be one of paylink:create, paylink:read, status-check, capture, refund, cancel, protocol:read",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigValidationErrorsIpRanges(t *testing.T) {
+	docs.Description("check that invalid ip ranges lead to validation errors")
+	wrongConfigYaml := `# yaml with ip range validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+  trusted_proxies:
+    - 10.0.0.0/8
+    - 'localhost'
+  webhook_allowed_ips:
+    - 192.0.2.1
+    - 192.0.2.0/33
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: security.trusted_proxies: invalid range 'localhost', must be an ip address or CIDR range, e.g. 192.0.2.0/24",
+		"configuration error: security.webhook_allowed_ips: invalid range '192.0.2.0/33', must be an ip address or CIDR range, e.g. 192.0.2.0/24",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigValidationErrorsRedirects(t *testing.T) {
+	docs.Description("check that invalid redirect settings lead to validation errors")
+	wrongConfigYaml := `# yaml with redirect validation errors
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+  success_redirect: 'reg.example.com/register'
+  redirect_allowlist:
+    - reg.example.com/register
+    - 'https://rooms.example.com'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(wrongConfigYaml), tstLogRecorder)
+	require.NotNil(t, err, "expected an error")
+	require.EqualValues(t, []string{
+		"configuration error: service.redirect_allowlist: invalid entry 'https://rooms.example.com', must be a host name with an optional path prefix, without scheme, e.g. reg.example.com/register",
+		"configuration error: service.success_redirect: success redirect must be empty or start with http:// or https://",
+	}, recording)
+}
+
+func TestParseAndOverwriteConfigApiKeyFromEnvironment(t *testing.T) {
+	docs.Description("check that api keys can be given in the environment, and are looked up by client name")
+	t.Setenv("REG_SECRET_API_KEY_ADMIN_TOOL", "admin-tool-key-from-environment")
+	configYaml := `# yaml with an api key given in the environment
+security:
+  fixed_token:
+    api: 'fixed-testing-token-abc'
+    webhook: 'fixed-webhook-token-abc'
+  api_keys:
+    - name: 'admin-tool'
+      scopes:
+        - protocol:read
+database:
+  use: inmemory
+service:
+  public_url: 'http://localhost/hello'
+  nexi_merchant_id: 'my-demo-merchant'
+  nexi_api_key: 'my-demo-secret'
+  terms_url: 'http://localhost/terms'
+invoice:
+  title: 'demo title'
+  description: 'demo description'
+  purpose: 'demo purpose'
+`
+	recording = make([]string, 0)
+	err := parseAndOverwriteConfig([]byte(configYaml), tstLogRecorder)
+	require.Nil(t, err, "expected no error")
+
+	client, ok := ApiClientForKey("admin-tool-key-from-environment")
+	require.True(t, ok)
+	require.Equal(t, "admin-tool", client)
+	require.Equal(t, []Permission{PermissionProtocolRead}, ApiClientScopes(client))
+
+	client, ok = ApiClientForKey("fixed-testing-token-abc")
+	require.True(t, ok)
+	require.Equal(t, FixedTokenClientName, client)
+	require.Equal(t, AllPermissions, ApiClientScopes(client))
+
+	_, ok = ApiClientForKey("unknown-key")
+	require.False(t, ok)
 }
@@ -1,12 +1,34 @@
 package config
 
+import "net/netip"
+
 type (
-	DatabaseType string
+	DatabaseType      string
+	Permission        string
+	OpenApiValidation string
 )
 
 const (
 	Inmemory DatabaseType = "inmemory"
 	Mysql    DatabaseType = "mysql"
+	Postgres DatabaseType = "postgres"
+	Sqlite   DatabaseType = "sqlite"
+)
+
+const (
+	OpenApiValidationOff    OpenApiValidation = "off"
+	OpenApiValidationLog    OpenApiValidation = "log"
+	OpenApiValidationStrict OpenApiValidation = "strict"
+)
+
+const (
+	PermissionPaylinkCreate Permission = "paylink:create"
+	PermissionPaylinkRead   Permission = "paylink:read"
+	PermissionStatusCheck   Permission = "status-check"
+	PermissionCapture       Permission = "capture"
+	PermissionRefund        Permission = "refund"
+	PermissionCancel        Permission = "cancel"
+	PermissionProtocolRead  Permission = "protocol:read"
 )
 
 // Application is the root configuration type
@@ -17,50 +39,95 @@ type Application struct {
 	Logging  LoggingConfig  `yaml:"logging"`
 	Security SecurityConfig `yaml:"security"`
 	Invoice  InvoiceConfig  `yaml:"invoice"`
+	Tracing  TracingConfig  `yaml:"tracing"`
 }
 
 // ServerConfig contains all values for http configuration
 type ServerConfig struct {
-	Address      string `yaml:"address"`
-	Port         uint16 `yaml:"port"`
-	ReadTimeout  int    `yaml:"read_timeout_seconds"`
-	WriteTimeout int    `yaml:"write_timeout_seconds"`
-	IdleTimeout  int    `yaml:"idle_timeout_seconds"`
+	Address           string            `yaml:"address"`
+	Port              uint16            `yaml:"port"`
+	ReadTimeout       int               `yaml:"read_timeout_seconds"`
+	WriteTimeout      int               `yaml:"write_timeout_seconds"`
+	IdleTimeout       int               `yaml:"idle_timeout_seconds"`
+	OpenApiValidation OpenApiValidation `yaml:"openapi_validation"` // check requests and responses against the OpenAPI spec: off, log (default), or strict
 }
 
 // ServiceConfig contains configuration values
 // for service related tasks. E.g. URLs to downstream services
 type ServiceConfig struct {
-	Name                string `yaml:"name"`
-	PublicURL           string `yaml:"public_url"`           // my own public base url, without a trailing slash
-	WebhookOverrideURL  string `yaml:"webhook_override_url"` // override webhook base url to point to log only endpoint on another instance
-	AttendeeService     string `yaml:"attendee_service"`     // base url, usually http://localhost:nnnn, will use in-memory-mock if unset
-	MailService         string `yaml:"mail_service"`         // base url, usually http://localhost:nnnn, will use in-memory-mock if unset
-	PaymentService      string `yaml:"payment_service"`      // base url, usually http://localhost:nnnn, will use in-memory-mock if unset
-	NexiDownstream      string `yaml:"nexi_downstream"`      // base url, usually https://www.computop-paygate.com/api/v2, will use in-memory-mock if unset
-	NexiApiKey          string `yaml:"nexi_api_key"`         // authorization header (secret api key), required
-	NexiMerchantID      string `yaml:"nexi_merchant_id"`     // merchant id, required
-	NexiSimulationMode  bool   `yaml:"nexi_simulation_mode"` // enable simulation mode
-	SuccessRedirect     string `yaml:"success_redirect"`
-	FailureRedirect     string `yaml:"failure_redirect"`
-	TransactionIDPrefix string `yaml:"transaction_id_prefix"`
-	TermsURL            string `yaml:"terms_url"` // our terms, required
+	Name                string   `yaml:"name"`
+	PublicURL           string   `yaml:"public_url"`           // my own public base url, without a trailing slash
+	WebhookOverrideURL  string   `yaml:"webhook_override_url"` // override webhook base url to point to log only endpoint on another instance
+	AttendeeService     string   `yaml:"attendee_service"`     // base url, usually http://localhost:nnnn, will use in-memory-mock if unset
+	MailService         string   `yaml:"mail_service"`         // base url, usually http://localhost:nnnn, will use in-memory-mock if unset
+	PaymentService      string   `yaml:"payment_service"`      // base url, usually http://localhost:nnnn, will use in-memory-mock if unset
+	NexiDownstream      string   `yaml:"nexi_downstream"`      // base url, usually https://www.computop-paygate.com/api/v2, will use in-memory-mock if unset
+	NexiApiKey          string   `yaml:"nexi_api_key"`         // authorization header (secret api key), required
+	NexiMerchantID      string   `yaml:"nexi_merchant_id"`     // merchant id, required
+	NexiSimulationMode  bool     `yaml:"nexi_simulation_mode"` // enable simulation mode
+	SuccessRedirect     string   `yaml:"success_redirect"`
+	FailureRedirect     string   `yaml:"failure_redirect"`
+	ReturnCheckTimeout  int      `yaml:"return_check_timeout_seconds"` // how long the return url may wait for the payment status check
+	RedirectAllowlist   []string `yaml:"redirect_allowlist"`           // hosts and path prefixes per-request redirect urls may point to, e.g. reg.example.com/register
+	TransactionIDPrefix string   `yaml:"transaction_id_prefix"`
+	TermsURL            string   `yaml:"terms_url"`       // our terms, required
+	ReadinessPings      bool     `yaml:"readiness_pings"` // ping the attendee, mail and payment services in readiness checks
 }
 
-// DatabaseConfig configures which db to use (mysql, inmemory)
-// and how to connect to it (needed for mysql only)
+// DatabaseConfig configures which db to use (mysql, postgres, sqlite, inmemory)
+// and how to connect to it (not needed for inmemory)
 type DatabaseConfig struct {
-	Use        DatabaseType `yaml:"use"`
-	Username   string       `yaml:"username"`
-	Password   string       `yaml:"password"`
-	Database   string       `yaml:"database"`
-	Parameters []string     `yaml:"parameters"`
+	Use        DatabaseType    `yaml:"use"`
+	Username   string          `yaml:"username"`
+	Password   string          `yaml:"password"`
+	Database   string          `yaml:"database"`
+	Parameters []string        `yaml:"parameters"`
+	MaxEntries int             `yaml:"max_entries"` // inmemory only: keep at most this many protocol entries, 0 = unlimited
+	Retention  RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig configures how long protocol entries and paylink records are kept.
+//
+// Any number of days left at 0 disables that part of the policy, so by default entries are kept forever.
+type RetentionConfig struct {
+	AnonymizeRawAfterDays   int `yaml:"anonymize_raw_after_days"`   // remove personal data from raw request/response entries
+	DeleteRawAfterDays      int `yaml:"delete_raw_after_days"`      // raw request/response entries
+	DeleteErrorsAfterDays   int `yaml:"delete_errors_after_days"`   // error and warning entries
+	DeleteAfterDays         int `yaml:"delete_after_days"`          // all other entries
+	DeletePaylinksAfterDays int `yaml:"delete_paylinks_after_days"` // paylink records, counted from their creation
+	IntervalMinutes         int `yaml:"interval_minutes"`           // how often to apply the policy while running, 0 means never
 }
 
 // SecurityConfig configures everything related to incoming request security
 type SecurityConfig struct {
-	Fixed FixedTokenConfig `yaml:"fixed_token"`
-	Cors  CorsConfig       `yaml:"cors"`
+	Fixed      FixedTokenConfig    `yaml:"fixed_token"`
+	ApiKeys    []ApiKeyConfig      `yaml:"api_keys"`
+	Oidc       OpenIdConnectConfig `yaml:"oidc"`
+	Cors       CorsConfig          `yaml:"cors"`
+	RateLimits RateLimitsConfig    `yaml:"rate_limits"`
+
+	TrustedProxies    []string `yaml:"trusted_proxies"`     // CIDR ranges of reverse proxies whose X-Forwarded-For header we believe
+	WebhookAllowedIps []string `yaml:"webhook_allowed_ips"` // CIDR ranges the webhook and weblogger may be called from, empty allows all
+
+	// the ranges above, parsed once when the configuration is loaded
+	trustedProxyRanges     []netip.Prefix
+	webhookAllowedIpRanges []netip.Prefix
+}
+
+// RateLimitsConfig configures a token bucket rate limit for each group of routes.
+//
+// Requests with a valid api key are limited per client, all others per client ip.
+type RateLimitsConfig struct {
+	Webhook   RateLimitConfig `yaml:"webhook"`   // the webhook and weblogger endpoints called by Nexi
+	Simulator RateLimitConfig `yaml:"simulator"` // the local paylink simulator
+	Api       RateLimitConfig `yaml:"api"`       // all other endpoints below /api/rest
+	Return    RateLimitConfig `yaml:"return"`    // the return from the hosted payment page per reference id, in addition to the api limit
+}
+
+type RateLimitConfig struct {
+	Disable           bool `yaml:"disable"`
+	RequestsPerMinute int  `yaml:"requests_per_minute"` // the rate at which the bucket is refilled
+	Burst             int  `yaml:"burst"`               // the size of the bucket, that is how many requests may arrive at once
 }
 
 type CorsConfig struct {
@@ -68,16 +135,48 @@ type CorsConfig struct {
 	AllowOrigin string `yaml:"allow_origin"`
 }
 
+// ApiKeyConfig is a named api key for one backend client.
+//
+// The key may also be given in the environment variable REG_SECRET_API_KEY_<NAME>, with the name in
+// upper case and dashes replaced by underscores.
+type ApiKeyConfig struct {
+	Name   string       `yaml:"name"`   // identifies the client in logs and protocol entries
+	Key    string       `yaml:"key"`    // shared secret, sent in the X-Api-Key header
+	Scopes []Permission `yaml:"scopes"` // the operations the client may perform
+}
+
 type FixedTokenConfig struct {
-	Api     string `yaml:"api"`     // shared-secret for server-to-server backend authentication
+	Api     string `yaml:"api"`     // shared-secret for server-to-server backend authentication, allows all operations and is also sent to the downstream services
 	Webhook string `yaml:"webhook"` // shared-secret for the webhook coming in from nexi
 }
 
+// OpenIdConnectConfig configures validation of JWT bearer tokens issued by an identity provider.
+//
+// Bearer tokens are only accepted if at least one source of signing keys is configured.
+type OpenIdConnectConfig struct {
+	JwksURL          string                  `yaml:"jwks_url"`              // where to fetch the signing keys of the identity provider, e.g. https://idp.example.com/.well-known/jwks.json
+	JwksCacheMinutes int                     `yaml:"jwks_cache_minutes"`    // how long fetched keys are kept, defaults to 60
+	TokenPublicKeys  []string                `yaml:"token_public_keys_PEM"` // additional fixed signing keys in PEM format, mostly useful for testing
+	Issuer           string                  `yaml:"issuer"`                // if set, the iss claim must match exactly
+	Audience         string                  `yaml:"audience"`              // if set, the aud claim must contain this value
+	RolesClaim       string                  `yaml:"roles_claim"`           // the claim listing the roles of the subject, defaults to groups
+	RolePermissions  map[string][]Permission `yaml:"role_permissions"`      // which permissions each role grants, roles not listed grant nothing
+}
+
 // LoggingConfig configures logging
 type LoggingConfig struct {
-	Severity        string `yaml:"severity"`
-	FullRequests    bool   `yaml:"full_requests"`
-	ErrorNotifyMail string `yaml:"error_notify_mail"`
+	Severity        string   `yaml:"severity"`
+	FullRequests    bool     `yaml:"full_requests"`
+	RedactPaths     []string `yaml:"redact_paths"` // json paths masked in raw payloads before they are logged or persisted
+	ErrorNotifyMail string   `yaml:"error_notify_mail"`
+}
+
+// TracingConfig configures export of OpenTelemetry traces, switched off by default
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Endpoint    string            `yaml:"endpoint"`     // base url of the OTLP/HTTP collector, e.g. http://localhost:4318
+	Headers     map[string]string `yaml:"headers"`      // additional headers sent to the collector, e.g. for authentication
+	SampleRatio float64           `yaml:"sample_ratio"` // fraction of traces started here that are recorded, defaults to 1
 }
 
 // InvoiceConfig defines what the invoices should look like
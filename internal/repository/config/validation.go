@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/pem"
 	"fmt"
+	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
+	"strings"
 )
 
 func setConfigurationDefaults(c *Application) {
@@ -20,9 +23,81 @@ func setConfigurationDefaults(c *Application) {
 	if c.Server.IdleTimeout <= 0 {
 		c.Server.IdleTimeout = 5
 	}
+	if c.Server.OpenApiValidation == "" {
+		c.Server.OpenApiValidation = OpenApiValidationLog
+	}
+	if c.Service.ReturnCheckTimeout <= 0 {
+		c.Service.ReturnCheckTimeout = 3
+	}
 	if c.Logging.Severity == "" {
 		c.Logging.Severity = "INFO"
 	}
+	if c.Logging.RedactPaths == nil {
+		c.Logging.RedactPaths = defaultRedactPaths
+	}
+	if c.Tracing.SampleRatio == 0 {
+		c.Tracing.SampleRatio = 1
+	}
+	if c.Security.RateLimits.Webhook.RequestsPerMinute == 0 {
+		// all calls from Nexi share a few ip addresses, or even the ip of our reverse proxy if trusted_proxies is
+		// not configured, so limiting them by default would throttle the webhooks
+		c.Security.RateLimits.Webhook.Disable = true
+	}
+	setRateLimitDefaults(&c.Security.RateLimits.Webhook, 120, 30)
+	setRateLimitDefaults(&c.Security.RateLimits.Simulator, 60, 20)
+	setRateLimitDefaults(&c.Security.RateLimits.Api, 600, 100)
+	setRateLimitDefaults(&c.Security.RateLimits.Return, 6, 3)
+	if c.Security.Oidc.JwksCacheMinutes == 0 {
+		c.Security.Oidc.JwksCacheMinutes = 60
+	}
+	if c.Security.Oidc.RolesClaim == "" {
+		c.Security.Oidc.RolesClaim = "groups"
+	}
+}
+
+func setRateLimitDefaults(c *RateLimitConfig, requestsPerMinute int, burst int) {
+	if c.RequestsPerMinute == 0 {
+		c.RequestsPerMinute = requestsPerMinute
+	}
+	if c.Burst == 0 {
+		c.Burst = burst
+	}
+}
+
+// defaultRedactPaths covers the personal data contained in Paygate requests, responses and webhooks.
+var defaultRedactPaths = []string{
+	"customerInfo.firstName",
+	"customerInfo.lastName",
+	"customerInfo.middleName",
+	"customerInfo.maidenName",
+	"customerInfo.email",
+	"customerInfo.phone",
+	"customerInfo.birthDate",
+	"customerInfo.birthPlace",
+	"billingAddress.streetName",
+	"billingAddress.streetNumber",
+	"billingAddress.addressLine2",
+	"billingAddress.addressLine3",
+	"billingAddress.city",
+	"billingAddress.postalCode",
+	"shipping.address.firstName",
+	"shipping.address.lastName",
+	"shipping.address.companyName",
+	"shipping.address.streetName",
+	"shipping.address.streetNumber",
+	"shipping.address.addressLine2",
+	"shipping.address.addressLine3",
+	"shipping.address.city",
+	"shipping.address.postalCode",
+	"shipping.address.phone",
+	"fraudData.deliveryEmail",
+	"browserInfo.ipAddress",
+	"paymentMethods.card.cardHolderName",
+	"paymentMethods.card.pseudoCardNumber",
+	"paymentMethods.card.first6Digits",
+	"paymentMethods.card.last4Digits",
+	"paymentMethods.card.bin",
+	"paymentMethods.card.prefillInfo.*",
 }
 
 const (
@@ -30,6 +105,7 @@ const (
 	envNexiIncomingWebhookSecret = "REG_SECRET_NEXI_INCOMING_WEBHOOK_SECRET"
 	envApiToken                  = "REG_SECRET_API_TOKEN"
 	envDbPassword                = "REG_SECRET_DB_PASSWORD"
+	envApiKeyPrefix              = "REG_SECRET_API_KEY_"
 )
 
 func applyEnvVarOverrides(c *Application) {
@@ -45,6 +121,11 @@ func applyEnvVarOverrides(c *Application) {
 	if dbPassword := os.Getenv(envDbPassword); dbPassword != "" {
 		c.Database.Password = dbPassword
 	}
+	for i, apiKey := range c.Security.ApiKeys {
+		if key := os.Getenv(envApiKeyPrefix + strings.ToUpper(strings.ReplaceAll(apiKey.Name, "-", "_"))); key != "" {
+			c.Security.ApiKeys[i].Key = key
+		}
+	}
 }
 
 func validateServerConfiguration(errs url.Values, c ServerConfig) {
@@ -52,19 +133,47 @@ func validateServerConfiguration(errs url.Values, c ServerConfig) {
 	checkIntValueRange(&errs, 1, 300, "server.read_timeout_seconds", c.ReadTimeout)
 	checkIntValueRange(&errs, 1, 300, "server.write_timeout_seconds", c.WriteTimeout)
 	checkIntValueRange(&errs, 1, 300, "server.idle_timeout_seconds", c.IdleTimeout)
+	if notInAllowedValues(allowedOpenApiValidations, c.OpenApiValidation) {
+		errs.Add("server.openapi_validation", "must be one of off, log, strict")
+	}
 }
 
-var allowedDatabases = []DatabaseType{Mysql, Inmemory}
+var allowedOpenApiValidations = []OpenApiValidation{OpenApiValidationOff, OpenApiValidationLog, OpenApiValidationStrict}
+
+var allowedDatabases = []DatabaseType{Mysql, Postgres, Sqlite, Inmemory}
 
 func validateDatabaseConfiguration(errs url.Values, c DatabaseConfig) {
 	if notInAllowedValues(allowedDatabases, c.Use) {
-		errs.Add("database.use", "must be one of mysql, inmemory")
+		errs.Add("database.use", "must be one of mysql, postgres, sqlite, inmemory")
+	}
+	if c.Use == Sqlite {
+		checkLength(&errs, 1, 256, "database.database", c.Database)
 	}
-	if c.Use == Mysql {
+	checkIntValueRange(&errs, 0, 10000000, "database.max_entries", c.MaxEntries)
+	if c.Use == Mysql || c.Use == Postgres {
 		checkLength(&errs, 1, 256, "database.username", c.Username)
 		checkLength(&errs, 1, 256, "database.password", c.Password)
 		checkLength(&errs, 1, 256, "database.database", c.Database)
 	}
+	validateRetentionConfiguration(errs, c.Retention)
+}
+
+func validateRetentionConfiguration(errs url.Values, c RetentionConfig) {
+	checkIntValueRange(&errs, 0, 36500, "database.retention.anonymize_raw_after_days", c.AnonymizeRawAfterDays)
+	checkIntValueRange(&errs, 0, 36500, "database.retention.delete_raw_after_days", c.DeleteRawAfterDays)
+	checkIntValueRange(&errs, 0, 36500, "database.retention.delete_errors_after_days", c.DeleteErrorsAfterDays)
+	checkIntValueRange(&errs, 0, 36500, "database.retention.delete_after_days", c.DeleteAfterDays)
+	checkIntValueRange(&errs, 0, 36500, "database.retention.delete_paylinks_after_days", c.DeletePaylinksAfterDays)
+	checkIntValueRange(&errs, 0, 10080, "database.retention.interval_minutes", c.IntervalMinutes)
+	if c.AnonymizeRawAfterDays > 0 && c.DeleteRawAfterDays > 0 && c.AnonymizeRawAfterDays >= c.DeleteRawAfterDays {
+		errs.Add("database.retention.anonymize_raw_after_days", "must be less than delete_raw_after_days, or raw entries are deleted before they are anonymized")
+	}
+	if c.DeleteErrorsAfterDays > 0 && c.DeleteRawAfterDays == 0 {
+		errs.Add("database.retention.delete_errors_after_days", "must not be set unless delete_raw_after_days is also set, error entries must be kept longer than raw entries")
+	}
+	if c.DeleteErrorsAfterDays > 0 && c.DeleteErrorsAfterDays < c.DeleteRawAfterDays {
+		errs.Add("database.retention.delete_errors_after_days", "must be at least delete_raw_after_days, error entries must be kept longer than raw entries")
+	}
 }
 
 var allowedSeverities = []string{"DEBUG", "INFO", "WARN", "ERROR"}
@@ -73,11 +182,131 @@ func validateLoggingConfiguration(errs url.Values, c LoggingConfig) {
 	if notInAllowedValues(allowedSeverities[:], c.Severity) {
 		errs.Add("logging.severity", "must be one of DEBUG, INFO, WARN, ERROR")
 	}
+	for _, path := range c.RedactPaths {
+		if violatesPattern(redactPathPattern, path) {
+			errs.Add("logging.redact_paths", fmt.Sprintf("invalid path '%s', must be json keys or * separated by dots, e.g. customerInfo.email", path))
+		}
+	}
 }
 
+const redactPathPattern = `^([A-Za-z0-9_-]+|\*)(\.([A-Za-z0-9_-]+|\*))*$`
+
 func validateSecurityConfiguration(errs url.Values, c SecurityConfig) {
 	checkLength(&errs, 16, 256, "security.fixed.api", c.Fixed.Api)
 	checkLength(&errs, 8, 64, "security.fixed.webhook", c.Fixed.Webhook)
+	validateApiKeysConfiguration(errs, c)
+	validateOidcConfiguration(errs, c.Oidc)
+	validateCidrs(errs, "security.trusted_proxies", c.TrustedProxies)
+	validateCidrs(errs, "security.webhook_allowed_ips", c.WebhookAllowedIps)
+	validateRateLimitConfiguration(errs, "security.rate_limits.webhook", c.RateLimits.Webhook)
+	validateRateLimitConfiguration(errs, "security.rate_limits.simulator", c.RateLimits.Simulator)
+	validateRateLimitConfiguration(errs, "security.rate_limits.api", c.RateLimits.Api)
+	validateRateLimitConfiguration(errs, "security.rate_limits.return", c.RateLimits.Return)
+}
+
+func validateCidrs(errs url.Values, key string, cidrs []string) {
+	for _, cidr := range cidrs {
+		if _, err := parseCidr(cidr); err != nil {
+			errs.Add(key, fmt.Sprintf("invalid range '%s', must be an ip address or CIDR range, e.g. 192.0.2.0/24", cidr))
+		}
+	}
+}
+
+// parseRedirectAllowlistEntry splits an entry of the form host[:port][/path] into host and path prefix.
+func parseRedirectAllowlistEntry(entry string) (*url.URL, bool) {
+	if entry == "" || strings.Contains(entry, "://") {
+		return nil, false
+	}
+	parsed, err := url.Parse("https://" + entry)
+	if err != nil || parsed.Host == "" || parsed.User != nil || parsed.RawQuery != "" || parsed.Fragment != "" {
+		return nil, false
+	}
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	return parsed, true
+}
+
+// parseCidr accepts CIDR ranges and single ip addresses.
+func parseCidr(cidr string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(cidr); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	return prefix.Masked(), err
+}
+
+func parseCidrs(cidrs []string) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if prefix, err := parseCidr(cidr); err == nil {
+			result = append(result, prefix)
+		}
+	}
+	return result
+}
+
+func validateRateLimitConfiguration(errs url.Values, key string, c RateLimitConfig) {
+	checkIntValueRange(&errs, 1, 1000000, key+".requests_per_minute", c.RequestsPerMinute)
+	checkIntValueRange(&errs, 1, 100000, key+".burst", c.Burst)
+}
+
+// AllPermissions lists all valid permissions, which is what the fixed api token grants.
+var AllPermissions = []Permission{PermissionPaylinkCreate, PermissionPaylinkRead, PermissionStatusCheck, PermissionCapture, PermissionRefund, PermissionCancel, PermissionProtocolRead}
+
+const apiKeyNamePattern = "^[a-z0-9]([a-z0-9-]{0,62}[a-z0-9])?$"
+
+func validateApiKeysConfiguration(errs url.Values, c SecurityConfig) {
+	names := make(map[string]bool)
+	keys := map[string]bool{c.Fixed.Api: true}
+	for _, apiKey := range c.ApiKeys {
+		if violatesPattern(apiKeyNamePattern, apiKey.Name) || apiKey.Name == FixedTokenClientName {
+			errs.Add("security.api_keys.name", fmt.Sprintf("invalid name '%s', must be lowercase letters, digits and dashes, and not %s", apiKey.Name, FixedTokenClientName))
+		} else if names[apiKey.Name] {
+			errs.Add("security.api_keys.name", fmt.Sprintf("duplicate name '%s'", apiKey.Name))
+		}
+		names[apiKey.Name] = true
+
+		if len(apiKey.Key) < 16 || len(apiKey.Key) > 256 {
+			errs.Add("security.api_keys.key", fmt.Sprintf("%s: key must be at least 16 and at most 256 characters long", apiKey.Name))
+		} else if keys[apiKey.Key] {
+			errs.Add("security.api_keys.key", fmt.Sprintf("%s: key is also used by another client", apiKey.Name))
+		}
+		keys[apiKey.Key] = true
+
+		validatePermissions(errs, "security.api_keys.scopes", apiKey.Name, apiKey.Scopes)
+	}
+}
+
+func validatePermissions(errs url.Values, key string, owner string, permissions []Permission) {
+	for _, permission := range permissions {
+		if notInAllowedValues(AllPermissions, permission) {
+			errs.Add(key, fmt.Sprintf("%s: unknown permission '%s', must be one of %s", owner, permission, allPermissionsList()))
+		}
+	}
+}
+
+// allPermissionsList lists AllPermissions for error messages, so they cannot miss a permission.
+func allPermissionsList() string {
+	names := make([]string, 0, len(AllPermissions))
+	for _, permission := range AllPermissions {
+		names = append(names, string(permission))
+	}
+	return strings.Join(names, ", ")
+}
+
+func validateOidcConfiguration(errs url.Values, c OpenIdConnectConfig) {
+	if violatesPattern(downstreamPattern, c.JwksURL) {
+		errs.Add("security.oidc.jwks_url", "must be empty or start with http:// or https:// and may not end in a /")
+	}
+	checkIntValueRange(&errs, 1, 10080, "security.oidc.jwks_cache_minutes", c.JwksCacheMinutes)
+	for _, key := range c.TokenPublicKeys {
+		if block, _ := pem.Decode([]byte(key)); block == nil || block.Type != "PUBLIC KEY" {
+			errs.Add("security.oidc.token_public_keys_PEM", "must be public keys in PEM format, starting with -----BEGIN PUBLIC KEY-----")
+		}
+	}
+	for role, permissions := range c.RolePermissions {
+		validatePermissions(errs, "security.oidc.role_permissions", "role "+role, permissions)
+	}
 }
 
 const urlPattern = "^https?://.*$"
@@ -96,6 +325,18 @@ func validateServiceConfiguration(errs url.Values, c ServiceConfig) {
 	if violatesPattern(downstreamPattern, c.PublicURL) {
 		errs.Add("service.public_url", "public url must be empty or start with http:// or https:// and may not end in a /")
 	}
+	if c.SuccessRedirect != "" && violatesPattern(urlPattern, c.SuccessRedirect) {
+		errs.Add("service.success_redirect", "success redirect must be empty or start with http:// or https://")
+	}
+	if c.FailureRedirect != "" && violatesPattern(urlPattern, c.FailureRedirect) {
+		errs.Add("service.failure_redirect", "failure redirect must be empty or start with http:// or https://")
+	}
+	checkIntValueRange(&errs, 1, 60, "service.return_check_timeout_seconds", c.ReturnCheckTimeout)
+	for _, entry := range c.RedirectAllowlist {
+		if _, ok := parseRedirectAllowlistEntry(entry); !ok {
+			errs.Add("service.redirect_allowlist", fmt.Sprintf("invalid entry '%s', must be a host name with an optional path prefix, without scheme, e.g. reg.example.com/register", entry))
+		}
+	}
 	if violatesPattern(urlPattern, c.TermsURL) {
 		errs.Add("service.terms_url", "terms url must start with http:// or https://, and cannot be empty")
 	}
@@ -112,6 +353,15 @@ func validateInvoiceConfiguration(errs url.Values, c InvoiceConfig) {
 	checkLength(&errs, 1, 256, "invoice.description", c.Description)
 }
 
+func validateTracingConfiguration(errs url.Values, c TracingConfig) {
+	if c.Enabled && (c.Endpoint == "" || violatesPattern(downstreamPattern, c.Endpoint)) {
+		errs.Add("tracing.endpoint", "collector base url must start with http:// or https:// and may not end in a /")
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs.Add("tracing.sample_ratio", "must be a number between 0 and 1")
+	}
+}
+
 // -- helpers
 
 func violatesPattern(pattern string, value string) bool {
@@ -2,6 +2,8 @@ package dbrepo
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 )
@@ -9,7 +11,120 @@ import (
 type Repository interface {
 	Open() error
 	Close()
+
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+
+	// Migrate applies all pending schema migrations.
 	Migrate() error
 
+	// MigrateDown rolls back the given number of most recently applied schema migrations.
+	MigrateDown(steps int) error
+
+	// MigrationStatus lists all schema migrations known to the service or applied to the database, in version order.
+	MigrationStatus() ([]MigrationStatus, error)
+
+	// WriteProtocolEntry stores a new protocol entry. Fails with ErrInvalidProtocolKind if its kind is not one of entity.ProtocolKinds.
+	//
+	// If not set, Subject and Client are taken from the request context.
 	WriteProtocolEntry(ctx context.Context, e *entity.ProtocolEntry) error
+
+	// QueryProtocolEntries returns the matching protocol entries in ascending id order, and the total number
+	// of matching entries before Offset and Limit were applied.
+	QueryProtocolEntries(ctx context.Context, query ProtocolQuery) ([]*entity.ProtocolEntry, int64, error)
+
+	// ForEachProtocolEntry calls fn for every matching protocol entry in ascending id order, without loading
+	// them all into memory at once. Stops and returns the error if fn returns one.
+	//
+	// Offset and Limit of the query are ignored.
+	ForEachProtocolEntry(ctx context.Context, query ProtocolQuery, fn func(e *entity.ProtocolEntry) error) error
+
+	// SummarizeProtocolEntries counts the matching protocol entries and sums up their amounts, grouped by
+	// kind, statuses, payment method and currency. The grouping is done by the database.
+	//
+	// Offset and Limit of the query are ignored.
+	SummarizeProtocolEntries(ctx context.Context, query ProtocolQuery) ([]ProtocolSummary, error)
+
+	// AnonymizeProtocolEntries replaces the details of all matching protocol entries that have not been
+	// anonymized yet by the result of the anonymize function, and marks them as anonymized.
+	//
+	// Offset and Limit of the query are ignored. Returns the number of entries that were anonymized.
+	AnonymizeProtocolEntries(ctx context.Context, query ProtocolQuery, anonymize func(details string) string) (int64, error)
+
+	// DeleteProtocolEntries permanently removes all matching protocol entries.
+	//
+	// Offset and Limit of the query are ignored. Returns the number of entries that were deleted.
+	DeleteProtocolEntries(ctx context.Context, query ProtocolQuery) (int64, error)
+
+	// WritePaylink stores the record of a newly created paylink, replacing any earlier record with the same reference id.
+	WritePaylink(ctx context.Context, p *entity.Paylink) error
+
+	// UpdatePaylinkStatus records the last known Paygate status and payment id of the paylink with the given reference id.
+	//
+	// Does nothing if there is no record, e.g. for paylinks created before records were kept.
+	UpdatePaylinkStatus(ctx context.Context, referenceId string, apiId string, status string) error
+
+	// QueryPaylinks returns the matching paylinks in ascending id order, and the total number
+	// of matching paylinks before Offset and Limit were applied.
+	QueryPaylinks(ctx context.Context, query PaylinkQuery) ([]*entity.Paylink, int64, error)
+
+	// DeletePaylinks permanently removes all matching paylink records.
+	//
+	// Offset and Limit of the query are ignored. Returns the number of records that were deleted.
+	DeletePaylinks(ctx context.Context, query PaylinkQuery) (int64, error)
+}
+
+var ErrInvalidProtocolKind = errors.New("invalid protocol entry kind")
+
+// ProtocolQuery selects protocol entries. Fields left at their zero value do not restrict the result.
+type ProtocolQuery struct {
+	ReferenceId       string
+	ReferenceIdPrefix string
+	ApiId             string
+	Kinds             []entity.ProtocolKind // matches any of the given kinds
+	ExcludeKinds      []entity.ProtocolKind // matches none of the given kinds
+	RequestId         string
+	Currency          string
+	UpstreamStatus    string
+	WebhookStatus     string
+	TransactionStatus string
+	CreatedAfter      time.Time // inclusive
+	CreatedBefore     time.Time // exclusive
+
+	Offset int
+	Limit  int // 0 means unlimited
+}
+
+// ProtocolSummary aggregates all protocol entries with the same kind, operation, statuses, payment method and currency.
+type ProtocolSummary struct {
+	Kind              entity.ProtocolKind
+	Operation         string
+	UpstreamStatus    string
+	WebhookStatus     string
+	TransactionStatus string
+	PaymentMethod     string
+	Currency          string
+	Count             int64
+	Volume            int64 // sum of the amounts, entries without an amount count as 0
+}
+
+// PaylinkQuery selects paylinks. Fields left at their zero value do not restrict the result.
+type PaylinkQuery struct {
+	ReferenceId   string
+	DebitorId     uint64
+	Status        string    // the last known Paygate status
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+
+	Offset int
+	Limit  int // 0 means unlimited
+}
+
+// MigrationStatus describes one schema migration and whether it has been applied to the database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Unknown   bool // applied to the database, but not known to this version of the service
 }
@@ -0,0 +1,358 @@
+// Package gormdb contains the parts of the sql database repositories that are shared between all gorm dialects.
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	anonymizeBatchSize = 100
+	exportBatchSize    = 500
+)
+
+type GormRepository struct {
+	db  *gorm.DB
+	Now func() time.Time
+
+	name      string
+	dialector func() gorm.Dialector
+	options   Options
+}
+
+// Options covers the differences between the sql databases that go beyond the gorm dialect.
+type Options struct {
+	// SingleConnection limits the pool to one connection that is never recycled.
+	//
+	// Needed for sqlite, which only allows a single writer, and where an in-memory database
+	// is lost when its connection is closed.
+	SingleConnection bool
+}
+
+// New creates a repository for the sql database name (used in log messages and to select the migrations).
+//
+// dialector is called on Open() to obtain the configured gorm driver.
+func New(name string, dialector func() gorm.Dialector, options Options) *GormRepository {
+	return &GormRepository{
+		Now:       time.Now,
+		name:      name,
+		dialector: dialector,
+		options:   options,
+	}
+}
+
+func (r *GormRepository) Open() error {
+	gormConfig := gorm.Config{
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix: "nexi_",
+		},
+		Logger: logger.Default.LogMode(logger.Silent),
+		// sqlite compares timestamps as strings, so they must all be in the same time zone
+		NowFunc: func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+
+	db, err := gorm.Open(r.dialector(), &gormConfig)
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to open %s connection: %s", r.name, err.Error())
+		return err
+	}
+
+	sqlDb, err := db.DB()
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to configure %s connection: %s", r.name, err.Error())
+		return err
+	}
+
+	if r.options.SingleConnection {
+		sqlDb.SetMaxOpenConns(1)
+		sqlDb.SetMaxIdleConns(1)
+		sqlDb.SetConnMaxLifetime(0)
+	} else {
+		// see https://making.pusher.com/production-ready-connection-pooling-in-go/
+		sqlDb.SetMaxOpenConns(100)
+		sqlDb.SetMaxIdleConns(50)
+		sqlDb.SetConnMaxLifetime(time.Minute * 10)
+	}
+
+	r.db = db
+	return nil
+}
+
+func (r *GormRepository) Close() {
+	// no more db close in gorm v2, but the underlying connection pool can still be closed
+	if r.db == nil {
+		return
+	}
+	if sqlDb, err := r.db.DB(); err == nil {
+		_ = sqlDb.Close()
+	}
+	r.db = nil
+}
+
+func (r *GormRepository) Ping(ctx context.Context) error {
+	if r.db == nil {
+		return errors.New("database is not open")
+	}
+	sqlDb, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
+// --- log entries ---
+
+func (r *GormRepository) WriteProtocolEntry(ctx context.Context, e *entity.ProtocolEntry) error {
+	if !e.Kind.Valid() {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("refusing to write protocol entry with invalid kind '%s'", e.Kind)
+		return dbrepo.ErrInvalidProtocolKind
+	}
+	if e.Subject == "" {
+		e.Subject = ctxvalues.Subject(ctx)
+	}
+	if e.Client == "" {
+		e.Client = ctxvalues.ApiClient(ctx)
+	}
+	err := r.db.Create(e).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry insert: %s", r.name, err.Error())
+	}
+	return err
+}
+
+func (r *GormRepository) QueryProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery) ([]*entity.ProtocolEntry, int64, error) {
+	result := make([]*entity.ProtocolEntry, 0)
+
+	var total int64
+	err := r.protocolQuery(ctx, query).Count(&total).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry count: %s", r.name, err.Error())
+		return result, 0, err
+	}
+
+	db := r.protocolQuery(ctx, query).Order("id")
+	if query.Offset > 0 {
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	err = db.Find(&result).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry query: %s", r.name, err.Error())
+	}
+	return result, total, err
+}
+
+func (r *GormRepository) ForEachProtocolEntry(ctx context.Context, query dbrepo.ProtocolQuery, fn func(e *entity.ProtocolEntry) error) error {
+	batch := make([]*entity.ProtocolEntry, 0)
+	err := r.protocolQuery(ctx, query).FindInBatches(&batch, exportBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, e := range batch {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry iteration: %s", r.name, err.Error())
+	}
+	return err
+}
+
+func (r *GormRepository) SummarizeProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery) ([]dbrepo.ProtocolSummary, error) {
+	result := make([]dbrepo.ProtocolSummary, 0)
+	err := r.protocolQuery(ctx, query).
+		Select("kind, COALESCE(operation, '') AS operation, COALESCE(upstream_status, '') AS upstream_status, COALESCE(webhook_status, '') AS webhook_status, " +
+			"COALESCE(transaction_status, '') AS transaction_status, COALESCE(payment_method, '') AS payment_method, " +
+			"COALESCE(currency, '') AS currency, COUNT(*) AS count, COALESCE(SUM(amount), 0) AS volume").
+		Group("kind, COALESCE(operation, ''), COALESCE(upstream_status, ''), COALESCE(webhook_status, ''), COALESCE(transaction_status, ''), " +
+			"COALESCE(payment_method, ''), COALESCE(currency, '')").
+		Scan(&result).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry summary: %s", r.name, err.Error())
+	}
+	return result, err
+}
+
+func (r *GormRepository) AnonymizeProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery, anonymize func(details string) string) (int64, error) {
+	count := int64(0)
+	lastId := uint(0)
+	for {
+		batch := make([]*entity.ProtocolEntry, 0)
+		err := r.protocolQuery(ctx, query).
+			Where("anonymized = ?", false).
+			Where("id > ?", lastId).
+			Order("id").
+			Limit(anonymizeBatchSize).
+			Find(&batch).Error
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry anonymization query: %s", r.name, err.Error())
+			return count, err
+		}
+
+		for _, e := range batch {
+			err = r.db.WithContext(ctx).Model(e).Updates(map[string]any{
+				"details":    anonymize(e.Details),
+				"anonymized": true,
+			}).Error
+			if err != nil {
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during protocol entry anonymization: %s", r.name, err.Error())
+				return count, err
+			}
+			count++
+			lastId = e.ID
+		}
+
+		if len(batch) < anonymizeBatchSize {
+			return count, nil
+		}
+	}
+}
+
+func (r *GormRepository) DeleteProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery) (int64, error) {
+	result := r.protocolQuery(ctx, query).Unscoped().Delete(&entity.ProtocolEntry{})
+	if result.Error != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(result.Error).Printf("%s error during protocol entry delete: %s", r.name, result.Error.Error())
+	}
+	return result.RowsAffected, result.Error
+}
+
+// --- paylinks ---
+
+func (r *GormRepository) WritePaylink(ctx context.Context, p *entity.Paylink) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// records are unique by reference id, so a paylink created again replaces the old record
+		if err := tx.Unscoped().Where("reference_id = ?", p.ReferenceId).Delete(&entity.Paylink{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(p).Error
+	})
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during paylink insert: %s", r.name, err.Error())
+	}
+	return err
+}
+
+func (r *GormRepository) UpdatePaylinkStatus(ctx context.Context, referenceId string, apiId string, status string) error {
+	updates := map[string]any{
+		"status":    status,
+		"status_at": r.Now().UTC(),
+	}
+	if apiId != "" {
+		updates["api_id"] = apiId
+	}
+	err := r.db.WithContext(ctx).Model(&entity.Paylink{}).Where("reference_id = ?", referenceId).Updates(updates).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during paylink status update: %s", r.name, err.Error())
+	}
+	return err
+}
+
+func (r *GormRepository) DeletePaylinks(ctx context.Context, query dbrepo.PaylinkQuery) (int64, error) {
+	result := r.paylinkQuery(ctx, query).Unscoped().Delete(&entity.Paylink{})
+	if result.Error != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(result.Error).Printf("%s error during paylink delete: %s", r.name, result.Error.Error())
+	}
+	return result.RowsAffected, result.Error
+}
+
+func (r *GormRepository) QueryPaylinks(ctx context.Context, query dbrepo.PaylinkQuery) ([]*entity.Paylink, int64, error) {
+	result := make([]*entity.Paylink, 0)
+
+	var total int64
+	err := r.paylinkQuery(ctx, query).Count(&total).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during paylink count: %s", r.name, err.Error())
+		return result, 0, err
+	}
+
+	db := r.paylinkQuery(ctx, query).Order("id")
+	if query.Offset > 0 {
+		db = db.Offset(query.Offset)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	err = db.Find(&result).Error
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("%s error during paylink query: %s", r.name, err.Error())
+	}
+	return result, total, err
+}
+
+func (r *GormRepository) paylinkQuery(ctx context.Context, query dbrepo.PaylinkQuery) *gorm.DB {
+	db := r.db.WithContext(ctx).Model(&entity.Paylink{})
+	if query.ReferenceId != "" {
+		db = db.Where("reference_id = ?", query.ReferenceId)
+	}
+	if query.DebitorId != 0 {
+		db = db.Where("debitor_id = ?", query.DebitorId)
+	}
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	if !query.CreatedAfter.IsZero() {
+		db = db.Where("created_at >= ?", query.CreatedAfter.UTC())
+	}
+	if !query.CreatedBefore.IsZero() {
+		db = db.Where("created_at < ?", query.CreatedBefore.UTC())
+	}
+	return db
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, using an escape character that works the same in all databases.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (r *GormRepository) protocolQuery(ctx context.Context, query dbrepo.ProtocolQuery) *gorm.DB {
+	db := r.db.WithContext(ctx).Model(&entity.ProtocolEntry{})
+	if query.ReferenceId != "" {
+		db = db.Where("reference_id = ?", query.ReferenceId)
+	}
+	if query.ReferenceIdPrefix != "" {
+		db = db.Where("reference_id LIKE ? ESCAPE '!'", likeEscaper.Replace(query.ReferenceIdPrefix)+"%")
+	}
+	if query.ApiId != "" {
+		db = db.Where("api_id = ?", query.ApiId)
+	}
+	if len(query.Kinds) > 0 {
+		db = db.Where("kind IN ?", query.Kinds)
+	}
+	if len(query.ExcludeKinds) > 0 {
+		db = db.Where("kind NOT IN ?", query.ExcludeKinds)
+	}
+	if query.RequestId != "" {
+		db = db.Where("request_id = ?", query.RequestId)
+	}
+	if query.Currency != "" {
+		db = db.Where("currency = ?", query.Currency)
+	}
+	if query.UpstreamStatus != "" {
+		db = db.Where("upstream_status = ?", query.UpstreamStatus)
+	}
+	if query.WebhookStatus != "" {
+		db = db.Where("webhook_status = ?", query.WebhookStatus)
+	}
+	if query.TransactionStatus != "" {
+		db = db.Where("transaction_status = ?", query.TransactionStatus)
+	}
+	if !query.CreatedAfter.IsZero() {
+		db = db.Where("created_at >= ?", query.CreatedAfter.UTC())
+	}
+	if !query.CreatedBefore.IsZero() {
+		db = db.Where("created_at < ?", query.CreatedBefore.UTC())
+	}
+	return db
+}
@@ -0,0 +1,130 @@
+package gormdb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func tstMigratedSqliteRepository(t *testing.T) *GormRepository {
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	require.Nil(t, r.Migrate())
+	return r
+}
+
+func TestWriteProtocolEntry_StructuredData(t *testing.T) {
+	docs.Description("structured payment data is stored and can be queried")
+	r := tstMigratedSqliteRepository(t)
+	defer r.Close()
+
+	amount := int64(18500)
+	require.Nil(t, r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+		ReferenceId:       "EF1995-000001",
+		Kind:              entity.KindSuccess,
+		Message:           "transaction updated successfully",
+		Amount:            &amount,
+		Currency:          "EUR",
+		UpstreamStatus:    "OK",
+		WebhookStatus:     "OK",
+		TransactionStatus: "valid",
+	}))
+	require.Nil(t, r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{ReferenceId: "EF1995-000002", Kind: entity.KindRaw}))
+
+	entries, total, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{Currency: "EUR", TransactionStatus: "valid"})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+	require.Equal(t, int64(18500), *entries[0].Amount)
+	require.Equal(t, "OK", entries[0].UpstreamStatus)
+
+	entries, _, err = r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{Kinds: []entity.ProtocolKind{entity.KindRaw}})
+	require.Nil(t, err)
+	require.Nil(t, entries[0].Amount)
+	require.Equal(t, "", entries[0].Currency)
+}
+
+func TestWriteProtocolEntry_InvalidKind(t *testing.T) {
+	docs.Description("entries with a kind that is not one of the known kinds are rejected")
+	r := tstMigratedSqliteRepository(t)
+	defer r.Close()
+
+	err := r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{ReferenceId: "EF1995-000001", Kind: "failure"})
+	require.ErrorIs(t, err, dbrepo.ErrInvalidProtocolKind)
+}
+
+func TestSummarizeProtocolEntries(t *testing.T) {
+	docs.Description("protocol entries are counted and their amounts summed up by the database")
+	r := tstMigratedSqliteRepository(t)
+	defer r.Close()
+
+	for _, amount := range []int64{18500, 4000} {
+		require.Nil(t, r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+			ReferenceId:       "EF1995-000001",
+			Kind:              entity.KindSuccess,
+			Amount:            &amount,
+			Currency:          "EUR",
+			TransactionStatus: "valid",
+		}))
+	}
+	require.Nil(t, r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{ReferenceId: "EF1995-000002", Kind: entity.KindError}))
+	require.Nil(t, r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{ReferenceId: "EF1995-000002", Kind: entity.KindRaw}))
+
+	summaries, err := r.SummarizeProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{ExcludeKinds: []entity.ProtocolKind{entity.KindRaw}})
+	require.Nil(t, err)
+	require.ElementsMatch(t, []dbrepo.ProtocolSummary{
+		{Kind: entity.KindSuccess, TransactionStatus: "valid", Currency: "EUR", Count: 2, Volume: 22500},
+		{Kind: entity.KindError, Count: 1},
+	}, summaries)
+}
+
+func TestPing(t *testing.T) {
+	docs.Description("ping succeeds while the database is open, and fails once it is closed")
+	r := tstMigratedSqliteRepository(t)
+	require.Nil(t, r.Ping(context.TODO()))
+
+	r.Close()
+	require.NotNil(t, r.Ping(context.TODO()))
+}
+
+func TestPaylinks(t *testing.T) {
+	docs.Description("paylinks are stored once per reference id, their status can be updated, and they can be queried")
+	r := tstMigratedSqliteRepository(t)
+	defer r.Close()
+
+	require.Nil(t, r.WritePaylink(context.TODO(), &entity.Paylink{ReferenceId: "EF1995-000001", DebitorId: 1, Amount: 18500, Currency: "EUR", VatRate: 19}))
+	require.Nil(t, r.WritePaylink(context.TODO(), &entity.Paylink{ReferenceId: "EF1995-000002", DebitorId: 2, Amount: 9000, Currency: "EUR", VatRate: 19}))
+	require.Nil(t, r.WritePaylink(context.TODO(), &entity.Paylink{ReferenceId: "EF1995-000001", DebitorId: 1, Amount: 20000, Currency: "EUR", VatRate: 19}))
+	require.Nil(t, r.UpdatePaylinkStatus(context.TODO(), "EF1995-000001", "42", "OK"))
+	require.Nil(t, r.UpdatePaylinkStatus(context.TODO(), "EF1995-000099", "43", "OK"))
+
+	paylinks, total, err := r.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{DebitorId: 1})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+	require.Equal(t, int64(20000), paylinks[0].Amount)
+	require.Equal(t, "42", paylinks[0].ApiId)
+	require.Equal(t, "OK", paylinks[0].Status)
+	require.NotNil(t, paylinks[0].StatusAt)
+
+	paylinks, total, err = r.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{Status: "OK"})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+	require.Equal(t, "EF1995-000001", paylinks[0].ReferenceId)
+
+	paylinks, total, err = r.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{ReferenceId: "EF1995-000002"})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+	require.Equal(t, "EF1995-000002", paylinks[0].ReferenceId)
+
+	paylinks, total, err = r.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{Limit: 1})
+	require.Nil(t, err)
+	require.Equal(t, int64(2), total)
+	require.Equal(t, "EF1995-000002", paylinks[0].ReferenceId)
+}
@@ -0,0 +1,288 @@
+package gormdb
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"gorm.io/gorm"
+)
+
+// migrationFiles contains one directory per database (named like the repository), each holding
+// pairs of files <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+// Statements within a file are separated by a ; at the end of a line.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	schemaVersionTable = "nexi_schema_versions"
+	protocolTable      = "nexi_protocol_entries"
+)
+
+// autoMigrateBaseline lists the migrations whose changes versions before the migrations already made via gorm
+// AutoMigrate, each with a column they added. Such databases have the protocol table, but no schema versions.
+var autoMigrateBaseline = []struct {
+	version int
+	column  string
+}{
+	{version: 1, column: "reference_id"},
+	{version: 2, column: "anonymized"},
+}
+
+var migrationFilePattern = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for database %s: %v", dialect, err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, f := range files {
+		matches := migrationFilePattern.FindStringSubmatch(f.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name %s/%s", dir, f.Name())
+		}
+		version, _ := strconv.Atoi(matches[1])
+		contents, err := fs.ReadFile(migrationFiles, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: matches[2]}
+			byVersion[version] = m
+		} else if m.name != matches[2] {
+			return nil, fmt.Errorf("migration version %d used for both %s and %s", version, m.name, matches[2])
+		}
+		if matches[3] == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	result := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up script", m.version, m.name)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	return result, nil
+}
+
+func splitStatements(script string) []string {
+	result := make([]string, 0)
+	current := strings.Builder{}
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			result = append(result, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		result = append(result, strings.TrimSpace(current.String()))
+	}
+	return result
+}
+
+func (r *GormRepository) Migrate() error {
+	migrations, applied, err := r.migrationState()
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to migrate %s db: %s", r.name, err.Error())
+		return err
+	}
+	if err := r.adoptAutoMigrateSchema(migrations, applied); err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to adopt existing schema of %s db: %s", r.name, err.Error())
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		aulogging.Logger.NoCtx().Info().Printf("applying schema migration %04d_%s", m.version, m.name)
+		// MySQL commits DDL implicitly, so there the transaction only covers data changes and the version row.
+		// This is why each mysql migration is either a single DDL statement or data changes only.
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range splitStatements(m.up) {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Exec("INSERT INTO "+schemaVersionTable+" (version, name, applied_at) VALUES (?, ?, ?)",
+				m.version, m.name, time.Now().UTC()).Error
+		})
+		if err != nil {
+			aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to apply schema migration %04d_%s to %s db: %s", m.version, m.name, r.name, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *GormRepository) MigrateDown(steps int) error {
+	migrations, applied, err := r.migrationState()
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to roll back %s db: %s", r.name, err.Error())
+		return err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	for idx := 0; idx < steps && idx < len(versions); idx++ {
+		m, ok := findMigration(migrations, versions[idx])
+		if !ok || m.down == "" {
+			err := fmt.Errorf("schema migration version %d has no down script in this version of the service", versions[idx])
+			aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to roll back %s db: %s", r.name, err.Error())
+			return err
+		}
+
+		aulogging.Logger.NoCtx().Info().Printf("rolling back schema migration %04d_%s", m.version, m.name)
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			for _, statement := range splitStatements(m.down) {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Exec("DELETE FROM "+schemaVersionTable+" WHERE version = ?", m.version).Error
+		})
+		if err != nil {
+			aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to roll back schema migration %04d_%s in %s db: %s", m.version, m.name, r.name, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *GormRepository) MigrationStatus() ([]dbrepo.MigrationStatus, error) {
+	migrations, applied, err := r.migrationState()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]dbrepo.MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.version]
+		result = append(result, dbrepo.MigrationStatus{
+			Version:   m.version,
+			Name:      m.name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+		delete(applied, m.version)
+	}
+	for version, appliedAt := range applied {
+		result = append(result, dbrepo.MigrationStatus{
+			Version:   version,
+			Applied:   true,
+			AppliedAt: appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
+
+// migrationState loads the known migrations and the versions applied to the database, creating the version table if needed.
+func (r *GormRepository) migrationState() ([]migration, map[int]time.Time, error) {
+	if r.db == nil {
+		return nil, nil, errors.New("database is not open")
+	}
+
+	migrations, err := loadMigrations(r.name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = r.db.Exec("CREATE TABLE IF NOT EXISTS " + schemaVersionTable +
+		" (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)").Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := r.db.Raw("SELECT version, applied_at FROM " + schemaVersionTable).Rows()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return migrations, applied, rows.Err()
+}
+
+// adoptAutoMigrateSchema records the baseline migrations as applied that AutoMigrate has already carried out.
+func (r *GormRepository) adoptAutoMigrateSchema(migrations []migration, applied map[int]time.Time) error {
+	if len(applied) > 0 || !r.db.Migrator().HasTable(protocolTable) {
+		return nil
+	}
+
+	for _, baseline := range autoMigrateBaseline {
+		m, ok := findMigration(migrations, baseline.version)
+		if !ok || !r.db.Migrator().HasColumn(protocolTable, baseline.column) {
+			break
+		}
+		aulogging.Logger.NoCtx().Info().Printf("adopting schema migration %04d_%s, already applied by an earlier version", m.version, m.name)
+		appliedAt := time.Now().UTC()
+		err := r.db.Exec("INSERT INTO "+schemaVersionTable+" (version, name, applied_at) VALUES (?, ?, ?)",
+			m.version, m.name, appliedAt).Error
+		if err != nil {
+			return err
+		}
+		applied[m.version] = appliedAt
+	}
+	return nil
+}
+
+func findMigration(migrations []migration, version int) (migration, bool) {
+	for _, m := range migrations {
+		if m.version == version {
+			return m, true
+		}
+	}
+	return migration{}, false
+}
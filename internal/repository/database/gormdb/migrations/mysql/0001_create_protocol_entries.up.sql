@@ -0,0 +1,16 @@
+-- matches the table previously created by gorm AutoMigrate, so existing databases are adopted unchanged
+CREATE TABLE IF NOT EXISTS nexi_protocol_entries (
+    id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at   DATETIME(3)     NULL,
+    updated_at   DATETIME(3)     NULL,
+    deleted_at   DATETIME(3)     NULL,
+    reference_id VARCHAR(80)     NOT NULL,
+    api_id       LONGTEXT        NULL,
+    kind         VARCHAR(8)      NOT NULL,
+    message      VARCHAR(255)    NULL,
+    details      LONGTEXT        NULL,
+    request_id   VARCHAR(8)      NULL,
+    PRIMARY KEY (id),
+    INDEX nexi_ref_id_idx (reference_id),
+    INDEX idx_nexi_protocol_entries_deleted_at (deleted_at)
+) CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
@@ -0,0 +1,6 @@
+ALTER TABLE nexi_protocol_entries
+    DROP COLUMN transaction_status,
+    DROP COLUMN webhook_status,
+    DROP COLUMN upstream_status,
+    DROP COLUMN currency,
+    DROP COLUMN amount;
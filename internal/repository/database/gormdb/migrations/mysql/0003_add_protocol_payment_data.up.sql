@@ -0,0 +1,6 @@
+ALTER TABLE nexi_protocol_entries
+    ADD COLUMN amount BIGINT NULL,
+    ADD COLUMN currency VARCHAR(3) NULL,
+    ADD COLUMN upstream_status VARCHAR(32) NULL,
+    ADD COLUMN webhook_status VARCHAR(32) NULL,
+    ADD COLUMN transaction_status VARCHAR(32) NULL;
@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN payment_method VARCHAR(32) NULL;
@@ -0,0 +1,21 @@
+CREATE TABLE nexi_paylinks (
+    id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    created_at   DATETIME(3)     NULL,
+    updated_at   DATETIME(3)     NULL,
+    deleted_at   DATETIME(3)     NULL,
+    reference_id VARCHAR(80)     NOT NULL,
+    debitor_id   BIGINT UNSIGNED NOT NULL,
+    amount       BIGINT          NOT NULL,
+    currency     VARCHAR(3)      NOT NULL,
+    vat_rate     DOUBLE          NOT NULL,
+    link         VARCHAR(2048)   NULL,
+    api_id       VARCHAR(80)     NULL,
+    status       VARCHAR(32)     NULL,
+    status_at    DATETIME(3)     NULL,
+    subject      VARCHAR(255)    NULL,
+    client       VARCHAR(64)     NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX nexi_paylink_ref_id_idx (reference_id),
+    INDEX nexi_paylink_debitor_id_idx (debitor_id),
+    INDEX idx_nexi_paylinks_deleted_at (deleted_at)
+) CHARSET = utf8mb4 COLLATE = utf8mb4_general_ci;
@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries DROP CHECK nexi_protocol_kind_chk;
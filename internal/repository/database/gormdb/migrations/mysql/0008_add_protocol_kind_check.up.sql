@@ -0,0 +1,2 @@
+-- the kinds of entity.ProtocolKinds, keep them in sync
+ALTER TABLE nexi_protocol_entries ADD CONSTRAINT nexi_protocol_kind_chk CHECK (kind IN ('raw', 'success', 'pending', 'warning', 'error'));
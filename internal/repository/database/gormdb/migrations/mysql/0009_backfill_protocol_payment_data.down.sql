@@ -0,0 +1 @@
+-- the backfilled data is kept, it does not hurt older versions
@@ -0,0 +1,8 @@
+-- entries written before 0003 only have the amount and currency in details, as "amount=18500 currency=EUR ..."
+-- or "amount=18500 EUR verified=...". Statuses follow in 0010.
+UPDATE nexi_protocol_entries
+SET amount = CAST(SUBSTRING_INDEX(SUBSTRING(details, 8), ' ', 1) AS SIGNED)
+WHERE amount IS NULL AND REGEXP_LIKE(details, '^amount=[0-9]+( |$)', 'c');
+UPDATE nexi_protocol_entries
+SET currency = REPLACE(SUBSTRING_INDEX(SUBSTRING_INDEX(details, ' ', 2), ' ', -1), 'currency=', '')
+WHERE currency IS NULL AND REGEXP_LIKE(details, '^amount=[0-9]+ (currency=)?[A-Z]{3}( |$)', 'c');
@@ -0,0 +1,11 @@
+-- entries written before 0003 have no statuses. Recover those the payment statistics need from the messages,
+-- which have been the same in all versions. All other statuses stay in details only.
+UPDATE nexi_protocol_entries
+SET transaction_status = 'valid'
+WHERE transaction_status IS NULL AND kind = 'success' AND message LIKE 'transaction updated successfully%';
+UPDATE nexi_protocol_entries
+SET transaction_status = 'pending'
+WHERE transaction_status IS NULL AND kind = 'pending' AND message = 'transaction updated to PENDING';
+UPDATE nexi_protocol_entries
+SET webhook_status = SUBSTRING(message, 9, CHAR_LENGTH(message) - 23)
+WHERE webhook_status IS NULL AND kind = 'error' AND message LIKE 'webhook % unknown status';
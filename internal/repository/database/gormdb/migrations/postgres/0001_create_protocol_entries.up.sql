@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS nexi_protocol_entries (
+    id           BIGSERIAL    PRIMARY KEY,
+    created_at   TIMESTAMPTZ  NULL,
+    updated_at   TIMESTAMPTZ  NULL,
+    deleted_at   TIMESTAMPTZ  NULL,
+    reference_id VARCHAR(80)  NOT NULL,
+    api_id       TEXT         NULL,
+    kind         VARCHAR(8)   NOT NULL,
+    message      VARCHAR(255) NULL,
+    details      TEXT         NULL,
+    request_id   VARCHAR(8)   NULL
+);
+CREATE INDEX IF NOT EXISTS nexi_ref_id_idx ON nexi_protocol_entries (reference_id);
+CREATE INDEX IF NOT EXISTS idx_nexi_protocol_entries_deleted_at ON nexi_protocol_entries (deleted_at);
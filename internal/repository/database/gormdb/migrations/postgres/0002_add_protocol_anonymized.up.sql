@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN anonymized BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+ALTER TABLE nexi_protocol_entries DROP COLUMN transaction_status;
+ALTER TABLE nexi_protocol_entries DROP COLUMN webhook_status;
+ALTER TABLE nexi_protocol_entries DROP COLUMN upstream_status;
+ALTER TABLE nexi_protocol_entries DROP COLUMN currency;
+ALTER TABLE nexi_protocol_entries DROP COLUMN amount;
@@ -0,0 +1,5 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN amount BIGINT NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN currency VARCHAR(3) NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN upstream_status VARCHAR(32) NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN webhook_status VARCHAR(32) NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN transaction_status VARCHAR(32) NULL;
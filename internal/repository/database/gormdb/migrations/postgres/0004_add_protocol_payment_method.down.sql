@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries DROP COLUMN payment_method;
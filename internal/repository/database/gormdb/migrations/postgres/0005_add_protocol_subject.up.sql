@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN subject VARCHAR(255) NULL;
@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN client VARCHAR(64) NULL;
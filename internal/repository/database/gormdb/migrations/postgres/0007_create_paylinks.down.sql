@@ -0,0 +1 @@
+DROP TABLE nexi_paylinks;
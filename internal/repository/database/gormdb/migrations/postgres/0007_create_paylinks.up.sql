@@ -0,0 +1,20 @@
+CREATE TABLE nexi_paylinks (
+    id           BIGSERIAL        PRIMARY KEY,
+    created_at   TIMESTAMPTZ      NULL,
+    updated_at   TIMESTAMPTZ      NULL,
+    deleted_at   TIMESTAMPTZ      NULL,
+    reference_id VARCHAR(80)      NOT NULL,
+    debitor_id   BIGINT           NOT NULL,
+    amount       BIGINT           NOT NULL,
+    currency     VARCHAR(3)       NOT NULL,
+    vat_rate     DOUBLE PRECISION NOT NULL,
+    link         VARCHAR(2048)    NULL,
+    api_id       VARCHAR(80)      NULL,
+    status       VARCHAR(32)      NULL,
+    status_at    TIMESTAMPTZ      NULL,
+    subject      VARCHAR(255)     NULL,
+    client       VARCHAR(64)      NULL
+);
+CREATE UNIQUE INDEX nexi_paylink_ref_id_idx ON nexi_paylinks (reference_id);
+CREATE INDEX nexi_paylink_debitor_id_idx ON nexi_paylinks (debitor_id);
+CREATE INDEX idx_nexi_paylinks_deleted_at ON nexi_paylinks (deleted_at);
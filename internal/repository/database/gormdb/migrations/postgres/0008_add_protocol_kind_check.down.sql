@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries DROP CONSTRAINT nexi_protocol_kind_chk;
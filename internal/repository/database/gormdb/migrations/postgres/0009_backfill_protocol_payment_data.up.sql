@@ -0,0 +1,8 @@
+-- entries written before 0003 only have the amount and currency in details, as "amount=18500 currency=EUR ..."
+-- or "amount=18500 EUR verified=...". Statuses follow in 0010.
+UPDATE nexi_protocol_entries
+SET amount = CAST(substring(details from '^amount=([0-9]+)') AS BIGINT)
+WHERE amount IS NULL AND details ~ '^amount=[0-9]+( |$)';
+UPDATE nexi_protocol_entries
+SET currency = substring(details from '^amount=[0-9]+ (?:currency=)?([A-Z]{3})(?: |$)')
+WHERE currency IS NULL AND details ~ '^amount=[0-9]+ (currency=)?[A-Z]{3}( |$)';
@@ -0,0 +1 @@
+-- the backfilled statuses are kept, they do not hurt older versions
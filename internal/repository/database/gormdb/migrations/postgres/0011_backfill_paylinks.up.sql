@@ -0,0 +1,20 @@
+-- paylinks created before 0007 are only known from their create-pay-link protocol entries, which have the link
+-- in details. The most recent entry per reference id wins, like a paylink created again replaces the old record.
+-- Reference ids of the registration system carry the debitor id in their second part, others get 0.
+-- The vat rate was never recorded, so it is left at 0.
+-- Entries written before 0003 only have the link in details, not the amount and currency, which 0009 could not
+-- recover either. Those paylinks are skipped rather than recorded with made up amounts, they remain available
+-- from Paygate through the paylink resource.
+INSERT INTO nexi_paylinks (created_at, updated_at, reference_id, debitor_id, amount, currency, vat_rate, link, subject, client)
+SELECT e.created_at, e.created_at, e.reference_id,
+       CASE WHEN e.reference_id ~ '^[^-]+-[0-9]{1,18}-'
+            THEN CAST(split_part(e.reference_id, '-', 2) AS BIGINT)
+            ELSE 0 END,
+       e.amount, e.currency, 0, e.details, e.subject, e.client
+FROM nexi_protocol_entries e
+WHERE e.id IN (SELECT MAX(id) FROM nexi_protocol_entries
+               WHERE kind = 'success' AND message = 'create-pay-link' AND deleted_at IS NULL
+               GROUP BY reference_id)
+  AND char_length(e.details) <= 2048
+  AND e.amount IS NOT NULL AND e.currency IS NOT NULL AND e.currency <> ''
+  AND NOT EXISTS (SELECT 1 FROM nexi_paylinks p WHERE p.reference_id = e.reference_id);
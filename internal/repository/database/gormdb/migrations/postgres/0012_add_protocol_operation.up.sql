@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN operation VARCHAR(16) NULL;
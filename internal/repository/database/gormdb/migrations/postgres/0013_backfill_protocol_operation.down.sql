@@ -0,0 +1 @@
+-- the backfilled operations are kept, 0012 removes them together with the column
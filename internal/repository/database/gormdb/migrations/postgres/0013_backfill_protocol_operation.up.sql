@@ -0,0 +1,13 @@
+-- entries written before 0012 have no operation. Recover it from the messages, which have been the same in all versions.
+UPDATE nexi_protocol_entries
+SET operation = 'create'
+WHERE operation IS NULL AND message IN ('create-pay-link', 'create-pay-link failed', 'create-pay-link empty');
+UPDATE nexi_protocol_entries
+SET operation = 'capture'
+WHERE operation IS NULL AND message IN ('capture', 'capture failed');
+UPDATE nexi_protocol_entries
+SET operation = 'refund'
+WHERE operation IS NULL AND message IN ('refund', 'refund failed');
+UPDATE nexi_protocol_entries
+SET operation = 'cancel'
+WHERE operation IS NULL AND message IN ('cancel', 'cancel failed');
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS nexi_protocol_entries;
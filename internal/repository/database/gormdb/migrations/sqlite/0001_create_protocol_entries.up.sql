@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS nexi_protocol_entries (
+    id           INTEGER  PRIMARY KEY AUTOINCREMENT,
+    created_at   DATETIME NULL,
+    updated_at   DATETIME NULL,
+    deleted_at   DATETIME NULL,
+    reference_id TEXT     NOT NULL,
+    api_id       TEXT     NULL,
+    kind         TEXT     NOT NULL,
+    message      TEXT     NULL,
+    details      TEXT     NULL,
+    request_id   TEXT     NULL
+);
+CREATE INDEX IF NOT EXISTS nexi_ref_id_idx ON nexi_protocol_entries (reference_id);
+CREATE INDEX IF NOT EXISTS idx_nexi_protocol_entries_deleted_at ON nexi_protocol_entries (deleted_at);
@@ -0,0 +1 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN anonymized NUMERIC NOT NULL DEFAULT false;
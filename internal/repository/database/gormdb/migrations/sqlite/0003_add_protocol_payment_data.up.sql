@@ -0,0 +1,5 @@
+ALTER TABLE nexi_protocol_entries ADD COLUMN amount INTEGER NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN currency TEXT NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN upstream_status TEXT NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN webhook_status TEXT NULL;
+ALTER TABLE nexi_protocol_entries ADD COLUMN transaction_status TEXT NULL;
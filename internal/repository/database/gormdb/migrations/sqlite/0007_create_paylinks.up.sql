@@ -0,0 +1,20 @@
+CREATE TABLE nexi_paylinks (
+    id           INTEGER  PRIMARY KEY AUTOINCREMENT,
+    created_at   DATETIME NULL,
+    updated_at   DATETIME NULL,
+    deleted_at   DATETIME NULL,
+    reference_id TEXT     NOT NULL,
+    debitor_id   INTEGER  NOT NULL,
+    amount       INTEGER  NOT NULL,
+    currency     TEXT     NOT NULL,
+    vat_rate     REAL     NOT NULL,
+    link         TEXT     NULL,
+    api_id       TEXT     NULL,
+    status       TEXT     NULL,
+    status_at    DATETIME NULL,
+    subject      TEXT     NULL,
+    client       TEXT     NULL
+);
+CREATE UNIQUE INDEX nexi_paylink_ref_id_idx ON nexi_paylinks (reference_id);
+CREATE INDEX nexi_paylink_debitor_id_idx ON nexi_paylinks (debitor_id);
+CREATE INDEX idx_nexi_paylinks_deleted_at ON nexi_paylinks (deleted_at);
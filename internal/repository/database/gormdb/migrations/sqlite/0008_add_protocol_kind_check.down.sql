@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS nexi_protocol_kind_update_chk;
+DROP TRIGGER IF EXISTS nexi_protocol_kind_insert_chk;
@@ -0,0 +1,7 @@
+-- sqlite cannot add constraints to existing tables, so triggers check the kinds of entity.ProtocolKinds, keep them in sync
+CREATE TRIGGER nexi_protocol_kind_insert_chk BEFORE INSERT ON nexi_protocol_entries
+WHEN NEW.kind NOT IN ('raw', 'success', 'pending', 'warning', 'error')
+BEGIN SELECT RAISE(ABORT, 'invalid protocol kind'); END;
+CREATE TRIGGER nexi_protocol_kind_update_chk BEFORE UPDATE OF kind ON nexi_protocol_entries
+WHEN NEW.kind NOT IN ('raw', 'success', 'pending', 'warning', 'error')
+BEGIN SELECT RAISE(ABORT, 'invalid protocol kind'); END;
@@ -0,0 +1,9 @@
+-- entries written before 0003 only have the amount and currency in details, as "amount=18500 currency=EUR ..."
+-- or "amount=18500 EUR verified=...". Statuses follow in 0010.
+UPDATE nexi_protocol_entries
+SET amount = CAST(substr(details, 8, instr(substr(details, 8) || ' ', ' ') - 1) AS INTEGER)
+WHERE amount IS NULL AND details GLOB 'amount=[0-9]*';
+UPDATE nexi_protocol_entries
+SET currency = replace(substr(substr(details, instr(details, ' ') + 1), 1, instr(substr(details, instr(details, ' ') + 1) || ' ', ' ') - 1), 'currency=', '')
+WHERE currency IS NULL AND details GLOB 'amount=[0-9]* *'
+  AND replace(substr(substr(details, instr(details, ' ') + 1), 1, instr(substr(details, instr(details, ' ') + 1) || ' ', ' ') - 1), 'currency=', '') GLOB '[A-Z][A-Z][A-Z]';
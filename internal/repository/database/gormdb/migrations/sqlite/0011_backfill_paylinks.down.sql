@@ -0,0 +1 @@
+-- the recovered paylinks are kept, they cannot be told apart from paylinks created later
@@ -0,0 +1,313 @@
+package gormdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMigrationsConsistentAcrossDatabases(t *testing.T) {
+	docs.Description("every database has the same migrations, each with an up and a down script")
+	expected, err := loadMigrations("mysql")
+	require.Nil(t, err)
+	require.NotEmpty(t, expected)
+	for _, dialect := range []string{"mysql", "postgres", "sqlite"} {
+		actual, err := loadMigrations(dialect)
+		require.Nil(t, err)
+		require.Equal(t, len(expected), len(actual), dialect)
+		for idx, m := range actual {
+			require.Equal(t, expected[idx].version, m.version, dialect)
+			require.Equal(t, expected[idx].name, m.name, dialect)
+			require.NotEmpty(t, m.down, dialect)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	docs.Description("migration scripts are split into statements at a ; at the end of a line, comments are dropped")
+	script := "-- some comment\nCREATE TABLE a (\n  x INTEGER\n);\n\nCREATE INDEX b ON a (x);\nDROP TABLE c"
+	require.Equal(t, []string{"CREATE TABLE a (\n  x INTEGER\n)", "CREATE INDEX b ON a (x)", "DROP TABLE c"}, splitStatements(script))
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	docs.Description("migrations can be applied, listed and rolled back on an sqlite database")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+
+	status, err := r.MigrationStatus()
+	require.Nil(t, err)
+	require.Equal(t, 13, len(status))
+	last := len(status) - 1
+	require.False(t, status[0].Applied)
+	require.False(t, status[last].Applied)
+
+	require.Nil(t, r.Migrate())
+	status, err = r.MigrationStatus()
+	require.Nil(t, err)
+	require.Equal(t, "create_protocol_entries", status[0].Name)
+	require.True(t, status[0].Applied)
+	require.True(t, status[last].Applied)
+	require.False(t, status[last].AppliedAt.IsZero())
+
+	docs.Description("applying migrations again is a no-op")
+	require.Nil(t, r.Migrate())
+
+	require.Nil(t, r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{ReferenceId: "EF1995-000001", Kind: "success", Message: "hello"}))
+
+	require.Nil(t, r.MigrateDown(last))
+	status, err = r.MigrationStatus()
+	require.Nil(t, err)
+	require.True(t, status[0].Applied)
+	require.False(t, status[1].Applied)
+	require.False(t, status[last].Applied)
+
+	require.Nil(t, r.Migrate())
+	entries, total, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+	require.Equal(t, "hello", entries[0].Message)
+	require.False(t, entries[0].Anonymized)
+
+	require.Nil(t, r.MigrateDown(len(status)+1))
+	status, err = r.MigrationStatus()
+	require.Nil(t, err)
+	require.False(t, status[0].Applied)
+	require.False(t, status[1].Applied)
+	require.False(t, r.db.Migrator().HasTable(&entity.ProtocolEntry{}))
+	require.False(t, r.db.Migrator().HasTable(&entity.Paylink{}))
+}
+
+func TestMigrateAdoptsAutoMigrateSchema(t *testing.T) {
+	docs.Description("databases created via AutoMigrate by earlier versions are adopted, keeping their data")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+
+	// the table as AutoMigrate created it, before the migrations were introduced
+	require.Nil(t, r.db.Exec("CREATE TABLE nexi_protocol_entries (id INTEGER PRIMARY KEY AUTOINCREMENT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME, "+
+		"reference_id TEXT NOT NULL, api_id TEXT, kind TEXT NOT NULL, message TEXT, details TEXT, request_id TEXT, anonymized NUMERIC NOT NULL DEFAULT false)").Error)
+	require.Nil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (reference_id, kind, message) VALUES ('EF1995-000001', 'success', 'hello')").Error)
+
+	require.Nil(t, r.Migrate())
+	status, err := r.MigrationStatus()
+	require.Nil(t, err)
+	for _, s := range status {
+		require.True(t, s.Applied, s.Name)
+	}
+
+	entries, total, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+	require.Equal(t, "hello", entries[0].Message)
+}
+
+func TestMysqlMigrationsSingleDdlStatement(t *testing.T) {
+	docs.Description("mysql commits DDL implicitly, so each mysql script is a single DDL statement or data changes only")
+	migrations, err := loadMigrations("mysql")
+	require.Nil(t, err)
+	for _, m := range migrations {
+		for _, script := range []string{m.up, m.down} {
+			statements := splitStatements(script)
+			ddl := 0
+			for _, statement := range statements {
+				keyword := strings.ToUpper(strings.Fields(statement)[0])
+				if keyword == "CREATE" || keyword == "ALTER" || keyword == "DROP" {
+					ddl++
+				}
+			}
+			require.True(t, ddl == 0 || len(statements) == 1, "migration %04d_%s mixes DDL with other statements", m.version, m.name)
+		}
+	}
+}
+
+func TestMigrateKindCheck(t *testing.T) {
+	docs.Description("the database rejects protocol entries with an invalid kind, even if the Go validation is bypassed")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+	require.Nil(t, r.Migrate())
+
+	require.Nil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (reference_id, kind, message) VALUES ('EF1995-000001', 'success', 'hello')").Error)
+	require.NotNil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (reference_id, kind, message) VALUES ('EF1995-000001', 'bogus', 'hello')").Error)
+	require.NotNil(t, r.db.Exec("UPDATE nexi_protocol_entries SET kind = 'bogus'").Error)
+}
+
+func TestMigrateBackfillsPaymentData(t *testing.T) {
+	docs.Description("amount and currency of entries written before they had columns of their own are taken from details")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+	require.Nil(t, r.Migrate())
+	require.Nil(t, r.MigrateDown(11))
+
+	for _, details := range []string{
+		"amount=18500 currency=EUR",
+		"amount=390 EUR verified=390 EUR",
+		"amount=18500 currency=EUR error=some error",
+		"code=00000000 desc=Transaktion erfolgreich",
+		"existing_amount=10500 ignored_amount=18500",
+	} {
+		require.Nil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (reference_id, kind, details) VALUES ('EF1995-000001', 'success', ?)", details).Error)
+	}
+	require.Nil(t, r.Migrate())
+
+	entries, _, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Len(t, entries, 5)
+	expected := []struct {
+		amount   *int64
+		currency string
+	}{
+		{amountPtr(18500), "EUR"},
+		{amountPtr(390), "EUR"},
+		{amountPtr(18500), "EUR"},
+		{nil, ""},
+		{nil, ""},
+	}
+	for idx, e := range expected {
+		require.Equal(t, e.amount, entries[idx].Amount, entries[idx].Details)
+		require.Equal(t, e.currency, entries[idx].Currency, entries[idx].Details)
+	}
+}
+
+func TestMigrateBackfillsPaylinks(t *testing.T) {
+	docs.Description("paylinks created before they were recorded are recovered from the create-pay-link protocol entries that have an amount")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+	require.Nil(t, r.Migrate())
+	require.Nil(t, r.MigrateDown(3))
+
+	for _, e := range []struct {
+		referenceId string
+		kind        string
+		message     string
+		details     string
+	}{
+		{"EF1995-000001-221216-122218-4132", "success", "create-pay-link", "http://localhost/old"},
+		{"EF1995-000001-221216-122218-4132", "success", "create-pay-link", "http://localhost/new"},
+		{"EF1995-000017-230001-122218-5555", "success", "create-pay-link", "http://localhost/other"},
+		{"ROOMS-B42", "success", "create-pay-link", "http://localhost/rooms"},
+		{"EF1995-000042-221216-122218-4132", "success", "create-pay-link", "http://localhost/unknown"},
+		{"EF1995-000023-230001-122218-5555", "error", "create-pay-link failed", "downstream unavailable"},
+		{"EF1995-000001-221216-122218-4132", "success", "get-payment", ""},
+	} {
+		require.Nil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (created_at, reference_id, kind, message, details, subject) VALUES (?, ?, ?, ?, ?, 'someone')",
+			time.Date(2022, 12, 16, 12, 22, 18, 0, time.UTC), e.referenceId, e.kind, e.message, e.details).Error)
+	}
+	require.Nil(t, r.db.Exec("UPDATE nexi_protocol_entries SET amount = 18500, currency = 'EUR' WHERE details IN ('http://localhost/new', 'http://localhost/rooms')").Error)
+	require.Nil(t, r.db.Exec("INSERT INTO nexi_paylinks (reference_id, debitor_id, amount, currency, vat_rate, link) VALUES ('EF1995-000017-230001-122218-5555', 17, 39000, 'EUR', 19, 'http://localhost/recorded')").Error)
+	require.Nil(t, r.Migrate())
+
+	paylinks, total, err := r.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(3), total)
+	require.Equal(t, "EF1995-000017-230001-122218-5555", paylinks[0].ReferenceId)
+	require.Equal(t, "http://localhost/recorded", paylinks[0].Link, "existing records must be kept")
+	require.Equal(t, "EF1995-000001-221216-122218-4132", paylinks[1].ReferenceId)
+	require.Equal(t, uint64(1), paylinks[1].DebitorId)
+	require.Equal(t, int64(18500), paylinks[1].Amount)
+	require.Equal(t, "EUR", paylinks[1].Currency)
+	require.Equal(t, "http://localhost/new", paylinks[1].Link, "the most recent entry must win")
+	require.Equal(t, "someone", paylinks[1].Subject)
+	require.Equal(t, 2022, paylinks[1].CreatedAt.Year())
+	require.Equal(t, "ROOMS-B42", paylinks[2].ReferenceId)
+	require.Equal(t, uint64(0), paylinks[2].DebitorId)
+	require.Equal(t, int64(18500), paylinks[2].Amount)
+	// EF1995-000042-... has no amount and currency, so it is not recorded with made up ones
+}
+
+func amountPtr(v int64) *int64 {
+	return &v
+}
+
+func TestMigrateBackfillsStatuses(t *testing.T) {
+	docs.Description("the statuses needed for the payment statistics are recovered from the messages of old entries")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+	require.Nil(t, r.Migrate())
+	require.Nil(t, r.MigrateDown(11))
+
+	for _, e := range []struct {
+		kind    string
+		message string
+	}{
+		{"success", "transaction updated successfully"},
+		{"success", "transaction updated successfully by status-check"},
+		{"pending", "transaction updated to PENDING"},
+		{"error", "webhook REFUNDED unknown status"},
+		{"success", "create-pay-link"},
+	} {
+		require.Nil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (reference_id, kind, message) VALUES ('EF1995-000001', ?, ?)", e.kind, e.message).Error)
+	}
+	require.Nil(t, r.Migrate())
+
+	entries, _, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t, "valid", entries[0].TransactionStatus)
+	require.Equal(t, "valid", entries[1].TransactionStatus)
+	require.Equal(t, "pending", entries[2].TransactionStatus)
+	require.Equal(t, "REFUNDED", entries[3].WebhookStatus)
+	require.Equal(t, "", entries[3].TransactionStatus)
+	require.Equal(t, "", entries[4].TransactionStatus)
+	require.Equal(t, "", entries[4].WebhookStatus)
+}
+
+func TestMigrateBackfillsOperations(t *testing.T) {
+	docs.Description("the operations needed for the payment statistics are recovered from the messages of old entries")
+	r := New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(":memory:")
+	}, Options{SingleConnection: true})
+	require.Nil(t, r.Open())
+	defer r.Close()
+	require.Nil(t, r.Migrate())
+	require.Nil(t, r.MigrateDown(2))
+
+	for _, e := range []struct {
+		kind    string
+		message string
+	}{
+		{"success", "create-pay-link"},
+		{"error", "create-pay-link failed"},
+		{"success", "refund"},
+		{"success", "cancel"},
+		{"error", "capture failed"},
+		{"success", "transaction updated successfully"},
+	} {
+		require.Nil(t, r.db.Exec("INSERT INTO nexi_protocol_entries (reference_id, kind, message) VALUES ('EF1995-000001', ?, ?)", e.kind, e.message).Error)
+	}
+	require.Nil(t, r.Migrate())
+
+	entries, _, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Len(t, entries, 6)
+	require.Equal(t, entity.OperationCreate, entries[0].Operation)
+	require.Equal(t, entity.OperationCreate, entries[1].Operation)
+	require.Equal(t, entity.OperationRefund, entries[2].Operation)
+	require.Equal(t, entity.OperationCancel, entries[3].Operation)
+	require.Equal(t, entity.OperationCapture, entries[4].Operation)
+	require.Equal(t, "", entries[5].Operation)
+}
@@ -2,12 +2,15 @@ package database
 
 import (
 	"os"
+	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/inmemorydb"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/mysqldb"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/postgresdb"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/sqlitedb"
 )
 
 var (
@@ -24,6 +27,12 @@ func Open() error {
 	if config.DatabaseUse() == "mysql" {
 		aulogging.Logger.NoCtx().Info().Print("Opening mysql database...")
 		r = mysqldb.Create()
+	} else if config.DatabaseUse() == "postgres" {
+		aulogging.Logger.NoCtx().Info().Print("Opening postgres database...")
+		r = postgresdb.Create()
+	} else if config.DatabaseUse() == "sqlite" {
+		aulogging.Logger.NoCtx().Info().Print("Opening sqlite database...")
+		r = sqlitedb.Create()
 	} else {
 		aulogging.Logger.NoCtx().Warn().Print("Opening inmemory database (not useful for production!)...")
 		r = inmemorydb.Create()
@@ -49,6 +58,33 @@ func MigrateIfSwitchedOn() (err error) {
 	return
 }
 
+func MigrateDown() error {
+	steps := config.MigrateDatabaseDownSteps()
+	aulogging.Logger.NoCtx().Info().Printf("Rolling back %d database migration(s)...", steps)
+	return GetRepository().MigrateDown(steps)
+}
+
+func LogMigrationStatus() error {
+	status, err := GetRepository().MigrationStatus()
+	if err != nil {
+		aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to read database migration status: %s", err.Error())
+		return err
+	}
+	if len(status) == 0 {
+		aulogging.Logger.NoCtx().Info().Print("No database migrations for this database type.")
+	}
+	for _, s := range status {
+		if s.Unknown {
+			aulogging.Logger.NoCtx().Warn().Printf("migration %04d: applied %s, unknown to this version of the service", s.Version, s.AppliedAt.Format(time.RFC3339))
+		} else if s.Applied {
+			aulogging.Logger.NoCtx().Info().Printf("migration %04d_%s: applied %s", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+		} else {
+			aulogging.Logger.NoCtx().Info().Printf("migration %04d_%s: pending", s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
 func GetRepository() dbrepo.Repository {
 	if ActiveRepository == nil {
 		aulogging.Logger.NoCtx().Error().Print("You must Open() the database before using it. This is an error in your implementation.")
@@ -2,32 +2,57 @@ package inmemorydb
 
 import (
 	"context"
-	"sync/atomic"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 )
 
+// InMemoryRepository keeps the protocol in memory. It is safe for concurrent use.
+//
+// If MaxEntries is set, the protocol is a ring buffer that drops the oldest entries once full.
 type InMemoryRepository struct {
-	protocol   []*entity.ProtocolEntry
-	idSequence uint32
+	mu         sync.RWMutex
+	protocol   []*entity.ProtocolEntry // in ring buffer mode, the oldest entry is at index start
+	start      int
+	idSequence uint
+	paylinks   []*entity.Paylink
+	MaxEntries int
 	Now        func() time.Time
 }
 
 func Create() dbrepo.Repository {
 	return &InMemoryRepository{
-		Now: time.Now,
+		MaxEntries: config.DatabaseInmemoryMaxEntries(),
+		Now:        time.Now,
 	}
 }
 
 func (r *InMemoryRepository) Open() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.protocol = make([]*entity.ProtocolEntry, 0)
+	r.start = 0
+	r.paylinks = make([]*entity.Paylink, 0)
 	return nil
 }
 
 func (r *InMemoryRepository) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.protocol = nil
+	r.start = 0
+	r.paylinks = nil
+}
+
+func (r *InMemoryRepository) Ping(ctx context.Context) error {
+	// always reachable
+	return nil
 }
 
 func (r *InMemoryRepository) Migrate() error {
@@ -35,26 +60,332 @@ func (r *InMemoryRepository) Migrate() error {
 	return nil
 }
 
+func (r *InMemoryRepository) MigrateDown(steps int) error {
+	// nothing to do
+	return nil
+}
+
+func (r *InMemoryRepository) MigrationStatus() ([]dbrepo.MigrationStatus, error) {
+	// no schema, so no migrations
+	return []dbrepo.MigrationStatus{}, nil
+}
+
 // --- log entries ---
 
 func (r *InMemoryRepository) WriteProtocolEntry(ctx context.Context, e *entity.ProtocolEntry) error {
-	newId := uint(atomic.AddUint32(&r.idSequence, 1))
-	e.ID = newId
+	if !e.Kind.Valid() {
+		return dbrepo.ErrInvalidProtocolKind
+	}
+	if e.Subject == "" {
+		e.Subject = ctxvalues.Subject(ctx)
+	}
+	if e.Client == "" {
+		e.Client = ctxvalues.ApiClient(ctx)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.idSequence++
+	e.ID = r.idSequence
 
-	// copy the attendee, so later modifications won't also modify it in the simulated db
-	copiedEntry := *e
-	copiedEntry.CreatedAt = time.Now()
-	r.protocol = append(r.protocol, &copiedEntry)
+	// copy the entry, so later modifications won't also modify it in the simulated db
+	copiedEntry := copyEntry(e)
+	copiedEntry.CreatedAt = r.Now()
+	if r.MaxEntries > 0 && len(r.protocol) >= r.MaxEntries {
+		// overwrite the oldest entry
+		r.protocol[r.start] = &copiedEntry
+		r.start = (r.start + 1) % len(r.protocol)
+	} else {
+		r.protocol = append(r.protocol, &copiedEntry)
+	}
 	return nil
 }
 
+func (r *InMemoryRepository) QueryProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery) ([]*entity.ProtocolEntry, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*entity.ProtocolEntry, 0)
+	total := int64(0)
+	for _, e := range r.ordered() {
+		if !matchesProtocolQuery(e, query) {
+			continue
+		}
+		total++
+		if total <= int64(query.Offset) {
+			continue
+		}
+		if query.Limit > 0 && len(result) >= query.Limit {
+			continue
+		}
+		copiedEntry := copyEntry(e)
+		result = append(result, &copiedEntry)
+	}
+	return result, total, nil
+}
+
+func (r *InMemoryRepository) ForEachProtocolEntry(ctx context.Context, query dbrepo.ProtocolQuery, fn func(e *entity.ProtocolEntry) error) error {
+	query.Offset = 0
+	query.Limit = 0
+	// copy first, fn must be able to use the repository without running into the lock
+	entries, _, _ := r.QueryProtocolEntries(ctx, query)
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) SummarizeProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery) ([]dbrepo.ProtocolSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]dbrepo.ProtocolSummary, 0)
+	index := make(map[dbrepo.ProtocolSummary]int)
+	for _, e := range r.ordered() {
+		if !matchesProtocolQuery(e, query) {
+			continue
+		}
+		key := dbrepo.ProtocolSummary{
+			Kind:              e.Kind,
+			Operation:         e.Operation,
+			UpstreamStatus:    e.UpstreamStatus,
+			WebhookStatus:     e.WebhookStatus,
+			TransactionStatus: e.TransactionStatus,
+			PaymentMethod:     e.PaymentMethod,
+			Currency:          e.Currency,
+		}
+		idx, ok := index[key]
+		if !ok {
+			idx = len(result)
+			index[key] = idx
+			result = append(result, key)
+		}
+		result[idx].Count++
+		if e.Amount != nil {
+			result[idx].Volume += *e.Amount
+		}
+	}
+	return result, nil
+}
+
+func (r *InMemoryRepository) AnonymizeProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery, anonymize func(details string) string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := int64(0)
+	for idx, e := range r.protocol {
+		if e.Anonymized || !matchesProtocolQuery(e, query) {
+			continue
+		}
+		// replace rather than modify, entries handed out earlier are copies but may still be read elsewhere
+		copiedEntry := copyEntry(e)
+		copiedEntry.Details = anonymize(e.Details)
+		copiedEntry.Anonymized = true
+		r.protocol[idx] = &copiedEntry
+		count++
+	}
+	return count, nil
+}
+
+func (r *InMemoryRepository) DeleteProtocolEntries(ctx context.Context, query dbrepo.ProtocolQuery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := make([]*entity.ProtocolEntry, 0, len(r.protocol))
+	for _, e := range r.ordered() {
+		if !matchesProtocolQuery(e, query) {
+			remaining = append(remaining, e)
+		}
+	}
+	count := int64(len(r.protocol) - len(remaining))
+	r.protocol = remaining
+	r.start = 0
+	return count, nil
+}
+
+// ordered returns the entries oldest first. The caller must hold the lock.
+func (r *InMemoryRepository) ordered() []*entity.ProtocolEntry {
+	if r.start == 0 {
+		return r.protocol
+	}
+	return append(slices.Clone(r.protocol[r.start:]), r.protocol[:r.start]...)
+}
+
+// copyEntry returns a deep copy, so the entries in the simulated db cannot be modified from outside.
+func copyEntry(e *entity.ProtocolEntry) entity.ProtocolEntry {
+	copied := *e
+	if e.Amount != nil {
+		amount := *e.Amount
+		copied.Amount = &amount
+	}
+	return copied
+}
+
+func matchesProtocolQuery(e *entity.ProtocolEntry, query dbrepo.ProtocolQuery) bool {
+	if query.ReferenceId != "" && e.ReferenceId != query.ReferenceId {
+		return false
+	}
+	if query.ReferenceIdPrefix != "" && !strings.HasPrefix(e.ReferenceId, query.ReferenceIdPrefix) {
+		return false
+	}
+	if query.ApiId != "" && e.ApiId != query.ApiId {
+		return false
+	}
+	if len(query.Kinds) > 0 && !slices.Contains(query.Kinds, e.Kind) {
+		return false
+	}
+	if slices.Contains(query.ExcludeKinds, e.Kind) {
+		return false
+	}
+	if query.RequestId != "" && e.RequestId != query.RequestId {
+		return false
+	}
+	if query.Currency != "" && e.Currency != query.Currency {
+		return false
+	}
+	if query.UpstreamStatus != "" && e.UpstreamStatus != query.UpstreamStatus {
+		return false
+	}
+	if query.WebhookStatus != "" && e.WebhookStatus != query.WebhookStatus {
+		return false
+	}
+	if query.TransactionStatus != "" && e.TransactionStatus != query.TransactionStatus {
+		return false
+	}
+	if !query.CreatedAfter.IsZero() && e.CreatedAt.Before(query.CreatedAfter) {
+		return false
+	}
+	if !query.CreatedBefore.IsZero() && !e.CreatedAt.Before(query.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// --- paylinks ---
+
+func (r *InMemoryRepository) WritePaylink(ctx context.Context, p *entity.Paylink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.idSequence++
+	p.ID = r.idSequence
+
+	copiedPaylink := copyPaylink(p)
+	copiedPaylink.CreatedAt = r.Now()
+	r.paylinks = slices.DeleteFunc(r.paylinks, func(existing *entity.Paylink) bool {
+		return existing.ReferenceId == p.ReferenceId
+	})
+	r.paylinks = append(r.paylinks, &copiedPaylink)
+	return nil
+}
+
+func (r *InMemoryRepository) UpdatePaylinkStatus(ctx context.Context, referenceId string, apiId string, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for idx, p := range r.paylinks {
+		if p.ReferenceId != referenceId {
+			continue
+		}
+		// replace rather than modify, records handed out earlier may still be read elsewhere
+		copiedPaylink := copyPaylink(p)
+		now := r.Now()
+		copiedPaylink.Status = status
+		copiedPaylink.StatusAt = &now
+		if apiId != "" {
+			copiedPaylink.ApiId = apiId
+		}
+		r.paylinks[idx] = &copiedPaylink
+	}
+	return nil
+}
+
+func (r *InMemoryRepository) QueryPaylinks(ctx context.Context, query dbrepo.PaylinkQuery) ([]*entity.Paylink, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*entity.Paylink, 0)
+	total := int64(0)
+	for _, p := range r.paylinks {
+		if !matchesPaylinkQuery(p, query) {
+			continue
+		}
+		total++
+		if total <= int64(query.Offset) {
+			continue
+		}
+		if query.Limit > 0 && len(result) >= query.Limit {
+			continue
+		}
+		copiedPaylink := copyPaylink(p)
+		result = append(result, &copiedPaylink)
+	}
+	return result, total, nil
+}
+
+func (r *InMemoryRepository) DeletePaylinks(ctx context.Context, query dbrepo.PaylinkQuery) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := len(r.paylinks)
+	r.paylinks = slices.DeleteFunc(r.paylinks, func(p *entity.Paylink) bool {
+		return matchesPaylinkQuery(p, query)
+	})
+	return int64(before - len(r.paylinks)), nil
+}
+
+// copyPaylink returns a deep copy, so the records in the simulated db cannot be modified from outside.
+func copyPaylink(p *entity.Paylink) entity.Paylink {
+	copied := *p
+	if p.StatusAt != nil {
+		statusAt := *p.StatusAt
+		copied.StatusAt = &statusAt
+	}
+	return copied
+}
+
+func matchesPaylinkQuery(p *entity.Paylink, query dbrepo.PaylinkQuery) bool {
+	if query.ReferenceId != "" && p.ReferenceId != query.ReferenceId {
+		return false
+	}
+	if query.DebitorId != 0 && p.DebitorId != query.DebitorId {
+		return false
+	}
+	if query.Status != "" && p.Status != query.Status {
+		return false
+	}
+	if !query.CreatedAfter.IsZero() && p.CreatedAt.Before(query.CreatedAfter) {
+		return false
+	}
+	if !query.CreatedBefore.IsZero() && !p.CreatedAt.Before(query.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
 // --- testing ---
 
+// ProtocolEntries returns copies of all entries, oldest first.
 func (r *InMemoryRepository) ProtocolEntries() []*entity.ProtocolEntry {
-	return r.protocol
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]*entity.ProtocolEntry, 0, len(r.protocol))
+	for _, e := range r.ordered() {
+		copiedEntry := copyEntry(e)
+		result = append(result, &copiedEntry)
+	}
+	return result
 }
 
 func (r *InMemoryRepository) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.protocol = make([]*entity.ProtocolEntry, 0)
+	r.start = 0
 	r.idSequence = 0
+	r.paylinks = make([]*entity.Paylink, 0)
 }
@@ -0,0 +1,111 @@
+package inmemorydb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/stretchr/testify/require"
+)
+
+func tstRepository(maxEntries int) *InMemoryRepository {
+	r := &InMemoryRepository{MaxEntries: maxEntries, Now: time.Now}
+	_ = r.Open()
+	return r
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	docs.Description("concurrent writes and queries neither race nor lose entries (run with -race)")
+	r := tstRepository(0)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+					ReferenceId: fmt.Sprintf("ref-%d", i),
+					Kind:        "success",
+				})
+				_, _, _ = r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{ReferenceId: "ref-0", Limit: 5})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	entries, total, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(1000), total)
+	ids := make(map[uint]bool)
+	for _, e := range entries {
+		ids[e.ID] = true
+	}
+	require.Equal(t, 1000, len(ids), "ids must be unique")
+}
+
+func TestRingBuffer(t *testing.T) {
+	docs.Description("with max entries set, the oldest entries are dropped, and queries see the rest in order")
+	r := tstRepository(3)
+	for i := 1; i <= 7; i++ {
+		kind := entity.KindSuccess
+		if i%2 == 0 {
+			kind = entity.KindError
+		}
+		_ = r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{Kind: kind, Message: fmt.Sprintf("entry %d", i)})
+	}
+
+	entries, total, err := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(3), total)
+	require.Equal(t, "entry 5", entries[0].Message)
+	require.Equal(t, "entry 6", entries[1].Message)
+	require.Equal(t, "entry 7", entries[2].Message)
+
+	entries, total, err = r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{Kinds: []entity.ProtocolKind{entity.KindSuccess}, Offset: 1})
+	require.Nil(t, err)
+	require.Equal(t, int64(2), total)
+	require.Equal(t, 1, len(entries))
+	require.Equal(t, "entry 7", entries[0].Message)
+
+	deleted, err := r.DeleteProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{Kinds: []entity.ProtocolKind{entity.KindError}})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), deleted)
+
+	_ = r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{Kind: "success", Message: "entry 8"})
+	_ = r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{Kind: "success", Message: "entry 9"})
+	entries = r.ProtocolEntries()
+	require.Equal(t, 3, len(entries))
+	require.Equal(t, "entry 7", entries[0].Message)
+	require.Equal(t, "entry 8", entries[1].Message)
+	require.Equal(t, "entry 9", entries[2].Message)
+	require.Equal(t, uint(9), entries[2].ID)
+}
+
+func TestReturnedEntriesAreCopies(t *testing.T) {
+	docs.Description("modifying entries returned by a query does not modify the stored entries")
+	r := tstRepository(0)
+	amount := int64(18500)
+	_ = r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{Kind: "success", Message: "original", Amount: &amount})
+	amount = 1
+
+	entries, _, _ := r.QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	entries[0].Message = "modified"
+	*entries[0].Amount = 2
+
+	require.Equal(t, "original", r.ProtocolEntries()[0].Message)
+	require.Equal(t, int64(18500), *r.ProtocolEntries()[0].Amount)
+}
+
+func TestWriteProtocolEntry_InvalidKind(t *testing.T) {
+	docs.Description("entries with a kind that is not one of the known kinds are rejected")
+	r := tstRepository(0)
+	err := r.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{Kind: "failure", Message: "hello"})
+	require.ErrorIs(t, err, dbrepo.ErrInvalidProtocolKind)
+	require.Equal(t, 0, len(r.ProtocolEntries()))
+}
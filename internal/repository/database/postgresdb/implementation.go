@@ -0,0 +1,15 @@
+package postgresdb
+
+import (
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/gormdb"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func Create() dbrepo.Repository {
+	return gormdb.New("postgres", func() gorm.Dialector {
+		return postgres.Open(config.DatabasePostgresConnectString())
+	}, gormdb.Options{})
+}
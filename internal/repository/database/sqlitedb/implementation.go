@@ -0,0 +1,18 @@
+package sqlitedb
+
+import (
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/gormdb"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// Create uses a pure go sqlite driver, so no cgo is required to build the service.
+func Create() dbrepo.Repository {
+	return gormdb.New("sqlite", func() gorm.Dialector {
+		return sqlite.Open(config.DatabaseSqliteConnectString())
+	}, gormdb.Options{
+		SingleConnection: true,
+	})
+}
@@ -0,0 +1,187 @@
+package idp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+)
+
+// minRefetchInterval limits how often an unknown key id causes the key set to be fetched before the cache expires,
+// so tokens with made up key ids cannot be used to flood the identity provider.
+const minRefetchInterval = time.Minute
+
+type Impl struct {
+	jwksURL       string
+	cacheDuration time.Duration
+	fixedKeys     []any
+	client        *http.Client
+	now           func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]any
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func newClient(jwksURL string, cacheDuration time.Duration, fixedKeys []any) *Impl {
+	return &Impl{
+		jwksURL:       jwksURL,
+		cacheDuration: cacheDuration,
+		fixedKeys:     fixedKeys,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		now:           time.Now,
+		keys:          make(map[string]any),
+	}
+}
+
+func (i *Impl) VerificationKeys(ctx context.Context, kid string) ([]any, error) {
+	result := make([]any, 0)
+	if i.jwksURL != "" {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		if i.needsFetch(kid) {
+			i.attemptedAt = i.now()
+			keys, err := i.fetch(ctx)
+			if err != nil {
+				// keep using what we have, the identity provider may just be down for a moment
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("failed to fetch signing keys from %s: %s", i.jwksURL, err.Error())
+				if len(i.keys) == 0 && len(i.fixedKeys) == 0 {
+					return nil, DownstreamError
+				}
+			} else {
+				i.keys = keys
+				i.fetchedAt = i.attemptedAt
+			}
+		}
+
+		if kid == "" {
+			for _, key := range i.keys {
+				result = append(result, key)
+			}
+		} else if key, ok := i.keys[kid]; ok {
+			result = append(result, key)
+		}
+	}
+
+	result = append(result, i.fixedKeys...)
+	if len(result) == 0 {
+		return nil, NoKeysError
+	}
+	return result, nil
+}
+
+// needsFetch is true if the cached key set has expired or does not contain kid.
+func (i *Impl) needsFetch(kid string) bool {
+	now := i.now()
+	expired := now.Sub(i.fetchedAt) >= i.cacheDuration
+	_, known := i.keys[kid]
+	return (expired || kid != "" && !known) && now.Sub(i.attemptedAt) >= minRefetchInterval
+}
+
+// --- parsing the key set ---
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (i *Impl) fetch(ctx context.Context) (map[string]any, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, i.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := i.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %d", response.StatusCode)
+	}
+
+	set := jwkSet{}
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to parse key set: %s", err.Error())
+	}
+
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().Printf("ignoring signing key %s: %s", k.Kid, err.Error())
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid base64url value")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
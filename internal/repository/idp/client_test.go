@@ -0,0 +1,101 @@
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/stretchr/testify/require"
+)
+
+func tstJwksServer(t *testing.T, key *rsa.PublicKey, kid string) (*httptest.Server, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"%s","use":"sig","alg":"RS256","n":"%s","e":"%s"},{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"}]}`,
+			kid,
+			base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+	}))
+	t.Cleanup(server.Close)
+	return server, calls
+}
+
+func tstKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	return key
+}
+
+func TestVerificationKeys_Cached(t *testing.T) {
+	docs.Description("the key set is fetched once and then served from the cache until it expires")
+	key := tstKey(t)
+	server, calls := tstJwksServer(t, &key.PublicKey, "k1")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cut := newClient(server.URL, time.Hour, nil)
+	cut.now = func() time.Time { return now }
+
+	keys, err := cut.VerificationKeys(context.TODO(), "k1")
+	require.Nil(t, err)
+	require.Equal(t, []any{&key.PublicKey}, keys)
+
+	now = now.Add(59 * time.Minute)
+	_, err = cut.VerificationKeys(context.TODO(), "")
+	require.Nil(t, err)
+	require.Equal(t, int32(1), calls.Load())
+
+	now = now.Add(time.Minute)
+	_, err = cut.VerificationKeys(context.TODO(), "k1")
+	require.Nil(t, err)
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestVerificationKeys_UnknownKid(t *testing.T) {
+	docs.Description("an unknown key id causes a refetch, but at most once a minute")
+	key := tstKey(t)
+	server, calls := tstJwksServer(t, &key.PublicKey, "k1")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cut := newClient(server.URL, time.Hour, nil)
+	cut.now = func() time.Time { return now }
+
+	_, err := cut.VerificationKeys(context.TODO(), "k1")
+	require.Nil(t, err)
+
+	now = now.Add(30 * time.Second)
+	_, err = cut.VerificationKeys(context.TODO(), "k2")
+	require.ErrorIs(t, err, NoKeysError)
+	require.Equal(t, int32(1), calls.Load())
+
+	now = now.Add(30 * time.Second)
+	_, err = cut.VerificationKeys(context.TODO(), "k2")
+	require.ErrorIs(t, err, NoKeysError)
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestVerificationKeys_Unavailable(t *testing.T) {
+	docs.Description("if the identity provider is unavailable, fixed keys are still used")
+	key := tstKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cut := newClient(server.URL, time.Hour, nil)
+	_, err := cut.VerificationKeys(context.TODO(), "")
+	require.ErrorIs(t, err, DownstreamError)
+
+	cut = newClient(server.URL, time.Hour, []any{&key.PublicKey})
+	keys, err := cut.VerificationKeys(context.TODO(), "k1")
+	require.Nil(t, err)
+	require.Equal(t, []any{&key.PublicKey}, keys)
+}
@@ -0,0 +1,46 @@
+package idp
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+)
+
+var activeInstance IdentityProvider
+
+// Create sets up the identity provider, or leaves it nil if bearer tokens are not configured.
+func Create() (err error) {
+	if !config.OidcEnabled() {
+		aulogging.Logger.NoCtx().Info().Print("security.oidc not configured. Bearer tokens will not be accepted")
+		activeInstance = nil
+		return nil
+	}
+
+	fixedKeys := make([]any, 0, len(config.OidcTokenPublicKeys()))
+	for _, keyPEM := range config.OidcTokenPublicKeys() {
+		key, err := parsePublicKeyPEM(keyPEM)
+		if err != nil {
+			aulogging.Logger.NoCtx().Error().WithErr(err).Printf("failed to parse token public key: %s", err.Error())
+			return err
+		}
+		fixedKeys = append(fixedKeys, key)
+	}
+
+	activeInstance = newClient(config.OidcJwksURL(), config.OidcJwksCacheDuration(), fixedKeys)
+	return nil
+}
+
+func Get() IdentityProvider {
+	return activeInstance
+}
+
+func parsePublicKeyPEM(keyPEM string) (any, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
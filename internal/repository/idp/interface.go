@@ -0,0 +1,19 @@
+package idp
+
+import (
+	"context"
+	"errors"
+)
+
+// IdentityProvider supplies the public keys used to verify the signatures of bearer tokens.
+type IdentityProvider interface {
+	// VerificationKeys returns the keys that may have signed a token with the given key id.
+	//
+	// If kid is empty, all known keys are returned.
+	VerificationKeys(ctx context.Context, kid string) ([]any, error)
+}
+
+var (
+	NoKeysError     = errors.New("no signing keys available for bearer tokens")
+	DownstreamError = errors.New("identity provider unavailable - see log for details")
+)
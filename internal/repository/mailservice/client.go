@@ -11,11 +11,16 @@ import (
 	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
 	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/middleware"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
 )
 
+// CircuitBreakerName identifies the circuit breaker protecting calls to this service, e.g. in metrics.
+const CircuitBreakerName = "mail-service-breaker"
+
 type Impl struct {
 	client  aurestclientapi.Client
 	baseUrl string
@@ -24,6 +29,7 @@ type Impl struct {
 func requestManipulator(ctx context.Context, r *http.Request) {
 	r.Header.Add(media.HeaderXApiKey, config.FixedApiToken())
 	r.Header.Add(middleware.TraceIdHeader, ctxvalues.RequestId(ctx))
+	tracing.Inject(ctx, r.Header)
 }
 
 func newClient() (MailService, error) {
@@ -35,7 +41,7 @@ func newClient() (MailService, error) {
 	requestLoggingClient := aurestlogging.New(httpClient)
 
 	circuitBreakerClient := aurestbreaker.New(requestLoggingClient,
-		"mail-service-breaker",
+		CircuitBreakerName,
 		10,
 		2*time.Minute,
 		30*time.Second,
@@ -43,7 +49,7 @@ func newClient() (MailService, error) {
 	)
 
 	return &Impl{
-		client:  circuitBreakerClient,
+		client:  tracing.NewClient(metrics.NewClient(circuitBreakerClient, "mail-service"), "mail-service"),
 		baseUrl: config.MailServiceBaseUrl(),
 	}, nil
 }
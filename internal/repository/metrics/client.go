@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	aurestbreaker "github.com/StephanHCB/go-autumn-restclient-circuitbreaker/implementation/breaker"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	OutcomeSuccess     = "success"
+	OutcomeClientError = "client_error"
+	OutcomeServerError = "server_error"
+	OutcomeError       = "error"
+	OutcomeRejected    = "rejected"
+)
+
+var breakerStates = map[string]float64{
+	gobreaker.StateClosed.String():   0,
+	gobreaker.StateHalfOpen.String(): 1,
+	gobreaker.StateOpen.String():     2,
+}
+
+// lastBreakerStates maps circuit breaker names to their state, so it can be reported without parsing the gauge.
+var lastBreakerStates sync.Map
+
+type clientImpl struct {
+	wrapped aurestclientapi.Client
+	target  string
+}
+
+// NewClient wraps a client so that all its requests are counted and timed under the given target.
+//
+// Wrap the circuit breaker client, so requests the breaker rejects are counted, too. The state of the
+// circuit breaker is published as well.
+func NewClient(wrapped aurestclientapi.Client, target string) aurestclientapi.Client {
+	if cb, ok := wrapped.(*aurestbreaker.Impl); ok {
+		circuitBreakerState.WithLabelValues(cb.Name).Set(breakerStates[cb.CB.State().String()])
+		aurestbreaker.Instrument(wrapped, breakerStateChanged, nil)
+	}
+	return &clientImpl{
+		wrapped: wrapped,
+		target:  target,
+	}
+}
+
+func (c *clientImpl) Perform(ctx context.Context, method string, requestUrl string, requestBody interface{}, response *aurestclientapi.ParsedResponse) error {
+	start := time.Now()
+	err := c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	outcome := outcomeOf(response.Status, err)
+	downstreamRequests.WithLabelValues(c.target, outcome).Inc()
+	downstreamRequestDuration.WithLabelValues(c.target, outcome).Observe(time.Since(start).Seconds())
+	return err
+}
+
+func outcomeOf(status int, err error) string {
+	switch {
+	case errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests):
+		return OutcomeRejected
+	case status >= 500:
+		return OutcomeServerError
+	case err != nil:
+		return OutcomeError
+	case status >= 400:
+		return OutcomeClientError
+	default:
+		return OutcomeSuccess
+	}
+}
+
+func breakerStateChanged(circuitBreakerName string, state string) {
+	circuitBreakerState.WithLabelValues(circuitBreakerName).Set(breakerStates[state])
+	lastBreakerStates.Store(circuitBreakerName, state)
+}
+
+// CircuitBreakerState is the last known state of the named circuit breaker: closed, half-open or open.
+func CircuitBreakerState(circuitBreakerName string) string {
+	if state, ok := lastBreakerStates.Load(circuitBreakerName); ok {
+		return state.(string)
+	}
+	return gobreaker.StateClosed.String()
+}
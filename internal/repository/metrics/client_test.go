@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	aurestbreaker "github.com/StephanHCB/go-autumn-restclient-circuitbreaker/implementation/breaker"
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type tstClient struct {
+	status int
+	err    error
+}
+
+func (c *tstClient) Perform(_ context.Context, _ string, _ string, _ interface{}, response *aurestclientapi.ParsedResponse) error {
+	response.Status = c.status
+	return c.err
+}
+
+func TestOutcomes(t *testing.T) {
+	docs.Description("downstream requests are counted by target and outcome")
+	for _, tc := range []struct {
+		status  int
+		err     error
+		outcome string
+	}{
+		{http.StatusOK, nil, OutcomeSuccess},
+		{http.StatusNotFound, nil, OutcomeClientError},
+		{http.StatusBadGateway, nil, OutcomeServerError},
+		{0, errors.New("connection refused"), OutcomeError},
+	} {
+		before := testutil.ToFloat64(downstreamRequests.WithLabelValues("test-outcomes", tc.outcome))
+		client := NewClient(&tstClient{status: tc.status, err: tc.err}, "test-outcomes")
+		_ = client.Perform(context.TODO(), http.MethodGet, "http://localhost/", nil, &aurestclientapi.ParsedResponse{})
+		require.Equal(t, before+1, testutil.ToFloat64(downstreamRequests.WithLabelValues("test-outcomes", tc.outcome)), tc.outcome)
+	}
+}
+
+func TestCircuitBreakerState(t *testing.T) {
+	docs.Description("the circuit breaker state is published, and rejected requests are counted")
+	breaker := aurestbreaker.New(&tstClient{status: http.StatusInternalServerError}, "test-breaker", 1, time.Minute, time.Minute, time.Second)
+	client := NewClient(breaker, "test-breaker-target")
+	require.Equal(t, float64(0), testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-breaker")))
+
+	// gobreaker trips after more than 5 consecutive failures by default
+	for range 6 {
+		_ = client.Perform(context.TODO(), http.MethodGet, "http://localhost/", nil, &aurestclientapi.ParsedResponse{})
+	}
+	require.Equal(t, float64(2), testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-breaker")))
+	require.Equal(t, float64(6), testutil.ToFloat64(downstreamRequests.WithLabelValues("test-breaker-target", OutcomeServerError)))
+
+	err := client.Perform(context.TODO(), http.MethodGet, "http://localhost/", nil, &aurestclientapi.ParsedResponse{})
+	require.NotNil(t, err)
+	require.Equal(t, float64(1), testutil.ToFloat64(downstreamRequests.WithLabelValues("test-breaker-target", OutcomeRejected)))
+}
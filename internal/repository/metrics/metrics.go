@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "paygate"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Inbound http requests by method, route and response status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of inbound http requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	downstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downstream_requests_total",
+		Help:      "Outbound requests to downstream services by target and outcome.",
+	}, []string{"target", "outcome"})
+
+	downstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "downstream_request_duration_seconds",
+		Help:      "Latency of outbound requests to downstream services by target and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"target", "outcome"})
+
+	circuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breakers: 0 = closed, 1 = half-open, 2 = open.",
+	}, []string{"name"})
+
+	webhooks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_total",
+		Help:      "Received webhooks by payment status and processing outcome.",
+	}, []string{"status", "outcome"})
+
+	webhooksRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_rejected_total",
+		Help:      "Webhook and weblogger calls rejected because their source ip is not allowed, by endpoint and whether they knew the secret.",
+	}, []string{"endpoint", "secret"})
+
+	notifyMails = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notify_mails_total",
+		Help:      "Error notification mails by operation and result.",
+	}, []string{"operation", "result"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Inbound requests rejected by the rate limiter by route group and whether they were limited per client or per ip.",
+	}, []string{"group", "limited_by"})
+)
+
+// Handler serves all metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RequestServed records an inbound request. route is the route pattern, so path parameters do not add labels.
+func RequestServed(method string, route string, status int, seconds float64) {
+	httpRequests.WithLabelValues(method, route, statusLabel(status)).Inc()
+	httpRequestDuration.WithLabelValues(method, route).Observe(seconds)
+}
+
+// RequestRateLimited records a rejected request. limitedBy is client or ip, the ip itself is not recorded.
+func RequestRateLimited(group string, limitedBy string) {
+	rateLimited.WithLabelValues(group, limitedBy).Inc()
+}
+
+// WebhookProcessed records a webhook. Status is the payment status the webhook reported.
+func WebhookProcessed(status string, outcome string) {
+	webhooks.WithLabelValues(status, outcome).Inc()
+}
+
+// WebhookRejected records a webhook or weblogger call from a source ip that is not allowed. Secret is valid or invalid,
+// the ip itself is not recorded.
+func WebhookRejected(endpoint string, secret string) {
+	webhooksRejected.WithLabelValues(endpoint, secret).Inc()
+}
+
+// NotifyMailSent records an error notification mail. Result is one of sent, failed, not_configured.
+func NotifyMailSent(operation string, result string) {
+	notifyMails.WithLabelValues(operation, result).Inc()
+}
+
+func statusLabel(status int) string {
+	if status == 0 {
+		// nothing was written, which net/http answers with 200
+		status = http.StatusOK
+	}
+	return strconv.Itoa(status)
+}
@@ -6,12 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"net/url"
 	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/redaction"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 
 	aurestbreaker "github.com/StephanHCB/go-autumn-restclient-circuitbreaker/implementation/breaker"
@@ -19,9 +20,14 @@ import (
 	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
 	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 	"github.com/go-http-utils/headers"
 )
 
+// CircuitBreakerName identifies the circuit breaker protecting calls to this service, e.g. in metrics.
+const CircuitBreakerName = "nexi-downstream-breaker"
+
 type Impl struct {
 	client  aurestclientapi.Client
 	baseUrl string
@@ -48,7 +54,7 @@ func newClient() (NexiDownstream, error) {
 	requestLoggingClient := aurestlogging.New(httpClient)
 
 	circuitBreakerClient := aurestbreaker.New(requestLoggingClient,
-		"nexi-downstream-breaker",
+		CircuitBreakerName,
 		10,
 		2*time.Minute,
 		30*time.Second,
@@ -56,7 +62,7 @@ func newClient() (NexiDownstream, error) {
 	)
 
 	return &Impl{
-		client:  circuitBreakerClient,
+		client:  tracing.NewClient(metrics.NewClient(circuitBreakerClient, "nexi"), "nexi"),
 		baseUrl: config.NexiDownstreamBaseUrl(),
 	}, nil
 }
@@ -80,15 +86,9 @@ func (i *Impl) CreatePaymentLink(ctx context.Context, request NexiCreateCheckout
 		return NexiCreateCheckoutSessionResponse{}, fmt.Errorf("failed to marshal request: %v", err)
 	}
 	if config.LogFullRequests() {
-		db := database.GetRepository()
-		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: request.TransId,
-			Kind:        "raw",
-			Message:     "nexi create request",
-			Details:     string(requestBody),
-			RequestId:   ctxvalues.RequestId(ctx),
-		})
-		aulogging.Logger.Ctx(ctx).Info().Print("nexi create request: " + string(requestBody))
+		redacted := redaction.Redact(string(requestBody))
+		writeRawProtocolEntry(ctx, request.TransId, "nexi create request", redacted)
+		aulogging.Logger.Ctx(ctx).Info().Print("nexi create request: " + redacted)
 	}
 	var responseRaw *[]byte
 	response := aurestclientapi.ParsedResponse{
@@ -102,44 +102,20 @@ func (i *Impl) CreatePaymentLink(ctx context.Context, request NexiCreateCheckout
 	}
 	if response.Status >= 300 {
 		if config.LogFullRequests() {
-			db := database.GetRepository()
-			bodyStr := string(*responseRaw)
-			bodyStr = strings.ReplaceAll(bodyStr, "\r", "")
-			bodyStr = strings.ReplaceAll(bodyStr, "\n", "")
-			bodyStr = strings.ReplaceAll(bodyStr, " ", "")
-			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: request.TransId,
-				Kind:        "raw",
-				Message:     "nexi create error response",
-				Details:     bodyStr,
-				RequestId:   ctxvalues.RequestId(ctx),
-			})
-			aulogging.Logger.Ctx(ctx).Info().Printf("nexi create error response (status %d): %s", response.Status, string(*responseRaw))
+			redacted := redaction.Redact(string(*responseRaw))
+			writeRawProtocolEntry(ctx, request.TransId, "nexi create error response", redacted)
+			aulogging.Logger.Ctx(ctx).Info().Printf("nexi create error response (status %d): %s", response.Status, redacted)
 		}
-		return NexiCreateCheckoutSessionResponse{}, fmt.Errorf("unexpected response status %d", response.Status)
+		return NexiCreateCheckoutSessionResponse{}, fmt.Errorf("%w: unexpected response status %d", DownstreamError, response.Status)
 	}
 	responseBody := NexiCreateCheckoutSessionResponse{}
 	if err := json.Unmarshal(*responseRaw, &responseBody); err != nil {
 		return NexiCreateCheckoutSessionResponse{}, fmt.Errorf("failed to unmarshal response body: %v", err)
 	}
 	if config.LogFullRequests() {
-		// Log response
-		if response.Body != nil {
-			aulogging.Logger.Ctx(ctx).Info().Print("nexi create success response: " + string(*responseRaw))
-			// Also write to protocol with ApiId
-			db := database.GetRepository()
-			bodyStr := string(*responseRaw)
-			bodyStr = strings.ReplaceAll(bodyStr, "\r", "")
-			bodyStr = strings.ReplaceAll(bodyStr, "\n", "")
-			bodyStr = strings.ReplaceAll(bodyStr, " ", "")
-			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: request.TransId,
-				Kind:        "raw",
-				Message:     "nexi create success response",
-				Details:     bodyStr,
-				RequestId:   ctxvalues.RequestId(ctx),
-			})
-		}
+		redacted := redaction.Redact(string(*responseRaw))
+		aulogging.Logger.Ctx(ctx).Info().Print("nexi create success response: " + redacted)
+		writeRawProtocolEntry(ctx, request.TransId, "nexi create success response", redacted)
 	}
 	return responseBody, nil
 }
@@ -158,46 +134,95 @@ func (i *Impl) QueryPaymentLink(ctx context.Context, transactionId string) (Nexi
 	}
 	if response.Status >= 300 {
 		if config.LogFullRequests() {
-			db := database.GetRepository()
-			bodyStr := string(*responseRaw)
-			bodyStr = strings.ReplaceAll(bodyStr, "\r", "")
-			bodyStr = strings.ReplaceAll(bodyStr, "\n", "")
-			bodyStr = strings.ReplaceAll(bodyStr, " ", "")
-			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: transactionId,
-				Kind:        "raw",
-				Message:     "nexi get error response",
-				Details:     bodyStr,
-				RequestId:   ctxvalues.RequestId(ctx),
-			})
-			aulogging.Logger.Ctx(ctx).Info().Printf("nexi get error response (status %d): %s", response.Status, string(*responseRaw))
+			redacted := redaction.Redact(string(*responseRaw))
+			writeRawProtocolEntry(ctx, transactionId, "nexi get error response", redacted)
+			aulogging.Logger.Ctx(ctx).Info().Printf("nexi get error response (status %d): %s", response.Status, redacted)
+		}
+		if response.Status == http.StatusNotFound {
+			// Paygate only knows payments once the attendee has used the payment page
+			return NexiPaymentQueryResponse{}, NoSuchID404Error
+		}
+		return NexiPaymentQueryResponse{}, fmt.Errorf("%w: unexpected response status %d", DownstreamError, response.Status)
+	}
+	responseBody := NexiPaymentQueryResponse{}
+	if err := json.Unmarshal(*responseRaw, &responseBody); err != nil {
+		return NexiPaymentQueryResponse{}, fmt.Errorf("failed to unmarshal response body: %v", err)
+	}
+	if config.LogFullRequests() {
+		redacted := redaction.Redact(string(*responseRaw))
+		aulogging.Logger.Ctx(ctx).Info().Print("nexi get success response: " + redacted)
+		writeRawProtocolEntry(ctx, transactionId, "nexi get success response", redacted)
+	}
+	return responseBody, nil
+}
+
+func (i *Impl) CapturePayment(ctx context.Context, paymentId string, request NexiAmountRequest) (NexiPaymentQueryResponse, error) {
+	return i.changePayment(ctx, "capture", fmt.Sprintf("%s/payments/%s/captures", i.baseUrl, url.PathEscape(paymentId)), request.TransId, request)
+}
+
+func (i *Impl) RefundPayment(ctx context.Context, paymentId string, request NexiAmountRequest) (NexiPaymentQueryResponse, error) {
+	return i.changePayment(ctx, "refund", fmt.Sprintf("%s/payments/%s/refunds", i.baseUrl, url.PathEscape(paymentId)), request.TransId, request)
+}
+
+func (i *Impl) CancelPayment(ctx context.Context, paymentId string, request NexiReversalRequest) (NexiPaymentQueryResponse, error) {
+	return i.changePayment(ctx, "cancel", fmt.Sprintf("%s/payments/%s/reversals", i.baseUrl, url.PathEscape(paymentId)), request.TransId, request)
+}
+
+// changePayment performs an operation on an existing payment, which Paygate answers with the changed payment.
+func (i *Impl) changePayment(ctx context.Context, operation string, requestUrl string, transactionId string, request any) (NexiPaymentQueryResponse, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return NexiPaymentQueryResponse{}, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	var responseRaw *[]byte
+	response := aurestclientapi.ParsedResponse{
+		Body: &responseRaw,
+	}
+	if err := i.client.Perform(ctx, http.MethodPost, requestUrl, string(requestBody), &response); err != nil {
+		return NexiPaymentQueryResponse{}, err
+	}
+	if responseRaw == nil {
+		return NexiPaymentQueryResponse{}, fmt.Errorf("response body is empty")
+	}
+	if response.Status >= 300 {
+		redacted := redaction.Redact(string(*responseRaw))
+		if config.LogFullRequests() {
+			writeRawProtocolEntry(ctx, transactionId, fmt.Sprintf("nexi %s error response", operation), redacted)
+		}
+		aulogging.Logger.Ctx(ctx).Info().Printf("nexi %s error response (status %d): %s", operation, response.Status, redacted)
+		switch response.Status {
+		case http.StatusNotFound:
+			return NexiPaymentQueryResponse{}, NoSuchID404Error
+		case http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity:
+			return NexiPaymentQueryResponse{}, fmt.Errorf("%w: %s status %d", OperationRejected, operation, response.Status)
+		default:
+			return NexiPaymentQueryResponse{}, fmt.Errorf("%w: unexpected response status %d", DownstreamError, response.Status)
 		}
-		return NexiPaymentQueryResponse{}, fmt.Errorf("unexpected response status %d", response.Status)
 	}
 	responseBody := NexiPaymentQueryResponse{}
 	if err := json.Unmarshal(*responseRaw, &responseBody); err != nil {
 		return NexiPaymentQueryResponse{}, fmt.Errorf("failed to unmarshal response body: %v", err)
 	}
 	if config.LogFullRequests() {
-		// Log response
-		aulogging.Logger.Ctx(ctx).Info().Print("nexi get success response: " + string(*responseRaw))
-		// Also write to protocol with ApiId
-		db := database.GetRepository()
-		bodyStr := string(*responseRaw)
-		bodyStr = strings.ReplaceAll(bodyStr, "\r", "")
-		bodyStr = strings.ReplaceAll(bodyStr, "\n", "")
-		bodyStr = strings.ReplaceAll(bodyStr, " ", "")
-		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: transactionId,
-			Kind:        "raw",
-			Message:     "nexi get success response",
-			Details:     bodyStr,
-			RequestId:   ctxvalues.RequestId(ctx),
-		})
+		redacted := redaction.Redact(string(*responseRaw))
+		aulogging.Logger.Ctx(ctx).Info().Printf("nexi %s success response: %s", operation, redacted)
+		writeRawProtocolEntry(ctx, transactionId, fmt.Sprintf("nexi %s success response", operation), redacted)
 	}
 	return responseBody, nil
 }
 
+// writeRawProtocolEntry persists an already redacted raw payload to the protocol table.
+func writeRawProtocolEntry(ctx context.Context, referenceId string, message string, redacted string) {
+	db := database.GetRepository()
+	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId: referenceId,
+		Kind:        entity.KindRaw,
+		Message:     message,
+		Details:     redacted,
+		RequestId:   ctxvalues.RequestId(ctx),
+	})
+}
+
 func (i *Impl) DeletePaymentLink(ctx context.Context, paymentId string, amount int64) error {
 	//TODO implement me
 	panic("implement me")
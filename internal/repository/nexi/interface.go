@@ -11,6 +11,18 @@ type NexiDownstream interface {
 	QueryPaymentLink(ctx context.Context, transactionId string) (NexiPaymentQueryResponse, error)
 	DeletePaymentLink(ctx context.Context, paymentId string, amount int64) error
 
+	// CapturePayment captures amount of the authorized payment with Paygate payment id paymentId, which must not
+	// exceed what was authorized and not yet captured. Fails with OperationRejected if Paygate refuses the capture.
+	CapturePayment(ctx context.Context, paymentId string, request NexiAmountRequest) (NexiPaymentQueryResponse, error)
+
+	// RefundPayment refunds amount of the captured payment with Paygate payment id paymentId, which must not
+	// exceed what was captured and not yet refunded. Fails with OperationRejected if Paygate refuses the refund.
+	RefundPayment(ctx context.Context, paymentId string, request NexiAmountRequest) (NexiPaymentQueryResponse, error)
+
+	// CancelPayment reverses the authorization of the payment with Paygate payment id paymentId, which must not
+	// have been captured. Fails with OperationRejected if Paygate refuses the reversal.
+	CancelPayment(ctx context.Context, paymentId string, request NexiReversalRequest) (NexiPaymentQueryResponse, error)
+
 	QueryTransactions(ctx context.Context, timeGreaterThan time.Time, timeLessThan time.Time) ([]TransactionData, error)
 }
 
@@ -19,10 +31,25 @@ var (
 	DownstreamError  = errors.New("downstream unavailable - see log for details")
 	NotSuccessful    = errors.New("response body status field did not indicate success")
 	NotConfigured    = errors.New("paygate API not configured in simulator mode")
+	// OperationRejected means Paygate refused an operation on a payment, e.g. because it is in the wrong status
+	OperationRejected = errors.New("operation rejected by paygate")
 )
 
 // -- New Nexi PayGate API Structures --
 
+// --- NexiAmountRequest, the body of captures and refunds
+
+type NexiAmountRequest struct {
+	TransId string     `json:"transId,omitempty"` // our reference id, for finding the operation in Paygate
+	Amount  NexiAmount `json:"amount"`            // required
+}
+
+// --- NexiReversalRequest, the body of reversals
+
+type NexiReversalRequest struct {
+	TransId string `json:"transId,omitempty"` // our reference id, for finding the operation in Paygate
+}
+
 // --- NexiCreateCheckoutSessionRequest
 
 type NexiCreateCheckoutSessionRequest struct {
@@ -74,6 +74,25 @@ func newMock() Mock {
 		MerchantId: "mymerchant",
 	}
 
+	simData["EF1995-000001-230001-122218-6666"] = NexiPaymentQueryResponse{
+		PayId:               "4243",
+		TransId:             "EF1995-000001-230001-122218-6666",
+		Status:              "AUTHORIZED",
+		ResponseCode:        "00000000",
+		ResponseDescription: "Transaktion erfolgreich",
+		Amount: &NexiAmountResponse{
+			Value:         39000,
+			Currency:      "EUR",
+			CapturedValue: p(int64(0)),
+			RefundedValue: p(int64(0)),
+		},
+		Language: "de",
+		PaymentMethods: &NexiPaymentMethodsResponse{
+			Type: "CARD",
+		},
+		MerchantId: "mymerchant",
+	}
+
 	webhookCache := make(map[string]nexiapi.WebhookDto)
 	return &mockImpl{
 		recording:     make([]string, 0),
@@ -164,6 +183,89 @@ func (m *mockImpl) DeletePaymentLink(ctx context.Context, id string, amount int6
 	return nil
 }
 
+func (m *mockImpl) CapturePayment(ctx context.Context, paymentId string, request NexiAmountRequest) (NexiPaymentQueryResponse, error) {
+	if m.simulateError != nil {
+		return NexiPaymentQueryResponse{}, m.simulateError
+	}
+	m.recording = append(m.recording, fmt.Sprintf("CapturePayment %s %d %s", paymentId, request.Amount.Value, request.Amount.Currency))
+
+	return m.changePayment(paymentId, func(data *NexiPaymentQueryResponse) error {
+		if data.Status != "AUTHORIZED" {
+			return fmt.Errorf("%w: only authorized payments can be captured", OperationRejected)
+		}
+		captured := int64(0)
+		if data.Amount.CapturedValue != nil {
+			captured = *data.Amount.CapturedValue
+		}
+		if request.Amount.Value > data.Amount.Value-captured {
+			return fmt.Errorf("%w: cannot capture more than was authorized", OperationRejected)
+		}
+		data.Amount.CapturedValue = p(captured + request.Amount.Value)
+		if *data.Amount.CapturedValue == data.Amount.Value {
+			data.Status = "OK"
+		}
+		return nil
+	})
+}
+
+func (m *mockImpl) RefundPayment(ctx context.Context, paymentId string, request NexiAmountRequest) (NexiPaymentQueryResponse, error) {
+	if m.simulateError != nil {
+		return NexiPaymentQueryResponse{}, m.simulateError
+	}
+	m.recording = append(m.recording, fmt.Sprintf("RefundPayment %s %d %s", paymentId, request.Amount.Value, request.Amount.Currency))
+
+	return m.changePayment(paymentId, func(data *NexiPaymentQueryResponse) error {
+		captured := int64(0)
+		if data.Amount.CapturedValue != nil {
+			captured = *data.Amount.CapturedValue
+		}
+		refunded := int64(0)
+		if data.Amount.RefundedValue != nil {
+			refunded = *data.Amount.RefundedValue
+		}
+		if request.Amount.Value > captured-refunded {
+			return fmt.Errorf("%w: cannot refund more than was captured", OperationRejected)
+		}
+		data.Amount.RefundedValue = p(refunded + request.Amount.Value)
+		return nil
+	})
+}
+
+func (m *mockImpl) CancelPayment(ctx context.Context, paymentId string, request NexiReversalRequest) (NexiPaymentQueryResponse, error) {
+	if m.simulateError != nil {
+		return NexiPaymentQueryResponse{}, m.simulateError
+	}
+	m.recording = append(m.recording, fmt.Sprintf("CancelPayment %s", paymentId))
+
+	return m.changePayment(paymentId, func(data *NexiPaymentQueryResponse) error {
+		if data.Status != "AUTHORIZED" || (data.Amount.CapturedValue != nil && *data.Amount.CapturedValue > 0) {
+			return fmt.Errorf("%w: only authorized payments that have not been captured can be cancelled", OperationRejected)
+		}
+		data.Status = "CANCELLED"
+		return nil
+	})
+}
+
+// changePayment applies change to a copy of the simulated payment with the given payment id, and keeps it if successful.
+func (m *mockImpl) changePayment(paymentId string, change func(data *NexiPaymentQueryResponse) error) (NexiPaymentQueryResponse, error) {
+	for transId, data := range m.simulatorData {
+		if data.PayId != paymentId {
+			continue
+		}
+		if data.Amount == nil {
+			return NexiPaymentQueryResponse{}, fmt.Errorf("%w: payment has no amount", OperationRejected)
+		}
+		copiedAmount := *data.Amount
+		data.Amount = &copiedAmount
+		if err := change(&data); err != nil {
+			return NexiPaymentQueryResponse{}, err
+		}
+		m.simulatorData[transId] = data
+		return data, nil
+	}
+	return NexiPaymentQueryResponse{}, NoSuchID404Error
+}
+
 func (m *mockImpl) QueryTransactions(ctx context.Context, timeGreaterThan time.Time, timeLessThan time.Time) ([]TransactionData, error) {
 	if m.simulateError != nil {
 		return []TransactionData{}, m.simulateError
@@ -2,6 +2,7 @@ package paymentservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -15,9 +16,14 @@ import (
 	auresthttpclient "github.com/StephanHCB/go-autumn-restclient/implementation/httpclient"
 	aurestlogging "github.com/StephanHCB/go-autumn-restclient/implementation/requestlogging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
 )
 
+// CircuitBreakerName identifies the circuit breaker protecting calls to this service, e.g. in metrics.
+const CircuitBreakerName = "payment-service-breaker"
+
 type Impl struct {
 	client  aurestclientapi.Client
 	baseUrl string
@@ -26,6 +32,7 @@ type Impl struct {
 func requestManipulator(ctx context.Context, r *http.Request) {
 	r.Header.Add(media.HeaderXApiKey, config.FixedApiToken())
 	r.Header.Add(middleware.TraceIdHeader, ctxvalues.RequestId(ctx))
+	tracing.Inject(ctx, r.Header)
 }
 
 func newClient() (PaymentService, error) {
@@ -37,7 +44,7 @@ func newClient() (PaymentService, error) {
 	requestLoggingClient := aurestlogging.New(httpClient)
 
 	circuitBreakerClient := aurestbreaker.New(requestLoggingClient,
-		"payment-service-breaker",
+		CircuitBreakerName,
 		10,
 		2*time.Minute,
 		30*time.Second,
@@ -45,7 +52,7 @@ func newClient() (PaymentService, error) {
 	)
 
 	return &Impl{
-		client:  circuitBreakerClient,
+		client:  tracing.NewClient(metrics.NewClient(circuitBreakerClient, "payment-service"), "payment-service"),
 		baseUrl: config.PaymentServiceBaseUrl(),
 	}, nil
 }
@@ -94,3 +101,23 @@ func (i *Impl) GetTransactionByReferenceId(ctx context.Context, reference_id str
 	}
 	return bodyDto.Payload[0], err
 }
+
+func (i *Impl) GetTransactionsByEffectiveDate(ctx context.Context, effectiveFrom string, effectiveBefore string) ([]Transaction, error) {
+	url := fmt.Sprintf("%s/api/rest/v1/transactions?effective_from=%s&effective_before=%s", i.baseUrl,
+		url.QueryEscape(effectiveFrom), url.QueryEscape(effectiveBefore))
+	bodyDto := TransactionResponse{}
+	response := aurestclientapi.ParsedResponse{
+		Body: &bodyDto,
+	}
+	err := i.client.Perform(ctx, http.MethodGet, url, nil, &response)
+
+	err = errByStatus(err, response.Status)
+	if errors.Is(err, NotFoundError) {
+		// no transactions in the range
+		return make([]Transaction, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bodyDto.Payload, nil
+}
@@ -10,6 +10,9 @@ type PaymentService interface {
 	AddTransaction(ctx context.Context, transaction Transaction) error
 	UpdateTransaction(ctx context.Context, transaction Transaction) error
 	GetTransactionByReferenceId(ctx context.Context, reference_id string) (Transaction, error)
+	// GetTransactionsByEffectiveDate lists all transactions with an effective date from effectiveFrom
+	// up to, but excluding effectiveBefore. Dates are given as yyyy-mm-dd.
+	GetTransactionsByEffectiveDate(ctx context.Context, effectiveFrom string, effectiveBefore string) ([]Transaction, error)
 }
 
 var (
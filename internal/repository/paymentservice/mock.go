@@ -10,6 +10,7 @@ type Mock interface {
 	InjectTransaction(ctx context.Context, transaction Transaction) error
 	Reset()
 	Recording() []Transaction
+	QueryCounts() (single int, byRange int)
 	SimulateAddError(err error)
 }
 
@@ -19,6 +20,8 @@ type MockImpl struct {
 	simulateGetError    error
 	simulateAddError    error
 	simulateUpdateError error
+	singleQueries       int
+	rangeQueries        int
 }
 
 var (
@@ -56,6 +59,7 @@ func (m *MockImpl) UpdateTransaction(ctx context.Context, transaction Transactio
 }
 
 func (m *MockImpl) GetTransactionByReferenceId(ctx context.Context, referenceId string) (Transaction, error) {
+	m.singleQueries++
 	for _, transactions := range m.data {
 		for _, transaction := range transactions {
 			if transaction.ID == referenceId {
@@ -66,6 +70,19 @@ func (m *MockImpl) GetTransactionByReferenceId(ctx context.Context, referenceId
 	return Transaction{}, NotFoundError
 }
 
+func (m *MockImpl) GetTransactionsByEffectiveDate(ctx context.Context, effectiveFrom string, effectiveBefore string) ([]Transaction, error) {
+	m.rangeQueries++
+	result := make([]Transaction, 0)
+	for _, transactions := range m.data {
+		for _, transaction := range transactions {
+			if transaction.EffectiveDate >= effectiveFrom && transaction.EffectiveDate < effectiveBefore {
+				result = append(result, transaction)
+			}
+		}
+	}
+	return result, nil
+}
+
 // only used in tests
 
 func (m *MockImpl) Reset() {
@@ -74,12 +91,19 @@ func (m *MockImpl) Reset() {
 	m.simulateGetError = nil
 	m.simulateAddError = nil
 	m.simulateUpdateError = nil
+	m.singleQueries = 0
+	m.rangeQueries = 0
 }
 
 func (m *MockImpl) Recording() []Transaction {
 	return m.recording
 }
 
+// QueryCounts returns how often transactions were looked up by reference id and by date range.
+func (m *MockImpl) QueryCounts() (single int, byRange int) {
+	return m.singleQueries, m.rangeQueries
+}
+
 func (m *MockImpl) SimulateAddError(err error) {
 	m.simulateAddError = err
 }
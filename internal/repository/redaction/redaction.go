@@ -0,0 +1,178 @@
+package redaction
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+)
+
+// MaskedValue replaces every value that has been redacted.
+const MaskedValue = "***"
+
+// Redact masks the values at all configured json paths (logging.redact_paths) in a raw payload.
+//
+// Payloads that cannot be parsed as json are replaced completely, because we cannot tell which parts
+// of them are personal data. If no paths are configured, the payload is returned unchanged.
+func Redact(payload string) string {
+	return redact(payload, parsePaths(config.LogRedactPaths()))
+}
+
+func redact(payload string, paths [][]string) string {
+	if len(paths) == 0 {
+		return payload
+	}
+
+	redacted, ok := Mask(payload, matcher(paths))
+	if !ok {
+		return fmt.Sprintf("(redacted non-json payload of %d bytes)", len(payload))
+	}
+	return redacted
+}
+
+// Mask parses a json payload and replaces the value of every object key for which matches returns true.
+//
+// matches is called with the keys leading from the document root to the value, array indices are skipped.
+// Key order is preserved. If nothing was masked, the payload is returned unchanged, otherwise in compact
+// form. ok is false if the payload is not valid json.
+func Mask(payload string, matches func(path []string) bool) (masked string, ok bool) {
+	if strings.TrimSpace(payload) == "" {
+		return payload, true
+	}
+
+	m := &masker{
+		decoder: json.NewDecoder(strings.NewReader(payload)),
+		matches: matches,
+	}
+	m.decoder.UseNumber()
+	if err := m.value(nil); err != nil {
+		return "", false
+	}
+	if _, err := m.decoder.Token(); err != io.EOF {
+		// trailing data after the json value
+		return "", false
+	}
+
+	if !m.masked {
+		return payload, true
+	}
+	return m.output.String(), true
+}
+
+type masker struct {
+	decoder *json.Decoder
+	matches func(path []string) bool
+	output  bytes.Buffer
+	masked  bool
+}
+
+func (m *masker) value(path []string) error {
+	token, err := m.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		m.output.WriteByte('{')
+		for m.decoder.More() {
+			keyToken, err := m.decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := keyToken.(string)
+			if m.output.Bytes()[m.output.Len()-1] != '{' {
+				m.output.WriteByte(',')
+			}
+			if err := m.literal(key); err != nil {
+				return err
+			}
+			m.output.WriteByte(':')
+
+			childPath := append(path[:len(path):len(path)], key)
+			if m.matches(childPath) {
+				var skipped json.RawMessage
+				if err := m.decoder.Decode(&skipped); err != nil {
+					return err
+				}
+				m.masked = true
+				if err := m.literal(MaskedValue); err != nil {
+					return err
+				}
+			} else if err := m.value(childPath); err != nil {
+				return err
+			}
+		}
+		m.output.WriteByte('}')
+		_, err = m.decoder.Token()
+		return err
+	case json.Delim('['):
+		m.output.WriteByte('[')
+		for m.decoder.More() {
+			if m.output.Bytes()[m.output.Len()-1] != '[' {
+				m.output.WriteByte(',')
+			}
+			if err := m.value(path); err != nil {
+				return err
+			}
+		}
+		m.output.WriteByte(']')
+		_, err = m.decoder.Token()
+		return err
+	default:
+		return m.literal(token)
+	}
+}
+
+func (m *masker) literal(value any) error {
+	encoded := &bytes.Buffer{}
+	encoder := json.NewEncoder(encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	m.output.Write(bytes.TrimRight(encoded.Bytes(), "\n"))
+	return nil
+}
+
+// PathMatcher returns a function for Mask that matches the json paths in the format of logging.redact_paths.
+func PathMatcher(configured []string) func(path []string) bool {
+	return matcher(parsePaths(configured))
+}
+
+func matcher(paths [][]string) func(path []string) bool {
+	return func(path []string) bool {
+		for _, p := range paths {
+			if pathMatches(p, path) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func parsePaths(configured []string) [][]string {
+	result := make([][]string, 0, len(configured))
+	for _, p := range configured {
+		if p != "" {
+			result = append(result, strings.Split(p, "."))
+		}
+	}
+	return result
+}
+
+// pathMatches compares json keys case-insensitively, "*" matches any single key.
+func pathMatches(pattern []string, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for idx, segment := range pattern {
+		if segment != "*" && !strings.EqualFold(segment, path[idx]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package redaction
+
+import (
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactCreateRequest(t *testing.T) {
+	docs.Description("configured paths are masked, everything else is kept in its original order")
+	paths := parsePaths([]string{"customerInfo.email", "customerInfo.phone", "billingAddress.*", "order.items.name"})
+	payload := `{"transId":"EF1995-000001","customerInfo":{"email":"jsquirrel_github_9a6d@packetloss.de","phone":{"countryCode":"49","number":"12345"},"customerType":"individual"},` +
+		`"billingAddress":{"city":"Berlin","country":"DE"},"order":{"items":[{"name":"Sponsor","price":8500},{"name":"Room","price":10000}]},"amount":{"value":18500,"currency":"EUR"}}`
+	expected := `{"transId":"EF1995-000001","customerInfo":{"email":"***","phone":"***","customerType":"individual"},` +
+		`"billingAddress":{"city":"***","country":"***"},"order":{"items":[{"name":"***","price":8500},{"name":"***","price":10000}]},"amount":{"value":18500,"currency":"EUR"}}`
+	require.Equal(t, expected, redact(payload, paths))
+}
+
+func TestRedactCaseInsensitive(t *testing.T) {
+	docs.Description("json keys are compared case-insensitively")
+	paths := parsePaths([]string{"paymentmethods.card.LAST4DIGITS"})
+	require.Equal(t, `{"paymentMethods":{"card":{"last4Digits":"***"}}}`, redact(`{"paymentMethods":{"card":{"last4Digits":"1111"}}}`, paths))
+}
+
+func TestRedactUnchanged(t *testing.T) {
+	docs.Description("payloads without matches are returned unchanged, including formatting")
+	paths := parsePaths([]string{"customerInfo.email"})
+	payload := `{"payId": "ef00000000000000000000000000cafe", "amount": {"value": 18500}}`
+	require.Equal(t, payload, redact(payload, paths))
+	require.Equal(t, "", redact("", paths))
+}
+
+func TestRedactNoPaths(t *testing.T) {
+	docs.Description("without configured paths redaction is switched off")
+	require.Equal(t, "not json", redact("not json", nil))
+}
+
+func TestRedactInvalidJson(t *testing.T) {
+	docs.Description("payloads that are not valid json are replaced completely")
+	paths := parsePaths([]string{"customerInfo.email"})
+	require.Equal(t, "(redacted non-json payload of 8 bytes)", redact("not json", paths))
+	require.Equal(t, "(redacted non-json payload of 4 bytes)", redact("{}{}", paths))
+	require.Equal(t, "(redacted non-json payload of 10 bytes)", redact(`{"email":}`, paths))
+}
+
+func TestRedactDefaultPaths(t *testing.T) {
+	docs.Description("the default paths mask card prefill data and the shipping address, but keep the country")
+	config.LoadTestingConfigurationFromPathOrAbort("../../../test/resources/testconfig.yaml")
+	payload := `{"paymentMethods":{"card":{"prefillInfo":{"number":"4111111111111111","cardholderName":"Jane Doe","expiryDate":"203012"}}},` +
+		`"shipping":{"type":"none","address":{"firstName":"Jane","city":"Berlin","country":"DEU","phone":{"countryCode":"49","number":"12345"}}}}`
+	expected := `{"paymentMethods":{"card":{"prefillInfo":{"number":"***","cardholderName":"***","expiryDate":"***"}}},` +
+		`"shipping":{"type":"none","address":{"firstName":"***","city":"***","country":"DEU","phone":"***"}}}`
+	require.Equal(t, expected, Redact(payload))
+}
@@ -0,0 +1,31 @@
+// Package spreadsheet protects csv files that are opened in a spreadsheet application.
+package spreadsheet
+
+import (
+	"strconv"
+	"strings"
+)
+
+// formulaPrefixes are the characters with which a cell value is taken as a formula by spreadsheet applications.
+const formulaPrefixes = "=+-@\t\r"
+
+// Cell prefixes a value with ' if a spreadsheet would execute it as a formula, so it is shown as text instead.
+//
+// Plain numbers, e.g. negative amounts, are left unchanged.
+func Cell(value string) string {
+	if value == "" || !strings.ContainsRune(formulaPrefixes, rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// Row applies Cell to all values of a csv row, in place.
+func Row(values []string) []string {
+	for idx, value := range values {
+		values[idx] = Cell(value)
+	}
+	return values
+}
@@ -0,0 +1,27 @@
+package spreadsheet
+
+import (
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCellFormula(t *testing.T) {
+	docs.Description("values that a spreadsheet would execute as a formula are prefixed with '")
+	for _, value := range []string{"=HYPERLINK(\"http://evil\")", "+1+1", "-1+1", "@SUM(A1)", "\tx", "\rx", "-"} {
+		require.Equal(t, "'"+value, Cell(value), value)
+	}
+}
+
+func TestCellUnchanged(t *testing.T) {
+	docs.Description("other values, including plain negative numbers, are left unchanged")
+	for _, value := range []string{"", "EF1995-000001-221216-122218-4132", "-12.50", "-18500", "+49", "a=b", "2022-12-16T12:22:18Z"} {
+		require.Equal(t, value, Cell(value), value)
+	}
+}
+
+func TestRow(t *testing.T) {
+	docs.Description("all values of a row are protected")
+	require.Equal(t, []string{"1", "'=cmd", "ok"}, Row([]string{"1", "=cmd", "ok"}))
+}
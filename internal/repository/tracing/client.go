@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type clientImpl struct {
+	wrapped aurestclientapi.Client
+	target  string
+}
+
+// NewClient wraps a client so that each request gets its own span, named after the target.
+//
+// The span is in the context passed down the client stack, so a request manipulator can Inject it.
+func NewClient(wrapped aurestclientapi.Client, target string) aurestclientapi.Client {
+	return &clientImpl{
+		wrapped: wrapped,
+		target:  target,
+	}
+}
+
+func (c *clientImpl) Perform(ctx context.Context, method string, requestUrl string, requestBody interface{}, response *aurestclientapi.ParsedResponse) error {
+	ctx, span := Start(ctx, c.target+" "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(method),
+			semconv.URLFull(requestUrl),
+			semconv.PeerService(c.target),
+		),
+	)
+	defer span.End()
+
+	err := c.wrapped.Perform(ctx, method, requestUrl, requestBody, response)
+	if response.Status > 0 {
+		span.SetAttributes(StatusAttribute(response.Status))
+	}
+	if err != nil {
+		Fail(span, err)
+	} else if response.Status >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(response.Status))
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	aurestclientapi "github.com/StephanHCB/go-autumn-restclient/api"
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// tstClient records the headers a request manipulator would add.
+type tstClient struct {
+	status int
+	err    error
+	header http.Header
+}
+
+func (c *tstClient) Perform(ctx context.Context, _ string, _ string, _ interface{}, response *aurestclientapi.ParsedResponse) error {
+	c.header = http.Header{}
+	Inject(ctx, c.header)
+	response.Status = c.status
+	return c.err
+}
+
+func tstSetupRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return recorder
+}
+
+func TestClientSpanAndPropagation(t *testing.T) {
+	docs.Description("each downstream request gets a client span, which is propagated as traceparent")
+	recorder := tstSetupRecorder(t)
+	wrapped := &tstClient{status: http.StatusOK}
+
+	ctx, parent := Start(context.TODO(), "parent")
+	err := NewClient(wrapped, "payment-service").Perform(ctx, http.MethodGet, "http://localhost/api/rest/v1/transactions", nil, &aurestclientapi.ParsedResponse{})
+	parent.End()
+	require.Nil(t, err)
+
+	spans := recorder.Ended()
+	require.Equal(t, 2, len(spans))
+	require.Equal(t, "payment-service GET", spans[0].Name())
+	require.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	expected := "00-" + spans[0].SpanContext().TraceID().String() + "-" + spans[0].SpanContext().SpanID().String() + "-01"
+	require.Equal(t, expected, wrapped.header.Get("traceparent"))
+}
+
+func TestClientSpanFailure(t *testing.T) {
+	docs.Description("failed downstream requests mark their span as failed")
+	recorder := tstSetupRecorder(t)
+
+	err := NewClient(&tstClient{err: errors.New("connection refused")}, "mail-service").Perform(context.TODO(), http.MethodPost, "http://localhost/api/v1/mail", nil, &aurestclientapi.ParsedResponse{})
+	require.NotNil(t, err)
+	_ = NewClient(&tstClient{status: http.StatusBadGateway}, "mail-service").Perform(context.TODO(), http.MethodPost, "http://localhost/api/v1/mail", nil, &aurestclientapi.ParsedResponse{})
+
+	spans := recorder.Ended()
+	require.Equal(t, 2, len(spans))
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, "connection refused", spans[0].Status().Description)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Equal(t, "Bad Gateway", spans[1].Status().Description)
+}
@@ -0,0 +1,19 @@
+package healthsrv
+
+import (
+	"net/http"
+	"time"
+)
+
+// pingTimeout limits each individual check, so the whole check stays below common probe timeouts.
+const pingTimeout = 2 * time.Second
+
+type Impl struct {
+	Client *http.Client
+}
+
+func New() HealthService {
+	return &Impl{
+		Client: &http.Client{Timeout: pingTimeout},
+	}
+}
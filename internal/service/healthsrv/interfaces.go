@@ -0,0 +1,24 @@
+package healthsrv
+
+import (
+	"context"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+)
+
+type HealthService interface {
+	// CheckReadiness checks the database and the downstream services.
+	//
+	// The database and the payment service are required, webhooks cannot be processed without them.
+	// Nexi and the other services are reported, but do not make this instance unready.
+	CheckReadiness(ctx context.Context) nexiapi.ReadinessReportDto
+}
+
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+
+	ComponentUp   = "up"
+	ComponentDown = "down"
+	ComponentMock = "mock"
+)
@@ -0,0 +1,128 @@
+package healthsrv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/attendeeservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+)
+
+func (i *Impl) CheckReadiness(ctx context.Context) nexiapi.ReadinessReportDto {
+	report := nexiapi.ReadinessReportDto{
+		Status: StatusReady,
+		Mode: nexiapi.ReadinessModeDto{
+			Nexi:           "live",
+			SimulationMode: config.NexiSimulationMode(),
+			Database:       string(config.DatabaseUse()),
+		},
+		Components: make(map[string]nexiapi.ReadinessComponentDto),
+	}
+	if config.NexiDownstreamBaseUrl() == "" {
+		report.Mode.Nexi = "simulator"
+	}
+
+	checks := map[string]func(ctx context.Context) nexiapi.ReadinessComponentDto{
+		"database": i.checkDatabase,
+		"nexi": func(ctx context.Context) nexiapi.ReadinessComponentDto {
+			return i.checkBreaker(config.NexiDownstreamBaseUrl(), nexi.CircuitBreakerName, false)
+		},
+		"payment_service": func(ctx context.Context) nexiapi.ReadinessComponentDto {
+			return i.checkService(ctx, config.PaymentServiceBaseUrl(), paymentservice.CircuitBreakerName, true)
+		},
+		"attendee_service": func(ctx context.Context) nexiapi.ReadinessComponentDto {
+			return i.checkService(ctx, config.AttendeeServiceBaseUrl(), attendeeservice.CircuitBreakerName, false)
+		},
+		"mail_service": func(ctx context.Context) nexiapi.ReadinessComponentDto {
+			return i.checkService(ctx, config.MailServiceBaseUrl(), mailservice.CircuitBreakerName, false)
+		},
+	}
+
+	// run the checks in parallel, so slow services do not add up
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = result
+		}()
+	}
+	wg.Wait()
+
+	for name, component := range report.Components {
+		if component.Status == ComponentDown {
+			aulogging.Logger.Ctx(ctx).Warn().Printf("readiness check: %s is down: %s", name, component.Message)
+			if component.Required {
+				report.Status = StatusNotReady
+			}
+		}
+	}
+	return report
+}
+
+func (i *Impl) checkDatabase(ctx context.Context) nexiapi.ReadinessComponentDto {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := database.GetRepository().Ping(ctx); err != nil {
+		return nexiapi.ReadinessComponentDto{Status: ComponentDown, Required: true, Message: err.Error()}
+	}
+	return nexiapi.ReadinessComponentDto{Status: ComponentUp, Required: true}
+}
+
+// checkBreaker reports a downstream service as down while its circuit breaker is open.
+func (i *Impl) checkBreaker(baseUrl string, breakerName string, required bool) nexiapi.ReadinessComponentDto {
+	if baseUrl == "" {
+		return nexiapi.ReadinessComponentDto{Status: ComponentMock, Required: required}
+	}
+	state := metrics.CircuitBreakerState(breakerName)
+	if state == "open" {
+		return nexiapi.ReadinessComponentDto{Status: ComponentDown, Required: required, Message: "circuit breaker open"}
+	}
+	return nexiapi.ReadinessComponentDto{Status: ComponentUp, Required: required, Message: "circuit breaker " + state}
+}
+
+// checkService additionally pings the health endpoint of the service, if configured.
+func (i *Impl) checkService(ctx context.Context, baseUrl string, breakerName string, required bool) nexiapi.ReadinessComponentDto {
+	result := i.checkBreaker(baseUrl, breakerName, required)
+	if result.Status != ComponentUp || !config.ReadinessPings() {
+		return result
+	}
+
+	if err := i.ping(ctx, baseUrl+"/info/health"); err != nil {
+		return nexiapi.ReadinessComponentDto{Status: ComponentDown, Required: required, Message: err.Error()}
+	}
+	return result
+}
+
+func (i *Impl) ping(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := i.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("health endpoint returned status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package paymentlinksrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+)
+
+func (i *Impl) CancelPayment(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.CancelPayment")
+	defer span.End()
+
+	if config.NexiDownstreamBaseUrl() == "" {
+		return nexi.NotConfigured
+	}
+
+	upstream, err := nexi.Get().QueryPaymentLink(ctx, id)
+	if err != nil {
+		i.paymentQueryFailed(ctx, id, err)
+		return err
+	}
+	i.paymentQueried(ctx, id, upstream)
+
+	if upstream.Status != "AUTHORIZED" {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("cancel: payment in status %s. reference_id=%s", upstream.Status, id)
+		return fmt.Errorf("%w: payment is in status %s, only authorized payments can be cancelled", CancelNotPossibleError, upstream.Status)
+	}
+	amount, currency := int64(0), ""
+	if upstream.Amount != nil {
+		if upstream.Amount.CapturedValue != nil && *upstream.Amount.CapturedValue > 0 {
+			aulogging.Logger.Ctx(ctx).Warn().Printf("cancel: payment already partially captured. reference_id=%s", id)
+			return fmt.Errorf("%w: %d has already been captured, refund it instead", CancelNotPossibleError, *upstream.Amount.CapturedValue)
+		}
+		amount, currency = upstream.Amount.Value, upstream.Amount.Currency
+	}
+
+	method := ""
+	if upstream.PaymentMethods != nil {
+		method = upstream.PaymentMethods.Type
+	}
+
+	cancelled, err := nexi.Get().CancelPayment(ctx, upstream.PayId, nexi.NexiReversalRequest{
+		TransId: id,
+	})
+	db := database.GetRepository()
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().Printf("cancel failed. reference_id=%s err=%s", id, err.Error())
+		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+			ReferenceId:    id,
+			ApiId:          upstream.PayId,
+			Kind:           entity.KindError,
+			Message:        "cancel failed",
+			Operation:      entity.OperationCancel,
+			Details:        fmt.Sprintf("amount=%d currency=%s error=%s", amount, currency, err.Error()),
+			Amount:         amountOf(amount),
+			Currency:       currency,
+			UpstreamStatus: upstream.Status,
+			PaymentMethod:  method,
+			RequestId:      ctxvalues.RequestId(ctx),
+		})
+		_ = i.SendErrorNotifyMail(ctx, "cancel", id, "cancel-failed")
+		if errors.Is(err, nexi.OperationRejected) {
+			return fmt.Errorf("%w: %s", CancelNotPossibleError, err.Error())
+		}
+		return err
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("cancelled amount=%d currency=%s reference_id=%s", amount, currency, id)
+	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          upstream.PayId,
+		Kind:           entity.KindSuccess,
+		Message:        "cancel",
+		Operation:      entity.OperationCancel,
+		Details:        fmt.Sprintf("amount=%d currency=%s", amount, currency),
+		Amount:         amountOf(amount),
+		Currency:       currency,
+		UpstreamStatus: cancelled.Status,
+		PaymentMethod:  method,
+		RequestId:      ctxvalues.RequestId(ctx),
+	})
+	_ = db.UpdatePaylinkStatus(ctx, id, upstream.PayId, cancelled.Status)
+	return nil
+}
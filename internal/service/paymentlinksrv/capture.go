@@ -0,0 +1,109 @@
+package paymentlinksrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+)
+
+func (i *Impl) CapturePayment(ctx context.Context, id string, amount *int64) error {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.CapturePayment")
+	defer span.End()
+
+	if config.NexiDownstreamBaseUrl() == "" {
+		return nexi.NotConfigured
+	}
+
+	upstream, err := nexi.Get().QueryPaymentLink(ctx, id)
+	if err != nil {
+		i.paymentQueryFailed(ctx, id, err)
+		return err
+	}
+	i.paymentQueried(ctx, id, upstream)
+
+	if upstream.Status != "AUTHORIZED" {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("capture: payment in status %s. reference_id=%s", upstream.Status, id)
+		return fmt.Errorf("%w: payment is in status %s, only authorized payments can be captured", CaptureNotPossibleError, upstream.Status)
+	}
+	capturable := int64(0)
+	currency := ""
+	if upstream.Amount != nil {
+		capturable = upstream.Amount.Value
+		if upstream.Amount.CapturedValue != nil {
+			capturable -= *upstream.Amount.CapturedValue
+		}
+		currency = upstream.Amount.Currency
+	}
+	value := capturable
+	if amount != nil {
+		value = *amount
+	}
+	if capturable <= 0 {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("capture: nothing left to capture. reference_id=%s", id)
+		return fmt.Errorf("%w: nothing left to capture", CaptureNotPossibleError)
+	}
+	if value > capturable {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("capture: amount %d exceeds capturable amount %d. reference_id=%s", value, capturable, id)
+		return fmt.Errorf("%w: at most %d can be captured", CaptureNotPossibleError, capturable)
+	}
+
+	method := ""
+	if upstream.PaymentMethods != nil {
+		method = upstream.PaymentMethods.Type
+	}
+
+	captured, err := nexi.Get().CapturePayment(ctx, upstream.PayId, nexi.NexiAmountRequest{
+		TransId: id,
+		Amount: nexi.NexiAmount{
+			Value:    value,
+			Currency: currency,
+		},
+	})
+	db := database.GetRepository()
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().Printf("capture failed. reference_id=%s err=%s", id, err.Error())
+		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+			ReferenceId:    id,
+			ApiId:          upstream.PayId,
+			Kind:           entity.KindError,
+			Message:        "capture failed",
+			Operation:      entity.OperationCapture,
+			Details:        fmt.Sprintf("amount=%d currency=%s error=%s", value, currency, err.Error()),
+			Amount:         amountOf(value),
+			Currency:       currency,
+			UpstreamStatus: upstream.Status,
+			PaymentMethod:  method,
+			RequestId:      ctxvalues.RequestId(ctx),
+		})
+		_ = i.SendErrorNotifyMail(ctx, "capture", id, "capture-failed")
+		if errors.Is(err, nexi.OperationRejected) {
+			return fmt.Errorf("%w: %s", CaptureNotPossibleError, err.Error())
+		}
+		return err
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("captured amount=%d currency=%s reference_id=%s", value, currency, id)
+	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          upstream.PayId,
+		Kind:           entity.KindSuccess,
+		Message:        "capture",
+		Operation:      entity.OperationCapture,
+		Details:        fmt.Sprintf("amount=%d currency=%s", value, currency),
+		Amount:         amountOf(value),
+		Currency:       currency,
+		UpstreamStatus: captured.Status,
+		PaymentMethod:  method,
+		RequestId:      ctxvalues.RequestId(ctx),
+	})
+	_ = db.UpdatePaylinkStatus(ctx, id, upstream.PayId, captured.Status)
+	return nil
+}
@@ -11,10 +11,20 @@ import (
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 )
 
 func (i *Impl) CheckPaymentStatus(ctx context.Context, id string) (nexiapi.PaymentDto, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.CheckPaymentStatus")
+	defer span.End()
+
+	return i.checkPaymentStatus(ctx, id, false)
+}
+
+// checkPaymentStatus implements CheckPaymentStatus. If validIsDone is set, a transaction that is already valid
+// with the amount paid is not a conflict, because the webhook may have been faster.
+func (i *Impl) checkPaymentStatus(ctx context.Context, id string, validIsDone bool) (nexiapi.PaymentDto, error) {
 	if config.NexiDownstreamBaseUrl() == "" {
 		return nexiapi.PaymentDto{}, nexi.NotConfigured
 	}
@@ -36,6 +46,12 @@ func (i *Impl) CheckPaymentStatus(ctx context.Context, id string) (nexiapi.Payme
 	if nexiDto.Status == "OK" && nexiDto.ResponseCode == "00000000" {
 		aulogging.Logger.Ctx(ctx).Info().Printf("paygate status is OK, checking payment status. reference_id=%s", id)
 
+		if validIsDone && transaction.Status == paymentservice.Valid &&
+			transaction.Amount.GrossCent == nexiDto.AmountPaid && transaction.Amount.Currency == nexiDto.Currency {
+			aulogging.Logger.Ctx(ctx).Info().Printf("transaction already valid, nothing to do. reference_id=%s", id)
+			return nexiDto, nil
+		}
+
 		if transaction.Status != paymentservice.Pending && transaction.Status != paymentservice.Tentative {
 			aulogging.Logger.Ctx(ctx).Warn().Printf(
 				"aborting transaction update - currently in status %s! reference_id=%s", transaction.Status, id,
@@ -44,12 +60,15 @@ func (i *Impl) CheckPaymentStatus(ctx context.Context, id string) (nexiapi.Payme
 			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 				ReferenceId: id,
 				ApiId:       nexiDto.Id,
-				Kind:        "warning",
+				Kind:        entity.KindWarning,
 				Message:     fmt.Sprintf("status-check: payment in status %s - skipping update", transaction.Status),
 				Details: fmt.Sprintf("transaction_status=%s upstream_status=%s",
 					transaction.Status,
 					nexiDto.Status),
-				RequestId: ctxvalues.RequestId(ctx),
+				UpstreamStatus:    nexiDto.Status,
+				PaymentMethod:     nexiDto.PaymentMethod,
+				TransactionStatus: string(transaction.Status),
+				RequestId:         ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "status-check", id, fmt.Sprintf("abort-update-for-%s-%s", transaction.Status, nexiDto.Status))
 			return nexiDto, TransactionStatusError
@@ -63,7 +82,7 @@ func (i *Impl) CheckPaymentStatus(ctx context.Context, id string) (nexiapi.Payme
 			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 				ReferenceId: id,
 				ApiId:       nexiDto.Id,
-				Kind:        "warning",
+				Kind:        entity.KindWarning,
 				Message:     fmt.Sprintf("status-check: amount or currency differs - skipping update"),
 				Details: fmt.Sprintf("tx_amount=%d upstream_amount=%d tx_currency=%s upstream_currency=%s transaction_status=%s upstream_status=%s",
 					transaction.Amount.GrossCent,
@@ -72,7 +91,12 @@ func (i *Impl) CheckPaymentStatus(ctx context.Context, id string) (nexiapi.Payme
 					nexiDto.Currency,
 					transaction.Status,
 					nexiDto.Status),
-				RequestId: ctxvalues.RequestId(ctx),
+				Amount:            amountOf(transaction.Amount.GrossCent),
+				Currency:          transaction.Amount.Currency,
+				UpstreamStatus:    nexiDto.Status,
+				PaymentMethod:     nexiDto.PaymentMethod,
+				TransactionStatus: string(transaction.Status),
+				RequestId:         ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "status-check", id, "abort-update-values-differ")
 			return nexiDto, TransactionDataMismatchError
@@ -86,28 +110,73 @@ func (i *Impl) CheckPaymentStatus(ctx context.Context, id string) (nexiapi.Payme
 			aulogging.Logger.Ctx(ctx).Error().Printf("status-check unable to update upstream transaction. reference_id=%s", id)
 			db := database.GetRepository()
 			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: id,
-				ApiId:       nexiDto.Id,
-				Kind:        "error",
-				Message:     "status-check failed to update transaction",
-				Details:     fmt.Sprintf("amount=%d currency=%s error=%s", transaction.Amount.GrossCent, transaction.Amount.Currency, err.Error()),
-				RequestId:   ctxvalues.RequestId(ctx),
+				ReferenceId:       id,
+				ApiId:             nexiDto.Id,
+				Kind:              entity.KindError,
+				Message:           "status-check failed to update transaction",
+				Details:           fmt.Sprintf("amount=%d currency=%s error=%s", transaction.Amount.GrossCent, transaction.Amount.Currency, err.Error()),
+				Amount:            amountOf(transaction.Amount.GrossCent),
+				Currency:          transaction.Amount.Currency,
+				UpstreamStatus:    nexiDto.Status,
+				PaymentMethod:     nexiDto.PaymentMethod,
+				TransactionStatus: string(transaction.Status),
+				RequestId:         ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "status-check", id, "update-tx-err")
 			return nexiDto, err
 		}
 
 		aulogging.Logger.Ctx(ctx).Info().Printf("status-check: successfully updated upstream transaction to valid. reference_id=%s", id)
-		db := database.GetRepository()
-		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: id,
-			ApiId:       nexiDto.Id,
-			Kind:        "success",
-			Message:     "transaction updated successfully by status-check",
-			Details:     fmt.Sprintf("amount=%d currency=%s upstream=%s", transaction.Amount.GrossCent, transaction.Amount.Currency, nexiDto.Status),
-			RequestId:   ctxvalues.RequestId(ctx),
-		})
+		entry := &entity.ProtocolEntry{
+			ReferenceId:       id,
+			ApiId:             nexiDto.Id,
+			Kind:              entity.KindSuccess,
+			Message:           "transaction updated successfully by status-check",
+			Details:           fmt.Sprintf("amount=%d currency=%s upstream=%s", transaction.Amount.GrossCent, transaction.Amount.Currency, nexiDto.Status),
+			Amount:            amountOf(transaction.Amount.GrossCent),
+			Currency:          transaction.Amount.Currency,
+			UpstreamStatus:    nexiDto.Status,
+			PaymentMethod:     nexiDto.PaymentMethod,
+			TransactionStatus: string(transaction.Status),
+			RequestId:         ctxvalues.RequestId(ctx),
+		}
+		_ = database.GetRepository().WriteProtocolEntry(ctx, entry)
+		i.publishPaymentEvent(ctx, EventSourceStatusCheck, entry, nexiDto.ResponseCode)
 	}
 
 	return nexiDto, nil
 }
+
+func (i *Impl) CheckReturningPayment(ctx context.Context, id string) (ReturnOutcome, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.CheckReturningPayment")
+	defer span.End()
+
+	transaction, err := paymentservice.Get().GetTransactionByReferenceId(ctx, id)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("return: error fetching transaction from payment service. reference_id=%s err=%s", id, err.Error())
+		return ReturnOutcomeUnknown, err
+	}
+	if transaction.Status == paymentservice.Valid {
+		// usually the webhook was faster, nothing left to do
+		return ReturnOutcomePaid, nil
+	}
+	if transaction.Status != paymentservice.Pending && transaction.Status != paymentservice.Tentative {
+		aulogging.Logger.Ctx(ctx).Info().Printf("return: transaction in status %s. reference_id=%s", transaction.Status, id)
+		return ReturnOutcomeFailed, nil
+	}
+
+	nexiDto, err := i.checkPaymentStatus(ctx, id, true)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("return: status check failed. reference_id=%s err=%s", id, err.Error())
+		return ReturnOutcomeUnknown, err
+	}
+
+	switch {
+	case nexiDto.Status == "OK" && nexiDto.ResponseCode == "00000000":
+		return ReturnOutcomePaid, nil
+	case nexiDto.Status == "FAILED":
+		return ReturnOutcomeFailed, nil
+	default:
+		return ReturnOutcomePending, nil
+	}
+}
@@ -0,0 +1,159 @@
+package paymentlinksrv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+)
+
+const (
+	EventSourceWebhook     = "webhook"
+	EventSourceStatusCheck = "status-check"
+	EventSourceRecord      = "record"
+)
+
+// subscribers that fall this many events behind miss the newer ones, publishing never blocks
+const eventBufferSize = 16
+
+// eventBroker distributes payment events to the subscribers of their reference id, in process.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan nexiapi.PaymentEventDto]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{
+		subscribers: make(map[string]map[chan nexiapi.PaymentEventDto]struct{}),
+	}
+}
+
+// subscribe registers a subscriber for id and sends it the events returned by initial first.
+//
+// initial is called while no events can be published, so an event is either among them or delivered afterwards.
+// It may be in both, which subscribers recognize by its event id.
+func (b *eventBroker) subscribe(ctx context.Context, id string, initial func() []nexiapi.PaymentEventDto) (chan nexiapi.PaymentEventDto, func()) {
+	ch := make(chan nexiapi.PaymentEventDto, eventBufferSize)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[id] == nil {
+		b.subscribers[id] = make(map[chan nexiapi.PaymentEventDto]struct{})
+	}
+	b.subscribers[id][ch] = struct{}{}
+
+	for _, event := range initial() {
+		select {
+		case ch <- event:
+		default:
+			aulogging.Logger.Ctx(ctx).Warn().Printf("too many payment events to replay, dropping event. reference_id=%s status=%s", event.ReferenceId, event.Status)
+		}
+	}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers[id], ch)
+			if len(b.subscribers[id]) == 0 {
+				delete(b.subscribers, id)
+			}
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+func (b *eventBroker) publish(ctx context.Context, event nexiapi.PaymentEventDto) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[event.ReferenceId] {
+		select {
+		case ch <- event:
+		default:
+			aulogging.Logger.Ctx(ctx).Warn().Printf("payment event subscriber too slow, dropping event. reference_id=%s status=%s", event.ReferenceId, event.Status)
+		}
+	}
+}
+
+func (i *Impl) SubscribePaymentEvents(ctx context.Context, id string, lastEventId uint) (<-chan nexiapi.PaymentEventDto, func()) {
+	return i.events.subscribe(ctx, id, func() []nexiapi.PaymentEventDto {
+		if lastEventId > 0 {
+			return replayPaymentEvents(ctx, id, lastEventId)
+		}
+		return lastKnownPaymentEvent(ctx, id)
+	})
+}
+
+// lastKnownPaymentEvent returns the last known status from our record of the paylink, if any.
+func lastKnownPaymentEvent(ctx context.Context, id string) []nexiapi.PaymentEventDto {
+	paylinks, _, err := database.GetRepository().QueryPaylinks(ctx, dbrepo.PaylinkQuery{ReferenceId: id})
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("could not read paylink record for event stream, continuing without. reference_id=%s", id)
+		return nil
+	}
+	if len(paylinks) == 0 || paylinks[0].Status == "" || paylinks[0].StatusAt == nil {
+		return nil
+	}
+	return []nexiapi.PaymentEventDto{{
+		ReferenceId: id,
+		PaymentId:   paylinks[0].ApiId,
+		Status:      paylinks[0].Status,
+		Source:      EventSourceRecord,
+		Timestamp:   paylinks[0].StatusAt.UTC().Format(time.RFC3339),
+	}}
+}
+
+// replayPaymentEvents returns the events published after the event with id lastEventId, from the protocol.
+func replayPaymentEvents(ctx context.Context, id string, lastEventId uint) []nexiapi.PaymentEventDto {
+	entries, _, err := database.GetRepository().QueryProtocolEntries(ctx, dbrepo.ProtocolQuery{
+		ReferenceId: id,
+		Kinds:       []entity.ProtocolKind{entity.KindSuccess, entity.KindPending},
+	})
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("could not read protocol for event stream, continuing without. reference_id=%s", id)
+		return nil
+	}
+
+	result := make([]nexiapi.PaymentEventDto, 0)
+	for _, e := range entries {
+		// only the transaction updates published an event
+		if e.ID <= lastEventId || e.TransactionStatus == "" {
+			continue
+		}
+		source := EventSourceStatusCheck
+		if e.WebhookStatus != "" {
+			source = EventSourceWebhook
+		}
+		result = append(result, nexiapi.PaymentEventDto{
+			EventId:     e.ID,
+			ReferenceId: id,
+			PaymentId:   e.ApiId,
+			Status:      e.UpstreamStatus,
+			Source:      source,
+			Timestamp:   e.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return result
+}
+
+// publishPaymentEvent publishes the transaction update recorded in the protocol entry e.
+//
+// The id of the entry becomes the event id, so streams can be resumed from the protocol.
+func (i *Impl) publishPaymentEvent(ctx context.Context, source string, e *entity.ProtocolEntry, responseCode string) {
+	i.events.publish(ctx, nexiapi.PaymentEventDto{
+		EventId:      e.ID,
+		ReferenceId:  e.ReferenceId,
+		PaymentId:    e.ApiId,
+		Status:       e.UpstreamStatus,
+		ResponseCode: responseCode,
+		Source:       source,
+		Timestamp:    i.Now().UTC().Format(time.RFC3339),
+	})
+}
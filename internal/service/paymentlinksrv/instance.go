@@ -10,11 +10,15 @@ var NowFunc = time.Now
 type Impl struct {
 	Now               func() time.Time
 	simulationMatcher *regexp.Regexp
+	events            *eventBroker
+	rejections        *rejectionThrottle
 }
 
 func New() PaymentLinkService {
 	return &Impl{
 		Now:               NowFunc,
 		simulationMatcher: regexp.MustCompile(`^[0-9]{4}$`),
+		events:            newEventBroker(),
+		rejections:        newRejectionThrottle(),
 	}
 }
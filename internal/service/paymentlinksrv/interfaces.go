@@ -6,6 +6,8 @@ import (
 	"net/url"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	nexiapiv2 "github.com/eurofurence/reg-paygate-adapter/internal/api/v2/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 )
 
 type PaymentLinkService interface {
@@ -20,6 +22,25 @@ type PaymentLinkService interface {
 	// id under which to manage the payment link.
 	CreatePaymentLink(ctx context.Context, request nexiapi.PaymentLinkRequestDto) (nexiapi.PaymentLinkDto, string, error)
 
+	// ListPaymentLinks returns our own records of the payment links matching the query, with the last known Paygate status.
+	//
+	// Page and PageSize of the result are left for the caller to fill in.
+	ListPaymentLinks(ctx context.Context, query dbrepo.PaylinkQuery) (nexiapi.PaymentLinkListDto, error)
+
+	// CreatePaylink is CreatePaymentLink, returning the representation of api v2.
+	CreatePaylink(ctx context.Context, request nexiapi.PaymentLinkRequestDto) (nexiapiv2.PaylinkDto, string, error)
+
+	// ListPaylinks is ListPaymentLinks in the representation of api v2, without querying Paygate.
+	//
+	// Page, PageSize and Links of the result are left for the caller to fill in.
+	ListPaylinks(ctx context.Context, query dbrepo.PaylinkQuery) (nexiapiv2.PaylinkListDto, error)
+
+	// GetPaylink merges our record of the payment link with the payment at Paygate and the status history from the
+	// protocol, in the representation of api v2.
+	//
+	// Payment links we have a record of are returned even if Paygate does not know a payment for them yet.
+	GetPaylink(ctx context.Context, id string) (nexiapiv2.PaylinkDto, error)
+
 	// GetPayment obtains the payment information from the downstream api.
 	GetPayment(ctx context.Context, id string) (nexiapi.PaymentDto, error)
 
@@ -28,9 +49,52 @@ type PaymentLinkService interface {
 	// id is a reference id. First it gets the payment from payment service to ensure it exists, then it
 	CheckPaymentStatus(ctx context.Context, id string) (nexiapi.PaymentDto, error)
 
-	// LogRawWebhook logs the payload of an incoming webhook both in the DB and the service log
+	// CapturePayment captures amount of the authorized payment with reference id id at Paygate, or everything that was
+	// authorized and not captured yet if amount is nil.
+	//
+	// Fails with an error wrapping CaptureNotPossibleError if the payment is not authorized, nothing is left to
+	// capture, amount exceeds what is left, or Paygate rejects the capture. The booking in the payment service is
+	// updated by the webhook Paygate sends once the payment is captured in full.
+	CapturePayment(ctx context.Context, id string, amount *int64) error
+
+	// RefundPayment refunds amount of the payment with reference id id at Paygate, or everything that was captured
+	// and not refunded yet if amount is nil.
+	//
+	// Fails with an error wrapping RefundNotPossibleError if nothing is left to refund, amount exceeds what is left,
+	// or Paygate rejects the refund. The booking in the payment service is not changed.
+	RefundPayment(ctx context.Context, id string, amount *int64) error
+
+	// CancelPayment reverses the authorization of the payment with reference id id at Paygate.
+	//
+	// Fails with an error wrapping CancelNotPossibleError if the payment is not authorized, has already been
+	// partially captured, or Paygate rejects the reversal. The booking in the payment service is not changed.
+	CancelPayment(ctx context.Context, id string) error
+
+	// CheckReturningPayment finds out whether the payment with reference id id has succeeded, for the attendee
+	// returning from the hosted payment page.
+	//
+	// Pending payments are run through CheckPaymentStatus, so they are updated even if the webhook has not arrived yet.
+	// Payments that are already valid are not checked again. Returns ReturnOutcomeUnknown with an error if
+	// the status could not be determined.
+	CheckReturningPayment(ctx context.Context, id string) (ReturnOutcome, error)
+
+	// SubscribePaymentEvents subscribes to the events for the payment with reference id id, published whenever
+	// a webhook or status check has successfully updated its transaction, with the status verified at Paygate.
+	//
+	// Events are numbered by the protocol entry that records the update. If lastEventId is set, the events after it
+	// are replayed from the protocol first, else the last known status is delivered first if there is one.
+	// The returned function ends the subscription and closes the channel, it must be called.
+	SubscribePaymentEvents(ctx context.Context, id string, lastEventId uint) (<-chan nexiapi.PaymentEventDto, func())
+
+	// LogRawWebhook logs the payload of an incoming webhook both in the DB and the service log, with personal data redacted
 	LogRawWebhook(ctx context.Context, payload string) error
 
+	// LogRejectedWebhook logs and records a webhook or weblogger call from a source ip outside the configured allowlist
+	//
+	// If the call knew the webhook secret, we are also notified by mail, because the secret has then leaked.
+	// Repeated calls from the same ip are only recorded and mailed once per hour, so they cannot flood the protocol.
+	LogRejectedWebhook(ctx context.Context, endpoint string, ip string, secretValid bool)
+
 	// HandleWebhook requests the payment referenced in the webhook data and reacts to any payment status updates
 	HandleWebhook(ctx context.Context, webhook nexiapi.WebhookDto) error
 
@@ -38,10 +102,23 @@ type PaymentLinkService interface {
 	SendErrorNotifyMail(ctx context.Context, operation string, referenceId string, status string) error
 }
 
+// ReturnOutcome is the result of CheckReturningPayment, passed on to the success or failure page.
+type ReturnOutcome string
+
+const (
+	ReturnOutcomePaid    ReturnOutcome = "paid"
+	ReturnOutcomePending ReturnOutcome = "pending"
+	ReturnOutcomeFailed  ReturnOutcome = "failed"
+	ReturnOutcomeUnknown ReturnOutcome = "unknown"
+)
+
 var (
 	ReceivedEmptyPaylink         = errors.New("received empty paylink")
 	WebhookValidationErr         = errors.New("webhook referenced invalid invoice id, must be positive integer")
 	WebhookRefIdMismatchErr      = errors.New("webhook reference_id differes from paylink reference_id")
 	TransactionStatusError       = errors.New("transaction status blocks update")
 	TransactionDataMismatchError = errors.New("transaction data mismatch")
+	CaptureNotPossibleError      = errors.New("capture not possible")
+	RefundNotPossibleError       = errors.New("refund not possible")
+	CancelNotPossibleError       = errors.New("cancel not possible")
 )
@@ -6,12 +6,18 @@ import (
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 )
 
 func (i *Impl) SendErrorNotifyMail(ctx context.Context, operation string, referenceId string, status string) error {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.SendErrorNotifyMail")
+	defer span.End()
+
 	notifyMail := config.ErrorNotifyMail()
 	if notifyMail == "" {
 		aulogging.Logger.Ctx(ctx).Error().Printf("error notification mail cannot be sent - no address configured. Operation: %s, ReferenceId: %s, Status: %s", operation, referenceId, status)
+		metrics.NotifyMailSent(operation, "not_configured")
 		return nil
 	} else {
 		aulogging.Logger.Ctx(ctx).Warn().Printf("sending error notification mail - Operation: %s, ReferenceId: %s, Status: %s", operation, referenceId, status)
@@ -34,7 +40,9 @@ func (i *Impl) SendErrorNotifyMail(ctx context.Context, operation string, refere
 	err := mailservice.Get().SendEmail(ctx, mailDto)
 	if err != nil {
 		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to send error notification mail to %s - Operation: %s, ReferenceId: %s, Status: %s - error was: %s", notifyMail, operation, referenceId, status, err.Error())
+		metrics.NotifyMailSent(operation, "failed")
 		return err
 	}
+	metrics.NotifyMailSent(operation, "sent")
 	return nil
 }
@@ -5,18 +5,25 @@ import (
 	"fmt"
 	"math"
 	"net/url"
+	"time"
 
+	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/attendeeservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 )
 
 func (i *Impl) ValidatePaymentLinkRequest(ctx context.Context, data nexiapi.PaymentLinkRequestDto) url.Values {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.ValidatePaymentLinkRequest")
+	defer span.End()
+
 	errs := url.Values{}
 
 	if data.DebitorId == 0 {
@@ -31,6 +38,12 @@ func (i *Impl) ValidatePaymentLinkRequest(ctx context.Context, data nexiapi.Paym
 	if data.VatRate < 0.0 || data.VatRate > 50.0 {
 		errs.Add("vat_rate", "vat rate should be provided in percent and must be between 0.0 and 50.0")
 	}
+	if data.SuccessUrl != "" && (len(data.SuccessUrl) > 2048 || !config.RedirectAllowed(data.SuccessUrl)) {
+		errs.Add("success_url", "if set, must be an absolute url of at most 2048 characters, on a host and path in the redirect allowlist")
+	}
+	if data.CancelUrl != "" && (len(data.CancelUrl) > 2048 || !config.RedirectAllowed(data.CancelUrl)) {
+		errs.Add("cancel_url", "if set, must be an absolute url of at most 2048 characters, on a host and path in the redirect allowlist")
+	}
 
 	if len(errs) == 0 {
 		return nil
@@ -40,6 +53,9 @@ func (i *Impl) ValidatePaymentLinkRequest(ctx context.Context, data nexiapi.Paym
 }
 
 func (i *Impl) CreatePaymentLink(ctx context.Context, data nexiapi.PaymentLinkRequestDto) (nexiapi.PaymentLinkDto, string, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.CreatePaymentLink")
+	defer span.End()
+
 	attendee, err := attendeeservice.Get().GetAttendee(ctx, uint(data.DebitorId))
 	if err != nil {
 		return nexiapi.PaymentLinkDto{}, "", err
@@ -51,9 +67,12 @@ func (i *Impl) CreatePaymentLink(ctx context.Context, data nexiapi.PaymentLinkRe
 		db := database.GetRepository()
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 			ReferenceId: nexiRequest.TransId,
-			Kind:        "error",
+			Kind:        entity.KindError,
 			Message:     "create-pay-link failed",
+			Operation:   entity.OperationCreate,
 			Details:     err.Error(),
+			Amount:      amountOf(data.AmountDue),
+			Currency:    data.Currency,
 			RequestId:   ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "create-pay-link", data.ReferenceId, err.Error())
@@ -64,9 +83,12 @@ func (i *Impl) CreatePaymentLink(ctx context.Context, data nexiapi.PaymentLinkRe
 		db := database.GetRepository()
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 			ReferenceId: nexiRequest.TransId,
-			Kind:        "error",
+			Kind:        entity.KindError,
 			Message:     "create-pay-link empty",
+			Operation:   entity.OperationCreate,
 			Details:     "response did not include a redirect link",
+			Amount:      amountOf(data.AmountDue),
+			Currency:    data.Currency,
 			RequestId:   ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "create-pay-link", data.ReferenceId, "response did not include a redirect link")
@@ -75,18 +97,70 @@ func (i *Impl) CreatePaymentLink(ctx context.Context, data nexiapi.PaymentLinkRe
 	db := database.GetRepository()
 	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 		ReferenceId: nexiRequest.TransId,
-		Kind:        "success",
+		Kind:        entity.KindSuccess,
 		Message:     "create-pay-link",
+		Operation:   entity.OperationCreate,
 		Details:     redirect.Href,
+		Amount:      amountOf(data.AmountDue),
+		Currency:    data.Currency,
 		RequestId:   ctxvalues.RequestId(ctx),
 	})
+	err = db.WritePaylink(ctx, &entity.Paylink{
+		ReferenceId: nexiRequest.TransId,
+		DebitorId:   data.DebitorId,
+		Amount:      data.AmountDue,
+		Currency:    data.Currency,
+		VatRate:     data.VatRate,
+		Link:        redirect.Href,
+		Subject:     ctxvalues.Subject(ctx),
+		Client:      ctxvalues.ApiClient(ctx),
+	})
+	if err != nil {
+		// the paylink works anyway, it is just missing from the list
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to record paylink %s: %s", nexiRequest.TransId, err.Error())
+	}
 	output := i.apiResponseFromNexiResponse(nexiResponse, nexiRequest)
 	return output, nexiRequest.TransId, nil
 }
 
+func (i *Impl) ListPaymentLinks(ctx context.Context, query dbrepo.PaylinkQuery) (nexiapi.PaymentLinkListDto, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.ListPaymentLinks")
+	defer span.End()
+
+	paylinks, total, err := database.GetRepository().QueryPaylinks(ctx, query)
+	if err != nil {
+		return nexiapi.PaymentLinkListDto{}, err
+	}
+
+	result := nexiapi.PaymentLinkListDto{
+		Paylinks: make([]nexiapi.PaymentLinkRecordDto, 0, len(paylinks)),
+		Total:    total,
+	}
+	for _, p := range paylinks {
+		dto := nexiapi.PaymentLinkRecordDto{
+			ReferenceId: p.ReferenceId,
+			DebitorId:   p.DebitorId,
+			AmountDue:   p.Amount,
+			Currency:    p.Currency,
+			VatRate:     p.VatRate,
+			Link:        p.Link,
+			CreatedAt:   p.CreatedAt.UTC().Format(time.RFC3339),
+			PaymentId:   p.ApiId,
+			Status:      p.Status,
+			Subject:     p.Subject,
+			Client:      p.Client,
+		}
+		if p.StatusAt != nil {
+			dto.StatusUpdatedAt = p.StatusAt.UTC().Format(time.RFC3339)
+		}
+		result.Paylinks = append(result.Paylinks, dto)
+	}
+	return result, nil
+}
+
 func (i *Impl) nexiCreateRequestFromApiRequest(data nexiapi.PaymentLinkRequestDto, attendee attendeeservice.AttendeeDto) nexi.NexiCreateCheckoutSessionRequest {
-	amountDue := int64(data.AmountDue)
-	taxAmountCents := int64(math.Round(float64(data.AmountDue) * data.VatRate / 100.0))
+	amountDue := data.AmountDue
+	taxAmountCents := taxAmount(amountDue, data.VatRate)
 	netItemTotal := amountDue - taxAmountCents
 
 	language := "en"
@@ -101,6 +175,30 @@ func (i *Impl) nexiCreateRequestFromApiRequest(data nexiapi.PaymentLinkRequestDt
 		webhook = config.ServicePublicURL() + "/api/rest/v1/webhook/" + config.WebhookSecret()
 	}
 
+	returnUrl, cancelUrl := config.SuccessRedirect(), config.FailureRedirect()
+	if data.SuccessUrl != "" {
+		returnUrl = data.SuccessUrl
+	}
+	if data.CancelUrl != "" {
+		cancelUrl = data.CancelUrl
+	}
+	if config.ServicePublicURL() != "" {
+		// the attendee comes back through our return endpoint, which checks the payment before showing the result.
+		// It checks the requested pages against the allowlist again, so it cannot be abused as an open redirect.
+		returnUrl = config.ServicePublicURL() + "/api/rest/v1/return/" + url.PathEscape(data.ReferenceId)
+		query := url.Values{}
+		if data.SuccessUrl != "" {
+			query.Set("success", data.SuccessUrl)
+		}
+		if data.CancelUrl != "" {
+			query.Set("cancel", data.CancelUrl)
+		}
+		if len(query) > 0 {
+			returnUrl += "?" + query.Encode()
+		}
+		cancelUrl = returnUrl
+	}
+
 	request := nexi.NexiCreateCheckoutSessionRequest{
 		TransId: data.ReferenceId,
 		Amount: nexi.NexiAmount{
@@ -111,8 +209,8 @@ func (i *Impl) nexiCreateRequestFromApiRequest(data nexiapi.PaymentLinkRequestDt
 		},
 		Language: language,
 		Urls: nexi.NexiPaymentUrlsRequest{
-			Return:  config.SuccessRedirect(),
-			Cancel:  config.FailureRedirect(),
+			Return:  returnUrl,
+			Cancel:  cancelUrl,
 			Webhook: webhook,
 		},
 		StatementDescriptor: config.InvoiceTitle(),
@@ -167,6 +265,11 @@ func (i *Impl) apiResponseFromNexiResponse(response nexi.NexiCreateCheckoutSessi
 	}
 }
 
+// taxAmount is the vat we report to Paygate for an amount, in the smallest denomination
+func taxAmount(amount int64, vatRate float64) int64 {
+	return int64(math.Round(float64(amount) * vatRate / 100.0))
+}
+
 func p[T comparable](t T) *T {
 	var nullValue T
 	if t == nullValue {
@@ -0,0 +1,260 @@
+package paymentlinksrv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	nexiapiv2 "github.com/eurofurence/reg-paygate-adapter/internal/api/v2/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
+)
+
+// status history entries not caused by a webhook come from a status check or lookup
+const StatusSourceQuery = "query"
+
+func (i *Impl) CreatePaylink(ctx context.Context, request nexiapi.PaymentLinkRequestDto) (nexiapiv2.PaylinkDto, string, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.CreatePaylink")
+	defer span.End()
+
+	created, id, err := i.CreatePaymentLink(ctx, request)
+	if err != nil {
+		return nexiapiv2.PaylinkDto{}, "", err
+	}
+
+	records, _, err := database.GetRepository().QueryPaylinks(ctx, dbrepo.PaylinkQuery{ReferenceId: id})
+	if err == nil && len(records) > 0 {
+		return paylinkFromRecord(records[0]), id, nil
+	}
+
+	// the paylink works anyway, it is just missing from our records
+	result := paylinkWithItems(id, request.DebitorId, created.AmountDue, created.Currency, request.VatRate)
+	result.Links["payment_page"] = nexiapiv2.LinkDto{Href: created.Link, Method: http.MethodGet}
+	return result, id, nil
+}
+
+func (i *Impl) GetPaylink(ctx context.Context, id string) (nexiapiv2.PaylinkDto, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.GetPaylink")
+	defer span.End()
+
+	db := database.GetRepository()
+	records, _, err := db.QueryPaylinks(ctx, dbrepo.PaylinkQuery{ReferenceId: id})
+	if err != nil {
+		return nexiapiv2.PaylinkDto{}, err
+	}
+
+	var result nexiapiv2.PaylinkDto
+	upstream, err := nexi.Get().QueryPaymentLink(ctx, id)
+	if err != nil {
+		if len(records) == 0 || !errors.Is(err, nexi.NoSuchID404Error) {
+			i.paymentQueryFailed(ctx, id, err)
+			return nexiapiv2.PaylinkDto{}, err
+		}
+		// Paygate only knows payments once the attendee has opened the payment page
+		result = paylinkFromRecord(records[0])
+	} else {
+		i.paymentQueried(ctx, id, upstream)
+		if len(records) > 0 {
+			result = paylinkFromRecord(records[0])
+		} else {
+			// created before we kept records
+			result = paylinkFromUpstream(id, upstream)
+		}
+		withUpstreamState(&result, upstream)
+	}
+
+	result.StatusHistory, err = statusHistory(ctx, id)
+	if err != nil {
+		return nexiapiv2.PaylinkDto{}, err
+	}
+	return result, nil
+}
+
+func (i *Impl) ListPaylinks(ctx context.Context, query dbrepo.PaylinkQuery) (nexiapiv2.PaylinkListDto, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.ListPaylinks")
+	defer span.End()
+
+	records, total, err := database.GetRepository().QueryPaylinks(ctx, query)
+	if err != nil {
+		return nexiapiv2.PaylinkListDto{}, err
+	}
+
+	result := nexiapiv2.PaylinkListDto{
+		Paylinks: make([]nexiapiv2.PaylinkDto, 0, len(records)),
+		Total:    total,
+	}
+	for _, record := range records {
+		result.Paylinks = append(result.Paylinks, paylinkFromRecord(record))
+	}
+	return result, nil
+}
+
+func withUpstreamState(result *nexiapiv2.PaylinkDto, upstream nexi.NexiPaymentQueryResponse) {
+	result.Status = upstream.Status
+	result.ResponseCode = upstream.ResponseCode
+	result.PaymentId = upstream.PayId
+	if upstream.PaymentMethods != nil {
+		result.PaymentMethod = upstream.PaymentMethods.Type
+	}
+	if upstream.Amount != nil {
+		if upstream.Amount.CapturedValue != nil {
+			result.Amount.Captured = *upstream.Amount.CapturedValue
+		}
+		if upstream.Amount.RefundedValue != nil {
+			result.Amount.Refunded = *upstream.Amount.RefundedValue
+		}
+	}
+	result.ExpiresAt = upstream.ExpirationTime
+	withActionLinks(result, true)
+}
+
+func paylinkFromRecord(record *entity.Paylink) nexiapiv2.PaylinkDto {
+	result := paylinkWithItems(record.ReferenceId, record.DebitorId, record.Amount, record.Currency, record.VatRate)
+	result.Status = record.Status
+	result.PaymentId = record.ApiId
+	result.CreatedAt = record.CreatedAt.UTC().Format(time.RFC3339)
+	if record.Link != "" {
+		result.Links["payment_page"] = nexiapiv2.LinkDto{Href: record.Link, Method: http.MethodGet}
+	}
+	withActionLinks(&result, false)
+	return result
+}
+
+func paylinkFromUpstream(id string, upstream nexi.NexiPaymentQueryResponse) nexiapiv2.PaylinkDto {
+	debitorId, _ := debitorIdFromReferenceID(id)
+	amount, currency := int64(0), ""
+	if upstream.Amount != nil {
+		amount, currency = upstream.Amount.Value, upstream.Amount.Currency
+	}
+	vatRate := float64(0.0)
+	if upstream.Order != nil && len(upstream.Order.Items) > 0 {
+		vatRate = float64(upstream.Order.Items[0].TaxRate) / 100.0
+	}
+	return paylinkWithItems(id, uint64(debitorId), amount, currency, vatRate)
+}
+
+// paylinkWithItems fills in the amounts, line items and links. Payment links have a single item, see nexiCreateRequestFromApiRequest.
+func paylinkWithItems(id string, debitorId uint64, amount int64, currency string, vatRate float64) nexiapiv2.PaylinkDto {
+	vat := taxAmount(amount, vatRate)
+	items := []nexiapiv2.PaylinkLineItemDto{
+		{
+			Name:        config.InvoicePurpose(),
+			Quantity:    1,
+			GrossAmount: amount,
+			NetAmount:   amount - vat,
+			VatAmount:   vat,
+			VatRate:     vatRate,
+		},
+	}
+
+	return nexiapiv2.PaylinkDto{
+		ReferenceId: id,
+		DebitorId:   debitorId,
+		Amount: nexiapiv2.PaylinkAmountDto{
+			Currency: currency,
+			Due:      amount,
+		},
+		LineItems:    items,
+		VatBreakdown: vatBreakdown(items),
+		Links:        paylinkLinks(id),
+	}
+}
+
+func vatBreakdown(items []nexiapiv2.PaylinkLineItemDto) []nexiapiv2.PaylinkVatDto {
+	result := make([]nexiapiv2.PaylinkVatDto, 0)
+	for _, item := range items {
+		found := false
+		for k := range result {
+			if result[k].VatRate == item.VatRate {
+				result[k].NetAmount += item.NetAmount
+				result[k].VatAmount += item.VatAmount
+				result[k].GrossAmount += item.GrossAmount
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, nexiapiv2.PaylinkVatDto{
+				VatRate:     item.VatRate,
+				NetAmount:   item.NetAmount,
+				VatAmount:   item.VatAmount,
+				GrossAmount: item.GrossAmount,
+			})
+		}
+	}
+	return result
+}
+
+// paylinkLinks lists the related resources and actions, see withActionLinks for the actions that depend on the status.
+func paylinkLinks(id string) map[string]nexiapiv2.LinkDto {
+	escaped := url.PathEscape(id)
+	return map[string]nexiapiv2.LinkDto{
+		"self":         {Href: "/api/rest/v2/paylinks/" + escaped, Method: http.MethodGet},
+		"events":       {Href: "/api/rest/v1/paylinks/" + escaped + "/events", Method: http.MethodGet},
+		"status_check": {Href: "/api/rest/v1/paylinks/" + escaped + "/status-check", Method: http.MethodPost},
+	}
+}
+
+// withActionLinks offers capture, refund and cancel as far as the status and amounts of the payment allow them.
+//
+// Without the amounts from Paygate, payments in status OK are taken to be captured and not refunded.
+func withActionLinks(result *nexiapiv2.PaylinkDto, amountsKnown bool) {
+	escaped := url.PathEscape(result.ReferenceId)
+	delete(result.Links, "capture")
+	delete(result.Links, "refund")
+	delete(result.Links, "cancel")
+
+	authorized := result.Status == "AUTHORIZED"
+	if authorized && (!amountsKnown || result.Amount.Captured < result.Amount.Due) {
+		result.Links["capture"] = nexiapiv2.LinkDto{Href: "/api/rest/v1/paylinks/" + escaped + "/capture", Method: http.MethodPost}
+	}
+	if authorized && result.Amount.Captured == 0 {
+		result.Links["cancel"] = nexiapiv2.LinkDto{Href: "/api/rest/v1/paylinks/" + escaped + "/cancel", Method: http.MethodPost}
+	}
+	refundable := result.Status == "OK"
+	if amountsKnown {
+		refundable = result.Amount.Captured > result.Amount.Refunded
+	}
+	if refundable {
+		result.Links["refund"] = nexiapiv2.LinkDto{Href: "/api/rest/v1/paylinks/" + escaped + "/refund", Method: http.MethodPost}
+	}
+}
+
+// statusHistory reads the status changes from the protocol, repeated statuses are only listed once
+func statusHistory(ctx context.Context, id string) ([]nexiapiv2.PaylinkStatusChangeDto, error) {
+	entries, _, err := database.GetRepository().QueryProtocolEntries(ctx, dbrepo.ProtocolQuery{
+		ReferenceId:  id,
+		ExcludeKinds: []entity.ProtocolKind{entity.KindRaw},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]nexiapiv2.PaylinkStatusChangeDto, 0)
+	for _, entry := range entries {
+		change := nexiapiv2.PaylinkStatusChangeDto{
+			Status:    entry.UpstreamStatus,
+			Source:    StatusSourceQuery,
+			Timestamp: entry.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if entry.WebhookStatus != "" {
+			// webhooks are verified by querying the payment, so the status from the query wins if there is one
+			change.Source = EventSourceWebhook
+			if change.Status == "" {
+				change.Status = entry.WebhookStatus
+			}
+		}
+		if change.Status == "" || (len(result) > 0 && result[len(result)-1].Status == change.Status) {
+			continue
+		}
+		result = append(result, change)
+	}
+	return result, nil
+}
@@ -4,38 +4,26 @@ import (
 	"context"
 	"fmt"
 
+	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 )
 
 func (i *Impl) GetPayment(ctx context.Context, id string) (nexiapi.PaymentDto, error) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.GetPayment")
+	defer span.End()
+
 	data, err := nexi.Get().QueryPaymentLink(ctx, id)
 	if err != nil {
-		db := database.GetRepository()
-		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: id,
-			Kind:        "error",
-			Message:     "get-payment failed",
-			Details:     err.Error(),
-			RequestId:   ctxvalues.RequestId(ctx),
-		})
-		_ = i.SendErrorNotifyMail(ctx, "get-payment", fmt.Sprintf("reference id %s", id), err.Error())
+		i.paymentQueryFailed(ctx, id, err)
 		return nexiapi.PaymentDto{}, err
 	}
-
-	db := database.GetRepository()
-	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-		ReferenceId: id,
-		ApiId:       data.PayId,
-		Kind:        "success",
-		Message:     "get-payment",
-		Details:     "",
-		RequestId:   ctxvalues.RequestId(ctx),
-	})
+	i.paymentQueried(ctx, id, data)
 
 	amountDue := int64(0)
 	amountPaid := int64(0)
@@ -66,3 +54,40 @@ func (i *Impl) GetPayment(ctx context.Context, id string) (nexiapi.PaymentDto, e
 
 	return result, nil
 }
+
+// paymentQueryFailed records a failed payment query in the protocol, and notifies us
+func (i *Impl) paymentQueryFailed(ctx context.Context, id string, err error) {
+	db := database.GetRepository()
+	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId: id,
+		Kind:        entity.KindError,
+		Message:     "get-payment failed",
+		Details:     err.Error(),
+		RequestId:   ctxvalues.RequestId(ctx),
+	})
+	_ = i.SendErrorNotifyMail(ctx, "get-payment", fmt.Sprintf("reference id %s", id), err.Error())
+}
+
+// paymentQueried records a successful payment query in the protocol, and updates the last known status of the paylink
+func (i *Impl) paymentQueried(ctx context.Context, id string, data nexi.NexiPaymentQueryResponse) {
+	db := database.GetRepository()
+	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          data.PayId,
+		Kind:           entity.KindSuccess,
+		Message:        "get-payment",
+		Details:        "",
+		UpstreamStatus: data.Status,
+		RequestId:      ctxvalues.RequestId(ctx),
+	})
+	i.paylinkStatusVerified(ctx, id, data)
+}
+
+// paylinkStatusVerified updates the last known status of the paylink from a payment queried at Paygate.
+//
+// Only pass statuses verified at Paygate, never the status from a webhook body.
+func (i *Impl) paylinkStatusVerified(ctx context.Context, id string, data nexi.NexiPaymentQueryResponse) {
+	if err := database.GetRepository().UpdatePaylinkStatus(ctx, id, data.PayId, data.Status); err != nil {
+		aulogging.Logger.Ctx(ctx).Error().Printf("failed to update status of paylink %s: %s", id, err.Error())
+	}
+}
@@ -0,0 +1,107 @@
+package paymentlinksrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+)
+
+func (i *Impl) RefundPayment(ctx context.Context, id string, amount *int64) error {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.RefundPayment")
+	defer span.End()
+
+	if config.NexiDownstreamBaseUrl() == "" {
+		return nexi.NotConfigured
+	}
+
+	upstream, err := nexi.Get().QueryPaymentLink(ctx, id)
+	if err != nil {
+		i.paymentQueryFailed(ctx, id, err)
+		return err
+	}
+	i.paymentQueried(ctx, id, upstream)
+
+	refundable := int64(0)
+	currency := ""
+	if upstream.Amount != nil {
+		if upstream.Amount.CapturedValue != nil {
+			refundable = *upstream.Amount.CapturedValue
+		}
+		if upstream.Amount.RefundedValue != nil {
+			refundable -= *upstream.Amount.RefundedValue
+		}
+		currency = upstream.Amount.Currency
+	}
+	value := refundable
+	if amount != nil {
+		value = *amount
+	}
+	if refundable <= 0 {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("refund: nothing left to refund. reference_id=%s", id)
+		return fmt.Errorf("%w: nothing left to refund", RefundNotPossibleError)
+	}
+	if value > refundable {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("refund: amount %d exceeds refundable amount %d. reference_id=%s", value, refundable, id)
+		return fmt.Errorf("%w: at most %d can be refunded", RefundNotPossibleError, refundable)
+	}
+
+	method := ""
+	if upstream.PaymentMethods != nil {
+		method = upstream.PaymentMethods.Type
+	}
+
+	refunded, err := nexi.Get().RefundPayment(ctx, upstream.PayId, nexi.NexiAmountRequest{
+		TransId: id,
+		Amount: nexi.NexiAmount{
+			Value:    value,
+			Currency: currency,
+		},
+	})
+	db := database.GetRepository()
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().Printf("refund failed. reference_id=%s err=%s", id, err.Error())
+		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+			ReferenceId:    id,
+			ApiId:          upstream.PayId,
+			Kind:           entity.KindError,
+			Message:        "refund failed",
+			Operation:      entity.OperationRefund,
+			Details:        fmt.Sprintf("amount=%d currency=%s error=%s", value, currency, err.Error()),
+			Amount:         amountOf(value),
+			Currency:       currency,
+			UpstreamStatus: upstream.Status,
+			PaymentMethod:  method,
+			RequestId:      ctxvalues.RequestId(ctx),
+		})
+		_ = i.SendErrorNotifyMail(ctx, "refund", id, "refund-failed")
+		if errors.Is(err, nexi.OperationRejected) {
+			return fmt.Errorf("%w: %s", RefundNotPossibleError, err.Error())
+		}
+		return err
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("refunded amount=%d currency=%s reference_id=%s", value, currency, id)
+	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          upstream.PayId,
+		Kind:           entity.KindSuccess,
+		Message:        "refund",
+		Operation:      entity.OperationRefund,
+		Details:        fmt.Sprintf("amount=%d currency=%s", value, currency),
+		Amount:         amountOf(value),
+		Currency:       currency,
+		UpstreamStatus: refunded.Status,
+		PaymentMethod:  method,
+		RequestId:      ctxvalues.RequestId(ctx),
+	})
+	_ = db.UpdatePaylinkStatus(ctx, id, upstream.PayId, refunded.Status)
+	return nil
+}
@@ -0,0 +1,47 @@
+package paymentlinksrv
+
+import (
+	"sync"
+	"time"
+)
+
+// rejectionRecordInterval is how long repeated rejected calls from the same source are not recorded again.
+const rejectionRecordInterval = time.Hour
+
+// rejectionMaxSources is how many sources are remembered at most. While that many have been recorded within the
+// interval, rejected calls from further sources are only logged, so a flood from many ips cannot flood the protocol.
+const rejectionMaxSources = 1000
+
+// rejectionThrottle decides which rejected webhook and weblogger calls are recorded in the protocol and mailed to us.
+type rejectionThrottle struct {
+	mu       sync.Mutex
+	recorded map[string]time.Time
+}
+
+func newRejectionThrottle() *rejectionThrottle {
+	return &rejectionThrottle{
+		recorded: make(map[string]time.Time),
+	}
+}
+
+// shouldRecord is true for the first rejected call from key within rejectionRecordInterval.
+func (t *rejectionThrottle) shouldRecord(key string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.recorded[key]; ok && now.Sub(last) < rejectionRecordInterval {
+		return false
+	}
+	if len(t.recorded) >= rejectionMaxSources {
+		for k, last := range t.recorded {
+			if now.Sub(last) >= rejectionRecordInterval {
+				delete(t.recorded, k)
+			}
+		}
+		if len(t.recorded) >= rejectionMaxSources {
+			return false
+		}
+	}
+	t.recorded[key] = now
+	return true
+}
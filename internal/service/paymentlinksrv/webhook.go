@@ -12,28 +12,71 @@ import (
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/redaction"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 )
 
 const isoDateFormat = "2006-01-02"
 
 func (i *Impl) LogRawWebhook(ctx context.Context, payload string) error {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.LogRawWebhook")
+	defer span.End()
+
+	payload = redaction.Redact(payload)
 	aulogging.Logger.Ctx(ctx).Info().Print("webhook request: " + payload)
 
 	db := database.GetRepository()
 	return db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 		ReferenceId: "",
 		ApiId:       "",
-		Kind:        "raw",
+		Kind:        entity.KindRaw,
 		Message:     "webhook request",
 		Details:     payload,
 		RequestId:   ctxvalues.RequestId(ctx),
 	})
 }
 
+func (i *Impl) LogRejectedWebhook(ctx context.Context, endpoint string, ip string, secretValid bool) {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.LogRejectedWebhook")
+	defer span.End()
+
+	secret := "invalid"
+	if secretValid {
+		secret = "valid"
+	}
+	aulogging.Logger.Ctx(ctx).Warn().Printf("%s call rejected, source ip %s not allowed, secret %s", endpoint, ip, secret)
+
+	if !i.rejections.shouldRecord(endpoint+" "+ip+" "+secret, i.Now()) {
+		// already recorded within the hour
+		return
+	}
+
+	db := database.GetRepository()
+	err := db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+		ReferenceId: "",
+		ApiId:       "",
+		Kind:        entity.KindError,
+		Message:     fmt.Sprintf("%s rejected, source ip not allowed", endpoint),
+		Details:     fmt.Sprintf("ip=%s secret=%s", ip, secret),
+		RequestId:   ctxvalues.RequestId(ctx),
+	})
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().Printf("failed to write rejected %s call to database: %s", endpoint, err.Error())
+	}
+
+	if secretValid {
+		_ = i.SendErrorNotifyMail(ctx, endpoint, "", "secret-used-from-disallowed-ip")
+	}
+}
+
 func (i *Impl) HandleWebhook(ctx context.Context, webhook nexiapi.WebhookDto) error {
+	ctx, span := tracing.Start(ctx, "paymentlinksrv.HandleWebhook")
+	defer span.End()
+
 	aulogging.Logger.Ctx(ctx).Info().Printf("webhook id=%s tx=%s status=%s responsecode=%s", webhook.PayId, webhook.TransId, webhook.Status, webhook.ResponseCode)
 
 	if webhook.Status == "OK" || webhook.Status == "AUTHORIZED" {
@@ -50,12 +93,14 @@ func (i *Impl) success(ctx context.Context, webhook nexiapi.WebhookDto) error {
 		aulogging.Logger.Ctx(ctx).Warn().Printf("webhook with wrong ref id prefix, ref=%s", webhook.TransId)
 		db := database.GetRepository()
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: webhook.TransId,
-			ApiId:       webhook.PayId,
-			Kind:        "error",
-			Message:     fmt.Sprintf("webhook %s ref-id-prefix wrong", webhook.Status),
-			Details:     fmt.Sprintf("expecting prefix %s", prefix),
-			RequestId:   ctxvalues.RequestId(ctx),
+			ReferenceId:   webhook.TransId,
+			ApiId:         webhook.PayId,
+			Kind:          entity.KindError,
+			Message:       fmt.Sprintf("webhook %s ref-id-prefix wrong", webhook.Status),
+			Details:       fmt.Sprintf("expecting prefix %s", prefix),
+			WebhookStatus: webhook.Status,
+			PaymentMethod: webhook.PaymentMethods.Type,
+			RequestId:     ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "webhook", webhook.TransId, "ref-id-prefix-mismatch")
 		// report success so they don't retry, it's not a big problem after all
@@ -64,9 +109,10 @@ func (i *Impl) success(ctx context.Context, webhook nexiapi.WebhookDto) error {
 
 	// trust the webhook if no api url configured
 	upstreamPayment := nexi.NexiPaymentQueryResponse{
-		PayId:   webhook.PayId,
-		TransId: webhook.TransId,
-		Status:  webhook.Status,
+		PayId:        webhook.PayId,
+		TransId:      webhook.TransId,
+		Status:       webhook.Status,
+		ResponseCode: webhook.ResponseCode,
 	}
 	// if url available, read from API
 	if config.NexiDownstreamBaseUrl() != "" {
@@ -76,12 +122,14 @@ func (i *Impl) success(ctx context.Context, webhook nexiapi.WebhookDto) error {
 			aulogging.Logger.Ctx(ctx).Warn().Printf("failed to get payment info from upstream, ref=%s", webhook.TransId)
 			db := database.GetRepository()
 			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: webhook.TransId,
-				ApiId:       webhook.PayId,
-				Kind:        "error",
-				Message:     fmt.Sprintf("webhook %s failed to read payment - continuing with AUTHORIZED only", webhook.Status),
-				Details:     err.Error(),
-				RequestId:   ctxvalues.RequestId(ctx),
+				ReferenceId:   webhook.TransId,
+				ApiId:         webhook.PayId,
+				Kind:          entity.KindError,
+				Message:       fmt.Sprintf("webhook %s failed to read payment - continuing with AUTHORIZED only", webhook.Status),
+				Details:       err.Error(),
+				WebhookStatus: webhook.Status,
+				PaymentMethod: webhook.PaymentMethods.Type,
+				RequestId:     ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "webhook", webhook.TransId, "failed to read payment from upstream - set to pending - manual intervention needed")
 			// reset to minimal info
@@ -90,6 +138,9 @@ func (i *Impl) success(ctx context.Context, webhook nexiapi.WebhookDto) error {
 				TransId: webhook.TransId,
 				Status:  "unknown",
 			}
+		} else {
+			// the webhook itself could come from anyone who knows the secret
+			i.paylinkStatusVerified(ctx, webhook.TransId, upstreamPayment)
 		}
 	}
 
@@ -114,15 +165,36 @@ func (i *Impl) success(ctx context.Context, webhook nexiapi.WebhookDto) error {
 }
 
 func (i *Impl) unexpected(ctx context.Context, webhook nexiapi.WebhookDto) error {
-	aulogging.Logger.Ctx(ctx).Error().Printf("unexpected webhook status %s - skipped processing", webhook.Status)
 	db := database.GetRepository()
+	if operation := i.confirmedOperation(ctx, webhook); operation != "" {
+		aulogging.Logger.Ctx(ctx).Info().Printf("webhook status %s confirms %s - nothing to do", webhook.Status, operation)
+		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
+			ReferenceId:   webhook.TransId,
+			ApiId:         webhook.PayId,
+			Kind:          entity.KindSuccess,
+			Message:       fmt.Sprintf("webhook %s confirms %s", webhook.Status, operation),
+			Details:       fmt.Sprintf("code=%s desc=%s", webhook.ResponseCode, webhook.ResponseDescription),
+			Amount:        amountOf(webhook.Amount.Value),
+			Currency:      webhook.Amount.Currency,
+			WebhookStatus: webhook.Status,
+			PaymentMethod: webhook.PaymentMethods.Type,
+			RequestId:     ctxvalues.RequestId(ctx),
+		})
+		return nil
+	}
+
+	aulogging.Logger.Ctx(ctx).Error().Printf("unexpected webhook status %s - skipped processing", webhook.Status)
 	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-		ReferenceId: webhook.TransId,
-		ApiId:       webhook.PayId,
-		Kind:        "error",
-		Message:     fmt.Sprintf("webhook %s unknown status", webhook.Status),
-		Details:     fmt.Sprintf("code=%s desc=%s", webhook.ResponseCode, webhook.ResponseDescription),
-		RequestId:   ctxvalues.RequestId(ctx),
+		ReferenceId:   webhook.TransId,
+		ApiId:         webhook.PayId,
+		Kind:          entity.KindError,
+		Message:       fmt.Sprintf("webhook %s unknown status", webhook.Status),
+		Details:       fmt.Sprintf("code=%s desc=%s", webhook.ResponseCode, webhook.ResponseDescription),
+		Amount:        amountOf(webhook.Amount.Value),
+		Currency:      webhook.Amount.Currency,
+		WebhookStatus: webhook.Status,
+		PaymentMethod: webhook.PaymentMethods.Type,
+		RequestId:     ctxvalues.RequestId(ctx),
 	})
 	_ = i.SendErrorNotifyMail(ctx, "webhook", webhook.TransId, fmt.Sprintf("unexpected-status-%s", webhook.Status))
 
@@ -130,18 +202,41 @@ func (i *Impl) unexpected(ctx context.Context, webhook nexiapi.WebhookDto) error
 	return nil
 }
 
+// confirmedOperation returns the refund or cancellation made through this adapter that left the payment in the status
+// the webhook reports, or "" if there is none. Paygate sends a webhook when an operation changes the payment status.
+func (i *Impl) confirmedOperation(ctx context.Context, webhook nexiapi.WebhookDto) string {
+	entries, _, err := database.GetRepository().QueryProtocolEntries(ctx, dbrepo.ProtocolQuery{
+		ReferenceId: webhook.TransId,
+		Kinds:       []entity.ProtocolKind{entity.KindSuccess},
+	})
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("failed to read protocol for webhook, ref=%s: %s", webhook.TransId, err.Error())
+		return ""
+	}
+	for _, e := range entries {
+		if (e.Operation == entity.OperationRefund || e.Operation == entity.OperationCancel) && e.UpstreamStatus == webhook.Status {
+			return e.Operation
+		}
+	}
+	return ""
+}
+
 func (i *Impl) createTransaction(ctx context.Context, data nexiapi.WebhookDto, upstream nexi.NexiPaymentQueryResponse) error {
 	debitor_id, err := debitorIdFromReferenceID(data.TransId)
 	if err != nil {
 		aulogging.Logger.Ctx(ctx).Warn().Printf("webhook couldn't parse debitor_id from transId '%s'", data.TransId)
 		db := database.GetRepository()
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: data.TransId,
-			ApiId:       data.PayId,
-			Kind:        "error",
-			Message:     "webhook cannot determine debitor from reference id and payment not found",
-			Details:     fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
-			RequestId:   ctxvalues.RequestId(ctx),
+			ReferenceId:   data.TransId,
+			ApiId:         data.PayId,
+			Kind:          entity.KindError,
+			Message:       "webhook cannot determine debitor from reference id and payment not found",
+			Details:       fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
+			Amount:        amountOf(data.Amount.Value),
+			Currency:      data.Amount.Currency,
+			WebhookStatus: data.Status,
+			PaymentMethod: data.PaymentMethods.Type,
+			RequestId:     ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "parse-refid-err")
 		// do not continue - we wouldn't know which attendee to associate the payment with. Needs manual investigation
@@ -159,12 +254,17 @@ func (i *Impl) createTransaction(ctx context.Context, data nexiapi.WebhookDto, u
 			comment += " - verified amount/currency differs"
 			db := database.GetRepository()
 			_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: data.TransId,
-				ApiId:       data.PayId,
-				Kind:        "warning",
-				Message:     "verified amount/currency differs",
-				Details:     fmt.Sprintf("amount=%d %s verified=%d %s", data.Amount.Value, data.Amount.Currency, upstream.Amount.Value, upstream.Amount.Currency),
-				RequestId:   ctxvalues.RequestId(ctx),
+				ReferenceId:    data.TransId,
+				ApiId:          data.PayId,
+				Kind:           entity.KindWarning,
+				Message:        "verified amount/currency differs",
+				Details:        fmt.Sprintf("amount=%d %s verified=%d %s", data.Amount.Value, data.Amount.Currency, upstream.Amount.Value, upstream.Amount.Currency),
+				Amount:         amountOf(data.Amount.Value),
+				Currency:       data.Amount.Currency,
+				WebhookStatus:  data.Status,
+				PaymentMethod:  data.PaymentMethods.Type,
+				UpstreamStatus: upstream.Status,
+				RequestId:      ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "amount-or-currency-did-not-verify please manually check")
 		}
@@ -197,12 +297,17 @@ func (i *Impl) createTransaction(ctx context.Context, data nexiapi.WebhookDto, u
 		)
 		db := database.GetRepository()
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: data.TransId,
-			ApiId:       data.PayId,
-			Kind:        "error",
-			Message:     "webhook failed to create transaction in payment service",
-			Details:     fmt.Sprintf("amount=%d currency=%s error=%s", data.Amount.Value, data.Amount.Currency, err.Error()),
-			RequestId:   ctxvalues.RequestId(ctx),
+			ReferenceId:    data.TransId,
+			ApiId:          data.PayId,
+			Kind:           entity.KindError,
+			Message:        "webhook failed to create transaction in payment service",
+			Details:        fmt.Sprintf("amount=%d currency=%s error=%s", data.Amount.Value, data.Amount.Currency, err.Error()),
+			Amount:         amountOf(data.Amount.Value),
+			Currency:       data.Amount.Currency,
+			WebhookStatus:  data.Status,
+			PaymentMethod:  data.PaymentMethods.Type,
+			UpstreamStatus: upstream.Status,
+			RequestId:      ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "create-missing-err")
 		return err
@@ -216,18 +321,30 @@ func (i *Impl) createTransaction(ctx context.Context, data nexiapi.WebhookDto, u
 	)
 	db := database.GetRepository()
 	_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-		ReferenceId: data.TransId,
-		ApiId:       data.PayId,
-		Kind:        "warning",
-		Message:     "webhook created PENDING payment - did not exist - needs review",
-		Details:     fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
-		RequestId:   ctxvalues.RequestId(ctx),
+		ReferenceId:       data.TransId,
+		ApiId:             data.PayId,
+		Kind:              entity.KindWarning,
+		Message:           "webhook created PENDING payment - did not exist - needs review",
+		Details:           fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
+		Amount:            amountOf(data.Amount.Value),
+		Currency:          data.Amount.Currency,
+		WebhookStatus:     data.Status,
+		PaymentMethod:     data.PaymentMethods.Type,
+		UpstreamStatus:    upstream.Status,
+		TransactionStatus: string(transaction.Status),
+		RequestId:         ctxvalues.RequestId(ctx),
 	})
 	_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "create-missing-pending-success (needs review and tax rate fix)")
 	return nil
 }
 
 func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, transaction paymentservice.Transaction, upstream nexi.NexiPaymentQueryResponse) error {
+	if webhookAlreadyApplied(data, transaction, upstream) {
+		// repeated webhook, or the return check was faster
+		aulogging.Logger.Ctx(ctx).Info().Printf("webhook: transaction already valid with amount=%d currency=%s, nothing to do. reference_id=%s",
+			transaction.Amount.GrossCent, transaction.Amount.Currency, data.TransId)
+		return nil
+	}
 	if transaction.Status == paymentservice.Valid || transaction.Status == paymentservice.Deleted {
 		aulogging.Logger.Ctx(ctx).Warn().Printf(
 			"aborting transaction update - already in status %s! reference_id=%s",
@@ -237,7 +354,7 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 			ReferenceId: data.TransId,
 			ApiId:       data.PayId,
-			Kind:        "warning",
+			Kind:        entity.KindWarning,
 			Message:     fmt.Sprintf("webhook payment already in status %s", transaction.Status),
 			Details: fmt.Sprintf("existing_amount=%d ignored_amount=%d existing_currency=%s ignored_currency=%s webhook_status=%s upstream_status=%s",
 				transaction.Amount.GrossCent,
@@ -246,7 +363,13 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 				data.Amount.Currency,
 				data.Status,
 				upstream.Status),
-			RequestId: ctxvalues.RequestId(ctx),
+			Amount:            amountOf(data.Amount.Value),
+			Currency:          data.Amount.Currency,
+			WebhookStatus:     data.Status,
+			PaymentMethod:     data.PaymentMethods.Type,
+			UpstreamStatus:    upstream.Status,
+			TransactionStatus: string(transaction.Status),
+			RequestId:         ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, fmt.Sprintf("abort-update-for-%s-%s", transaction.Status, upstream.Status))
 		return nil // not an error
@@ -260,12 +383,18 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 		// warn about different amount / currency:
 		if data.Amount.Currency != upstream.Amount.Currency || data.Amount.Value != upstream.Amount.Value {
 			_ = database.GetRepository().WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: data.TransId,
-				ApiId:       data.PayId,
-				Kind:        "warning",
-				Message:     "verified amount/currency differs",
-				Details:     fmt.Sprintf("amount=%d %s verified=%d %s", data.Amount.Value, data.Amount.Currency, upstream.Amount.Value, upstream.Amount.Currency),
-				RequestId:   ctxvalues.RequestId(ctx),
+				ReferenceId:       data.TransId,
+				ApiId:             data.PayId,
+				Kind:              entity.KindWarning,
+				Message:           "verified amount/currency differs",
+				Details:           fmt.Sprintf("amount=%d %s verified=%d %s", data.Amount.Value, data.Amount.Currency, upstream.Amount.Value, upstream.Amount.Currency),
+				Amount:            amountOf(data.Amount.Value),
+				Currency:          data.Amount.Currency,
+				WebhookStatus:     data.Status,
+				PaymentMethod:     data.PaymentMethods.Type,
+				UpstreamStatus:    upstream.Status,
+				TransactionStatus: string(transaction.Status),
+				RequestId:         ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "amount-or-currency-upstream-difference-kept-pending-please-check")
 
@@ -276,12 +405,16 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 
 		if upstream.Status != "OK" {
 			_ = database.GetRepository().WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: data.TransId,
-				ApiId:       data.PayId,
-				Kind:        "warning",
-				Message:     "verified status not OK",
-				Details:     fmt.Sprintf("webhook=%s verified=%s", data.Status, upstream.Status),
-				RequestId:   ctxvalues.RequestId(ctx),
+				ReferenceId:       data.TransId,
+				ApiId:             data.PayId,
+				Kind:              entity.KindWarning,
+				Message:           "verified status not OK",
+				Details:           fmt.Sprintf("webhook=%s verified=%s", data.Status, upstream.Status),
+				WebhookStatus:     data.Status,
+				PaymentMethod:     data.PaymentMethods.Type,
+				UpstreamStatus:    upstream.Status,
+				TransactionStatus: string(transaction.Status),
+				RequestId:         ctxvalues.RequestId(ctx),
 			})
 			if upstream.Status != "CAPTURE_REQUEST" && upstream.Status != "AUTHORIZED" || transaction.Status != paymentservice.Tentative {
 				_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "upstream-status-not-OK-kept-pending-please-check")
@@ -294,12 +427,16 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 		// only trust webhook status if upstream not available - means we're using mock/simulator
 		if data.Status != "OK" {
 			_ = database.GetRepository().WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-				ReferenceId: data.TransId,
-				ApiId:       data.PayId,
-				Kind:        "warning",
-				Message:     "webhook status not OK",
-				Details:     fmt.Sprintf("webhook=%s verified=%s", data.Status, upstream.Status),
-				RequestId:   ctxvalues.RequestId(ctx),
+				ReferenceId:       data.TransId,
+				ApiId:             data.PayId,
+				Kind:              entity.KindWarning,
+				Message:           "webhook status not OK",
+				Details:           fmt.Sprintf("webhook=%s verified=%s", data.Status, upstream.Status),
+				WebhookStatus:     data.Status,
+				PaymentMethod:     data.PaymentMethods.Type,
+				UpstreamStatus:    upstream.Status,
+				TransactionStatus: string(transaction.Status),
+				RequestId:         ctxvalues.RequestId(ctx),
 			})
 			_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "webhook-status-not-OK-kept-pending-please-check")
 
@@ -313,14 +450,20 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
 			ReferenceId: data.TransId,
 			ApiId:       data.PayId,
-			Kind:        "warning",
+			Kind:        entity.KindWarning,
 			Message:     "webhook payment amount differs",
 			Details: fmt.Sprintf("old_amount=%d amount=%d old_currency=%s currency=%s",
 				transaction.Amount.GrossCent,
 				data.Amount.Value,
 				transaction.Amount.Currency,
 				data.Amount.Currency),
-			RequestId: ctxvalues.RequestId(ctx),
+			Amount:            amountOf(data.Amount.Value),
+			Currency:          data.Amount.Currency,
+			WebhookStatus:     data.Status,
+			PaymentMethod:     data.PaymentMethods.Type,
+			UpstreamStatus:    upstream.Status,
+			TransactionStatus: string(transaction.Status),
+			RequestId:         ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "amount-difference-kept-pending-please-check")
 		// continue, but keep in pending!
@@ -344,40 +487,59 @@ func (i *Impl) updateTransaction(ctx context.Context, data nexiapi.WebhookDto, t
 		aulogging.Logger.Ctx(ctx).Error().Printf("webhook unable to update upstream transaction. reference_id=%s", data.TransId)
 		db := database.GetRepository()
 		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: data.TransId,
-			ApiId:       data.PayId,
-			Kind:        "error",
-			Message:     "webhook failed to update transaction",
-			Details:     fmt.Sprintf("amount=%d currency=%s error=%s", data.Amount.Value, data.Amount.Currency, err.Error()),
-			RequestId:   ctxvalues.RequestId(ctx),
+			ReferenceId:       data.TransId,
+			ApiId:             data.PayId,
+			Kind:              entity.KindError,
+			Message:           "webhook failed to update transaction",
+			Details:           fmt.Sprintf("amount=%d currency=%s error=%s", data.Amount.Value, data.Amount.Currency, err.Error()),
+			Amount:            amountOf(data.Amount.Value),
+			Currency:          data.Amount.Currency,
+			WebhookStatus:     data.Status,
+			PaymentMethod:     data.PaymentMethods.Type,
+			UpstreamStatus:    upstream.Status,
+			TransactionStatus: string(transaction.Status),
+			RequestId:         ctxvalues.RequestId(ctx),
 		})
 		_ = i.SendErrorNotifyMail(ctx, "webhook", data.TransId, "update-tx-err")
 		return err
 	}
 
+	var entry *entity.ProtocolEntry
 	if forcePending {
 		aulogging.Logger.Ctx(ctx).Info().Printf("successfully updated upstream transaction to PENDING. reference_id=%s", data.TransId)
-		db := database.GetRepository()
-		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: data.TransId,
-			ApiId:       data.PayId,
-			Kind:        "pending",
-			Message:     "transaction updated to PENDING",
-			Details:     fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
-			RequestId:   ctxvalues.RequestId(ctx),
-		})
+		entry = &entity.ProtocolEntry{
+			ReferenceId:       data.TransId,
+			ApiId:             data.PayId,
+			Kind:              entity.KindPending,
+			Message:           "transaction updated to PENDING",
+			Details:           fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
+			Amount:            amountOf(data.Amount.Value),
+			Currency:          data.Amount.Currency,
+			WebhookStatus:     data.Status,
+			PaymentMethod:     data.PaymentMethods.Type,
+			UpstreamStatus:    upstream.Status,
+			TransactionStatus: string(transaction.Status),
+			RequestId:         ctxvalues.RequestId(ctx),
+		}
 	} else {
 		aulogging.Logger.Ctx(ctx).Info().Printf("successfully updated upstream transaction to valid. reference_id=%s", data.TransId)
-		db := database.GetRepository()
-		_ = db.WriteProtocolEntry(ctx, &entity.ProtocolEntry{
-			ReferenceId: data.TransId,
-			ApiId:       data.PayId,
-			Kind:        "success",
-			Message:     "transaction updated successfully",
-			Details:     fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
-			RequestId:   ctxvalues.RequestId(ctx),
-		})
+		entry = &entity.ProtocolEntry{
+			ReferenceId:       data.TransId,
+			ApiId:             data.PayId,
+			Kind:              entity.KindSuccess,
+			Message:           "transaction updated successfully",
+			Details:           fmt.Sprintf("amount=%d currency=%s", data.Amount.Value, data.Amount.Currency),
+			Amount:            amountOf(data.Amount.Value),
+			Currency:          data.Amount.Currency,
+			WebhookStatus:     data.Status,
+			PaymentMethod:     data.PaymentMethods.Type,
+			UpstreamStatus:    upstream.Status,
+			TransactionStatus: string(transaction.Status),
+			RequestId:         ctxvalues.RequestId(ctx),
+		}
 	}
+	_ = database.GetRepository().WriteProtocolEntry(ctx, entry)
+	i.publishPaymentEvent(ctx, EventSourceWebhook, entry, upstream.ResponseCode)
 
 	return nil
 }
@@ -409,3 +571,19 @@ func debitorIdFromReferenceID(ref_id string) (uint, error) {
 
 	return uint(debitor_id), nil
 }
+
+// webhookAlreadyApplied is true if the transaction is already valid with the amount the webhook would set.
+func webhookAlreadyApplied(data nexiapi.WebhookDto, transaction paymentservice.Transaction, upstream nexi.NexiPaymentQueryResponse) bool {
+	if transaction.Status != paymentservice.Valid {
+		return false
+	}
+	if upstream.Amount == nil {
+		// only trust webhook status if upstream not available - means we're using mock/simulator
+		return data.Status == "OK" && transaction.Amount.GrossCent == data.Amount.Value && transaction.Amount.Currency == data.Amount.Currency
+	}
+	return upstream.Status == "OK" && transaction.Amount.GrossCent == upstream.Amount.Value && transaction.Amount.Currency == upstream.Amount.Currency
+}
+
+func amountOf(value int64) *int64 {
+	return &value
+}
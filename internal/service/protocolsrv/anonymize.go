@@ -0,0 +1,52 @@
+package protocolsrv
+
+import (
+	"strings"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/redaction"
+)
+
+// anonymizedFallback replaces details that cannot be parsed as json, since we cannot tell which parts are personal data.
+const anonymizedFallback = "(anonymized)"
+
+// piiKeys lists the (lowercase) json keys in raw Paygate requests and responses that may contain personal data.
+var piiKeys = map[string]bool{
+	"email":            true,
+	"deliveryemail":    true,
+	"firstname":        true,
+	"lastname":         true,
+	"middlename":       true,
+	"maidenname":       true,
+	"cardholdername":   true,
+	"birthdate":        true,
+	"birthplace":       true,
+	"phone":            true,
+	"streetname":       true,
+	"streetnumber":     true,
+	"addressline2":     true,
+	"addressline3":     true,
+	"city":             true,
+	"postalcode":       true,
+	"ipaddress":        true,
+	"first6digits":     true,
+	"last4digits":      true,
+	"pseudocardnumber": true,
+	"accountbin":       true,
+}
+
+// anonymizeDetails masks the values of all keys that may contain personal data in a json payload.
+//
+// It is at least as strict as the redaction applied while logging: it masks the default and the configured
+// redaction paths, so entries written before redaction was configured are also covered, and in addition
+// the piiKeys wherever they occur.
+func anonymizeDetails(details string) string {
+	redacted := redaction.PathMatcher(append(config.DefaultRedactPaths(), config.LogRedactPaths()...))
+	anonymized, ok := redaction.Mask(details, func(path []string) bool {
+		return piiKeys[strings.ToLower(path[len(path)-1])] || redacted(path)
+	})
+	if !ok {
+		return anonymizedFallback
+	}
+	return anonymized
+}
@@ -0,0 +1,57 @@
+package protocolsrv
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/redaction"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizeCoversRedactPaths(t *testing.T) {
+	docs.Description("anonymization masks everything the redaction masks while logging, with the default and the configured paths")
+	config.LoadTestingConfigurationFromPathOrAbort("../../../test/resources/testconfig.yaml")
+	for _, path := range append(config.DefaultRedactPaths(), config.LogRedactPaths()...) {
+		keys := strings.Split(path, ".")
+		var payload any = "Jane Doe"
+		for idx := len(keys) - 1; idx >= 0; idx-- {
+			if keys[idx] == "*" {
+				keys[idx] = "someKey"
+				payload = map[string]any{keys[idx]: payload}
+			} else {
+				// a sibling that must be kept
+				payload = map[string]any{keys[idx]: payload, "country": "DEU"}
+			}
+		}
+		encoded, err := json.Marshal(payload)
+		require.Nil(t, err)
+
+		var anonymized any
+		require.Nil(t, json.Unmarshal([]byte(anonymizeDetails(string(encoded))), &anonymized), path)
+		for _, key := range keys {
+			object, ok := anonymized.(map[string]any)
+			require.True(t, ok, path)
+			if len(object) > 1 {
+				require.Equal(t, "DEU", object["country"], path)
+			}
+			anonymized = object[key]
+		}
+		require.Equal(t, redaction.MaskedValue, anonymized, path)
+	}
+}
+
+func TestAnonymizeKeysAnywhere(t *testing.T) {
+	docs.Description("keys that may contain personal data are masked wherever they occur, not only at the redaction paths")
+	config.LoadTestingConfigurationFromPathOrAbort("../../../test/resources/testconfig.yaml")
+	require.Equal(t, `{"payer":{"email":"***","accountBin":"***","country":"DEU"}}`,
+		anonymizeDetails(`{"payer":{"email":"jane@example.com","accountBin":"411111","country":"DEU"}}`))
+}
+
+func TestAnonymizeInvalidJson(t *testing.T) {
+	docs.Description("details that are not valid json are replaced completely")
+	config.LoadTestingConfigurationFromPathOrAbort("../../../test/resources/testconfig.yaml")
+	require.Equal(t, anonymizedFallback, anonymizeDetails("not json"))
+}
@@ -0,0 +1,121 @@
+package protocolsrv
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/spreadsheet"
+)
+
+var exportBaseColumns = []string{"id", "created_at", "reference_id", "api_id", "kind", "message", "details", "request_id", "subject", "client", "operation"}
+
+// exportDetailColumns lists the keys used in key=value details throughout the payment link service.
+//
+// The column set must be known before the first row is streamed. Unknown keys remain visible in the details column.
+var exportDetailColumns = []string{
+	"amount", "currency", "verified", "code", "desc", "error", "webhook", "upstream",
+	"transaction_status", "upstream_status", "webhook_status",
+	"tx_amount", "upstream_amount", "tx_currency", "upstream_currency",
+	"existing_amount", "ignored_amount", "existing_currency", "ignored_currency",
+	"old_amount", "old_currency", "payment_method",
+}
+
+func (i *Impl) ExportProtocol(ctx context.Context, query dbrepo.ProtocolQuery, format ExportFormat, w io.Writer) error {
+	switch format {
+	case ExportCsv:
+		return exportCsv(ctx, query, w)
+	case ExportJsonl:
+		return exportJsonl(ctx, query, w)
+	default:
+		return ErrUnknownExportFormat
+	}
+}
+
+func exportCsv(ctx context.Context, query dbrepo.ProtocolQuery, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append(exportBaseColumns[:len(exportBaseColumns):len(exportBaseColumns)], exportDetailColumns...)); err != nil {
+		return err
+	}
+
+	err := database.GetRepository().ForEachProtocolEntry(ctx, query, func(e *entity.ProtocolEntry) error {
+		dto := protocolEntryDtoFromEntity(e)
+		fields := parseDetailFields(e.Details)
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		// the structured data takes precedence, some details only have it in a combined format like "amount=18500 EUR"
+		if dto.Amount != nil {
+			fields["amount"] = strconv.FormatInt(*dto.Amount, 10)
+		}
+		setIfNotEmpty(fields, "currency", dto.Currency)
+		setIfNotEmpty(fields, "upstream_status", dto.UpstreamStatus)
+		setIfNotEmpty(fields, "webhook_status", dto.WebhookStatus)
+		setIfNotEmpty(fields, "transaction_status", dto.TransactionStatus)
+		setIfNotEmpty(fields, "payment_method", dto.PaymentMethod)
+		row := []string{
+			strconv.FormatUint(uint64(dto.Id), 10), dto.CreatedAt, dto.ReferenceId, dto.ApiId, dto.Kind, dto.Message, dto.Details, dto.RequestId, dto.Subject, dto.Client, dto.Operation,
+		}
+		for _, key := range exportDetailColumns {
+			row = append(row, fields[key])
+		}
+		// the values come from webhooks and clients, so they must not be executed when the file is opened in a spreadsheet
+		return writer.Write(spreadsheet.Row(row))
+	})
+	writer.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func setIfNotEmpty(fields map[string]string, key string, value string) {
+	if value != "" {
+		fields[key] = value
+	}
+}
+
+func exportJsonl(ctx context.Context, query dbrepo.ProtocolQuery, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return database.GetRepository().ForEachProtocolEntry(ctx, query, func(e *entity.ProtocolEntry) error {
+		return encoder.Encode(nexiapi.ProtocolExportEntryDto{
+			ProtocolEntryDto: protocolEntryDtoFromEntity(e),
+			Fields:           parseDetailFields(e.Details),
+		})
+	})
+}
+
+var detailKeyPattern = regexp.MustCompile(`^([a-z][a-z0-9_]*)=(.*)$`)
+
+// parseDetailFields splits details of the form "amount=18500 currency=EUR" into their values.
+//
+// Words without a key are appended to the previous value, as in "amount=18500 EUR verified=18500 EUR" or
+// "desc=Transaktion erfolgreich". If a key is repeated, its last value is kept. Returns nil if the details do
+// not start with a key.
+func parseDetailFields(details string) map[string]string {
+	words := strings.Split(details, " ")
+	if len(words) == 0 || !detailKeyPattern.MatchString(words[0]) {
+		return nil
+	}
+
+	result := make(map[string]string)
+	currentKey := ""
+	for _, word := range words {
+		if matches := detailKeyPattern.FindStringSubmatch(word); matches != nil {
+			currentKey = matches[1]
+			result[currentKey] = matches[2]
+			continue
+		}
+		result[currentKey] += " " + word
+	}
+	return result
+}
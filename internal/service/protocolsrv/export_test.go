@@ -0,0 +1,20 @@
+package protocolsrv
+
+import (
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDetailFields(t *testing.T) {
+	docs.Description("details in key=value form are split into fields, words without a key belong to the previous value")
+	require.Equal(t, map[string]string{"amount": "18500 EUR", "verified": "18500 EUR"}, parseDetailFields("amount=18500 EUR verified=18500 EUR"))
+	require.Equal(t, map[string]string{"desc": "Transaktion erfolgreich"}, parseDetailFields("desc=Transaktion erfolgreich"))
+	require.Nil(t, parseDetailFields("some free text"))
+}
+
+func TestParseDetailFieldsRepeatedKey(t *testing.T) {
+	docs.Description("a repeated key is a field of its own, its last value is kept")
+	require.Equal(t, map[string]string{"amount": "390", "currency": "EUR"}, parseDetailFields("amount=18500 currency=EUR amount=390"))
+}
@@ -0,0 +1,17 @@
+package protocolsrv
+
+import (
+	"time"
+)
+
+var NowFunc = time.Now
+
+type Impl struct {
+	Now func() time.Time
+}
+
+func New() ProtocolService {
+	return &Impl{
+		Now: NowFunc,
+	}
+}
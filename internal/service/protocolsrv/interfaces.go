@@ -0,0 +1,49 @@
+package protocolsrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+)
+
+type ProtocolService interface {
+	// QueryProtocol reads the protocol entries matching the query from the database.
+	//
+	// The returned nexiapi.ProtocolEntryListDto contains the entries and the total number of matches,
+	// the caller is expected to fill in the paging information.
+	QueryProtocol(ctx context.Context, query dbrepo.ProtocolQuery) (nexiapi.ProtocolEntryListDto, error)
+
+	// ExportProtocol writes all protocol entries matching the query to w, in the given format (csv or jsonl).
+	//
+	// Entries are streamed in ascending order of creation. Offset and Limit of the query are ignored.
+	// Details in key=value format are split into separate columns (csv) or a fields object (jsonl).
+	ExportProtocol(ctx context.Context, query dbrepo.ProtocolQuery, format ExportFormat, w io.Writer) error
+
+	// PaymentStatistics counts the paylinks created and the payments confirmed, declined, refunded and left
+	// pending according to the protocol, for the days from and to (inclusive) in the given time zone.
+	//
+	// The result is grouped by day, payment method and currency.
+	PaymentStatistics(ctx context.Context, from time.Time, to time.Time, location *time.Location) (nexiapi.PaymentStatsDto, error)
+
+	// ApplyRetentionPolicy anonymizes and deletes protocol entries and deletes paylink records according to the
+	// configured retention policy.
+	ApplyRetentionPolicy(ctx context.Context) error
+
+	// RunRetentionJob applies the retention policy at the configured interval until the context is cancelled.
+	//
+	// Returns immediately if no interval is configured.
+	RunRetentionJob(ctx context.Context)
+}
+
+type ExportFormat string
+
+const (
+	ExportCsv   ExportFormat = "csv"
+	ExportJsonl ExportFormat = "jsonl"
+)
+
+var ErrUnknownExportFormat = errors.New("unknown export format, must be one of csv, jsonl")
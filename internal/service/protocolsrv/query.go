@@ -0,0 +1,93 @@
+package protocolsrv
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+)
+
+func (i *Impl) QueryProtocol(ctx context.Context, query dbrepo.ProtocolQuery) (nexiapi.ProtocolEntryListDto, error) {
+	entries, total, err := database.GetRepository().QueryProtocolEntries(ctx, query)
+	if err != nil {
+		return nexiapi.ProtocolEntryListDto{}, err
+	}
+
+	result := nexiapi.ProtocolEntryListDto{
+		Entries: make([]nexiapi.ProtocolEntryDto, 0, len(entries)),
+		Total:   total,
+	}
+	for _, e := range entries {
+		result.Entries = append(result.Entries, protocolEntryDtoFromEntity(e))
+	}
+	return result, nil
+}
+
+func protocolEntryDtoFromEntity(e *entity.ProtocolEntry) nexiapi.ProtocolEntryDto {
+	dto := nexiapi.ProtocolEntryDto{
+		Id:                e.ID,
+		CreatedAt:         e.CreatedAt.Format(time.RFC3339),
+		ReferenceId:       e.ReferenceId,
+		ApiId:             e.ApiId,
+		Kind:              string(e.Kind),
+		Message:           e.Message,
+		Details:           e.Details,
+		RequestId:         e.RequestId,
+		Subject:           e.Subject,
+		Client:            e.Client,
+		Amount:            e.Amount,
+		Currency:          e.Currency,
+		UpstreamStatus:    e.UpstreamStatus,
+		WebhookStatus:     e.WebhookStatus,
+		TransactionStatus: e.TransactionStatus,
+		PaymentMethod:     e.PaymentMethod,
+		Operation:         e.Operation,
+	}
+	if e.Amount == nil && e.Currency == "" && e.UpstreamStatus == "" && e.WebhookStatus == "" && e.TransactionStatus == "" {
+		addStructuredDataFromDetails(&dto, parseDetailFields(e.Details))
+	}
+	return dto
+}
+
+// addStructuredDataFromDetails fills in the structured payment data for entries written before it had its own columns.
+//
+// Older versions only wrote it to the details, using a number of different key=value formats.
+func addStructuredDataFromDetails(dto *nexiapi.ProtocolEntryDto, fields map[string]string) {
+	if fields == nil {
+		return
+	}
+
+	amountWithCurrency := strings.Fields(firstNonEmpty(fields["amount"], fields["existing_amount"], fields["tx_amount"]))
+	if len(amountWithCurrency) > 0 {
+		if amount, err := strconv.ParseInt(amountWithCurrency[0], 10, 64); err == nil {
+			dto.Amount = &amount
+		}
+	}
+	dto.Currency = firstNonEmpty(fields["currency"], fields["existing_currency"], fields["tx_currency"])
+	if dto.Currency == "" && len(amountWithCurrency) == 2 {
+		// amount=18500 EUR
+		dto.Currency = amountWithCurrency[1]
+	}
+
+	dto.UpstreamStatus = firstNonEmpty(fields["upstream_status"], fields["upstream"])
+	dto.WebhookStatus = firstNonEmpty(fields["webhook_status"], fields["webhook"])
+	if fields["webhook"] != "" {
+		// webhook=OK verified=FAILED
+		dto.UpstreamStatus = fields["verified"]
+	}
+	dto.TransactionStatus = fields["transaction_status"]
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
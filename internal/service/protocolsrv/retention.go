@@ -0,0 +1,104 @@
+package protocolsrv
+
+import (
+	"context"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+)
+
+var (
+	rawKinds   = []entity.ProtocolKind{entity.KindRaw}
+	errorKinds = []entity.ProtocolKind{entity.KindError, entity.KindWarning}
+)
+
+func (i *Impl) ApplyRetentionPolicy(ctx context.Context) error {
+	policy := config.ProtocolRetention()
+	db := database.GetRepository()
+
+	if policy.AnonymizeRawAfterDays > 0 {
+		count, err := db.AnonymizeProtocolEntries(ctx, dbrepo.ProtocolQuery{
+			Kinds:         rawKinds,
+			CreatedBefore: i.cutoff(policy.AnonymizeRawAfterDays),
+		}, anonymizeDetails)
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("retention: failed to anonymize raw protocol entries: %s", err.Error())
+			return err
+		}
+		aulogging.Logger.Ctx(ctx).Info().Printf("retention: anonymized %d raw protocol entries older than %d days", count, policy.AnonymizeRawAfterDays)
+	}
+
+	if err := i.deleteOlderThan(ctx, "raw", policy.DeleteRawAfterDays, dbrepo.ProtocolQuery{
+		Kinds: rawKinds,
+	}); err != nil {
+		return err
+	}
+	if err := i.deleteOlderThan(ctx, "error and warning", policy.DeleteErrorsAfterDays, dbrepo.ProtocolQuery{
+		Kinds: errorKinds,
+	}); err != nil {
+		return err
+	}
+	if err := i.deleteOlderThan(ctx, "other", policy.DeleteAfterDays, dbrepo.ProtocolQuery{
+		ExcludeKinds: append(append([]entity.ProtocolKind{}, rawKinds...), errorKinds...),
+	}); err != nil {
+		return err
+	}
+
+	if policy.DeletePaylinksAfterDays > 0 {
+		count, err := db.DeletePaylinks(ctx, dbrepo.PaylinkQuery{
+			CreatedBefore: i.cutoff(policy.DeletePaylinksAfterDays),
+		})
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("retention: failed to delete paylinks: %s", err.Error())
+			return err
+		}
+		aulogging.Logger.Ctx(ctx).Info().Printf("retention: deleted %d paylinks older than %d days", count, policy.DeletePaylinksAfterDays)
+	}
+
+	return nil
+}
+
+func (i *Impl) deleteOlderThan(ctx context.Context, description string, days int, query dbrepo.ProtocolQuery) error {
+	if days <= 0 {
+		return nil
+	}
+
+	query.CreatedBefore = i.cutoff(days)
+	count, err := database.GetRepository().DeleteProtocolEntries(ctx, query)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("retention: failed to delete %s protocol entries: %s", description, err.Error())
+		return err
+	}
+	aulogging.Logger.Ctx(ctx).Info().Printf("retention: deleted %d %s protocol entries older than %d days", count, description, days)
+	return nil
+}
+
+func (i *Impl) cutoff(days int) time.Time {
+	return i.Now().AddDate(0, 0, -days)
+}
+
+func (i *Impl) RunRetentionJob(ctx context.Context) {
+	interval := config.ProtocolRetentionInterval()
+	if interval <= 0 {
+		aulogging.Logger.Ctx(ctx).Info().Print("retention: no interval configured, not scheduling protocol retention job")
+		return
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("retention: applying protocol retention policy every %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// errors are logged, just try again next time
+		_ = i.ApplyRetentionPolicy(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
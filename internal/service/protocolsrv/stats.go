@@ -0,0 +1,142 @@
+package protocolsrv
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+)
+
+const statsDayFormat = "2006-01-02"
+
+// refundStatuses are the payment statuses with which Nexi reports a refund.
+var refundStatuses = []string{"REFUNDED", "CREDITED"}
+
+// declinedStatuses are the payment statuses with which Nexi reports that a payment did not go through.
+var declinedStatuses = []string{"FAILED", "CANCELLED"}
+
+// processedStatuses are the webhook statuses that lead to a booking.
+var processedStatuses = []string{"OK", "AUTHORIZED"}
+
+type statsKey struct {
+	day           string
+	paymentMethod string
+	currency      string
+}
+
+func (i *Impl) PaymentStatistics(ctx context.Context, from time.Time, to time.Time, location *time.Location) (nexiapi.PaymentStatsDto, error) {
+	firstDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location)
+	lastDay := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, location)
+
+	groups := make(map[statsKey]*nexiapi.PaymentStatsGroupDto)
+	// one query per day, days do not have the same length in every time zone, so the database cannot group by them
+	for day := firstDay; !day.After(lastDay); day = day.AddDate(0, 0, 1) {
+		summaries, err := database.GetRepository().SummarizeProtocolEntries(ctx, dbrepo.ProtocolQuery{
+			Kinds:         []entity.ProtocolKind{entity.KindSuccess, entity.KindPending, entity.KindError},
+			CreatedAfter:  day,
+			CreatedBefore: day.AddDate(0, 0, 1),
+		})
+		if err != nil {
+			return nexiapi.PaymentStatsDto{}, err
+		}
+
+		for _, summary := range summaries {
+			value := statsValueFor(summary)
+			if value == nil {
+				continue
+			}
+
+			key := statsKey{
+				day:           day.Format(statsDayFormat),
+				paymentMethod: summary.PaymentMethod,
+				currency:      summary.Currency,
+			}
+			group, ok := groups[key]
+			if !ok {
+				group = &nexiapi.PaymentStatsGroupDto{
+					Day:           key.day,
+					PaymentMethod: key.paymentMethod,
+					Currency:      key.currency,
+				}
+				groups[key] = group
+			}
+
+			target := value(group)
+			target.Count += summary.Count
+			target.Volume += summary.Volume
+		}
+	}
+
+	result := nexiapi.PaymentStatsDto{
+		From:     firstDay.Format(statsDayFormat),
+		To:       lastDay.Format(statsDayFormat),
+		Timezone: location.String(),
+		Groups:   make([]nexiapi.PaymentStatsGroupDto, 0, len(groups)),
+	}
+	for _, group := range groups {
+		result.Groups = append(result.Groups, *group)
+	}
+	slices.SortFunc(result.Groups, func(a, b nexiapi.PaymentStatsGroupDto) int {
+		return cmp.Or(
+			strings.Compare(a.Day, b.Day),
+			strings.Compare(a.PaymentMethod, b.PaymentMethod),
+			strings.Compare(a.Currency, b.Currency),
+		)
+	})
+	return result, nil
+}
+
+// statsValueFor decides which statistic protocol entries count towards, or returns nil if they do not count.
+//
+// Each payment event counts once, using the entry that records its outcome:
+//   - a created paylink, a refund or a cancellation through this adapter is the successful entry of that operation,
+//     cancellations count as declined. Captures are not counted, the payment is confirmed by the booking that follows,
+//   - a confirmed payment is a successful entry that made the booking valid,
+//   - a payment kept pending by the webhook is declined if Paygate reports it as declined, and pending otherwise,
+//   - webhooks with a status we do not process are errors without a booking status, they are refunds
+//     or declined payments depending on that status. Webhooks that only confirm a refund or cancellation
+//     through this adapter are recorded as successful entries without an operation, so they are not counted again.
+//
+// The status reported by Paygate is the verified status, or the status from the webhook if it could not be verified.
+func statsValueFor(s dbrepo.ProtocolSummary) func(group *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto {
+	status := s.UpstreamStatus
+	if status == "" {
+		status = s.WebhookStatus
+	}
+
+	switch {
+	case s.Kind == entity.KindSuccess && s.Operation == entity.OperationCreate:
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Created }
+	case s.Kind == entity.KindSuccess && s.Operation == entity.OperationRefund:
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Refunded }
+	case s.Kind == entity.KindSuccess && s.Operation == entity.OperationCancel:
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Declined }
+	case s.Operation != "":
+		// captures, and operations that failed
+		return nil
+	case s.Kind == entity.KindSuccess && s.TransactionStatus == string(paymentservice.Valid):
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Confirmed }
+	case s.Kind == entity.KindPending && slices.Contains(declinedStatuses, status):
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Declined }
+	case s.Kind == entity.KindPending:
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Pending }
+	case s.Kind == entity.KindError && s.TransactionStatus == "" && s.UpstreamStatus == "" && s.WebhookStatus != "":
+		if slices.Contains(refundStatuses, s.WebhookStatus) {
+			return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Refunded }
+		}
+		if slices.Contains(processedStatuses, s.WebhookStatus) {
+			// processed, but failed for other reasons, e.g. a wrong reference id prefix
+			return nil
+		}
+		return func(g *nexiapi.PaymentStatsGroupDto) *nexiapi.PaymentStatsValueDto { return &g.Declined }
+	default:
+		return nil
+	}
+}
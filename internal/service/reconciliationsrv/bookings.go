@@ -0,0 +1,82 @@
+package reconciliationsrv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+)
+
+// bookingLookbackDays is how long before the earliest settlement date we look for bookings.
+//
+// Payments are settled a few days after they were booked, refunds and chargebacks may concern older
+// bookings, which are then looked up one by one.
+const bookingLookbackDays = 31
+
+// bookingCache holds the bookings of the payment service relevant for one settlement file, so each line
+// does not need a call to the payment service.
+type bookingCache struct {
+	byReferenceId map[string]*paymentservice.Transaction // nil for reference ids known to have no booking
+}
+
+// loadBookings loads all bookings with an effective date in the range covered by the settlement lines at once.
+func loadBookings(ctx context.Context, lines []SettlementLine) (*bookingCache, error) {
+	cache := &bookingCache{
+		byReferenceId: make(map[string]*paymentservice.Transaction),
+	}
+
+	first, last := "", ""
+	for _, line := range lines {
+		if first == "" || line.Date < first {
+			first = line.Date
+		}
+		if line.Date > last {
+			last = line.Date
+		}
+	}
+	if first == "" {
+		return cache, nil
+	}
+	// dates have been validated while parsing
+	from, _ := time.Parse(settlementDateFormat, first)
+	before, _ := time.Parse(settlementDateFormat, last)
+	from = from.AddDate(0, 0, -bookingLookbackDays)
+	before = before.AddDate(0, 0, 1)
+
+	transactions, err := paymentservice.Get().GetTransactionsByEffectiveDate(ctx,
+		from.Format(settlementDateFormat), before.Format(settlementDateFormat))
+	if err != nil {
+		return nil, err
+	}
+	for idx := range transactions {
+		// like the lookup by reference id, the first booking for a reference id counts
+		if _, seen := cache.byReferenceId[transactions[idx].ID]; !seen {
+			cache.byReferenceId[transactions[idx].ID] = &transactions[idx]
+		}
+	}
+	aulogging.Logger.Ctx(ctx).Info().Printf("loaded %d bookings with effective dates from %s before %s",
+		len(transactions), from.Format(settlementDateFormat), before.Format(settlementDateFormat))
+	return cache, nil
+}
+
+// get returns the booking for a reference id, looking it up in the payment service if it was not loaded before.
+func (c *bookingCache) get(ctx context.Context, referenceId string) (paymentservice.Transaction, error) {
+	if transaction, ok := c.byReferenceId[referenceId]; ok {
+		if transaction == nil {
+			return paymentservice.Transaction{}, paymentservice.NotFoundError
+		}
+		return *transaction, nil
+	}
+
+	transaction, err := paymentservice.Get().GetTransactionByReferenceId(ctx, referenceId)
+	if err != nil {
+		if errors.Is(err, paymentservice.NotFoundError) {
+			c.byReferenceId[referenceId] = nil
+		}
+		return paymentservice.Transaction{}, err
+	}
+	c.byReferenceId[referenceId] = &transaction
+	return transaction, nil
+}
@@ -0,0 +1,7 @@
+package reconciliationsrv
+
+type Impl struct{}
+
+func New() ReconciliationService {
+	return &Impl{}
+}
@@ -0,0 +1,27 @@
+package reconciliationsrv
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+)
+
+type ReconciliationService interface {
+	// ReconcileSettlement parses a Nexi settlement file and matches every line against the protocol and
+	// the bookings in the payment service.
+	//
+	// The returned report lists all lines that are unmatched, mismatched or fee only, and a summary over all lines.
+	// Fails with an error wrapping ErrInvalidSettlementFile if the file cannot be parsed.
+	ReconcileSettlement(ctx context.Context, settlementFile io.Reader) (nexiapi.ReconciliationReportDto, error)
+}
+
+const (
+	ResultMatched    = "matched"
+	ResultUnmatched  = "unmatched"
+	ResultMismatched = "mismatched"
+	ResultFeeOnly    = "fee_only"
+)
+
+var ErrInvalidSettlementFile = errors.New("invalid settlement file")
@@ -0,0 +1,194 @@
+package reconciliationsrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+)
+
+func (i *Impl) ReconcileSettlement(ctx context.Context, settlementFile io.Reader) (nexiapi.ReconciliationReportDto, error) {
+	lines, err := ParseSettlement(settlementFile)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("failed to parse settlement file: %s", err.Error())
+		return nexiapi.ReconciliationReportDto{}, err
+	}
+
+	report := nexiapi.ReconciliationReportDto{
+		Summary: nexiapi.ReconciliationSummaryDto{
+			Amounts: make(map[string]int64),
+			Fees:    make(map[string]int64),
+		},
+		Lines: make([]nexiapi.ReconciliationLineDto, 0),
+	}
+	bookings, err := loadBookings(ctx, lines)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to load bookings for settlement file: %s", err.Error())
+		return nexiapi.ReconciliationReportDto{}, err
+	}
+	captured := make(map[string]bool)
+	for _, line := range lines {
+		result, err := reconcileLine(ctx, line, bookings, captured)
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("reconciliation aborted at settlement line %d: %s", line.Line, err.Error())
+			return nexiapi.ReconciliationReportDto{}, err
+		}
+
+		summary := &report.Summary
+		summary.Total++
+		switch result.Result {
+		case ResultMatched:
+			summary.Matched++
+		case ResultUnmatched:
+			summary.Unmatched++
+		case ResultMismatched:
+			summary.Mismatched++
+		case ResultFeeOnly:
+			summary.FeeOnly++
+		}
+		summary.Amounts[line.Currency] += signedAmount(line)
+		summary.Fees[line.Currency] += line.FeeCent
+
+		if result.Result != ResultMatched {
+			report.Lines = append(report.Lines, result)
+		}
+	}
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("reconciled %d settlement lines: %d matched, %d unmatched, %d mismatched, %d fee only",
+		report.Summary.Total, report.Summary.Matched, report.Summary.Unmatched, report.Summary.Mismatched, report.Summary.FeeOnly)
+	return report, nil
+}
+
+// reconcileLine matches a single settlement line. captured tracks the reference ids of all captures seen so far.
+func reconcileLine(ctx context.Context, line SettlementLine, bookings *bookingCache, captured map[string]bool) (nexiapi.ReconciliationLineDto, error) {
+	result := nexiapi.ReconciliationLineDto{
+		Line:        line.Line,
+		ReferenceId: line.ReferenceId,
+		PayId:       line.PayId,
+		Type:        string(line.Type),
+		Amount:      line.AmountCent,
+		Currency:    line.Currency,
+		Fee:         line.FeeCent,
+		Date:        line.Date,
+		Result:      ResultMatched,
+	}
+
+	if line.Type == SettlementFee || (line.AmountCent == 0 && line.FeeCent != 0) {
+		result.Result = ResultFeeOnly
+		return result, nil
+	}
+	if line.ReferenceId == "" {
+		result.Result = ResultUnmatched
+		result.Problems = append(result.Problems, "no reference id")
+		return result, nil
+	}
+
+	// the protocol tells us whether the payment went through this service at all
+	entries, _, err := database.GetRepository().QueryProtocolEntries(ctx, dbrepo.ProtocolQuery{ReferenceId: line.ReferenceId})
+	if err != nil {
+		return result, err
+	}
+
+	transaction, err := bookings.get(ctx, line.ReferenceId)
+	if err != nil {
+		if !errors.Is(err, paymentservice.NotFoundError) {
+			return result, err
+		}
+		result.Result = ResultUnmatched
+		if len(entries) > 0 {
+			result.Problems = append(result.Problems, "no booking in the payment service")
+		} else {
+			result.Problems = append(result.Problems, "unknown reference id")
+		}
+		return result, nil
+	}
+	bookedAmount := transaction.Amount.GrossCent
+	result.BookingStatus = string(transaction.Status)
+	result.BookingAmount = &bookedAmount
+	result.BookingCurrency = transaction.Amount.Currency
+
+	if line.PayId != "" && payIdDiffers(entries, line.PayId) {
+		result.Problems = append(result.Problems, "pay id does not match our protocol")
+	}
+	if line.Currency != transaction.Amount.Currency {
+		result.Problems = append(result.Problems, fmt.Sprintf("currency differs, booked %s", transaction.Amount.Currency))
+	}
+
+	amount := abs(line.AmountCent)
+	switch line.Type {
+	case SettlementCapture:
+		if amount != bookedAmount {
+			result.Problems = append(result.Problems, fmt.Sprintf("amount differs, settled %s, booked %s",
+				formatCents(amount), formatCents(bookedAmount)))
+		}
+		if transaction.Status != paymentservice.Valid {
+			result.Problems = append(result.Problems, fmt.Sprintf("booking is in status %s", transaction.Status))
+		}
+		if captured[line.ReferenceId] {
+			result.Problems = append(result.Problems, "captured more than once")
+		}
+		captured[line.ReferenceId] = true
+	case SettlementRefund:
+		if amount > bookedAmount {
+			result.Problems = append(result.Problems, fmt.Sprintf("refund of %s exceeds booked amount %s",
+				formatCents(amount), formatCents(bookedAmount)))
+		}
+	case SettlementChargeback:
+		if transaction.Status == paymentservice.Valid {
+			result.Problems = append(result.Problems, "charged back, but booking is still valid")
+		}
+	default:
+		result.Problems = append(result.Problems, "unknown settlement type")
+	}
+
+	if len(result.Problems) > 0 {
+		result.Result = ResultMismatched
+	}
+	return result, nil
+}
+
+// payIdDiffers is true if the protocol lists pay ids for the payment, but not this one.
+func payIdDiffers(entries []*entity.ProtocolEntry, payId string) bool {
+	differs := false
+	for _, e := range entries {
+		if e.ApiId == payId {
+			return false
+		}
+		if e.ApiId != "" {
+			differs = true
+		}
+	}
+	return differs
+}
+
+// signedAmount is the amount with the sign of its effect on our balance, no matter how the file lists it.
+func signedAmount(line SettlementLine) int64 {
+	switch line.Type {
+	case SettlementRefund, SettlementChargeback:
+		return -abs(line.AmountCent)
+	default:
+		return line.AmountCent
+	}
+}
+
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func formatCents(value int64) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs(value)/100, abs(value)%100)
+}
@@ -0,0 +1,43 @@
+package reconciliationsrv
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/spreadsheet"
+)
+
+var reportColumns = []string{
+	"line", "reference_id", "pay_id", "type", "amount", "currency", "fee", "date",
+	"result", "problems", "booking_status", "booking_amount", "booking_currency",
+}
+
+// WriteReportCsv writes the lines of a reconciliation report as csv, for use in a spreadsheet.
+//
+// Unlike in the json representation, amounts are written as decimals, e.g. 185.00. Values from the settlement
+// file that a spreadsheet would take for a formula are prefixed with '.
+func WriteReportCsv(w io.Writer, report nexiapi.ReconciliationReportDto) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(reportColumns); err != nil {
+		return err
+	}
+	for _, line := range report.Lines {
+		bookingAmount := ""
+		if line.BookingAmount != nil {
+			bookingAmount = formatCents(*line.BookingAmount)
+		}
+		err := writer.Write(spreadsheet.Row([]string{
+			strconv.Itoa(line.Line), line.ReferenceId, line.PayId, line.Type, formatCents(line.Amount), line.Currency,
+			formatCents(line.Fee), line.Date, line.Result, strings.Join(line.Problems, "; "),
+			line.BookingStatus, bookingAmount, line.BookingCurrency,
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,35 @@
+package reconciliationsrv
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReportCsv(t *testing.T) {
+	docs.Description("report lines are written with decimal amounts, values that a spreadsheet would execute as a formula are prefixed with '")
+	var out strings.Builder
+	err := WriteReportCsv(&out, nexiapi.ReconciliationReportDto{
+		Lines: []nexiapi.ReconciliationLineDto{
+			{
+				Line:        2,
+				ReferenceId: "=HYPERLINK(\"https://evil.example.com\")",
+				PayId:       "@cafe",
+				Type:        "refund",
+				Amount:      -5000,
+				Currency:    "EUR",
+				Fee:         -35,
+				Date:        "2022-12-17",
+				Result:      "unmatched",
+				Problems:    []string{"no booking found"},
+			},
+		},
+	})
+	require.Nil(t, err)
+	require.Equal(t, "line,reference_id,pay_id,type,amount,currency,fee,date,result,problems,booking_status,booking_amount,booking_currency\n"+
+		"2,\"'=HYPERLINK(\"\"https://evil.example.com\"\")\",'@cafe,refund,-50.00,EUR,-0.35,2022-12-17,unmatched,no booking found,,,\n",
+		out.String())
+}
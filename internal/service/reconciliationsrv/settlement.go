@@ -0,0 +1,169 @@
+package reconciliationsrv
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SettlementType string
+
+const (
+	SettlementCapture    SettlementType = "capture"
+	SettlementRefund     SettlementType = "refund"
+	SettlementChargeback SettlementType = "chargeback"
+	SettlementFee        SettlementType = "fee"
+)
+
+// settlementTypes maps the values of the Action column (lowercase) to our types.
+var settlementTypes = map[string]SettlementType{
+	"capture":    SettlementCapture,
+	"credit":     SettlementRefund,
+	"chargeback": SettlementChargeback,
+	"fee":        SettlementFee,
+}
+
+// settlementColumns maps the lowercase column names of the Paygate settlement layout to the fields of a SettlementLine.
+//
+// The names are the ones Paygate uses in all its interfaces. MerchantID, XID and RefNr are part of the layout,
+// but not needed for matching.
+var settlementColumns = map[string]string{
+	"transid":  "reference_id",
+	"payid":    "pay_id",
+	"action":   "type",
+	"amount":   "amount",
+	"currency": "currency",
+	"fee":      "fee",
+	"date":     "date",
+}
+
+var requiredSettlementColumns = []string{"TransID", "PayID", "Action", "Amount", "Currency", "Fee", "Date"}
+
+const settlementDateFormat = "2006-01-02"
+
+// SettlementLine is one transaction listed in a settlement file.
+type SettlementLine struct {
+	Line        int // line number in the file, the header is line 1
+	ReferenceId string
+	PayId       string
+	Type        SettlementType // the value from the file if not one of the known types
+	AmountCent  int64          // negative for money going back to the customer
+	Currency    string
+	FeeCent     int64  // negative for fees charged
+	Date        string // the settlement date, yyyy-mm-dd
+}
+
+// ParseSettlement reads a daily settlement file in the Paygate csv layout.
+//
+// The file is semicolon separated and starts with a header line naming the columns MerchantID, PayID, XID,
+// TransID, RefNr, Action, Amount, Currency, Fee and Date. Columns are identified by their header, so their
+// order does not matter, and MerchantID, XID and RefNr may be missing. Like everywhere in Paygate, amounts and
+// fees are integers in the smallest denomination of the currency.
+func ParseSettlement(r io.Reader) ([]SettlementLine, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = ';'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: file is empty", ErrInvalidSettlementFile)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSettlementFile, err.Error())
+	}
+	columns, err := settlementColumnIndexes(header)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]SettlementLine, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSettlementFile, err.Error())
+		}
+		lineNo, _ := reader.FieldPos(0)
+
+		line, err := settlementLineFromRecord(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidSettlementFile, lineNo, err.Error())
+		}
+		line.Line = lineNo
+		result = append(result, line)
+	}
+}
+
+func settlementColumnIndexes(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for idx, name := range header {
+		normalized := strings.ToLower(strings.TrimPrefix(name, "\ufeff"))
+		normalized = strings.TrimSpace(normalized)
+		if field, ok := settlementColumns[normalized]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = idx
+			}
+		}
+	}
+	for _, name := range requiredSettlementColumns {
+		if _, ok := columns[settlementColumns[strings.ToLower(name)]]; !ok {
+			return nil, fmt.Errorf("%w: missing column %s in header", ErrInvalidSettlementFile, name)
+		}
+	}
+	return columns, nil
+}
+
+func settlementLineFromRecord(record []string, columns map[string]int) (SettlementLine, error) {
+	value := func(field string) string {
+		idx, ok := columns[field]
+		if !ok || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+
+	line := SettlementLine{
+		ReferenceId: value("reference_id"),
+		PayId:       value("pay_id"),
+		Currency:    strings.ToUpper(value("currency")),
+		Date:        value("date"),
+	}
+
+	rawType := value("type")
+	if known, ok := settlementTypes[strings.ToLower(rawType)]; ok {
+		line.Type = known
+	} else {
+		line.Type = SettlementType(rawType)
+	}
+
+	var err error
+	if line.AmountCent, err = parseSmallestUnit(value("amount")); err != nil {
+		return line, fmt.Errorf("invalid amount: %s", err.Error())
+	}
+	if line.FeeCent, err = parseSmallestUnit(value("fee")); err != nil {
+		return line, fmt.Errorf("invalid fee: %s", err.Error())
+	}
+	if _, err := time.Parse(settlementDateFormat, line.Date); err != nil {
+		return line, fmt.Errorf("invalid date: '%s' is not in the format yyyy-mm-dd", line.Date)
+	}
+	return line, nil
+}
+
+// parseSmallestUnit parses an amount given in the smallest denomination, e.g. "18500" or "-35". An empty value is zero.
+func parseSmallestUnit(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a number", value)
+	}
+	return amount, nil
+}
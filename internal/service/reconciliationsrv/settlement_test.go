@@ -0,0 +1,63 @@
+package reconciliationsrv
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSettlementPaygateLayout(t *testing.T) {
+	docs.Description("settlement files in the Paygate layout are parsed, amounts are in the smallest denomination")
+	file, err := os.Open("../../../test/resources/settlement-paygate.csv")
+	require.Nil(t, err)
+	defer file.Close()
+
+	lines, err := ParseSettlement(file)
+	require.Nil(t, err)
+	require.Len(t, lines, 9)
+	require.Equal(t, SettlementLine{
+		Line:        2,
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		PayId:       "ef00000000000000000000000000cafe",
+		Type:        SettlementCapture,
+		AmountCent:  18500,
+		Currency:    "EUR",
+		FeeCent:     -35,
+		Date:        "2022-12-17",
+	}, lines[0])
+	require.Equal(t, SettlementRefund, lines[5].Type)
+	require.Equal(t, int64(-5000), lines[5].AmountCent)
+	require.Equal(t, SettlementFee, lines[8].Type)
+	require.Equal(t, int64(-1500), lines[8].FeeCent)
+}
+
+func TestParseSettlementColumnOrder(t *testing.T) {
+	docs.Description("columns are identified by their header, a byte order mark is ignored")
+	lines, err := ParseSettlement(strings.NewReader("\ufeffdate;currency;amount;fee;action;transid;payid\n2022-12-17;eur;390;;capture;EF1995-000001;ef00000000000000000000000000cafe\n"))
+	require.Nil(t, err)
+	require.Len(t, lines, 1)
+	require.Equal(t, "EF1995-000001", lines[0].ReferenceId)
+	require.Equal(t, "EUR", lines[0].Currency)
+	require.Equal(t, int64(390), lines[0].AmountCent)
+	require.Equal(t, int64(0), lines[0].FeeCent)
+}
+
+func TestParseSettlementInvalid(t *testing.T) {
+	docs.Description("files that do not follow the Paygate layout are rejected")
+	for input, expected := range map[string]string{
+		"": "invalid settlement file: file is empty",
+		"TransID,PayID,Action,Amount,Currency,Fee,Date\n":                                                 "invalid settlement file: missing column TransID in header",
+		"TransID;PayID;Action;Amount;Currency;Fee\n":                                                      "invalid settlement file: missing column Date in header",
+		"TransID;PayID;Action;Amount;Currency;Fee;Date\nEF1995-000001;;Capture;185.00;EUR;0;2022-12-17\n": "invalid settlement file: line 2: invalid amount: '185.00' is not a number",
+		"TransID;PayID;Action;Amount;Currency;Fee;Date\nEF1995-000001;;Capture;18500;EUR;0;17.12.2022\n":  "invalid settlement file: line 2: invalid date: '17.12.2022' is not in the format yyyy-mm-dd",
+	} {
+		_, err := ParseSettlement(strings.NewReader(input))
+		require.NotNil(t, err, input)
+		require.True(t, errors.Is(err, ErrInvalidSettlementFile), input)
+		require.Equal(t, expected, err.Error(), input)
+	}
+}
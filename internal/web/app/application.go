@@ -1,12 +1,19 @@
 package app
 
 import (
+	"context"
+	"time"
+
+	auzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/attendeeservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/idp"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/tracing"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/protocolsrv"
 )
 
 type Application interface {
@@ -28,14 +35,53 @@ func (i *Impl) Run() int {
 	}
 	setLoglevel(config.LoggingSeverity())
 
+	shutdownTracing, err := tracing.Setup(auzerolog.AddLoggerToCtx(context.Background()))
+	if err != nil {
+		return 1
+	}
+	defer func() {
+		// flush remaining spans, but do not hang on exit if the collector is gone
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
 	if err := database.Open(); err != nil {
 		return 1
 	}
 	defer database.Close()
+
+	if config.MigrationStatusAndExit() {
+		if err := database.LogMigrationStatus(); err != nil {
+			return 1
+		}
+		return 0
+	}
+	if config.MigrateDatabaseDownSteps() > 0 {
+		if err := database.MigrateDown(); err != nil {
+			return 1
+		}
+		return 0
+	}
+
 	if err := database.MigrateIfSwitchedOn(); err != nil {
 		return 1
 	}
 
+	if config.ProtocolExportFile() != "" {
+		if err := exportProtocol(auzerolog.AddLoggerToCtx(context.Background())); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	if config.ApplyRetentionAndExit() {
+		if err := protocolsrv.New().ApplyRetentionPolicy(auzerolog.AddLoggerToCtx(context.Background())); err != nil {
+			return 1
+		}
+		return 0
+	}
+
 	if err := attendeeservice.Create(); err != nil {
 		return 1
 	}
@@ -48,10 +94,21 @@ func (i *Impl) Run() int {
 		return 1
 	}
 
+	if config.ReconcileSettlementFile() != "" {
+		if err := reconcileSettlement(auzerolog.AddLoggerToCtx(context.Background())); err != nil {
+			return 1
+		}
+		return 0
+	}
+
 	if err := nexi.Create(); err != nil {
 		return 1
 	}
 
+	if err := idp.Create(); err != nil {
+		return 1
+	}
+
 	if err := runServerWithGracefulShutdown(); err != nil {
 		return 2
 	}
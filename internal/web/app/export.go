@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/protocolsrv"
+)
+
+func exportProtocol(ctx context.Context) error {
+	query := dbrepo.ProtocolQuery{
+		ReferenceIdPrefix: config.ProtocolExportReferenceIdPrefix(),
+	}
+	var err error
+	if query.Kinds, err = parseExportKinds(config.ProtocolExportKinds()); err != nil {
+		return err
+	}
+	if query.CreatedAfter, err = parseExportTime("export-created-after", config.ProtocolExportCreatedAfter()); err != nil {
+		return err
+	}
+	if query.CreatedBefore, err = parseExportTime("export-created-before", config.ProtocolExportCreatedBefore()); err != nil {
+		return err
+	}
+
+	format := protocolsrv.ExportFormat(config.ProtocolExportFormat())
+	if format != protocolsrv.ExportCsv && format != protocolsrv.ExportJsonl {
+		aulogging.Logger.Ctx(ctx).Error().Print(protocolsrv.ErrUnknownExportFormat.Error())
+		return protocolsrv.ErrUnknownExportFormat
+	}
+
+	filename := config.ProtocolExportFile()
+	f, err := os.Create(filename)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to create protocol export file: %s", err.Error())
+		return err
+	}
+	defer f.Close()
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("Exporting protocol to %s...", filename)
+	err = protocolsrv.New().ExportProtocol(ctx, query, format, f)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("protocol export failed: %s", err.Error())
+		return err
+	}
+	return f.Close()
+}
+
+func parseExportKinds(values []string) ([]entity.ProtocolKind, error) {
+	var result []entity.ProtocolKind
+	for _, value := range values {
+		kind := entity.ProtocolKind(value)
+		if !kind.Valid() {
+			err := fmt.Errorf("-export-kinds must only contain raw, success, pending, warning, error, but got '%s'", value)
+			aulogging.Logger.NoCtx().Error().Print(err.Error())
+			return nil, err
+		}
+		result = append(result, kind)
+	}
+	return result, nil
+}
+
+func parseExportTime(flagName string, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		err = fmt.Errorf("-%s must be a date and time in RFC3339 format, e.g. 2025-08-01T00:00:00Z", flagName)
+		aulogging.Logger.NoCtx().Error().Print(err.Error())
+		return time.Time{}, err
+	}
+	return parsed, nil
+}
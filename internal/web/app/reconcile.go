@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"os"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/reconciliationsrv"
+)
+
+func reconcileSettlement(ctx context.Context) error {
+	settlementFilename := config.ReconcileSettlementFile()
+	in, err := os.Open(settlementFilename)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to open settlement file: %s", err.Error())
+		return err
+	}
+	defer in.Close()
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("Reconciling settlement file %s...", settlementFilename)
+	report, err := reconciliationsrv.New().ReconcileSettlement(ctx, in)
+	if err != nil {
+		return err
+	}
+
+	reportFilename := config.ReconcileReportFile()
+	out, err := os.Create(reportFilename)
+	if err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to create reconciliation report file: %s", err.Error())
+		return err
+	}
+	defer out.Close()
+
+	if err := reconciliationsrv.WriteReportCsv(out, report); err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("failed to write reconciliation report: %s", err.Error())
+		return err
+	}
+	aulogging.Logger.Ctx(ctx).Info().Printf("Wrote %d lines that need attention to %s", len(report.Lines), reportFilename)
+	return out.Close()
+}
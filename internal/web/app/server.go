@@ -11,14 +11,22 @@ import (
 	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
+	auzerolog "github.com/StephanHCB/go-autumn-logging-zerolog"
 	"github.com/StephanHCB/go-autumn-logging-zerolog/loggermiddleware"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/self"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/healthsrv"
 	"github.com/eurofurence/reg-paygate-adapter/internal/service/paymentlinksrv"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/protocolsrv"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/reconciliationsrv"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/fallbackctl"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/infoctl"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/openapictl"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/paylinkctl"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/protocolctl"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/reconciliationctl"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/simulatorctl"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/statsctl"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/controller/webhookctl"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/middleware"
 	"github.com/go-chi/chi/v5"
@@ -29,27 +37,43 @@ func CreateRouter(ctx context.Context) (chi.Router, error) {
 	server := chi.NewRouter()
 
 	server.Use(middleware.AddRequestIdToContextAndResponse)
+	server.Use(middleware.RequestTracing)
 	server.Use(loggermiddleware.AddZerologLoggerToContext)
 	server.Use(middleware.RequestLogger)
+	server.Use(middleware.RequestMetrics)
 	server.Use(middleware.PanicRecoverer)
 	server.Use(middleware.CorsHandling)
+	server.Use(middleware.RateLimiter())
 	server.Use(middleware.TokenValidator)
+	// after the rate limiter and authentication, so rejected requests are not buffered or checked
+	openApiValidator, err := middleware.OpenApiValidator()
+	if err != nil {
+		return server, err
+	}
+	server.Use(openApiValidator)
 
 	// add your business logic services here
 	paymentLinkService := paymentlinksrv.New()
+	protocolService := protocolsrv.New()
+	reconciliationService := reconciliationsrv.New()
+	healthService := healthsrv.New()
 
 	// add your controllers here
 	paylinkctl.Create(server, paymentLinkService)
 	webhookctl.Create(server, paymentLinkService)
+	protocolctl.Create(server, protocolService)
+	reconciliationctl.Create(server, reconciliationService)
+	statsctl.Create(server, protocolService)
 	if config.NexiDownstreamBaseUrl() == "" {
 		aulogging.Logger.NoCtx().Warn().Printf("service.nexi_downstream not configured. Enabling local paylink simulator at %s/simulator (not useful for production!)", config.ServicePublicURL())
-		err := self.Create()
+		err = self.Create()
 		if err != nil {
 			return server, err
 		}
 		simulatorctl.Create(server, paymentLinkService)
 	}
-	infoctl.Create(server)
+	infoctl.Create(server, healthService)
+	openapictl.Create(server)
 	fallbackctl.Create(server)
 	return server, nil
 }
@@ -81,6 +105,8 @@ func runServerWithGracefulShutdown() error {
 	}
 	srv := newServer(ctx, handler)
 
+	go protocolsrv.New().RunRetentionJob(auzerolog.AddLoggerToCtx(ctx))
+
 	go func() {
 		<-sig
 		defer cancel()
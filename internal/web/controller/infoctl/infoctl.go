@@ -4,16 +4,39 @@ import (
 	"net/http"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/healthsrv"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
 )
 
-func Create(server chi.Router) {
+var healthService healthsrv.HealthService
+
+func Create(server chi.Router, healthSrv healthsrv.HealthService) {
+	healthService = healthSrv
+
 	server.Get("/", healthHandler)
 	server.Get("/info/health", healthHandler)
+	server.Get("/ready", readyHandler)
+	server.Get("/info/ready", readyHandler)
+	server.Method(http.MethodGet, "/metrics", metrics.Handler())
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	dto := nexiapi.HealthReportDto{Status: "OK"}
 	ctlutil.WriteJson(r.Context(), w, dto)
 }
+
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	dto := healthService.CheckReadiness(ctx)
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	if dto.Status == healthsrv.StatusReady {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	ctlutil.WriteJson(ctx, w, dto)
+}
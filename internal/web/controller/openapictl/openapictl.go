@@ -0,0 +1,56 @@
+package openapictl
+
+import (
+	"net/http"
+
+	openapispec "github.com/eurofurence/reg-paygate-adapter/api/openapi-spec"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+func Create(server chi.Router) {
+	server.Get("/api/rest/v1/openapi.yaml", specHandler)
+	server.Get("/api/rest/v1/docs", docsHandler)
+}
+
+func specHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationYaml)
+	_, _ = w.Write(openapispec.Yaml)
+}
+
+// swaggerUiBase pins the Swagger UI release, so the CDN cannot hand us a different one
+const swaggerUiBase = "https://unpkg.com/swagger-ui-dist@5.17.14/"
+
+// docsPage renders the spec with Swagger UI, which the browser loads from a CDN
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Paygate Adapter API</title>
+  <link rel="stylesheet" href="` + swaggerUiBase + `swagger-ui.css" crossorigin="anonymous">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + swaggerUiBase + `swagger-ui-bundle.js" crossorigin="anonymous"></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: 'openapi.yaml', dom_id: '#swagger-ui' });
+    };
+  </script>
+</body>
+</html>
+`
+
+// docsPolicy only lets the page run the pinned Swagger UI and its own script, which is allowed by its hash,
+// and only lets it talk to us
+const docsPolicy = "default-src 'none'; " +
+	"script-src " + swaggerUiBase + " 'sha256-8STfTmdYq1/67rTd3gDkyJ2GlNqjnYZK/1iLmGNHhTE='; " +
+	"style-src " + swaggerUiBase + " 'unsafe-inline'; " +
+	"img-src 'self' data:; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headers.ContentType, media.ContentTypeTextHtml)
+	w.Header().Set(headers.ContentSecurityPolicy, docsPolicy)
+	_, _ = w.Write([]byte(docsPage))
+}
@@ -5,24 +5,40 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/attendeeservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/service/paymentlinksrv"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-http-utils/headers"
 )
 
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+
+	// keeps proxies from closing idle event streams
+	eventStreamHeartbeat = 15 * time.Second
+	// event streams are closed after this long, EventSource clients reconnect by themselves
+	eventStreamMaxDuration = 10 * time.Minute
+	eventStreamRetryMillis = 3000
+)
+
 var paymentLinkService paymentlinksrv.PaymentLinkService
 
 var refIdRegex *regexp.Regexp
@@ -31,18 +47,32 @@ func Create(server chi.Router, paymentLinkSrv paymentlinksrv.PaymentLinkService)
 	paymentLinkService = paymentLinkSrv
 
 	server.Post("/api/rest/v1/paylinks", createPaylinkHandler)
+	server.Get("/api/rest/v1/paylinks", listPaylinksHandler)
 	server.Get("/api/rest/v1/paylinks/{refid}", getPaymentHandler)
+	server.Get("/api/rest/v1/paylinks/{refid}/events", paymentEventsHandler)
 	server.Post("/api/rest/v1/paylinks/{refid}/status-check", checkPaymentStatusHandler)
+	server.Post("/api/rest/v1/paylinks/{refid}/capture", capturePaymentHandler)
+	server.Post("/api/rest/v1/paylinks/{refid}/refund", refundPaymentHandler)
+	server.Post("/api/rest/v1/paylinks/{refid}/cancel", cancelPaymentHandler)
+	server.Get("/api/rest/v1/return/{refid}", returnHandler)
+
+	server.Post("/api/rest/v2/paylinks", createPaylinkV2Handler)
+	server.Get("/api/rest/v2/paylinks", listPaylinksV2Handler)
+	server.Get("/api/rest/v2/paylinks/{refid}", getPaylinkV2Handler)
 
 	refIdRegex = regexp.MustCompile("^[A-Z0-9][A-Z0-9-]+[A-Z0-9]$")
 }
 
 func createPaylinkHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	if !ctxvalues.HasApiToken(ctx) {
+	if !ctxvalues.IsAuthenticated(ctx) {
 		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
 		return
 	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkCreate) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:create permission")
+		return
+	}
 
 	request, err := parseBodyToPaymentLinkRequestDto(ctx, w, r)
 	if err != nil {
@@ -68,16 +98,50 @@ func createPaylinkHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set(headers.Location, fmt.Sprintf("/api/rest/v1/paylinks/%s", id))
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
 	w.WriteHeader(http.StatusCreated)
 	ctlutil.WriteJson(ctx, w, dto)
 }
 
+func listPaylinksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:read permission")
+		return
+	}
+
+	query, page, pageSize, errs := paylinkQueryFromParams(r.URL.Query())
+	if len(errs) > 0 {
+		paylinkQueryInvalidErrorHandler(ctx, w, r, errs)
+		return
+	}
+
+	dto, err := paymentLinkService.ListPaymentLinks(ctx, query)
+	if err != nil {
+		ctlutil.UnexpectedError(ctx, w, r, err)
+		return
+	}
+	dto.Page = page
+	dto.PageSize = pageSize
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	ctlutil.WriteJson(ctx, w, dto)
+}
+
 func getPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	if !ctxvalues.HasApiToken(ctx) {
+	if !ctxvalues.IsAuthenticated(ctx) {
 		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
 		return
 	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:read permission")
+		return
+	}
 
 	id, err := refidFromVars(ctx, w, r)
 	if err != nil {
@@ -96,15 +160,103 @@ func getPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
 	ctlutil.WriteJson(ctx, w, dto)
 }
 
+func paymentEventsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:read permission")
+		return
+	}
+
+	id, err := refidFromVars(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	// the server write timeout would otherwise end the stream
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("could not lift write deadline for event stream: %s", err.Error())
+	}
+
+	// EventSource clients send the id of the last event they received when reconnecting
+	var lastEventId uint
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Info().Printf("ignoring invalid Last-Event-ID %s", value)
+		}
+		lastEventId = uint(parsed)
+	}
+
+	events, unsubscribe := paymentLinkService.SubscribePaymentEvents(ctx, id, lastEventId)
+	defer unsubscribe()
+
+	w.Header().Set(headers.ContentType, media.ContentTypeTextEventStream)
+	w.Header().Set(headers.CacheControl, "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetryMillis)
+	if err := rc.Flush(); err != nil {
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("event stream cannot be flushed: %s", err.Error())
+		return
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+	maxDuration := time.NewTimer(eventStreamMaxDuration)
+	defer maxDuration.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-maxDuration.C:
+			return
+		case <-heartbeat.C:
+			_, _ = fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.EventId != 0 && event.EventId <= lastEventId {
+				// replayed and published
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("error while encoding payment event: %s", err.Error())
+				continue
+			}
+			if event.EventId != 0 {
+				lastEventId = event.EventId
+				_, _ = fmt.Fprintf(w, "id: %d\n", event.EventId)
+			}
+			_, _ = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
 func checkPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	if !ctxvalues.HasApiToken(ctx) {
+	if !ctxvalues.IsAuthenticated(ctx) {
 		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
 		return
 	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionStatusCheck) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without status-check permission")
+		return
+	}
 
 	id, err := refidFromVars(ctx, w, r)
 	if err != nil {
@@ -129,9 +281,206 @@ func checkPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
 	ctlutil.WriteJson(ctx, w, dto)
 }
 
+func capturePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionCapture) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without capture permission")
+		return
+	}
+
+	id, err := refidFromVars(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	request, err := parseBodyToCaptureRequestDto(ctx, w, r)
+	if err != nil {
+		return
+	}
+	if request.Amount != nil && *request.Amount <= 0 {
+		paylinkRequestInvalidErrorHandler(ctx, w, r, url.Values{"amount": {"must be a positive integer"}})
+		return
+	}
+
+	err = paymentLinkService.CapturePayment(ctx, id, request.Amount)
+	if err != nil {
+		if errors.Is(err, nexi.DownstreamError) {
+			downstreamErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, nexi.NoSuchID404Error) {
+			paymentNotFoundErrorHandler(ctx, w, r, id)
+		} else if errors.Is(err, nexi.NotConfigured) {
+			downstreamNotConfiguredErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, paymentlinksrv.CaptureNotPossibleError) {
+			cannotUpdatePaymentErrorHandler(ctx, w, r, id, err)
+		} else {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func refundPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionRefund) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without refund permission")
+		return
+	}
+
+	id, err := refidFromVars(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	request, err := parseBodyToRefundRequestDto(ctx, w, r)
+	if err != nil {
+		return
+	}
+	if request.Amount != nil && *request.Amount <= 0 {
+		paylinkRequestInvalidErrorHandler(ctx, w, r, url.Values{"amount": {"must be a positive integer"}})
+		return
+	}
+
+	err = paymentLinkService.RefundPayment(ctx, id, request.Amount)
+	if err != nil {
+		if errors.Is(err, nexi.DownstreamError) {
+			downstreamErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, nexi.NoSuchID404Error) {
+			paymentNotFoundErrorHandler(ctx, w, r, id)
+		} else if errors.Is(err, nexi.NotConfigured) {
+			downstreamNotConfiguredErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, paymentlinksrv.RefundNotPossibleError) {
+			cannotUpdatePaymentErrorHandler(ctx, w, r, id, err)
+		} else {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func cancelPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionCancel) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without cancel permission")
+		return
+	}
+
+	id, err := refidFromVars(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	err = paymentLinkService.CancelPayment(ctx, id)
+	if err != nil {
+		if errors.Is(err, nexi.DownstreamError) {
+			downstreamErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, nexi.NoSuchID404Error) {
+			paymentNotFoundErrorHandler(ctx, w, r, id)
+		} else if errors.Is(err, nexi.NotConfigured) {
+			downstreamNotConfiguredErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, paymentlinksrv.CancelNotPossibleError) {
+			cannotUpdatePaymentErrorHandler(ctx, w, r, id, err)
+		} else {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// returnHandler is where the hosted payment page sends the attendee back to, both after paying and after cancelling.
+//
+// Anonymous, because this is a browser redirect. Checks the payment, then redirects to the success or failure page,
+// so the attendee does not see their payment as open just because the webhook has not been processed yet.
+// The pages requested when creating the paylink are passed along in the query parameters success and cancel.
+func returnHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id := chi.URLParam(r, "refid")
+	if !refIdRegex.MatchString(id) || !strings.HasPrefix(id, config.TransactionIDPrefix()) || len(id) > 63 {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("return with invalid paylink id '%s'", url.QueryEscape(id))
+		redirectWithOutcome(ctx, w, r, "", paymentlinksrv.ReturnOutcomeUnknown)
+		return
+	}
+
+	// the check is not cancelled with the request, so it never stops halfway through updating the transaction.
+	// The attendee only waits for it up to the timeout.
+	result := make(chan paymentlinksrv.ReturnOutcome, 1)
+	go func() {
+		outcome, err := paymentLinkService.CheckReturningPayment(context.WithoutCancel(ctx), id)
+		if err != nil {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("return could not determine payment status for %s: %s", id, err.Error())
+		}
+		result <- outcome
+	}()
+
+	timeout := time.NewTimer(config.ReturnCheckTimeout())
+	defer timeout.Stop()
+	select {
+	case outcome := <-result:
+		redirectWithOutcome(ctx, w, r, id, outcome)
+	case <-timeout.C:
+		aulogging.Logger.Ctx(ctx).Warn().Printf("return check for %s is taking too long, redirecting without waiting for it", id)
+		redirectWithOutcome(ctx, w, r, id, paymentlinksrv.ReturnOutcomeUnknown)
+	case <-ctx.Done():
+		// the attendee has left
+	}
+}
+
+func redirectWithOutcome(ctx context.Context, w http.ResponseWriter, r *http.Request, id string, outcome paymentlinksrv.ReturnOutcome) {
+	target := requestedOrDefaultPage(r, "cancel", config.FailureRedirect())
+	if outcome == paymentlinksrv.ReturnOutcomePaid {
+		target = requestedOrDefaultPage(r, "success", config.SuccessRedirect())
+	}
+
+	targetUrl, err := url.Parse(target)
+	if target == "" || err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("return for %s with outcome %s, but no page configured to redirect to", id, outcome)
+		w.Header().Set(headers.ContentType, media.ContentTypeTextPlain)
+		_, _ = w.Write([]byte(fmt.Sprintf("payment %s: %s", id, outcome)))
+		return
+	}
+
+	query := targetUrl.Query()
+	if id != "" {
+		query.Set("refid", id)
+	}
+	query.Set("outcome", string(outcome))
+	targetUrl.RawQuery = query.Encode()
+
+	aulogging.Logger.Ctx(ctx).Info().Printf("return for %s with outcome %s", id, outcome)
+	http.Redirect(w, r, targetUrl.String(), http.StatusSeeOther)
+}
+
+// requestedOrDefaultPage returns the page given when the paylink was created, if it is still in the allowlist.
+func requestedOrDefaultPage(r *http.Request, param string, defaultPage string) string {
+	requested := r.URL.Query().Get(param)
+	if requested != "" && config.RedirectAllowed(requested) {
+		return requested
+	}
+	return defaultPage
+}
+
 func parseBodyToPaymentLinkRequestDto(ctx context.Context, w http.ResponseWriter, r *http.Request) (nexiapi.PaymentLinkRequestDto, error) {
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
@@ -143,6 +492,60 @@ func parseBodyToPaymentLinkRequestDto(ctx context.Context, w http.ResponseWriter
 	return dto, err
 }
 
+// parseBodyToCaptureRequestDto accepts an empty body, which captures everything that is left
+func parseBodyToCaptureRequestDto(ctx context.Context, w http.ResponseWriter, r *http.Request) (nexiapi.CaptureRequestDto, error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	dto := nexiapi.CaptureRequestDto{}
+	err := decoder.Decode(&dto)
+	if errors.Is(err, io.EOF) {
+		return dto, nil
+	}
+	if err != nil {
+		paylinkRequestParseErrorHandler(ctx, w, r, err)
+	}
+	return dto, err
+}
+
+// parseBodyToRefundRequestDto accepts an empty body, which refunds everything that is left
+func parseBodyToRefundRequestDto(ctx context.Context, w http.ResponseWriter, r *http.Request) (nexiapi.RefundRequestDto, error) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	dto := nexiapi.RefundRequestDto{}
+	err := decoder.Decode(&dto)
+	if errors.Is(err, io.EOF) {
+		return dto, nil
+	}
+	if err != nil {
+		paylinkRequestParseErrorHandler(ctx, w, r, err)
+	}
+	return dto, err
+}
+
+func paylinkQueryFromParams(params url.Values) (dbrepo.PaylinkQuery, int, int, url.Values) {
+	errs := url.Values{}
+
+	query := dbrepo.PaylinkQuery{
+		Status: params.Get("status"),
+	}
+	if value := params.Get("debitor_id"); value != "" {
+		debitorId, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || debitorId == 0 {
+			errs.Add("debitor_id", "must be a positive integer (the badge number)")
+		}
+		query.DebitorId = debitorId
+	}
+	query.CreatedAfter = ctlutil.TimeParam(errs, params, "created_after")
+	query.CreatedBefore = ctlutil.TimeParam(errs, params, "created_before")
+
+	page := ctlutil.IntParam(errs, params, "page", 1, 1, 1000000)
+	pageSize := ctlutil.IntParam(errs, params, "page_size", defaultPageSize, 1, maxPageSize)
+	query.Offset = (page - 1) * pageSize
+	query.Limit = pageSize
+
+	return query, page, pageSize, errs
+}
+
 func refidFromVars(ctx context.Context, w http.ResponseWriter, r *http.Request) (string, error) {
 	idStr := chi.URLParam(r, "refid")
 	// minimal validation to make sure the downstream api request will be valid
@@ -158,6 +561,11 @@ func paylinkRequestParseErrorHandler(ctx context.Context, w http.ResponseWriter,
 	ctlutil.ErrorHandler(ctx, w, r, "paylink.parse.error", http.StatusBadRequest, nil)
 }
 
+func paylinkQueryInvalidErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, validationErrors url.Values) {
+	aulogging.Logger.Ctx(ctx).Warn().Printf("received invalid paylink query: %v", validationErrors)
+	ctlutil.ErrorHandler(ctx, w, r, "paylink.query.invalid", http.StatusBadRequest, validationErrors)
+}
+
 func paylinkRequestInvalidErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, validationErrors url.Values) {
 	// validation already logged each individual error
 	ctlutil.ErrorHandler(ctx, w, r, "paylink.data.invalid", http.StatusBadRequest, validationErrors)
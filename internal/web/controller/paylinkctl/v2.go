@@ -0,0 +1,141 @@
+package paylinkctl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	nexiapiv2 "github.com/eurofurence/reg-paygate-adapter/internal/api/v2/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/attendeeservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-http-utils/headers"
+)
+
+// api v2 serves the same payment links as v1, but in one representation that merges link and payment state.
+// Requests and error responses are the same as for v1.
+
+func createPaylinkV2Handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkCreate) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:create permission")
+		return
+	}
+
+	request, err := parseBodyToPaymentLinkRequestDto(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	errs := paymentLinkService.ValidatePaymentLinkRequest(ctx, request)
+	if errs != nil {
+		paylinkRequestInvalidErrorHandler(ctx, w, r, errs)
+		return
+	}
+
+	dto, id, err := paymentLinkService.CreatePaylink(ctx, request)
+	if err != nil {
+		if errors.Is(err, nexi.DownstreamError) {
+			downstreamErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, attendeeservice.DownstreamError) {
+			downstreamErrorHandler(ctx, w, r, "attsrv", err)
+		} else {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set(headers.Location, fmt.Sprintf("/api/rest/v2/paylinks/%s", id))
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	w.WriteHeader(http.StatusCreated)
+	ctlutil.WriteJson(ctx, w, dto)
+}
+
+func listPaylinksV2Handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:read permission")
+		return
+	}
+
+	query, page, pageSize, errs := paylinkQueryFromParams(r.URL.Query())
+	if len(errs) > 0 {
+		paylinkQueryInvalidErrorHandler(ctx, w, r, errs)
+		return
+	}
+
+	dto, err := paymentLinkService.ListPaylinks(ctx, query)
+	if err != nil {
+		ctlutil.UnexpectedError(ctx, w, r, err)
+		return
+	}
+	dto.Page = page
+	dto.PageSize = pageSize
+	dto.Links = map[string]nexiapiv2.LinkDto{
+		"self": pageLink(r.URL.Query(), page),
+	}
+	if page > 1 {
+		dto.Links["prev"] = pageLink(r.URL.Query(), page-1)
+	}
+	if int64(page)*int64(pageSize) < dto.Total {
+		dto.Links["next"] = pageLink(r.URL.Query(), page+1)
+	}
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	ctlutil.WriteJson(ctx, w, dto)
+}
+
+func getPaylinkV2Handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionPaylinkRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without paylink:read permission")
+		return
+	}
+
+	id, err := refidFromVars(ctx, w, r)
+	if err != nil {
+		return
+	}
+
+	dto, err := paymentLinkService.GetPaylink(ctx, id)
+	if err != nil {
+		if errors.Is(err, nexi.DownstreamError) {
+			downstreamErrorHandler(ctx, w, r, "paylink", err)
+		} else if errors.Is(err, nexi.NoSuchID404Error) {
+			paymentNotFoundErrorHandler(ctx, w, r, id)
+		} else {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	ctlutil.WriteJson(ctx, w, dto)
+}
+
+// pageLink keeps the filters of the list request, so following the links pages through the same list
+func pageLink(params url.Values, page int) nexiapiv2.LinkDto {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("page", strconv.Itoa(page))
+	return nexiapiv2.LinkDto{Href: "/api/rest/v2/paylinks?" + query.Encode(), Method: http.MethodGet}
+}
@@ -0,0 +1,162 @@
+package protocolctl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/protocolsrv"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+var protocolService protocolsrv.ProtocolService
+
+func Create(server chi.Router, protocolSrv protocolsrv.ProtocolService) {
+	protocolService = protocolSrv
+
+	server.Get("/api/rest/v1/protocol", queryProtocolHandler)
+	server.Get("/api/rest/v1/protocol/export", exportProtocolHandler)
+}
+
+func queryProtocolHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionProtocolRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without protocol:read permission")
+		return
+	}
+
+	query, page, pageSize, errs := protocolQueryFromParams(r.URL.Query())
+	if len(errs) > 0 {
+		protocolQueryInvalidErrorHandler(ctx, w, r, errs)
+		return
+	}
+
+	dto, err := protocolService.QueryProtocol(ctx, query)
+	if err != nil {
+		ctlutil.UnexpectedError(ctx, w, r, err)
+		return
+	}
+	dto.Page = page
+	dto.PageSize = pageSize
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	ctlutil.WriteJson(ctx, w, dto)
+}
+
+func exportProtocolHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionProtocolRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without protocol:read permission")
+		return
+	}
+
+	query, format, errs := exportQueryFromParams(r.URL.Query())
+	if len(errs) > 0 {
+		protocolQueryInvalidErrorHandler(ctx, w, r, errs)
+		return
+	}
+
+	// exports can take much longer than the configured server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		aulogging.Logger.Ctx(ctx).Warn().Printf("failed to lift write deadline for protocol export: %s", err.Error())
+	}
+
+	w.Header().Set(headers.ContentType, exportContentTypes[format])
+	w.Header().Set(headers.ContentDisposition, fmt.Sprintf(`attachment; filename="protocol-export.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+	if err := protocolService.ExportProtocol(ctx, query, format, w); err != nil {
+		// too late to send an error response, the client will notice the truncated export
+		aulogging.Logger.Ctx(ctx).Error().WithErr(err).Printf("protocol export failed: %s", err.Error())
+	}
+}
+
+var exportContentTypes = map[protocolsrv.ExportFormat]string{
+	protocolsrv.ExportCsv:   "text/csv; charset=utf-8",
+	protocolsrv.ExportJsonl: "application/x-ndjson",
+}
+
+func exportQueryFromParams(params url.Values) (dbrepo.ProtocolQuery, protocolsrv.ExportFormat, url.Values) {
+	errs := url.Values{}
+
+	query := dbrepo.ProtocolQuery{
+		ReferenceIdPrefix: params.Get("reference_id_prefix"),
+		Kinds:             kindsParam(errs, params, "kind"),
+	}
+	query.CreatedAfter = ctlutil.TimeParam(errs, params, "created_after")
+	query.CreatedBefore = ctlutil.TimeParam(errs, params, "created_before")
+
+	format := protocolsrv.ExportCsv
+	if value := params.Get("format"); value != "" {
+		format = protocolsrv.ExportFormat(value)
+		if _, ok := exportContentTypes[format]; !ok {
+			errs.Add("format", "must be one of csv, jsonl")
+		}
+	}
+
+	return query, format, errs
+}
+
+func protocolQueryFromParams(params url.Values) (dbrepo.ProtocolQuery, int, int, url.Values) {
+	errs := url.Values{}
+
+	query := dbrepo.ProtocolQuery{
+		ReferenceId:       params.Get("reference_id"),
+		ApiId:             params.Get("api_id"),
+		Kinds:             kindsParam(errs, params, "kind"),
+		RequestId:         params.Get("request_id"),
+		Currency:          params.Get("currency"),
+		UpstreamStatus:    params.Get("upstream_status"),
+		WebhookStatus:     params.Get("webhook_status"),
+		TransactionStatus: params.Get("transaction_status"),
+	}
+	query.CreatedAfter = ctlutil.TimeParam(errs, params, "created_after")
+	query.CreatedBefore = ctlutil.TimeParam(errs, params, "created_before")
+
+	page := ctlutil.IntParam(errs, params, "page", 1, 1, 1000000)
+	pageSize := ctlutil.IntParam(errs, params, "page_size", defaultPageSize, 1, maxPageSize)
+	query.Offset = (page - 1) * pageSize
+	query.Limit = pageSize
+
+	return query, page, pageSize, errs
+}
+
+func kindsParam(errs url.Values, params url.Values, key string) []entity.ProtocolKind {
+	var result []entity.ProtocolKind
+	for _, value := range params[key] {
+		kind := entity.ProtocolKind(value)
+		if !kind.Valid() {
+			errs.Add(key, "must be one of raw, success, pending, warning, error")
+			return nil
+		}
+		result = append(result, kind)
+	}
+	return result
+}
+
+func protocolQueryInvalidErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, validationErrors url.Values) {
+	aulogging.Logger.Ctx(ctx).Warn().Printf("received invalid protocol query: %v", validationErrors)
+	ctlutil.ErrorHandler(ctx, w, r, "protocol.query.invalid", http.StatusBadRequest, validationErrors)
+}
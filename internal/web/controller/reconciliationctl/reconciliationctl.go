@@ -0,0 +1,83 @@
+package reconciliationctl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/reconciliationsrv"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+// settlement files list one line per transaction, this is plenty for a day
+const maxSettlementFileSize = 50 * 1024 * 1024
+
+var reconciliationService reconciliationsrv.ReconciliationService
+
+func Create(server chi.Router, reconciliationSrv reconciliationsrv.ReconciliationService) {
+	reconciliationService = reconciliationSrv
+
+	server.Post("/api/rest/v1/reconciliation/settlement", reconcileSettlementHandler)
+}
+
+func reconcileSettlementHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionProtocolRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without protocol:read permission")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		settlementInvalidErrorHandler(ctx, w, r, url.Values{"format": []string{"must be one of json, csv"}})
+		return
+	}
+
+	report, err := reconciliationService.ReconcileSettlement(ctx, http.MaxBytesReader(w, r.Body, maxSettlementFileSize))
+	if err != nil {
+		if errors.Is(err, reconciliationsrv.ErrInvalidSettlementFile) {
+			settlementInvalidErrorHandler(ctx, w, r, url.Values{"details": []string{err.Error()}})
+		} else if errors.Is(err, paymentservice.DownstreamError) {
+			aulogging.Logger.Ctx(ctx).Warn().WithErr(err).Printf("paysrv downstream error: %s", err.Error())
+			ctlutil.ErrorHandler(ctx, w, r, "paysrv.downstream.error", http.StatusBadGateway, nil)
+		} else {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+		}
+		return
+	}
+
+	if format == "csv" {
+		// render first, so a failure can still be reported properly
+		buffer := &bytes.Buffer{}
+		if err := reconciliationsrv.WriteReportCsv(buffer, report); err != nil {
+			ctlutil.UnexpectedError(ctx, w, r, err)
+			return
+		}
+		w.Header().Set(headers.ContentType, "text/csv; charset=utf-8")
+		w.Header().Set(headers.ContentDisposition, `attachment; filename="reconciliation-report.csv"`)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(buffer.Bytes())
+		return
+	}
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	ctlutil.WriteJson(ctx, w, report)
+}
+
+func settlementInvalidErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, validationErrors url.Values) {
+	aulogging.Logger.Ctx(ctx).Warn().Printf("received invalid settlement file: %v", validationErrors)
+	ctlutil.ErrorHandler(ctx, w, r, "reconciliation.settlement.invalid", http.StatusBadRequest, validationErrors)
+}
@@ -0,0 +1,105 @@
+package statsctl
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/protocolsrv"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	dateFormat      = "2006-01-02"
+	defaultDays     = 7
+	maxDays         = 366
+	defaultTimezone = "UTC"
+)
+
+var protocolService protocolsrv.ProtocolService
+
+var nowFunc = time.Now
+
+func Create(server chi.Router, protocolSrv protocolsrv.ProtocolService) {
+	protocolService = protocolSrv
+
+	server.Get("/api/rest/v1/stats", statsHandler)
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if !ctxvalues.IsAuthenticated(ctx) {
+		ctlutil.UnauthenticatedError(ctx, w, r, "you must be logged in for this operation", "anonymous access attempt")
+		return
+	}
+	if !ctxvalues.HasPermission(ctx, config.PermissionProtocolRead) {
+		ctlutil.UnauthorizedError(ctx, w, r, "you are not authorized for this operation", "access attempt without protocol:read permission")
+		return
+	}
+
+	from, to, location, errs := statsRangeFromParams(r.URL.Query())
+	if len(errs) > 0 {
+		statsQueryInvalidErrorHandler(ctx, w, r, errs)
+		return
+	}
+
+	dto, err := protocolService.PaymentStatistics(ctx, from, to, location)
+	if err != nil {
+		ctlutil.UnexpectedError(ctx, w, r, err)
+		return
+	}
+
+	w.Header().Set(headers.ContentType, media.ContentTypeApplicationJson)
+	ctlutil.WriteJson(ctx, w, dto)
+}
+
+// statsRangeFromParams defaults to the last week, including today.
+func statsRangeFromParams(params url.Values) (time.Time, time.Time, *time.Location, url.Values) {
+	errs := url.Values{}
+
+	timezone := params.Get("timezone")
+	if timezone == "" {
+		timezone = defaultTimezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		errs.Add("timezone", "must be a time zone name, e.g. Europe/Berlin")
+		location = time.UTC
+	}
+
+	to := dateParam(errs, params, "to", location, nowFunc().In(location))
+	from := dateParam(errs, params, "from", location, to.AddDate(0, 0, 1-defaultDays))
+	if len(errs) == 0 {
+		if to.Before(from) {
+			errs.Add("to", "must not be before from")
+		} else if from.AddDate(0, 0, maxDays-1).Before(to) {
+			errs.Add("to", "must not be more than 366 days including from")
+		}
+	}
+	return from, to, location, errs
+}
+
+func dateParam(errs url.Values, params url.Values, key string, location *time.Location, defaultValue time.Time) time.Time {
+	value := params.Get(key)
+	if value == "" {
+		return time.Date(defaultValue.Year(), defaultValue.Month(), defaultValue.Day(), 0, 0, 0, 0, location)
+	}
+	parsed, err := time.ParseInLocation(dateFormat, value, location)
+	if err != nil {
+		errs.Add(key, "must be a date in format YYYY-MM-DD, e.g. 2025-08-01")
+		return defaultValue
+	}
+	return parsed
+}
+
+func statsQueryInvalidErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, validationErrors url.Values) {
+	aulogging.Logger.Ctx(ctx).Warn().Printf("received invalid stats query: %v", validationErrors)
+	ctlutil.ErrorHandler(ctx, w, r, "stats.query.invalid", http.StatusBadRequest, validationErrors)
+}
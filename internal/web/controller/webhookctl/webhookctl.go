@@ -3,6 +3,7 @@ package webhookctl
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"io"
@@ -11,9 +12,11 @@ import (
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/service/paymentlinksrv"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/clientip"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
 	"github.com/go-chi/chi/v5"
 )
@@ -29,6 +32,10 @@ func Create(server chi.Router, paymentLinkSrv paymentlinksrv.PaymentLinkService)
 
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	if !sourceIpAllowed(ctx, r, "webhook") {
+		ctlutil.UnauthorizedError(ctx, w, r, "source ip not allowed", "webhook call from disallowed source ip")
+		return
+	}
 	if !secretFromVarsOk(ctx, w, r) {
 		ctlutil.UnauthenticatedError(ctx, w, r, "invalid secret supplied", "invalid secret for webhook")
 		return
@@ -36,27 +43,38 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	request, err := parseBodyToWebhookDtoTolerant(ctx, w, r)
 	if err != nil {
+		metrics.WebhookProcessed("", "parse_error")
 		return
 	}
 
 	err = paymentLinkService.HandleWebhook(ctx, request)
 	if err != nil {
 		if errors.Is(err, paymentlinksrv.WebhookValidationErr) {
+			metrics.WebhookProcessed(request.Status, "invalid")
 			webhookRequestInvalidErrorHandler(ctx, w, r, err)
 		} else if errors.Is(err, nexi.NoSuchID404Error) {
+			metrics.WebhookProcessed(request.Status, "not_found")
 			paylinkNotFoundErrorHandler(ctx, w, r)
 		} else if errors.Is(err, nexi.DownstreamError) || errors.Is(err, paymentservice.DownstreamError) {
+			metrics.WebhookProcessed(request.Status, "downstream_error")
 			downstreamErrorHandler(ctx, w, r, err)
 		} else {
+			metrics.WebhookProcessed(request.Status, "error")
 			ctlutil.UnexpectedError(ctx, w, r, err)
 		}
 	} else {
+		metrics.WebhookProcessed(request.Status, "processed")
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
 func webloggerHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
+	if !sourceIpAllowed(ctx, r, "weblogger") {
+		// answer as usual, the rejection is logged and counted
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 	if secretFromVarsOk(ctx, w, r) {
 		// ignore webhooks that don't know the secret to keep out potential log spammers
 		bodyBytes, err := io.ReadAll(r.Body)
@@ -98,8 +116,35 @@ func parseBodyToWebhookDtoTolerant(ctx context.Context, w http.ResponseWriter, r
 }
 
 func secretFromVarsOk(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
-	secretReceived := chi.URLParam(r, "secret")
-	return secretReceived == config.WebhookSecret()
+	return secretMatches(chi.URLParam(r, "secret"))
+}
+
+// secretMatches compares in constant time, so the secret cannot be guessed from response times.
+func secretMatches(secretReceived string) bool {
+	return subtle.ConstantTimeCompare([]byte(secretReceived), []byte(config.WebhookSecret())) == 1
+}
+
+// sourceIpAllowed checks the client ip against the configured allowlist, if any,
+// and counts, logs and records rejected calls.
+func sourceIpAllowed(ctx context.Context, r *http.Request, endpoint string) bool {
+	allowed := config.WebhookAllowedIps()
+	if len(allowed) == 0 {
+		return true
+	}
+
+	ip := clientip.Get(r)
+	if clientip.Allowed(ip, allowed) {
+		return true
+	}
+
+	secretValid := secretMatches(chi.URLParam(r, "secret"))
+	if secretValid {
+		metrics.WebhookRejected(endpoint, "valid")
+	} else {
+		metrics.WebhookRejected(endpoint, "invalid")
+	}
+	paymentLinkService.LogRejectedWebhook(ctx, endpoint, ip, secretValid)
+	return false
 }
 
 func webhookRequestParseErrorHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
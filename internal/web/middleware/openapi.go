@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	aulogging "github.com/StephanHCB/go-autumn-logging"
+	openapispec "github.com/eurofurence/reg-paygate-adapter/api/openapi-spec"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+// in log mode, larger response bodies are passed on without checking them, larger json request bodies are rejected
+const maxValidatedBodySize = 1024 * 1024
+
+// OpenApiValidator checks requests and responses against the embedded OpenAPI spec, so the spec cannot
+// drift from the code unnoticed.
+//
+// A response is in violation if it does not match the spec, if it belongs to a route below /api/rest that
+// the spec does not document, or if the request does not match the spec but was not rejected by the handler.
+// Requests the spec allows, but the handler rejects, are fine, because the handlers check more than the spec.
+//
+// In log mode, violations are logged. In strict mode, meant for the tests, responses are held back until
+// they have been checked, and replaced by an error response if they are in violation. Event streams
+// are never held back or checked, and only json request bodies are checked.
+func OpenApiValidator() (func(http.Handler) http.Handler, error) {
+	mode := config.ServerOpenApiValidation()
+	if mode == config.OpenApiValidationOff {
+		return func(next http.Handler) http.Handler { return next }, nil
+	}
+
+	routes, err := openApiRoutes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load embedded openapi spec: %w", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			// the handler consumes the body, but the request is only checked once the route is known
+			var requestBody []byte
+			checkRequestBody := hasJsonBody(r)
+			if checkRequestBody {
+				var err error
+				requestBody, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidatedBodySize))
+				_ = r.Body.Close()
+				if err != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						ctlutil.ErrorHandler(ctx, w, r, "request.body.too.large", http.StatusRequestEntityTooLarge, url.Values{})
+						return
+					}
+					aulogging.Logger.Ctx(ctx).Info().Printf("failed to read request body: %s", err.Error())
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(requestBody))
+			}
+
+			rec := &validationRecorder{
+				ResponseWriter: w,
+				buffer:         mode == config.OpenApiValidationStrict,
+			}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			violations := checkAgainstSpec(ctx, routes, r, checkRequestBody, requestBody, rec)
+			for _, violation := range violations {
+				aulogging.Logger.Ctx(ctx).Warn().Printf("openapi spec violation for %s %s with status %d: %s", r.Method, r.URL.Path, rec.status, violation)
+			}
+
+			if rec.buffer && !rec.passthrough {
+				if len(violations) > 0 {
+					ctlutil.ErrorHandler(ctx, w, r, "openapi.violation", http.StatusInternalServerError, url.Values{"details": violations})
+					return
+				}
+				w.WriteHeader(rec.status)
+				_, _ = w.Write(rec.body.Bytes())
+			}
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
+
+// hasJsonBody is true if the request has a json body. Other bodies, like file uploads, are neither buffered nor checked.
+func hasJsonBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(headers.ContentType))
+	if err != nil {
+		return false
+	}
+	return mediaType == media.ContentTypeApplicationJson || strings.HasSuffix(mediaType, "+json")
+}
+
+func checkAgainstSpec(ctx context.Context, routes map[string]*routers.Route, r *http.Request, checkRequestBody bool, requestBody []byte, rec *validationRecorder) []string {
+	violations := make([]string, 0)
+
+	// the route pattern is only known once routing is complete
+	routeCtx := chi.RouteContext(ctx)
+	if routeCtx == nil {
+		return violations
+	}
+	pattern := routeCtx.RoutePattern()
+	route, ok := routes[r.Method+" "+pattern]
+	if !ok {
+		if strings.HasPrefix(pattern, "/api/rest/") && rec.status != http.StatusMethodNotAllowed {
+			violations = append(violations, "route is not documented")
+		}
+		return violations
+	}
+
+	pathParams := make(map[string]string)
+	for i, key := range routeCtx.URLParams.Keys {
+		pathParams[key] = routeCtx.URLParams.Values[i]
+	}
+	request := r.Clone(ctx)
+	request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	input := &openapi3filter.RequestValidationInput{
+		Request:    request,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+			ExcludeRequestBody:  !checkRequestBody,
+			SkipSettingDefaults: true,
+			MultiError:          true,
+		},
+	}
+	if err := openapi3filter.ValidateRequest(ctx, input); err != nil && rec.status < 400 {
+		violations = append(violations, "request was accepted, but violates the spec: "+err.Error())
+	}
+
+	if rec.passthrough {
+		return violations
+	}
+	// only json bodies are checked, the spec does not give schemas for the other content types
+	contentType := rec.Header().Get(headers.ContentType)
+	if contentType == "" && rec.body.Len() > 0 {
+		// what net/http will send
+		contentType = http.DetectContentType(rec.body.Bytes())
+	}
+	excludeBody := rec.truncated || !strings.HasPrefix(contentType, media.ContentTypeApplicationJson)
+	isRedirect := rec.status >= 300 && rec.status < 400
+	if excludeBody && !isRedirect && rec.body.Len() > 0 && !contentTypeDocumented(route, rec.status, contentType) {
+		violations = append(violations, fmt.Sprintf("response content type %s is not documented", contentType))
+	}
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 rec.status,
+		Header:                 rec.Header(),
+		Options: &openapi3filter.Options{
+			ExcludeResponseBody:   excludeBody,
+			IncludeResponseStatus: true,
+			MultiError:            true,
+		},
+	}
+	responseInput.SetBodyBytes(rec.body.Bytes())
+	if err := openapi3filter.ValidateResponse(ctx, responseInput); err != nil {
+		violations = append(violations, "response violates the spec: "+err.Error())
+	}
+	return violations
+}
+
+func contentTypeDocumented(route *routers.Route, status int, contentType string) bool {
+	response := route.Operation.Responses.Status(status)
+	if response == nil {
+		response = route.Operation.Responses.Default()
+	}
+	if response == nil || response.Value == nil {
+		// undocumented status codes are reported by the response validation
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return response.Value.Content.Get(mediaType) != nil
+}
+
+// openApiRoutes lists the operations in the spec by method and chi route pattern, e.g. GET /api/rest/v1/paylinks/{refid}.
+func openApiRoutes() (map[string]*routers.Route, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openapispec.Yaml)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+
+	routes := make(map[string]*routers.Route)
+	for path, item := range doc.Paths.Map() {
+		servers := doc.Servers
+		if len(item.Servers) > 0 {
+			servers = item.Servers
+		}
+		prefix := ""
+		if len(servers) > 0 {
+			prefix = strings.TrimSuffix(servers[0].URL, "/")
+		}
+		for method, operation := range item.Operations() {
+			routes[method+" "+prefix+path] = &routers.Route{
+				Spec:      doc,
+				Path:      path,
+				PathItem:  item,
+				Method:    method,
+				Operation: operation,
+			}
+		}
+	}
+	return routes, nil
+}
+
+// validationRecorder records the response for checking it, and holds it back if buffer is set.
+type validationRecorder struct {
+	http.ResponseWriter
+	buffer      bool // hold back the response until it has been checked
+	passthrough bool // event streams are neither held back nor checked
+	status      int
+	body        bytes.Buffer
+	truncated   bool
+}
+
+func (rec *validationRecorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = status
+	if strings.HasPrefix(rec.Header().Get(headers.ContentType), media.ContentTypeTextEventStream) {
+		rec.passthrough = true
+	}
+	if !rec.buffer || rec.passthrough {
+		rec.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (rec *validationRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.passthrough {
+		return rec.ResponseWriter.Write(b)
+	}
+	if rec.buffer {
+		return rec.body.Write(b)
+	}
+	if !rec.truncated && rec.body.Len()+len(b) <= maxValidatedBodySize {
+		rec.body.Write(b)
+	} else {
+		rec.truncated = true
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *validationRecorder) Flush() {
+	if rec.buffer && !rec.passthrough {
+		return
+	}
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (rec *validationRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
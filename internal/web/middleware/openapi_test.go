@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func tstSpecViolations(t *testing.T, method string, pattern string, target string, status int, contentType string, body string) []string {
+	routes, err := openApiRoutes()
+	require.Nil(t, err)
+
+	var violations []string
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &validationRecorder{ResponseWriter: w, buffer: true}
+			next.ServeHTTP(rec, r)
+			violations = checkAgainstSpec(r.Context(), routes, r, false, nil, rec)
+		})
+	})
+	router.MethodFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headers.ContentType, contentType)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, target, nil))
+	return violations
+}
+
+func TestOpenApiRoutes(t *testing.T) {
+	docs.Description("the embedded spec is valid, and its operations are listed by method and full route pattern")
+	routes, err := openApiRoutes()
+	require.Nil(t, err)
+	require.Contains(t, routes, "GET /api/rest/v1/paylinks/{refid}")
+	require.Contains(t, routes, "POST /api/rest/v1/webhook/{secret}")
+	require.Contains(t, routes, "GET /info/health")
+	require.NotContains(t, routes, "GET /api/rest/v1/paylinks/{refid}/refund")
+	require.Contains(t, routes, "POST /api/rest/v1/paylinks/{refid}/refund")
+}
+
+func TestCheckAgainstSpec_Documented(t *testing.T) {
+	docs.Description("a documented response causes no violations")
+	violations := tstSpecViolations(t, http.MethodGet, "/api/rest/v1/paylinks/{refid}", "/api/rest/v1/paylinks/EF1995-000001",
+		http.StatusNotFound, media.ContentTypeApplicationJson,
+		`{"timestamp":"2022-12-16T12:22:18Z","requestid":"a8b7c6d5","message":"paylink.id.notfound"}`)
+	require.Empty(t, violations)
+}
+
+func TestCheckAgainstSpec_UndocumentedRoute(t *testing.T) {
+	docs.Description("routes below /api/rest must be documented")
+	violations := tstSpecViolations(t, http.MethodPost, "/api/rest/v1/paylinks/{refid}/archive", "/api/rest/v1/paylinks/EF1995-000001/archive",
+		http.StatusNoContent, media.ContentTypeApplicationJson, "")
+	require.Equal(t, []string{"route is not documented"}, violations)
+}
+
+func TestCheckAgainstSpec_UndocumentedErrorKey(t *testing.T) {
+	docs.Description("error message keys must be listed in the spec")
+	violations := tstSpecViolations(t, http.MethodGet, "/api/rest/v1/paylinks/{refid}", "/api/rest/v1/paylinks/EF1995-000001",
+		http.StatusNotFound, media.ContentTypeApplicationJson,
+		`{"timestamp":"2022-12-16T12:22:18Z","requestid":"a8b7c6d5","message":"paylink.refid.notfound"}`)
+	require.Len(t, violations, 1)
+	require.Contains(t, violations[0], "response violates the spec")
+}
+
+func TestCheckAgainstSpec_UndocumentedContentType(t *testing.T) {
+	docs.Description("responses must use a documented content type")
+	violations := tstSpecViolations(t, http.MethodGet, "/api/rest/v1/paylinks/{refid}", "/api/rest/v1/paylinks/EF1995-000001",
+		http.StatusNotFound, "", `{"message":"paylink.id.notfound"}`)
+	require.Equal(t, []string{"response content type text/plain; charset=utf-8 is not documented"}, violations)
+}
+
+func TestHasJsonBody(t *testing.T) {
+	docs.Description("only json request bodies are buffered and checked against the spec")
+	tstRequest := func(contentType string, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/rest/v1/paylinks", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set(headers.ContentType, contentType)
+		}
+		return r
+	}
+	require.True(t, hasJsonBody(tstRequest("application/json", "{}")))
+	require.True(t, hasJsonBody(tstRequest("application/json; charset=utf-8", "{}")))
+	require.True(t, hasJsonBody(tstRequest("application/merge-patch+json", "{}")))
+	require.False(t, hasJsonBody(tstRequest("text/csv", "a;b")))
+	require.False(t, hasJsonBody(tstRequest("multipart/form-data; boundary=xyz", "--xyz")))
+	require.False(t, hasJsonBody(tstRequest("", "{}")))
+	require.False(t, hasJsonBody(tstRequest("application/json", "")))
+}
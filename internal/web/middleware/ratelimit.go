@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/metrics"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/clientip"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	rateLimitGroupWebhook   = "webhook"
+	rateLimitGroupSimulator = "simulator"
+	rateLimitGroupApi       = "api"
+	rateLimitGroupReturn    = "return"
+)
+
+const returnPathPrefix = "/api/rest/v1/return/"
+
+// rateLimitMaxBuckets is how many clients are tracked at most. Beyond that, the least recently seen client
+// is forgotten, so a flood of different ips cannot use up our memory.
+const rateLimitMaxBuckets = 10000
+
+// rateLimitGroup returns the route group a request belongs to, or "" if it is not limited.
+func rateLimitGroup(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/rest/v1/webhook/"), strings.HasPrefix(path, "/api/rest/v1/weblogger/"):
+		return rateLimitGroupWebhook
+	case strings.HasPrefix(path, "/simulator/"):
+		return rateLimitGroupSimulator
+	case strings.HasPrefix(path, "/api/rest/"):
+		return rateLimitGroupApi
+	default:
+		return ""
+	}
+}
+
+// returnRefId returns the reference id a request to the return endpoint is for, or "" for all other requests.
+func returnRefId(path string) string {
+	refId, ok := strings.CutPrefix(path, returnPathPrefix)
+	if !ok || strings.Contains(refId, "/") {
+		return ""
+	}
+	return refId
+}
+
+type tokenBucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimiter struct {
+	limits     map[string]config.RateLimitConfig
+	now        func() time.Time
+	maxBuckets int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// least recently used bucket at the back
+	recent *list.List
+}
+
+func newRateLimiter(limits map[string]config.RateLimitConfig, now func() time.Time, maxBuckets int) *rateLimiter {
+	return &rateLimiter{
+		limits:     limits,
+		now:        now,
+		maxBuckets: maxBuckets,
+		buckets:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+// take removes a token from the bucket for key, and returns how long to wait if there was none.
+func (l *rateLimiter) take(group string, key string, limit config.RateLimitConfig) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	perSecond := float64(limit.RequestsPerMinute) / 60
+	burst := float64(limit.Burst)
+
+	bucket := l.bucket(group+" "+key, burst, now)
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+}
+
+// bucket returns the bucket for key, marked as most recently used. A new bucket starts full, and takes the
+// place of the least recently used one if there are too many.
+func (l *rateLimiter) bucket(key string, burst float64, now time.Time) *tokenBucket {
+	if element, ok := l.buckets[key]; ok {
+		l.recent.MoveToFront(element)
+		return element.Value.(*tokenBucket)
+	}
+
+	if l.recent.Len() >= l.maxBuckets {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*tokenBucket).key)
+	}
+	bucket := &tokenBucket{key: key, tokens: burst, updated: now}
+	l.buckets[key] = l.recent.PushFront(bucket)
+	return bucket
+}
+
+// RateLimiter limits the request rate per route group with a token bucket for each client.
+//
+// Requests with a valid api key are limited per client name, so clients behind the same ip
+// do not affect each other. All other requests are limited per client ip. Returns from the hosted
+// payment page are also limited per reference id, because each one may cause a status check at Paygate.
+func RateLimiter() func(http.Handler) http.Handler {
+	configured := config.RateLimits()
+	limiter := newRateLimiter(map[string]config.RateLimitConfig{
+		rateLimitGroupWebhook:   configured.Webhook,
+		rateLimitGroupSimulator: configured.Simulator,
+		rateLimitGroupApi:       configured.Api,
+		rateLimitGroupReturn:    configured.Return,
+	}, time.Now, rateLimitMaxBuckets)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			group := rateLimitGroup(r.URL.Path)
+			limit, ok := limiter.limits[group]
+			if !ok || limit.Disable {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limitedBy, key := "ip", clientip.Get(r)
+			if client, ok := config.ApiClientForKey(fromApiTokenHeader(r)); ok {
+				limitedBy, key = "client", "client "+client
+			}
+
+			wait := limiter.take(group, key, limit)
+			if refId := returnRefId(r.URL.Path); wait == 0 && refId != "" {
+				if returnLimit := limiter.limits[rateLimitGroupReturn]; !returnLimit.Disable {
+					limitedBy = "refid"
+					wait = limiter.take(rateLimitGroupReturn, refId, returnLimit)
+				}
+			}
+			if wait > 0 {
+				metrics.RequestRateLimited(group, limitedBy)
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				w.Header().Set(headers.RetryAfter, strconv.Itoa(retryAfter))
+				ctlutil.ErrorHandler(ctx, w, r, "request.rate.limited", http.StatusTooManyRequests, nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
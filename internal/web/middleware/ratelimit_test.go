@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	docs.Description("a client may send a burst of requests, after which the bucket refills at the configured rate")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limit := config.RateLimitConfig{RequestsPerMinute: 30, Burst: 3}
+	cut := newRateLimiter(map[string]config.RateLimitConfig{"api": limit}, func() time.Time { return now }, 10)
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.1", limit))
+	}
+	require.Equal(t, 2*time.Second, cut.take("api", "10.0.0.1", limit))
+
+	docs.Description("other clients and groups have their own buckets")
+	require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.2", limit))
+	require.Equal(t, time.Duration(0), cut.take("webhook", "10.0.0.1", limit))
+
+	now = now.Add(500 * time.Millisecond)
+	require.Equal(t, 1500*time.Millisecond, cut.take("api", "10.0.0.1", limit))
+
+	now = now.Add(1500 * time.Millisecond)
+	require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.1", limit))
+	require.Equal(t, 2*time.Second, cut.take("api", "10.0.0.1", limit))
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	docs.Description("beyond the maximum number of clients, the least recently seen client is forgotten")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limit := config.RateLimitConfig{RequestsPerMinute: 30, Burst: 1}
+	cut := newRateLimiter(map[string]config.RateLimitConfig{"api": limit}, func() time.Time { return now }, 2)
+
+	require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.1", limit))
+	require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.2", limit))
+	require.Equal(t, 2*time.Second, cut.take("api", "10.0.0.1", limit))
+
+	require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.3", limit))
+	require.Len(t, cut.buckets, 2)
+	require.Equal(t, 2*time.Second, cut.take("api", "10.0.0.1", limit), "recently seen client must be kept")
+	require.Equal(t, time.Duration(0), cut.take("api", "10.0.0.2", limit), "least recently seen client must be forgotten")
+	require.Len(t, cut.buckets, 2)
+}
+
+func TestRateLimitGroup(t *testing.T) {
+	docs.Description("requests are assigned to route groups by path")
+	require.Equal(t, "webhook", rateLimitGroup("/api/rest/v1/webhook/secret"))
+	require.Equal(t, "webhook", rateLimitGroup("/api/rest/v1/weblogger/secret"))
+	require.Equal(t, "simulator", rateLimitGroup("/simulator/42"))
+	require.Equal(t, "api", rateLimitGroup("/api/rest/v1/paylinks"))
+	require.Equal(t, "", rateLimitGroup("/info/health"))
+	require.Equal(t, "", rateLimitGroup("/metrics"))
+}
+
+func TestReturnRefId(t *testing.T) {
+	docs.Description("returns from the hosted payment page are also limited per reference id")
+	require.Equal(t, "EF1995-000001-221216-122218-4132", returnRefId("/api/rest/v1/return/EF1995-000001-221216-122218-4132"))
+	require.Equal(t, "", returnRefId("/api/rest/v1/return/EF1995-000001/other"))
+	require.Equal(t, "", returnRefId("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132"))
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
+	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
@@ -21,7 +22,11 @@ func RequestLogger(next http.Handler) http.Handler {
 
 		defer func() {
 			elapsed := time.Since(start)
-			aulogging.Logger.Ctx(ctx).Info().Printf("request %s %s -> %d (%d ms)", method, path, ww.Status(), elapsed.Nanoseconds()/1000000)
+			if client := ctxvalues.ApiClient(ctx); client != "" {
+				aulogging.Logger.Ctx(ctx).Info().Printf("request %s %s -> %d (%d ms) client %s", method, path, ww.Status(), elapsed.Nanoseconds()/1000000, client)
+			} else {
+				aulogging.Logger.Ctx(ctx).Info().Printf("request %s %s -> %d (%d ms)", method, path, ww.Status(), elapsed.Nanoseconds()/1000000)
+			}
 		}()
 
 		next.ServeHTTP(ww, r)
@@ -1,12 +1,19 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/idp"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctlutil"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/ctxvalues"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 // --- getting the values from the request ---
@@ -15,6 +22,93 @@ func fromApiTokenHeader(r *http.Request) string {
 	return r.Header.Get(media.HeaderXApiKey)
 }
 
+func fromAuthorizationHeader(r *http.Request) string {
+	scheme, value, found := strings.Cut(r.Header.Get(media.HeaderAuthorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(value)
+}
+
+// --- validating bearer tokens ---
+
+var allowedSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// tokenClockSkew is how far our clock may be off from that of the identity provider.
+const tokenClockSkew = 30 * time.Second
+
+type bearerClaims struct {
+	Subject string
+	Email   string
+	Name    string
+	Roles   []string
+}
+
+func parseBearerToken(ctx context.Context, tokenValue string) (bearerClaims, error) {
+	identityProvider := idp.Get()
+	if identityProvider == nil {
+		return bearerClaims{}, errors.New("bearer tokens are not configured")
+	}
+
+	keyFunc := func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		keys, err := identityProvider.VerificationKeys(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		keySet := jwt.VerificationKeySet{}
+		for _, key := range keys {
+			keySet.Keys = append(keySet.Keys, key)
+		}
+		return keySet, nil
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(allowedSigningMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenClockSkew),
+	}
+	if config.OidcIssuer() != "" {
+		options = append(options, jwt.WithIssuer(config.OidcIssuer()))
+	}
+	if config.OidcAudience() != "" {
+		options = append(options, jwt.WithAudience(config.OidcAudience()))
+	}
+
+	mapClaims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(tokenValue, mapClaims, keyFunc, options...); err != nil {
+		return bearerClaims{}, err
+	}
+
+	claims := bearerClaims{}
+	claims.Subject, _ = mapClaims["sub"].(string)
+	if claims.Subject == "" {
+		return bearerClaims{}, errors.New("token has no subject")
+	}
+	claims.Email, _ = mapClaims["email"].(string)
+	claims.Name, _ = mapClaims["name"].(string)
+	claims.Roles = stringsFromClaim(mapClaims[config.OidcRolesClaim()])
+	return claims, nil
+}
+
+// stringsFromClaim accepts both a list of strings and a single string of space separated values.
+func stringsFromClaim(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		result := make([]string, 0, len(value))
+		for _, entry := range value {
+			if s, ok := entry.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
 // --- middleware validating the values and adding to context values ---
 
 func TokenValidator(next http.Handler) http.Handler {
@@ -23,8 +117,9 @@ func TokenValidator(next http.Handler) http.Handler {
 
 		apiTokenValue := fromApiTokenHeader(r)
 		if apiTokenValue != "" {
-			if apiTokenValue == config.FixedApiToken() {
+			if client, ok := config.ApiClientForKey(apiTokenValue); ok {
 				ctxvalues.SetApiToken(ctx, apiTokenValue)
+				ctxvalues.SetApiClient(ctx, client)
 				next.ServeHTTP(w, r)
 			} else {
 				ctlutil.UnauthenticatedError(ctx, w, r, "invalid api token", "request supplied invalid api token, denying")
@@ -32,7 +127,25 @@ func TokenValidator(next http.Handler) http.Handler {
 			return
 		}
 
-		// not supplying an api token is a valid use case, there are endpoints that allow anonymous access
+		bearerTokenValue := fromAuthorizationHeader(r)
+		if bearerTokenValue != "" {
+			claims, err := parseBearerToken(ctx, bearerTokenValue)
+			if err != nil {
+				ctlutil.UnauthenticatedError(ctx, w, r, "invalid bearer token", "request supplied invalid bearer token, denying: "+err.Error())
+				return
+			}
+
+			ctxvalues.SetBearerToken(ctx, bearerTokenValue)
+			ctxvalues.SetSubject(ctx, claims.Subject)
+			ctxvalues.SetEmail(ctx, claims.Email)
+			ctxvalues.SetName(ctx, claims.Name)
+			ctxvalues.SetRoles(ctx, claims.Roles)
+			aulogging.Logger.Ctx(ctx).Info().Printf("request authenticated by bearer token for subject %s with roles %v", claims.Subject, claims.Roles)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// not supplying a token is a valid use case, there are endpoints that allow anonymous access
 		next.ServeHTTP(w, r)
 		return
 	}
@@ -0,0 +1,71 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/go-http-utils/headers"
+)
+
+// Get returns the ip address of the client that made the request.
+//
+// X-Forwarded-For is only believed if the request came from one of the configured trusted proxies.
+// It is read from the right, skipping further trusted proxies, because any entry further left
+// may have been made up by the client.
+func Get(r *http.Request) string {
+	return fromRequest(r, config.TrustedProxies())
+}
+
+// Allowed is true if ip lies within one of the ranges.
+func Allowed(ip string, ranges []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return contains(ranges, addr)
+}
+
+func fromRequest(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	client, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	client = client.Unmap()
+
+	hops := make([]string, 0)
+	for _, value := range r.Header.Values(headers.XForwardedFor) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	slices.Reverse(hops)
+
+	for _, hop := range hops {
+		if !contains(trustedProxies, client) {
+			break
+		}
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+	}
+	return client.String()
+}
+
+func contains(ranges []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range ranges {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package clientip
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func tstRequest(remoteAddr string, forwardedFor ...string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "http://localhost/api/rest/v1/webhook/secret", nil)
+	r.RemoteAddr = remoteAddr
+	for _, value := range forwardedFor {
+		r.Header.Add(headers.XForwardedFor, value)
+	}
+	return r
+}
+
+var tstTrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+func TestFromRequest_NoProxy(t *testing.T) {
+	docs.Description("without a trusted proxy, the remote address is the client, no matter what the headers say")
+	require.Equal(t, "192.0.2.10", fromRequest(tstRequest("192.0.2.10:4711", "198.51.100.1"), tstTrustedProxies))
+	require.Equal(t, "192.0.2.10", fromRequest(tstRequest("192.0.2.10:4711"), nil))
+}
+
+func TestFromRequest_TrustedProxies(t *testing.T) {
+	docs.Description("trusted proxies are skipped from the right, the first other entry is the client")
+	require.Equal(t, "198.51.100.1", fromRequest(tstRequest("10.0.0.1:4711", "198.51.100.1"), tstTrustedProxies))
+	require.Equal(t, "198.51.100.1", fromRequest(tstRequest("10.0.0.1:4711", "203.0.113.66, 198.51.100.1, 10.1.2.3"), tstTrustedProxies))
+	require.Equal(t, "198.51.100.1", fromRequest(tstRequest("[::1]:4711", "203.0.113.66", "198.51.100.1"), tstTrustedProxies))
+
+	docs.Description("garbage in the header ends the search at the last trusted hop")
+	require.Equal(t, "10.1.2.3", fromRequest(tstRequest("10.0.0.1:4711", "198.51.100.1, unknown, 10.1.2.3"), tstTrustedProxies))
+
+	docs.Description("without the header, the proxy itself is the client")
+	require.Equal(t, "10.0.0.1", fromRequest(tstRequest("10.0.0.1:4711"), tstTrustedProxies))
+}
+
+func TestAllowed(t *testing.T) {
+	docs.Description("addresses are checked against the ranges, ipv4 addresses also in their ipv6 mapped form")
+	ranges := []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+	require.True(t, Allowed("192.0.2.10", ranges))
+	require.True(t, Allowed("::ffff:192.0.2.10", ranges))
+	require.False(t, Allowed("192.0.3.10", ranges))
+	require.False(t, Allowed("not an ip", ranges))
+}
@@ -0,0 +1,38 @@
+package ctlutil
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// --- query parameters ---
+
+// note, these add a message to errs and return the default if the parameter is invalid
+
+func TimeParam(errs url.Values, params url.Values, key string) time.Time {
+	value := params.Get(key)
+	if value == "" {
+		return time.Time{}
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		errs.Add(key, "must be a date and time in RFC3339 format, e.g. 2025-08-01T00:00:00Z")
+		return time.Time{}
+	}
+	return parsed
+}
+
+func IntParam(errs url.Values, params url.Values, key string, defaultValue int, min int, max int) int {
+	value := params.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min || parsed > max {
+		errs.Add(key, fmt.Sprintf("must be an integer at least %d and at most %d", min, max))
+		return defaultValue
+	}
+	return parsed
+}
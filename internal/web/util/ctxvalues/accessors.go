@@ -2,6 +2,8 @@ package ctxvalues
 
 import (
 	"context"
+	"slices"
+	"strings"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 )
@@ -15,6 +17,7 @@ const ContextAuthorizedAs = "authorizedas"
 const ContextEmail = "email"
 const ContextName = "name"
 const ContextSubject = "subject"
+const ContextRoles = "roles"
 
 func CreateContextWithValueMap(ctx context.Context) context.Context {
 	// this is so we can add values to our context, like ... I don't know ... the http status from the response!
@@ -54,11 +57,92 @@ func SetRequestId(ctx context.Context, requestId string) {
 	setValue(ctx, ContextRequestId, requestId)
 }
 
+// HasApiToken is true if the request supplied a valid api key, no matter which client it belongs to.
 func HasApiToken(ctx context.Context) bool {
-	v := valueOrDefault(ctx, ContextApiToken, "")
-	return v == config.FixedApiToken()
+	return ApiClient(ctx) != ""
 }
 
 func SetApiToken(ctx context.Context, apiToken string) {
 	setValue(ctx, ContextApiToken, apiToken)
 }
+
+// ApiClient is the name of the client whose api key the request supplied, empty if it did not supply one.
+func ApiClient(ctx context.Context) string {
+	return valueOrDefault(ctx, ContextAuthorizedAs, "")
+}
+
+func SetApiClient(ctx context.Context, name string) {
+	setValue(ctx, ContextAuthorizedAs, name)
+}
+
+// --- bearer token and its claims ---
+
+func HasBearerToken(ctx context.Context) bool {
+	return valueOrDefault(ctx, ContextBearerToken, "") != ""
+}
+
+func SetBearerToken(ctx context.Context, bearerToken string) {
+	setValue(ctx, ContextBearerToken, bearerToken)
+}
+
+// Subject is the subject of a valid bearer token, empty if the request did not supply one.
+func Subject(ctx context.Context) string {
+	return valueOrDefault(ctx, ContextSubject, "")
+}
+
+func SetSubject(ctx context.Context, subject string) {
+	setValue(ctx, ContextSubject, subject)
+}
+
+func Email(ctx context.Context) string {
+	return valueOrDefault(ctx, ContextEmail, "")
+}
+
+func SetEmail(ctx context.Context, email string) {
+	setValue(ctx, ContextEmail, email)
+}
+
+func Name(ctx context.Context) string {
+	return valueOrDefault(ctx, ContextName, "")
+}
+
+func SetName(ctx context.Context, name string) {
+	setValue(ctx, ContextName, name)
+}
+
+func Roles(ctx context.Context) []string {
+	v := valueOrDefault(ctx, ContextRoles, "")
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, "\n")
+}
+
+func SetRoles(ctx context.Context, roles []string) {
+	setValue(ctx, ContextRoles, strings.Join(roles, "\n"))
+}
+
+// --- authorization ---
+
+// IsAuthenticated is true if the request supplied a valid api token or bearer token.
+func IsAuthenticated(ctx context.Context) bool {
+	return HasApiToken(ctx) || HasBearerToken(ctx)
+}
+
+// HasPermission is true if the request may perform operations that require the given permission.
+//
+// An api key grants the scopes configured for its client, a bearer token the permissions of the roles it lists.
+func HasPermission(ctx context.Context, permission config.Permission) bool {
+	if HasApiToken(ctx) {
+		return slices.Contains(config.ApiClientScopes(ApiClient(ctx)), permission)
+	}
+	if !HasBearerToken(ctx) {
+		return false
+	}
+	for _, role := range Roles(ctx) {
+		if slices.Contains(config.OidcRolePermissions(role), permission) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,5 +2,9 @@ package media
 
 const ContentTypeApplicationJson = "application/json"
 const ContentTypeTextPlain = "text/plain; charset=utf-8"
+const ContentTypeTextEventStream = "text/event-stream"
+const ContentTypeTextHtml = "text/html; charset=utf-8"
+const ContentTypeApplicationYaml = "application/yaml"
 
 const HeaderXApiKey = "X-Api-Key"
+const HeaderAuthorization = "Authorization"
@@ -0,0 +1,113 @@
+package acceptance
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/stretchr/testify/require"
+)
+
+// --- cancel ---
+
+func TestCancel_AdminSuccess(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment that was authorized, but not captured at paygate")
+	id := "EF1995-000001-230001-122218-6666" // set up in paygate mock as AUTHORIZED 390.00 EUR
+
+	docs.When("when an admin cancels it")
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/cancel", "", tstValidAdminToken())
+
+	docs.Then("then the request is successful")
+	require.Equal(t, http.StatusNoContent, response.status, "unexpected http response status")
+
+	docs.Then("and the authorization has been reversed at paygate")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+		"CancelPayment 4243",
+	)
+
+	docs.Then("and the expected protocol entries have been written, recording the subject of the admin")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       "4243",
+		Kind:        "success",
+		Message:     "get-payment",
+		Details:     "",
+		Subject:     tstAdminSubject,
+	}, entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          "4243",
+		Kind:           "success",
+		Message:        "cancel",
+		Details:        "amount=39000 currency=EUR",
+		Subject:        tstAdminSubject,
+		Amount:         tstAmount(39000),
+		Currency:       "EUR",
+		UpstreamStatus: "CANCELLED",
+	})
+}
+
+func TestCancel_PartiallyCaptured(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an authorized payment of which a part was captured")
+	id := "EF1995-000001-230001-122218-6666"
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/capture", `{"amount":10000}`, tstValidAdminToken())
+	require.Equal(t, http.StatusNoContent, response.status, "unexpected http response status")
+
+	docs.When("when an admin attempts to cancel it")
+	response = tstPerformPost("/api/rest/v1/paylinks/"+id+"/cancel", "", tstValidAdminToken())
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusConflict, "payment.update.conflict", map[string][]string{
+		"details": {"cancel not possible: 10000 has already been captured, refund it instead"},
+	})
+
+	docs.Then("and no reversal has been attempted")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+		"CapturePayment 4243 10000 EUR",
+		"QueryPaymentLink "+id,
+	)
+}
+
+func TestCancel_NexiNotFound(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an admin")
+	token := tstValidAdminToken()
+
+	docs.When("when they attempt to cancel a payment that does not exist in paygate")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000017-000000-000000-0000/cancel", "", token)
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusNotFound, "payment.refid.notfound", nil)
+
+	docs.Then("and no reversal has been attempted")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink EF1995-000017-000000-000000-0000",
+	)
+}
+
+func TestCancel_ClientMissingScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client who supplies its own api key, which does not allow cancelling payments")
+	token := tstValidRegsysApiToken()
+
+	docs.When("when they attempt to cancel an authorized payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-230001-122218-6666/cancel", "", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
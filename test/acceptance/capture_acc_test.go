@@ -0,0 +1,132 @@
+package acceptance
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/stretchr/testify/require"
+)
+
+// --- capture ---
+
+func TestCapture_AdminSuccess(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment that was authorized, but not captured at paygate")
+	id := "EF1995-000001-230001-122218-6666" // set up in paygate mock as AUTHORIZED 390.00 EUR
+
+	docs.When("when an admin captures it without giving an amount")
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/capture", "", tstValidAdminToken())
+
+	docs.Then("then the request is successful")
+	require.Equal(t, http.StatusNoContent, response.status, "unexpected http response status")
+
+	docs.Then("and everything that was authorized has been captured at paygate")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+		"CapturePayment 4243 39000 EUR",
+	)
+
+	docs.Then("and the expected protocol entries have been written, recording the subject of the admin")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       "4243",
+		Kind:        "success",
+		Message:     "get-payment",
+		Details:     "",
+		Subject:     tstAdminSubject,
+	}, entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          "4243",
+		Kind:           "success",
+		Message:        "capture",
+		Details:        "amount=39000 currency=EUR",
+		Subject:        tstAdminSubject,
+		Amount:         tstAmount(39000),
+		Currency:       "EUR",
+		UpstreamStatus: "OK",
+	})
+}
+
+func TestCapture_PartialThenTooMuch(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an authorized payment of which a part was captured")
+	id := "EF1995-000001-230001-122218-6666"
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/capture", `{"amount":10000}`, tstValidAdminToken())
+	require.Equal(t, http.StatusNoContent, response.status, "unexpected http response status")
+
+	docs.When("when an admin attempts to capture more than is left")
+	response = tstPerformPost("/api/rest/v1/paylinks/"+id+"/capture", `{"amount":29001}`, tstValidAdminToken())
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusConflict, "payment.update.conflict", map[string][]string{
+		"details": {"capture not possible: at most 29000 can be captured"},
+	})
+
+	docs.Then("and only the partial capture has been made at paygate")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+		"CapturePayment 4243 10000 EUR",
+		"QueryPaymentLink "+id,
+	)
+}
+
+func TestCapture_NotAuthorized(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment that was captured in full at paygate")
+	id := "EF1995-000001-221216-122218-4132" // set up in paygate mock as OK 185.00 EUR, captured in full
+
+	docs.When("when an admin attempts to capture it")
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/capture", "", tstValidAdminToken())
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusConflict, "payment.update.conflict", map[string][]string{
+		"details": {"capture not possible: payment is in status OK, only authorized payments can be captured"},
+	})
+
+	docs.Then("and no capture has been attempted")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+	)
+}
+
+func TestCapture_InvalidAmount(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an admin")
+	token := tstValidAdminToken()
+
+	docs.When("when they attempt to capture a payment with a negative amount")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-230001-122218-6666/capture", `{"amount":-1}`, token)
+
+	docs.Then("then the request fails with the appropriate error message")
+	require.Equal(t, http.StatusBadRequest, response.status, "unexpected http response status")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
+
+func TestCapture_ClientMissingScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client who supplies its own api key, which does not allow captures")
+	token := tstValidRegsysApiToken()
+
+	docs.When("when they attempt to capture an authorized payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-230001-122218-6666/capture", "", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
@@ -0,0 +1,249 @@
+package acceptance
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/stretchr/testify/require"
+)
+
+// --- payment event stream ---
+
+func TestPaymentEvents_Webhook(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a frontend that has opened the event stream for a payment")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "tentative")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken())
+	defer closeStream()
+
+	docs.When("when the webhook for the payment arrives")
+	response := tstPerformPost("/api/rest/v1/webhook/demosecret", tstBuildValidWebhookRequest(t, id, "OK", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("then the new status is pushed to the frontend")
+	tstRequireNextEvent(t, events, nexiapi.PaymentEventDto{
+		ReferenceId:  id,
+		PaymentId:    "ef00000000000000000000000000cafe",
+		Status:       "OK",
+		ResponseCode: "00000000",
+		Source:       "webhook",
+		Timestamp:    "2022-12-16T12:22:18Z",
+	})
+}
+
+func TestPaymentEvents_StatusCheck(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a frontend that has opened the event stream for a payment")
+	id := "EF1995-000001-221216-122218-4132" // set up in paygate mock as OK 185.00 EUR
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "pending")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken())
+	defer closeStream()
+
+	docs.When("when a status check for the payment is processed")
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/status-check", "", tstValidApiToken())
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("then the status from Paygate is pushed to the frontend")
+	tstRequireNextEvent(t, events, nexiapi.PaymentEventDto{
+		ReferenceId:  id,
+		PaymentId:    "42",
+		Status:       "OK",
+		ResponseCode: "00000000",
+		Source:       "status-check",
+		Timestamp:    "2022-12-16T12:22:18Z",
+	})
+}
+
+func TestPaymentEvents_OtherPayment(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a frontend that has opened the event stream for a payment")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/EF1995-000001-230001-122218-5555/events", tstValidRegsysApiToken())
+
+	docs.When("when the webhook for a different payment arrives")
+	id := "EF1995-000001-221216-122218-4132"
+	_ = paymentMock.InjectTransaction(context.TODO(), paymentservice.Transaction{
+		DebitorID: 1,
+		ID:        id,
+		Type:      "payment",
+		Method:    "credit",
+		Amount:    paymentservice.Amount{Currency: "EUR", GrossCent: 18500, VatRate: 19.0},
+		Status:    "tentative",
+	})
+	response := tstPerformPost("/api/rest/v1/webhook/demosecret", tstBuildValidWebhookRequest(t, id, "OK", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("then nothing is pushed to the frontend")
+	closeStream()
+	for event := range events {
+		require.Fail(t, "unexpected event", "%v", event)
+	}
+}
+
+func TestPaymentEvents_LastKnownStatus(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link whose payment status we already know")
+	id := "EF1995-000001-221216-122218-4132"
+	token := tstValidRegsysApiToken()
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+	queried := tstPerformGet("/api/rest/v1/paylinks/"+id, token)
+	require.Equal(t, http.StatusOK, queried.status)
+
+	docs.When("when a frontend opens the event stream for the payment")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", token)
+	defer closeStream()
+
+	docs.Then("then the last known status is sent right away")
+	event := <-events
+	require.NotEmpty(t, event.Timestamp)
+	event.Timestamp = ""
+	require.Equal(t, nexiapi.PaymentEventDto{
+		ReferenceId: id,
+		PaymentId:   "42",
+		Status:      "OK",
+		Source:      "record",
+	}, event)
+}
+
+func TestPaymentEvents_Resume(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a frontend that has received the event for a webhook, then lost the connection")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "tentative")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken())
+	response := tstPerformPost("/api/rest/v1/webhook/demosecret", tstBuildValidWebhookRequest(t, id, "OK", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+	eventId := tstRequireNextEvent(t, events, nexiapi.PaymentEventDto{
+		ReferenceId:  id,
+		PaymentId:    "ef00000000000000000000000000cafe",
+		Status:       "OK",
+		ResponseCode: "00000000",
+		Source:       "webhook",
+		Timestamp:    "2022-12-16T12:22:18Z",
+	})
+	closeStream()
+
+	docs.When("when it reconnects with the id of an earlier event")
+	resumed, closeResumed := tstResumeEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken(), strconv.Itoa(int(eventId-1)))
+	defer closeResumed()
+
+	docs.Then("then the missed event is replayed from the protocol")
+	select {
+	case event := <-resumed:
+		require.NotEmpty(t, event.Timestamp)
+		event.Timestamp = ""
+		require.Equal(t, nexiapi.PaymentEventDto{
+			EventId:     eventId,
+			ReferenceId: id,
+			PaymentId:   "ef00000000000000000000000000cafe",
+			Status:      "OK",
+			Source:      "webhook",
+		}, event)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no event replayed")
+	}
+}
+
+func TestPaymentEvents_ResumeNothingMissed(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a frontend that has received the event for a webhook")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "tentative")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken())
+	response := tstPerformPost("/api/rest/v1/webhook/demosecret", tstBuildValidWebhookRequest(t, id, "OK", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+	eventId := tstRequireNextEvent(t, events, nexiapi.PaymentEventDto{
+		ReferenceId:  id,
+		PaymentId:    "ef00000000000000000000000000cafe",
+		Status:       "OK",
+		ResponseCode: "00000000",
+		Source:       "webhook",
+		Timestamp:    "2022-12-16T12:22:18Z",
+	})
+	closeStream()
+
+	docs.When("when it reconnects with the id of that event")
+	resumed, closeResumed := tstResumeEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken(), strconv.Itoa(int(eventId)))
+	defer closeResumed()
+
+	docs.Then("then neither the event nor the last known status is sent again")
+	select {
+	case event := <-resumed:
+		require.Fail(t, "unexpected event", "%v", event)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestPaymentEvents_NoEventWithoutUpdate(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a frontend that has opened the event stream for a payment whose transaction was deleted")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "deleted")
+	events, closeStream := tstOpenEventStream(t, "/api/rest/v1/paylinks/"+id+"/events", tstValidRegsysApiToken())
+
+	docs.When("when the webhook and a status check for the payment are processed without updating it")
+	response := tstPerformPost("/api/rest/v1/webhook/demosecret", tstBuildValidWebhookRequest(t, id, "OK", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+	response = tstPerformPost("/api/rest/v1/paylinks/"+id+"/status-check", "", tstValidApiToken())
+	require.Equal(t, http.StatusConflict, response.status)
+
+	docs.Then("then nothing is pushed to the frontend")
+	closeStream()
+	for event := range events {
+		require.Fail(t, "unexpected event", "%v", event)
+	}
+}
+
+func TestPaymentEvents_InvalidId(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.When("when a caller attempts to open the event stream for an invalid reference id")
+	response := tstPerformGet("/api/rest/v1/paylinks/EF2022/events", tstValidApiToken())
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "payment.refid.invalid", nil)
+}
+
+func TestPaymentEvents_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.When("when an unauthenticated caller attempts to open the event stream for a payment")
+	response := tstPerformGet("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/events", tstNoToken())
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
+
+func TestPaymentEvents_MissingPermission(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.When("when a user whose roles do not allow reading payment links attempts to open the event stream")
+	response := tstPerformGet("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/events", tstValidAuditorToken())
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
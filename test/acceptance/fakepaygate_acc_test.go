@@ -0,0 +1,147 @@
+package acceptance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	nexiapiv2 "github.com/eurofurence/reg-paygate-adapter/internal/api/v2/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/fakepaygate"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/stretchr/testify/require"
+)
+
+// --- end-to-end against fakepaygate, using the real nexi client instead of the mock ---
+
+// tstSetupWithFakePaygate points the adapter at a fakepaygate server, which calls back our webhook
+func tstSetupWithFakePaygate(t *testing.T) *httptest.Server {
+	tstSetup(tstConfigFile)
+	fake := httptest.NewServer(nil)
+	fake.Config.Handler = fakepaygate.New(fakepaygate.Options{
+		PublicUrl:       fake.URL,
+		MerchantId:      config.NexiMerchantID(),
+		ApiKey:          config.NexiAPIKey(),
+		WebhookOverride: ts.URL + "/api/rest/v1/webhook/demosecret",
+	}).Router()
+	config.Configuration().Service.NexiDownstream = fake.URL
+	require.Nil(t, nexi.Create())
+	return fake
+}
+
+// tstSubmitPaymentPage does what the attendee's browser does on the hosted payment page
+func tstSubmitPaymentPage(t *testing.T, pageUrl string, action string) tstWebResponse {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.PostForm(pageUrl, url.Values{"action": {action}})
+	require.Nil(t, err)
+	return tstWebResponseFromResponse(response)
+}
+
+func TestFakePaygate_PaySuccess(t *testing.T) {
+	fake := tstSetupWithFakePaygate(t)
+	defer fake.Close()
+	defer tstShutdown()
+
+	docs.Given("given the adapter talks to fakepaygate, and a tentative transaction in the payment service")
+	_ = paymentMock.InjectTransaction(context.TODO(), paymentservice.Transaction{
+		DebitorID: 1,
+		ID:        "EF1995-000001-221216-122218-4132",
+		Type:      "payment",
+		Method:    "credit",
+		Amount: paymentservice.Amount{
+			Currency:  "EUR",
+			GrossCent: 390,
+			VatRate:   19.0,
+		},
+		Comment:       "CC previously created",
+		Status:        "tentative",
+		EffectiveDate: "2022-12-10",
+		DueDate:       "2022-12-10",
+	})
+	token := tstValidApiToken()
+
+	docs.When("when a payment link is created")
+	created := tstPerformPost("/api/rest/v2/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+	paylink := nexiapiv2.PaylinkDto{}
+	tstParseJson(created.body, &paylink)
+	pageUrl := paylink.Links["payment_page"].Href
+	require.True(t, strings.HasPrefix(pageUrl, fake.URL+"/hpp/"), "payment page not at fakepaygate: "+pageUrl)
+
+	docs.Then("then Paygate does not know a payment before the attendee has used the payment page")
+	response := tstPerformGet("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132", token)
+	tstRequireErrorResponse(t, response, http.StatusNotFound, "payment.refid.notfound", nil)
+
+	docs.When("when the attendee pays on the payment page")
+	response = tstSubmitPaymentPage(t, pageUrl, fakepaygate.ActionPay)
+
+	docs.Then("then they are sent back to the return url")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.NotEmpty(t, response.location)
+
+	docs.Then("and the webhook has marked the transaction as paid")
+	actual := paymentMock.Recording()
+	require.Len(t, actual, 1)
+	require.Equal(t, paymentservice.TransactionStatus("valid"), actual[0].Status)
+	require.Equal(t, "CC paymentId 00000000000000000000000000000001 - status OK", actual[0].Comment)
+
+	docs.Then("and the payment link reports the payment as captured")
+	response = tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-221216-122218-4132", token)
+	require.Equal(t, http.StatusOK, response.status)
+	paylink = nexiapiv2.PaylinkDto{}
+	tstParseJson(response.body, &paylink)
+	require.Equal(t, "OK", paylink.Status)
+	require.Equal(t, "CARD", paylink.PaymentMethod)
+	require.Equal(t, int64(390), paylink.Amount.Captured)
+}
+
+func TestFakePaygate_Declined(t *testing.T) {
+	fake := tstSetupWithFakePaygate(t)
+	defer fake.Close()
+	defer tstShutdown()
+
+	docs.Given("given the adapter talks to fakepaygate, and a payment link has been created")
+	token := tstValidApiToken()
+	created := tstPerformPost("/api/rest/v2/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+	paylink := nexiapiv2.PaylinkDto{}
+	tstParseJson(created.body, &paylink)
+
+	docs.When("when the payment is declined on the payment page")
+	response := tstSubmitPaymentPage(t, paylink.Links["payment_page"].Href, fakepaygate.ActionDecline)
+
+	docs.Then("then the attendee is sent to the cancel url")
+	require.Equal(t, http.StatusSeeOther, response.status)
+
+	docs.Then("and the payment link reports the failure")
+	response = tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-221216-122218-4132", token)
+	require.Equal(t, http.StatusOK, response.status)
+	paylink = nexiapiv2.PaylinkDto{}
+	tstParseJson(response.body, &paylink)
+	require.Equal(t, "FAILED", paylink.Status)
+	require.Equal(t, int64(0), paylink.Amount.Captured)
+}
+
+func TestFakePaygate_WrongCredentials(t *testing.T) {
+	fake := tstSetupWithFakePaygate(t)
+	defer fake.Close()
+	defer tstShutdown()
+
+	docs.Given("given the adapter talks to fakepaygate, but with the wrong api key")
+	config.Configuration().Service.NexiApiKey = "wrong"
+
+	docs.When("when a payment link is created")
+	response := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), tstValidApiToken())
+
+	docs.Then("then the request fails because Paygate rejects the credentials")
+	tstRequireErrorResponse(t, response, http.StatusBadGateway, "paylink.downstream.error", nil)
+}
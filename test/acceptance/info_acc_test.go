@@ -1,11 +1,18 @@
 package acceptance
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 	"testing"
 
+	openapispec "github.com/eurofurence/reg-paygate-adapter/api/openapi-spec"
 	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 	"github.com/eurofurence/reg-paygate-adapter/internal/web/util/media"
 	"github.com/stretchr/testify/require"
 )
@@ -37,3 +44,118 @@ func TestErrorFallback(t *testing.T) {
 	docs.Then("then they receive a 404 error")
 	require.Equal(t, http.StatusNotFound, response.status, "unexpected http response status")
 }
+
+func TestMetricsEndpoint(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated user, and some requests have been served")
+	_ = tstPerformGet("/info/health", tstNoToken())
+	_ = tstPerformPost("/api/rest/v1/webhook/demosecret", "{{{{", tstNoToken())
+
+	docs.When("when they perform GET on the metrics endpoint")
+	response := tstPerformGet("/metrics", tstNoToken())
+
+	docs.Then("then the metrics are returned in Prometheus text format, with requests counted by route pattern")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	require.Contains(t, response.contentType, "text/plain")
+	require.Contains(t, response.body, `paygate_http_requests_total{method="GET",route="/info/health",status="200"}`)
+	require.Contains(t, response.body, `paygate_http_requests_total{method="POST",route="/api/rest/v1/webhook/{secret}",status="400"}`)
+	require.Contains(t, response.body, `paygate_webhooks_total{outcome="parse_error",status=""}`)
+	require.NotContains(t, response.body, "demosecret")
+}
+
+func TestReadyEndpoint(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated user")
+
+	docs.When("when they perform GET on the readiness endpoint")
+	response := tstPerformGet("/ready", tstNoToken())
+
+	docs.Then("then the instance is ready, and the state of all components and the configuration mode are reported")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	require.Equal(t, media.ContentTypeApplicationJson, response.contentType, "unexpected response content type")
+	actual := nexiapi.ReadinessReportDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, nexiapi.ReadinessReportDto{
+		Status: "ready",
+		Mode: nexiapi.ReadinessModeDto{
+			Nexi:           "live",
+			SimulationMode: true,
+			Database:       string(config.DatabaseUse()),
+		},
+		Components: map[string]nexiapi.ReadinessComponentDto{
+			"database":         {Status: "up", Required: true},
+			"nexi":             {Status: "up", Message: "circuit breaker closed"},
+			"payment_service":  {Status: "mock", Required: true},
+			"attendee_service": {Status: "mock"},
+			"mail_service":     {Status: "mock"},
+		},
+	}, actual)
+}
+
+func TestReadyEndpoint_DatabaseDown(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated user, and the database cannot be reached")
+	database.SetRepository(&tstUnreachableRepository{Repository: database.GetRepository()})
+
+	docs.When("when they perform GET on the readiness endpoint")
+	response := tstPerformGet("/ready", tstNoToken())
+
+	docs.Then("then the instance is not ready (503), and the database is reported as down")
+	require.Equal(t, http.StatusServiceUnavailable, response.status, "unexpected http response status")
+	actual := nexiapi.ReadinessReportDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, "not_ready", actual.Status)
+	require.Equal(t, nexiapi.ReadinessComponentDto{Status: "down", Required: true, Message: "connection refused"}, actual.Components["database"])
+
+	docs.Then("and the liveness endpoint still reports OK")
+	require.Equal(t, http.StatusOK, tstPerformGet("/info/health", tstNoToken()).status)
+}
+
+// --- helpers ---
+
+type tstUnreachableRepository struct {
+	dbrepo.Repository
+}
+
+func (r *tstUnreachableRepository) Ping(_ context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestOpenApiSpec(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated user")
+
+	docs.When("when they request the openapi spec")
+	response := tstPerformGet("/api/rest/v1/openapi.yaml", tstNoToken())
+
+	docs.Then("then the spec embedded in the binary is returned")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	require.Equal(t, media.ContentTypeApplicationYaml, response.contentType, "unexpected response content type")
+	require.Equal(t, string(openapispec.Yaml), response.body)
+}
+
+func TestOpenApiDocs(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated user")
+
+	docs.When("when they open the api docs page")
+	response := tstPerformGet("/api/rest/v1/docs", tstNoToken())
+
+	docs.Then("then a page is returned that renders the spec")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	require.Equal(t, media.ContentTypeTextHtml, response.contentType, "unexpected response content type")
+	require.Contains(t, response.body, "url: 'openapi.yaml'")
+
+	docs.Then("and it loads a pinned release of Swagger UI")
+	require.Contains(t, response.body, "https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js")
+}
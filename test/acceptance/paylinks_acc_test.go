@@ -3,6 +3,7 @@ package acceptance
 import (
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/eurofurence/reg-paygate-adapter/docs"
@@ -45,6 +46,52 @@ func TestCreatePaylink_Success(t *testing.T) {
 	})
 }
 
+func TestCreatePaylink_ClientWithScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstClearDatabase() // ensure clean database for protocol entry checks
+
+	docs.Given("given a client who supplies its own api key, which allows creating payment links")
+	token := tstValidRegsysApiToken()
+
+	docs.When("when they attempt to create a payment link with valid information")
+	requestBody := tstBuildValidPaymentLinkRequest()
+	response := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(requestBody), token)
+
+	docs.Then("then the request is successful and the response is as expected")
+	tstRequirePaymentLinkResponse(t, response, http.StatusCreated, tstBuildValidPaymentLink())
+
+	docs.Then("and the expected protocol entries have been written, recording the name of the client")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		Kind:        "success",
+		Message:     "create-pay-link",
+		Details:     "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+		Client:      "regsys",
+	})
+}
+
+func TestCreatePaylink_ClientMissingScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client who supplies its own api key, which does not allow creating payment links")
+	token := tstValidAdminToolApiToken()
+
+	docs.When("when they attempt to create a payment link")
+	requestBody := tstBuildValidPaymentLinkRequest()
+	response := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(requestBody), token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
+
 func TestCreatePaylink_InvalidJson(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -65,6 +112,23 @@ func TestCreatePaylink_InvalidJson(t *testing.T) {
 	tstRequireProtocolEntries(t)
 }
 
+func TestCreatePaylink_BodyTooLarge(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they attempt to create a payment link but supply a json body larger than 1 MiB")
+	response := tstPerformPost("/api/rest/v1/paylinks", `{"reference_id":"`+strings.Repeat("x", 1024*1024)+`"}`, token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusRequestEntityTooLarge, "request.body.too.large", nil)
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
+
 func TestCreatePaylink_ValidJsonWrongFields(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -116,6 +180,52 @@ func TestCreatePaylink_InvalidData(t *testing.T) {
 	tstRequireProtocolEntries(t)
 }
 
+func TestCreatePaylink_RedirectUrls(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstClearDatabase() // ensure clean database for protocol entry checks
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they attempt to create a payment link with their own return pages from the redirect allowlist")
+	requestBody := tstBuildValidPaymentLinkRequest()
+	requestBody.SuccessUrl = "https://rooms.example.com/shop/paid?booking=17"
+	requestBody.CancelUrl = "https://rooms.example.com/shop/cancelled"
+	response := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(requestBody), token)
+
+	docs.Then("then the request is successful and the response is as expected")
+	tstRequirePaymentLinkResponse(t, response, http.StatusCreated, tstBuildValidPaymentLink())
+
+	docs.Then("and the expected request for a payment link has been made")
+	tstRequireNexiRecording(t,
+		"CreatePaymentLink",
+	)
+}
+
+func TestCreatePaylink_RedirectUrlsNotAllowed(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they attempt to create a payment link with return pages that are not in the redirect allowlist")
+	requestBody := tstBuildValidPaymentLinkRequest()
+	requestBody.SuccessUrl = "https://evil.example.com/register"
+	requestBody.CancelUrl = "https://rooms.example.com/admin"
+	response := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(requestBody), token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "paylink.data.invalid", url.Values{
+		"cancel_url":  []string{"if set, must be an absolute url of at most 2048 characters, on a host and path in the redirect allowlist"},
+		"success_url": []string{"if set, must be an absolute url of at most 2048 characters, on a host and path in the redirect allowlist"},
+	})
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
+
 func TestCreatePaylink_Anonymous(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -158,6 +268,27 @@ func TestCreatePaylink_WrongToken(t *testing.T) {
 	tstRequireProtocolEntries(t)
 }
 
+func TestCreatePaylink_BearerToken(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an admin who supplies a valid bearer token")
+	token := tstValidAdminToken()
+
+	docs.When("when they attempt to create a payment link")
+	requestBody := tstBuildValidPaymentLinkRequest()
+	response := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(requestBody), token)
+
+	docs.Then("then the request is denied as unauthorized (403), because the admin role does not allow creating payment links")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
+
 func TestCreatePaylink_DownstreamErrorAttSrv(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -240,6 +371,30 @@ func TestGetPaylink_Success(t *testing.T) {
 	})
 }
 
+func TestGetPaylink_ClientWithScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client who supplies its own api key, which allows reading payment links")
+	token := tstValidAdminToolApiToken()
+
+	docs.When("when they attempt to get an existing payment link by its reference id")
+	response := tstPerformGet("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132", token)
+
+	docs.Then("then the request is successful and the response is as expected")
+	tstRequirePaymentResponse(t, response, http.StatusOK, tstBuildValidPaymentGetResponse())
+
+	docs.Then("and the expected protocol entries have been written, recording the name of the client")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		ApiId:       "42",
+		Kind:        "success",
+		Message:     "get-payment",
+		Details:     "",
+		Client:      "admin-tool",
+	})
+}
+
 func TestGetPaylink_InvalidId(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -356,3 +511,117 @@ func TestGetPaylink_DownstreamError(t *testing.T) {
 		Details:     "downstream unavailable - see log for details",
 	})
 }
+
+// --- list ---
+
+func TestListPaylinks_Success(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link that has been created by a client, and whose payment has since been queried")
+	token := tstValidRegsysApiToken()
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+	queried := tstPerformGet("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132", token)
+	require.Equal(t, http.StatusOK, queried.status)
+
+	docs.When("when they list the payment links for the debitor")
+	response := tstPerformGet("/api/rest/v1/paylinks?debitor_id=1&status=OK", token)
+
+	docs.Then("then the request is successful and the response contains our record with the last known status")
+	require.Equal(t, http.StatusOK, response.status)
+	actual := nexiapi.PaymentLinkListDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, int64(1), actual.Total)
+	require.Equal(t, 1, actual.Page)
+	require.Equal(t, 50, actual.PageSize)
+	require.Len(t, actual.Paylinks, 1)
+	require.NotEmpty(t, actual.Paylinks[0].CreatedAt)
+	require.NotEmpty(t, actual.Paylinks[0].StatusUpdatedAt)
+	actual.Paylinks[0].CreatedAt = ""
+	actual.Paylinks[0].StatusUpdatedAt = ""
+	require.Equal(t, nexiapi.PaymentLinkRecordDto{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		DebitorId:   1,
+		AmountDue:   390,
+		Currency:    "EUR",
+		VatRate:     19.0,
+		Link:        "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+		PaymentId:   "42",
+		Status:      "OK",
+		Client:      "regsys",
+	}, actual.Paylinks[0])
+}
+
+func TestListPaylinks_Filtered(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link that has been created")
+	token := tstValidApiToken()
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+
+	docs.When("when they list payment links with filters that it does not match")
+	responses := []tstWebResponse{
+		tstPerformGet("/api/rest/v1/paylinks?debitor_id=2", token),
+		tstPerformGet("/api/rest/v1/paylinks?status=OK", token),
+		tstPerformGet("/api/rest/v1/paylinks?created_before=2020-01-01T00:00:00Z", token),
+		tstPerformGet("/api/rest/v1/paylinks?page=2&page_size=1", token),
+	}
+
+	docs.Then("then each request is successful but does not list the payment link")
+	for i, response := range responses {
+		require.Equal(t, http.StatusOK, response.status)
+		actual := nexiapi.PaymentLinkListDto{}
+		tstParseJson(response.body, &actual)
+		require.Empty(t, actual.Paylinks, "query %d", i)
+	}
+}
+
+func TestListPaylinks_InvalidParameters(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they list payment links with invalid parameters")
+	response := tstPerformGet("/api/rest/v1/paylinks?debitor_id=abc&created_after=yesterday&page=0&page_size=501", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "paylink.query.invalid", url.Values{
+		"debitor_id":    []string{"must be a positive integer (the badge number)"},
+		"created_after": []string{"must be a date and time in RFC3339 format, e.g. 2025-08-01T00:00:00Z"},
+		"page":          []string{"must be an integer at least 1 and at most 1000000"},
+		"page_size":     []string{"must be an integer at least 1 and at most 500"},
+	})
+}
+
+func TestListPaylinks_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated caller")
+	token := tstNoToken()
+
+	docs.When("when they attempt to list payment links")
+	response := tstPerformGet("/api/rest/v1/paylinks", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
+
+func TestListPaylinks_MissingPermission(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a user whose roles do not allow reading payment links")
+	token := tstValidAuditorToken()
+
+	docs.When("when they attempt to list payment links")
+	response := tstPerformGet("/api/rest/v1/paylinks", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
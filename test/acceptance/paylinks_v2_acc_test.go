@@ -0,0 +1,252 @@
+package acceptance
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	nexiapiv2 "github.com/eurofurence/reg-paygate-adapter/internal/api/v2/nexiapi"
+	"github.com/stretchr/testify/require"
+)
+
+// --- api v2 paylinks ---
+
+// tstClearPaylinkTimes blanks the times set by the database, after checking they are there
+func tstClearPaylinkTimes(t *testing.T, paylink *nexiapiv2.PaylinkDto) {
+	require.NotEmpty(t, paylink.CreatedAt)
+	paylink.CreatedAt = ""
+	for k := range paylink.StatusHistory {
+		require.NotEmpty(t, paylink.StatusHistory[k].Timestamp)
+		paylink.StatusHistory[k].Timestamp = ""
+	}
+}
+
+func tstBuildValidPaylinkV2(referenceId string) nexiapiv2.PaylinkDto {
+	return nexiapiv2.PaylinkDto{
+		ReferenceId: referenceId,
+		DebitorId:   1,
+		Amount: nexiapiv2.PaylinkAmountDto{
+			Currency: "EUR",
+			Due:      390,
+		},
+		LineItems: []nexiapiv2.PaylinkLineItemDto{
+			{
+				Name:        "some payment purpose",
+				Quantity:    1,
+				GrossAmount: 390,
+				NetAmount:   316,
+				VatAmount:   74,
+				VatRate:     19.0,
+			},
+		},
+		VatBreakdown: []nexiapiv2.PaylinkVatDto{
+			{
+				VatRate:     19.0,
+				NetAmount:   316,
+				VatAmount:   74,
+				GrossAmount: 390,
+			},
+		},
+		Links: map[string]nexiapiv2.LinkDto{
+			"self":         {Href: "/api/rest/v2/paylinks/" + referenceId, Method: http.MethodGet},
+			"payment_page": {Href: "http://localhost:1111/some/paylink/" + referenceId, Method: http.MethodGet},
+			"events":       {Href: "/api/rest/v1/paylinks/" + referenceId + "/events", Method: http.MethodGet},
+			"status_check": {Href: "/api/rest/v1/paylinks/" + referenceId + "/status-check", Method: http.MethodPost},
+		},
+	}
+}
+
+func TestCreatePaylinkV2_Success(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they create a payment link with valid information using api v2")
+	response := tstPerformPost("/api/rest/v2/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+
+	docs.Then("then the payment link is created, and returned in the v2 representation")
+	require.Equal(t, http.StatusCreated, response.status, "unexpected http response status")
+	require.Equal(t, "/api/rest/v2/paylinks/EF1995-000001-221216-122218-4132", response.location)
+	actual := nexiapiv2.PaylinkDto{}
+	tstParseJson(response.body, &actual)
+	tstClearPaylinkTimes(t, &actual)
+	require.Equal(t, tstBuildValidPaylinkV2("EF1995-000001-221216-122218-4132"), actual)
+
+	docs.Then("and the expected request for a payment link has been made")
+	tstRequireNexiRecording(t,
+		"CreatePaymentLink",
+	)
+}
+
+func TestCreatePaylinkV2_InvalidData(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they attempt to create a payment link without an amount using api v2")
+	request := tstBuildValidPaymentLinkRequest()
+	request.AmountDue = 0
+	response := tstPerformPost("/api/rest/v2/paylinks", tstRenderJson(request), token)
+
+	docs.Then("then the request is rejected just like in api v1")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "paylink.data.invalid", nil)
+}
+
+func TestGetPaylinkV2_Success(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link that has been created and paid")
+	token := tstValidApiToken()
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+
+	docs.When("when they get the payment link using api v2")
+	response := tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-221216-122218-4132", token)
+
+	docs.Then("then our record is returned merged with the payment state at Paygate and the status history")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	actual := nexiapiv2.PaylinkDto{}
+	tstParseJson(response.body, &actual)
+	tstClearPaylinkTimes(t, &actual)
+	expected := tstBuildValidPaylinkV2("EF1995-000001-221216-122218-4132")
+	expected.Status = "OK"
+	expected.ResponseCode = "00000000"
+	expected.PaymentId = "42"
+	expected.PaymentMethod = "CARD"
+	expected.Amount.Captured = 18500
+	expected.StatusHistory = []nexiapiv2.PaylinkStatusChangeDto{
+		{Status: "OK", Source: "query"},
+	}
+	expected.Links["refund"] = nexiapiv2.LinkDto{Href: "/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/refund", Method: http.MethodPost}
+	require.Equal(t, expected, actual)
+
+	docs.Then("and the expected request for a payment has been made")
+	tstRequireNexiRecording(t,
+		"CreatePaymentLink",
+		"QueryPaymentLink EF1995-000001-221216-122218-4132",
+	)
+}
+
+func TestGetPaylinkV2_Authorized(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link whose payment has been authorized, but not captured")
+	token := tstValidApiToken()
+	request := tstBuildValidPaymentLinkRequest()
+	request.ReferenceId = "EF1995-000001-230001-122218-6666" // set up in paygate mock as AUTHORIZED 390.00 EUR
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(request), token)
+	require.Equal(t, http.StatusCreated, created.status)
+
+	docs.When("when they get the payment link using api v2")
+	response := tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-230001-122218-6666", token)
+
+	docs.Then("then capture and cancel are offered, but no refund")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	actual := nexiapiv2.PaylinkDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, "AUTHORIZED", actual.Status)
+	require.Equal(t, nexiapiv2.LinkDto{Href: "/api/rest/v1/paylinks/EF1995-000001-230001-122218-6666/capture", Method: http.MethodPost}, actual.Links["capture"])
+	require.Equal(t, nexiapiv2.LinkDto{Href: "/api/rest/v1/paylinks/EF1995-000001-230001-122218-6666/cancel", Method: http.MethodPost}, actual.Links["cancel"])
+	require.NotContains(t, actual.Links, "refund")
+}
+
+func TestGetPaylinkV2_NotYetPaid(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link that has been created, but Paygate does not know a payment for it yet")
+	token := tstValidApiToken()
+	request := tstBuildValidPaymentLinkRequest()
+	request.ReferenceId = "EF1995-000001-221216-122218-0001"
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(request), token)
+	require.Equal(t, http.StatusCreated, created.status)
+
+	docs.When("when they get the payment link using api v2")
+	response := tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-221216-122218-0001", token)
+
+	docs.Then("then our record is returned, without a payment status")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	actual := nexiapiv2.PaylinkDto{}
+	tstParseJson(response.body, &actual)
+	tstClearPaylinkTimes(t, &actual)
+	require.Equal(t, tstBuildValidPaylinkV2("EF1995-000001-221216-122218-0001"), actual)
+}
+
+func TestGetPaylinkV2_NotFound(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they attempt to get a payment link that neither we nor Paygate know using api v2")
+	response := tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-221216-122218-0002", token)
+
+	docs.Then("then the payment link is not found")
+	tstRequireErrorResponse(t, response, http.StatusNotFound, "payment.refid.notfound", nil)
+}
+
+func TestGetPaylinkV2_WrongScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client whose api key does not allow reading payment links")
+	token := tstValidAuditorToken()
+
+	docs.When("when they attempt to get a payment link using api v2")
+	response := tstPerformGet("/api/rest/v2/paylinks/EF1995-000001-221216-122218-4132", token)
+
+	docs.Then("then the request is denied")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", nil)
+}
+
+func TestListPaylinksV2_Paging(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given two payment links that have been created")
+	token := tstValidApiToken()
+	for _, id := range []string{"EF1995-000001-221216-122218-0001", "EF1995-000001-221216-122218-0002"} {
+		request := tstBuildValidPaymentLinkRequest()
+		request.ReferenceId = id
+		created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(request), token)
+		require.Equal(t, http.StatusCreated, created.status)
+	}
+
+	docs.When("when they list the payment links one per page using api v2")
+	response := tstPerformGet("/api/rest/v2/paylinks?debitor_id=1&page_size=1", token)
+
+	docs.Then("then the first one is returned, with links to this and the next page that keep the filters")
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	actual := nexiapiv2.PaylinkListDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, int64(2), actual.Total)
+	require.Equal(t, 1, actual.Page)
+	require.Equal(t, 1, actual.PageSize)
+	require.Len(t, actual.Paylinks, 1)
+	tstClearPaylinkTimes(t, &actual.Paylinks[0])
+	require.Equal(t, []nexiapiv2.PaylinkDto{tstBuildValidPaylinkV2("EF1995-000001-221216-122218-0001")}, actual.Paylinks)
+	require.Equal(t, map[string]nexiapiv2.LinkDto{
+		"self": {Href: "/api/rest/v2/paylinks?debitor_id=1&page=1&page_size=1", Method: http.MethodGet},
+		"next": {Href: "/api/rest/v2/paylinks?debitor_id=1&page=2&page_size=1", Method: http.MethodGet},
+	}, actual.Links)
+
+	docs.Then("and following the next link returns the second one, with a link back")
+	response = tstPerformGet(actual.Links["next"].Href, token)
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	actual = nexiapiv2.PaylinkListDto{}
+	tstParseJson(response.body, &actual)
+	require.Len(t, actual.Paylinks, 1)
+	tstClearPaylinkTimes(t, &actual.Paylinks[0])
+	require.Equal(t, []nexiapiv2.PaylinkDto{tstBuildValidPaylinkV2("EF1995-000001-221216-122218-0002")}, actual.Paylinks)
+	require.Equal(t, map[string]nexiapiv2.LinkDto{
+		"self": {Href: "/api/rest/v2/paylinks?debitor_id=1&page=2&page_size=1", Method: http.MethodGet},
+		"prev": {Href: "/api/rest/v2/paylinks?debitor_id=1&page=1&page_size=1", Method: http.MethodGet},
+	}, actual.Links)
+}
@@ -0,0 +1,433 @@
+package acceptance
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/stretchr/testify/require"
+)
+
+// --- query ---
+
+func TestQueryProtocol_ByReferenceId(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the protocol for a reference id")
+	response := tstPerformGet("/api/rest/v1/protocol?reference_id=EF1995-000001-221216-122218-4132", token)
+
+	docs.Then("then the request is successful and all entries for that reference id are returned in order")
+	actual := tstRequireProtocolListResponse(t, response, 3, 1, 50)
+	require.Equal(t, 3, len(actual.Entries))
+	require.Equal(t, "create-pay-link", actual.Entries[0].Message)
+	require.Equal(t, "get-payment", actual.Entries[1].Message)
+	require.Equal(t, "transaction updated successfully", actual.Entries[2].Message)
+	require.Equal(t, "ef00000000000000000000000000cafe", actual.Entries[2].ApiId)
+	require.Equal(t, "amount=18500 currency=EUR", actual.Entries[2].Details)
+	require.Equal(t, "12345678", actual.Entries[2].RequestId)
+}
+
+func TestQueryProtocol_ByKindsAndApiId(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the protocol for several kinds and an api id")
+	response := tstPerformGet("/api/rest/v1/protocol?kind=error&kind=success&api_id=ef00000000000000000000000000cafe", token)
+
+	docs.Then("then only the matching entries are returned")
+	actual := tstRequireProtocolListResponse(t, response, 2, 1, 50)
+	require.Equal(t, "success", actual.Entries[0].Kind)
+	require.Equal(t, "error", actual.Entries[1].Kind)
+	require.Equal(t, "EF1995-000002-221216-122218-4711", actual.Entries[1].ReferenceId)
+}
+
+func TestQueryProtocol_Paging(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the second page of the protocol with a small page size")
+	response := tstPerformGet("/api/rest/v1/protocol?page=2&page_size=2", token)
+
+	docs.Then("then the second page is returned together with the total count")
+	actual := tstRequireProtocolListResponse(t, response, 5, 2, 2)
+	require.Equal(t, 2, len(actual.Entries))
+	require.Equal(t, "transaction updated successfully", actual.Entries[0].Message)
+	require.Equal(t, "webhook failed to update transaction", actual.Entries[1].Message)
+}
+
+func TestQueryProtocol_TimeRange(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the protocol for a time range in the past")
+	response := tstPerformGet("/api/rest/v1/protocol?created_after=2020-01-01T00:00:00Z&created_before=2020-01-02T00:00:00Z", token)
+
+	docs.Then("then no entries are returned")
+	actual := tstRequireProtocolListResponse(t, response, 0, 1, 50)
+	require.Empty(t, actual.Entries)
+}
+
+func TestQueryProtocol_StructuredDataOfOldEntries(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query protocol entries that only have their payment data in the details")
+	response := tstPerformGet("/api/rest/v1/protocol?reference_id=EF1995-000002-221216-122218-4711", token)
+
+	docs.Then("then the structured payment data is filled in from the details")
+	actual := tstRequireProtocolListResponse(t, response, 2, 1, 50)
+	require.Equal(t, int64(18500), *actual.Entries[0].Amount)
+	require.Equal(t, "EUR", actual.Entries[0].Currency)
+	require.Nil(t, actual.Entries[1].Amount)
+	require.Equal(t, "AUTHORIZED", actual.Entries[1].WebhookStatus)
+	require.Equal(t, "FAILED", actual.Entries[1].UpstreamStatus)
+}
+
+func TestQueryProtocol_ByStructuredData(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+	_ = database.GetRepository().WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+		ReferenceId:       "EF1995-000003-221216-122218-1234",
+		Kind:              entity.KindPending,
+		Message:           "transaction updated to PENDING",
+		Details:           "amount=9000 currency=EUR",
+		Amount:            tstAmount(9000),
+		Currency:          "EUR",
+		UpstreamStatus:    "AUTHORIZED",
+		WebhookStatus:     "AUTHORIZED",
+		TransactionStatus: "pending",
+	})
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the protocol by structured payment data")
+	response := tstPerformGet("/api/rest/v1/protocol?currency=EUR&upstream_status=AUTHORIZED&transaction_status=pending", token)
+
+	docs.Then("then only the entries with matching structured data are returned")
+	actual := tstRequireProtocolListResponse(t, response, 1, 1, 50)
+	require.Equal(t, "EF1995-000003-221216-122218-1234", actual.Entries[0].ReferenceId)
+	require.Equal(t, int64(9000), *actual.Entries[0].Amount)
+	require.Equal(t, "AUTHORIZED", actual.Entries[0].WebhookStatus)
+}
+
+func TestQueryProtocol_InvalidKind(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the protocol for an unknown kind")
+	response := tstPerformGet("/api/rest/v1/protocol?kind=success&kind=failure", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "protocol.query.invalid", url.Values{
+		"kind": []string{"must be one of raw, success, pending, warning, error"},
+	})
+}
+
+func TestQueryProtocol_InvalidParameters(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they query the protocol with invalid parameters")
+	response := tstPerformGet("/api/rest/v1/protocol?created_after=yesterday&page=0&page_size=100000", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "protocol.query.invalid", url.Values{
+		"created_after": []string{"must be a date and time in RFC3339 format, e.g. 2025-08-01T00:00:00Z"},
+		"page":          []string{"must be an integer at least 1 and at most 1000000"},
+		"page_size":     []string{"must be an integer at least 1 and at most 500"},
+	})
+}
+
+func TestQueryProtocol_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given an unauthenticated caller")
+	token := tstNoToken()
+
+	docs.When("when they attempt to query the protocol")
+	response := tstPerformGet("/api/rest/v1/protocol", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
+
+// --- export ---
+
+func TestQueryProtocol_Auditor(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a valid bearer token with a role that allows reading the protocol")
+	token := tstValidAuditorToken()
+
+	docs.When("when they query the protocol for a reference id")
+	response := tstPerformGet("/api/rest/v1/protocol?reference_id=EF1995-000001-221216-122218-4132", token)
+
+	docs.Then("then the request is successful")
+	actual := tstRequireProtocolListResponse(t, response, 3, 1, 50)
+	require.Equal(t, 3, len(actual.Entries))
+}
+
+func TestQueryProtocol_MissingRole(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a valid bearer token without a role that allows reading the protocol")
+	token := tstValidUserToken()
+
+	docs.When("when they attempt to query the protocol")
+	response := tstPerformGet("/api/rest/v1/protocol?reference_id=EF1995-000001-221216-122218-4132", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+}
+
+func TestExportProtocol_Csv(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+	_ = database.GetRepository().WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+		ReferenceId: "EF1994-000001-221216-122218-0815",
+		Kind:        "success",
+		Message:     "transaction updated successfully",
+		Details:     "amount=2500 currency=EUR",
+	})
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they export the protocol for one convention year and some kinds as csv")
+	response := tstPerformGet("/api/rest/v1/protocol/export?reference_id_prefix=EF1995&kind=error&kind=warning&kind=success", token)
+
+	docs.Then("then the request is successful and the matching entries are returned with details split into columns")
+	require.Equal(t, http.StatusOK, response.status)
+	require.Equal(t, "text/csv; charset=utf-8", response.contentType)
+	rows, err := csv.NewReader(strings.NewReader(response.body)).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 6, len(rows))
+	header := rows[0]
+	require.Equal(t, []string{"id", "created_at", "reference_id", "api_id", "kind", "message", "details", "request_id", "subject", "client", "operation", "amount", "currency", "verified", "code", "desc", "error", "webhook"}, header[:18])
+
+	column := func(row []string, name string) string {
+		return row[slices.Index(header, name)]
+	}
+	require.Equal(t, "create-pay-link", column(rows[1], "message"))
+	require.Equal(t, "", column(rows[1], "amount"))
+	require.Equal(t, "EF1995-000002-221216-122218-4711", column(rows[4], "reference_id"))
+	require.Equal(t, "amount=18500 currency=EUR error=downstream unavailable - see log for details", column(rows[4], "details"))
+	require.Equal(t, "18500", column(rows[4], "amount"))
+	require.Equal(t, "EUR", column(rows[4], "currency"))
+	require.Equal(t, "downstream unavailable - see log for details", column(rows[4], "error"))
+	require.Equal(t, "AUTHORIZED", column(rows[5], "webhook"))
+	require.Equal(t, "FAILED", column(rows[5], "verified"))
+}
+
+func TestExportProtocol_CsvFormulas(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	_ = database.GetRepository().WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4711",
+		Kind:        "error",
+		Message:     "=HYPERLINK(\"https://evil.example.com\")",
+		Details:     "desc=@SUM(A1) error=-1+1",
+		Subject:     "+cmd",
+		Amount:      tstAmount(-18500),
+		Currency:    "EUR",
+	})
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they export a protocol with values that a spreadsheet would execute as a formula as csv")
+	response := tstPerformGet("/api/rest/v1/protocol/export?reference_id_prefix=EF1995", token)
+
+	docs.Then("then the request is successful and these values are prefixed with ', while plain numbers are left unchanged")
+	require.Equal(t, http.StatusOK, response.status)
+	rows, err := csv.NewReader(strings.NewReader(response.body)).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rows))
+	header := rows[0]
+	column := func(row []string, name string) string {
+		return row[slices.Index(header, name)]
+	}
+	require.Equal(t, "'=HYPERLINK(\"https://evil.example.com\")", column(rows[1], "message"))
+	require.Equal(t, "'+cmd", column(rows[1], "subject"))
+	require.Equal(t, "desc=@SUM(A1) error=-1+1", column(rows[1], "details"))
+	require.Equal(t, "'@SUM(A1)", column(rows[1], "desc"))
+	require.Equal(t, "'-1+1", column(rows[1], "error"))
+	require.Equal(t, "-18500", column(rows[1], "amount"))
+}
+
+func TestExportProtocol_Jsonl(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they export the protocol for a time range as json lines")
+	after := url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339))
+	before := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	response := tstPerformGet("/api/rest/v1/protocol/export?format=jsonl&kind=error&created_after="+after+"&created_before="+before, token)
+
+	docs.Then("then the request is successful and the matching entries are returned one per line")
+	require.Equal(t, http.StatusOK, response.status)
+	require.Equal(t, "application/x-ndjson", response.contentType)
+	lines := strings.Split(strings.TrimSpace(response.body), "\n")
+	require.Equal(t, 1, len(lines))
+	actual := nexiapi.ProtocolExportEntryDto{}
+	tstParseJson(lines[0], &actual)
+	require.Equal(t, "webhook failed to update transaction", actual.Message)
+	require.Equal(t, map[string]string{
+		"amount":   "18500",
+		"currency": "EUR",
+		"error":    "downstream unavailable - see log for details",
+	}, actual.Fields)
+}
+
+func TestExportProtocol_TimeRangeExcludesAll(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they export the protocol for a time range in the past")
+	response := tstPerformGet("/api/rest/v1/protocol/export?format=jsonl&created_before=2020-01-01T00:00:00Z", token)
+
+	docs.Then("then the request is successful and the export is empty")
+	require.Equal(t, http.StatusOK, response.status)
+	require.Equal(t, "", response.body)
+}
+
+func TestExportProtocol_InvalidParameters(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request an export in an unknown format")
+	response := tstPerformGet("/api/rest/v1/protocol/export?format=xlsx&created_after=yesterday", token)
+
+	docs.Then("then the request fails with a validation error")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "protocol.query.invalid", url.Values{
+		"format":        []string{"must be one of csv, jsonl"},
+		"created_after": []string{"must be a date and time in RFC3339 format, e.g. 2025-08-01T00:00:00Z"},
+	})
+}
+
+func TestExportProtocol_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated caller")
+	token := tstNoToken()
+
+	docs.When("when they attempt to export the protocol")
+	response := tstPerformGet("/api/rest/v1/protocol/export", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
+
+// --- helpers ---
+
+func tstInjectProtocolEntries() {
+	db := database.GetRepository()
+	for _, e := range []entity.ProtocolEntry{
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			Kind:        "success",
+			Message:     "create-pay-link",
+			Details:     "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+			RequestId:   "12345678",
+		},
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			ApiId:       "42",
+			Kind:        "success",
+			Message:     "get-payment",
+			RequestId:   "12345678",
+		},
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			ApiId:       "ef00000000000000000000000000cafe",
+			Kind:        "success",
+			Message:     "transaction updated successfully",
+			Details:     "amount=18500 currency=EUR",
+			RequestId:   "12345678",
+		},
+		{
+			ReferenceId: "EF1995-000002-221216-122218-4711",
+			ApiId:       "ef00000000000000000000000000cafe",
+			Kind:        "error",
+			Message:     "webhook failed to update transaction",
+			Details:     "amount=18500 currency=EUR error=downstream unavailable - see log for details",
+			RequestId:   "87654321",
+		},
+		{
+			ReferenceId: "EF1995-000002-221216-122218-4711",
+			Kind:        "warning",
+			Message:     "verified status not OK",
+			Details:     "webhook=AUTHORIZED verified=FAILED",
+			RequestId:   "87654321",
+		},
+	} {
+		_ = db.WriteProtocolEntry(context.TODO(), &e)
+	}
+}
+
+func tstRequireProtocolListResponse(t *testing.T, response tstWebResponse, expectedTotal int64, expectedPage int, expectedPageSize int) nexiapi.ProtocolEntryListDto {
+	require.Equal(t, http.StatusOK, response.status, "unexpected http response status")
+	actual := nexiapi.ProtocolEntryListDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, expectedTotal, actual.Total, "unexpected total")
+	require.Equal(t, expectedPage, actual.Page, "unexpected page")
+	require.Equal(t, expectedPageSize, actual.PageSize, "unexpected page size")
+	return actual
+}
@@ -0,0 +1,239 @@
+package acceptance
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileSettlement_Report(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectReconciliationData()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they upload a settlement file in the Paygate layout")
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement", tstSettlementFile(), token)
+
+	docs.Then("then the request is successful and only the lines that need attention are reported")
+	require.Equal(t, http.StatusOK, response.status)
+	report := nexiapi.ReconciliationReportDto{}
+	tstParseJson(response.body, &report)
+
+	require.Equal(t, 9, report.Summary.Total)
+	require.Equal(t, 3, report.Summary.Matched)
+	require.Equal(t, 2, report.Summary.Unmatched)
+	require.Equal(t, 3, report.Summary.Mismatched)
+	require.Equal(t, 1, report.Summary.FeeOnly)
+	require.Equal(t, int64(18500+18500+18000+18500+4000+18500-5000-18500), report.Summary.Amounts["EUR"])
+	require.Equal(t, int64(-8*35-1500), report.Summary.Fees["EUR"])
+
+	require.Equal(t, 6, len(report.Lines))
+	tstRequireReconciliationLine(t, report.Lines[0], 3, "EF1995-000002-221216-122218-4711", nexiapi.ReconciliationLineDto{
+		Result:   "mismatched",
+		Problems: []string{"booking is in status pending"},
+	})
+	tstRequireReconciliationLine(t, report.Lines[1], 4, "EF1995-000003-221216-122218-1234", nexiapi.ReconciliationLineDto{
+		Result:   "mismatched",
+		Problems: []string{"amount differs, settled 180.00, booked 185.00"},
+	})
+	tstRequireReconciliationLine(t, report.Lines[2], 5, "EF1995-000004-221216-122218-5555", nexiapi.ReconciliationLineDto{
+		Result:   "unmatched",
+		Problems: []string{"no booking in the payment service"},
+	})
+	tstRequireReconciliationLine(t, report.Lines[3], 6, "EF1995-000099-221216-122218-9999", nexiapi.ReconciliationLineDto{
+		Result:   "unmatched",
+		Problems: []string{"unknown reference id"},
+	})
+	tstRequireReconciliationLine(t, report.Lines[4], 8, "EF1995-000001-221216-122218-4132", nexiapi.ReconciliationLineDto{
+		Result:   "mismatched",
+		Problems: []string{"captured more than once"},
+	})
+	tstRequireReconciliationLine(t, report.Lines[5], 10, "", nexiapi.ReconciliationLineDto{
+		Result: "fee_only",
+	})
+	require.Equal(t, "pending", report.Lines[0].BookingStatus)
+	require.Equal(t, int64(18500), *report.Lines[1].BookingAmount)
+	require.Equal(t, int64(-1500), report.Lines[5].Fee)
+
+	docs.Then("and the bookings were loaded at once, only those outside the date range were looked up one by one")
+	single, byRange := paymentMock.QueryCounts()
+	require.Equal(t, 1, byRange)
+	require.Equal(t, 2, single)
+}
+
+func TestReconcileSettlement_CsvReport(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectReconciliationData()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they upload a settlement file and request a csv report")
+	settlement := "TransID;PayID;Action;Amount;Currency;Fee;Date\n" +
+		"EF1995-000001-221216-122218-4132;ef00000000000000000000000000cafe;Capture;18500;EUR;-35;2022-12-17\n" +
+		"EF1995-000003-221216-122218-1234;;Capture;180000;EUR;-35;2022-12-17\n"
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement?format=csv", settlement, token)
+
+	docs.Then("then the request is successful and the lines that need attention are returned as csv")
+	require.Equal(t, http.StatusOK, response.status)
+	require.Equal(t, "text/csv; charset=utf-8", response.contentType)
+	rows, err := csv.NewReader(strings.NewReader(response.body)).ReadAll()
+	require.Nil(t, err)
+	require.Equal(t, [][]string{
+		{"line", "reference_id", "pay_id", "type", "amount", "currency", "fee", "date", "result", "problems", "booking_status", "booking_amount", "booking_currency"},
+		{"3", "EF1995-000003-221216-122218-1234", "", "capture", "1800.00", "EUR", "-0.35", "2022-12-17", "mismatched", "amount differs, settled 1800.00, booked 185.00", "valid", "185.00", "EUR"},
+	}, rows)
+}
+
+func TestReconcileSettlement_PayIdDiffers(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectReconciliationData()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they upload a settlement file with a pay id that differs from the one in our protocol")
+	settlement := "TransID;PayID;Action;Amount;Currency;Fee;Date\n" +
+		"EF1995-000001-221216-122218-4132;ef00000000000000000000000000beef;Capture;18500;EUR;-35;2022-12-17\n"
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement", settlement, token)
+
+	docs.Then("then the line is reported as mismatched")
+	require.Equal(t, http.StatusOK, response.status)
+	report := nexiapi.ReconciliationReportDto{}
+	tstParseJson(response.body, &report)
+	require.Equal(t, 1, len(report.Lines))
+	require.Equal(t, []string{"pay id does not match our protocol"}, report.Lines[0].Problems)
+}
+
+func TestReconcileSettlement_InvalidFile(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they upload a settlement file with an amount that is not in the smallest denomination")
+	settlement := "TransID;PayID;Action;Amount;Currency;Fee;Date\nEF1995-000001-221216-122218-4132;;Capture;185,00;EUR;-35;2022-12-17\n"
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement", settlement, token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "reconciliation.settlement.invalid",
+		"invalid settlement file: line 2: invalid amount: '185,00' is not a number")
+}
+
+func TestReconcileSettlement_MissingColumn(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they upload a file that is not a settlement file")
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement", "name;value\nfoo;bar\n", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "reconciliation.settlement.invalid",
+		"invalid settlement file: missing column TransID in header")
+}
+
+func TestReconcileSettlement_InvalidFormat(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request the report in an unknown format")
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement?format=xlsx", tstSettlementFile(), token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "reconciliation.settlement.invalid", url.Values{
+		"format": []string{"must be one of json, csv"},
+	})
+}
+
+func TestReconcileSettlement_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated caller")
+	token := tstNoToken()
+
+	docs.When("when they attempt to upload a settlement file")
+	response := tstPerformPostCsv("/api/rest/v1/reconciliation/settlement", tstSettlementFile(), token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
+
+// --- helpers ---
+
+// tstSettlementFile is a settlement file in the Paygate layout, see test/resources/settlement-paygate.csv
+func tstSettlementFile() string {
+	content, err := os.ReadFile("../resources/settlement-paygate.csv")
+	if err != nil {
+		panic(err)
+	}
+	return string(content)
+}
+
+func tstInjectReconciliationData() {
+	for _, tx := range []struct {
+		id     string
+		status paymentservice.TransactionStatus
+	}{
+		{"EF1995-000001-221216-122218-4132", paymentservice.Valid},
+		{"EF1995-000002-221216-122218-4711", paymentservice.Pending},
+		{"EF1995-000003-221216-122218-1234", paymentservice.Valid},
+	} {
+		_ = paymentMock.InjectTransaction(context.TODO(), paymentservice.Transaction{
+			DebitorID: 1,
+			ID:        tx.id,
+			Type:      paymentservice.Payment,
+			Method:    paymentservice.Credit,
+			Amount: paymentservice.Amount{
+				Currency:  "EUR",
+				GrossCent: 18500,
+				VatRate:   19.0,
+			},
+			Status:        tx.status,
+			EffectiveDate: "2022-12-16",
+		})
+	}
+
+	db := database.GetRepository()
+	_ = db.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		ApiId:       "ef00000000000000000000000000cafe",
+		Kind:        entity.KindSuccess,
+		Message:     "transaction updated successfully",
+	})
+	_ = db.WriteProtocolEntry(context.TODO(), &entity.ProtocolEntry{
+		ReferenceId: "EF1995-000004-221216-122218-5555",
+		Kind:        entity.KindSuccess,
+		Message:     "create-pay-link",
+	})
+}
+
+func tstRequireReconciliationLine(t *testing.T, actual nexiapi.ReconciliationLineDto, expectedLine int, expectedReferenceId string, expected nexiapi.ReconciliationLineDto) {
+	require.Equal(t, expectedLine, actual.Line, "unexpected line number")
+	require.Equal(t, expectedReferenceId, actual.ReferenceId)
+	require.Equal(t, expected.Result, actual.Result)
+	require.Equal(t, expected.Problems, actual.Problems)
+}
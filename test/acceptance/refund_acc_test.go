@@ -0,0 +1,150 @@
+package acceptance
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/stretchr/testify/require"
+)
+
+// --- refund ---
+
+func TestRefund_AdminSuccess(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment that was captured in full at paygate")
+	id := "EF1995-000001-221216-122218-4132" // set up in paygate mock as OK 185.00 EUR, captured in full
+
+	docs.When("when an admin refunds it without giving an amount")
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/refund", "", tstValidAdminToken())
+
+	docs.Then("then the request is successful")
+	require.Equal(t, http.StatusNoContent, response.status, "unexpected http response status")
+
+	docs.Then("and everything that was captured has been refunded at paygate")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+		"RefundPayment 42 18500 EUR",
+	)
+
+	docs.Then("and the expected protocol entries have been written, recording the subject of the admin")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       "42",
+		Kind:        "success",
+		Message:     "get-payment",
+		Details:     "",
+		Subject:     tstAdminSubject,
+	}, entity.ProtocolEntry{
+		ReferenceId:    id,
+		ApiId:          "42",
+		Kind:           "success",
+		Message:        "refund",
+		Details:        "amount=18500 currency=EUR",
+		Subject:        tstAdminSubject,
+		Amount:         tstAmount(18500),
+		Currency:       "EUR",
+		UpstreamStatus: "OK",
+	})
+}
+
+func TestRefund_PartialThenTooMuch(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment of which a part was refunded")
+	id := "EF1995-000001-221216-122218-4132"
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/refund", `{"amount":5000}`, tstValidAdminToken())
+	require.Equal(t, http.StatusNoContent, response.status, "unexpected http response status")
+
+	docs.When("when an admin attempts to refund more than is left")
+	response = tstPerformPost("/api/rest/v1/paylinks/"+id+"/refund", `{"amount":13501}`, tstValidAdminToken())
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusConflict, "payment.update.conflict", map[string][]string{
+		"details": {"refund not possible: at most 13500 can be refunded"},
+	})
+
+	docs.Then("and only the partial refund has been made at paygate")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink "+id,
+		"RefundPayment 42 5000 EUR",
+		"QueryPaymentLink "+id,
+	)
+}
+
+func TestRefund_InvalidAmount(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an admin")
+	token := tstValidAdminToken()
+
+	docs.When("when they attempt to refund a payment with an unknown field in the body")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/refund", `{"value":5000}`, token)
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "paylink.parse.error", nil)
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
+
+func TestRefund_NexiNotFound(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an admin")
+	token := tstValidAdminToken()
+
+	docs.When("when they attempt to refund a payment that does not exist in paygate")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000017-000000-000000-0000/refund", "", token)
+
+	docs.Then("then the request fails with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusNotFound, "payment.refid.notfound", nil)
+
+	docs.Then("and no refund has been attempted")
+	tstRequireNexiRecording(t,
+		"QueryPaymentLink EF1995-000017-000000-000000-0000",
+	)
+}
+
+func TestRefund_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated caller")
+	token := tstNoToken()
+
+	docs.When("when they attempt to refund an existing payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/refund", "", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+}
+
+func TestRefund_ClientMissingScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client who supplies its own api key, which does not allow refunds")
+	token := tstValidRegsysApiToken()
+
+	docs.When("when they attempt to refund an existing payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/refund", "", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
@@ -0,0 +1,196 @@
+package acceptance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
+	"github.com/eurofurence/reg-paygate-adapter/internal/service/protocolsrv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention_Anonymize(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a retention policy that anonymizes raw entries after 30 days")
+	config.Configuration().Database.Retention = config.RetentionConfig{
+		AnonymizeRawAfterDays: 30,
+	}
+
+	docs.Given("and protocol entries with personal data")
+	tstInjectRetentionProtocolEntries()
+
+	docs.When("when the retention policy is applied 31 days later")
+	err := tstRetentionService(31).ApplyRetentionPolicy(context.TODO())
+	require.Nil(t, err)
+
+	docs.Then("then personal data has been removed from the raw entries, and all other entries are unchanged")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		Kind:        "raw",
+		Message:     "nexi create request",
+		Details:     `{"transId":"EF1995-000001-221216-122218-4132","amount":{"value":18500,"currency":"EUR"},"customerInfo":{"email":"***"}}`,
+	}, entity.ProtocolEntry{
+		Kind:    "raw",
+		Message: "webhook request",
+		Details: "(anonymized)",
+	}, entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		Kind:        "success",
+		Message:     "create-pay-link",
+		Details:     "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+	}, entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		Kind:        "error",
+		Message:     "get-payment failed",
+		Details:     "downstream unavailable - see log for details",
+	})
+}
+
+func TestRetention_NothingDueYet(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a retention policy with all parts configured")
+	config.Configuration().Database.Retention = config.RetentionConfig{
+		AnonymizeRawAfterDays: 30,
+		DeleteRawAfterDays:    60,
+		DeleteErrorsAfterDays: 120,
+		DeleteAfterDays:       90,
+	}
+
+	docs.Given("and some protocol entries")
+	tstInjectRetentionProtocolEntries()
+
+	docs.When("when the retention policy is applied 29 days later")
+	err := tstRetentionService(29).ApplyRetentionPolicy(context.TODO())
+	require.Nil(t, err)
+
+	docs.Then("then no entries have been changed")
+	entries, _, err := database.GetRepository().QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
+	require.Equal(t, 4, len(entries))
+	for _, e := range entries {
+		require.False(t, e.Anonymized)
+	}
+}
+
+func TestRetention_DeleteByKind(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a retention policy that keeps errors longer than raw entries")
+	config.Configuration().Database.Retention = config.RetentionConfig{
+		AnonymizeRawAfterDays: 30,
+		DeleteRawAfterDays:    60,
+		DeleteErrorsAfterDays: 120,
+		DeleteAfterDays:       90,
+	}
+
+	docs.Given("and some protocol entries")
+	tstInjectRetentionProtocolEntries()
+
+	docs.When("when the retention policy is applied 91 days later")
+	err := tstRetentionService(91).ApplyRetentionPolicy(context.TODO())
+	require.Nil(t, err)
+
+	docs.Then("then only the error entry is left")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		Kind:        "error",
+		Message:     "get-payment failed",
+		Details:     "downstream unavailable - see log for details",
+	})
+
+	docs.When("when the retention policy is applied 121 days later")
+	err = tstRetentionService(121).ApplyRetentionPolicy(context.TODO())
+	require.Nil(t, err)
+
+	docs.Then("then no entries are left")
+	tstRequireProtocolEntries(t)
+}
+
+func TestRetention_DeletePaylinks(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a retention policy that deletes paylink records after 365 days")
+	config.Configuration().Database.Retention = config.RetentionConfig{
+		DeletePaylinksAfterDays: 365,
+	}
+
+	docs.Given("and a paylink record")
+	db := database.GetRepository()
+	require.Nil(t, db.WritePaylink(context.TODO(), &entity.Paylink{
+		ReferenceId: "EF1995-000001-221216-122218-4132",
+		DebitorId:   1,
+		Amount:      18500,
+		Currency:    "EUR",
+		Link:        "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+	}))
+
+	docs.When("when the retention policy is applied 364 days later")
+	err := tstRetentionService(364).ApplyRetentionPolicy(context.TODO())
+	require.Nil(t, err)
+
+	docs.Then("then the paylink record is kept")
+	_, total, err := db.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+
+	docs.When("when the retention policy is applied 366 days later")
+	err = tstRetentionService(366).ApplyRetentionPolicy(context.TODO())
+	require.Nil(t, err)
+
+	docs.Then("then the paylink record is gone")
+	_, total, err = db.QueryPaylinks(context.TODO(), dbrepo.PaylinkQuery{})
+	require.Nil(t, err)
+	require.Equal(t, int64(0), total)
+}
+
+// --- helpers ---
+
+func tstRetentionService(daysLater int) protocolsrv.ProtocolService {
+	return &protocolsrv.Impl{
+		Now: func() time.Time {
+			return time.Now().AddDate(0, 0, daysLater)
+		},
+	}
+}
+
+func tstInjectRetentionProtocolEntries() {
+	db := database.GetRepository()
+	for _, e := range []entity.ProtocolEntry{
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			Kind:        "raw",
+			Message:     "nexi create request",
+			Details:     `{"transId":"EF1995-000001-221216-122218-4132","amount":{"value":18500,"currency":"EUR"},"customerInfo":{"email":"jsquirrel_github_9a6d@packetloss.de"}}`,
+		},
+		{
+			Kind:    "raw",
+			Message: "webhook request",
+			Details: `not a json payload from jsquirrel_github_9a6d@packetloss.de`,
+		},
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			Kind:        "success",
+			Message:     "create-pay-link",
+			Details:     "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+		},
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			Kind:        "error",
+			Message:     "get-payment failed",
+			Details:     "downstream unavailable - see log for details",
+		},
+	} {
+		_ = db.WriteProtocolEntry(context.TODO(), &e)
+	}
+}
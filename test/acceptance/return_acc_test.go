@@ -0,0 +1,204 @@
+package acceptance
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
+	"github.com/stretchr/testify/require"
+)
+
+// --- return from the hosted payment page ---
+
+func TestReturn_PaidPending(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a transaction in status pending and matching payment in status OK, but no webhook yet")
+	id := "EF1995-000001-221216-122218-4132" // set up in paygate mock as OK 185.00 EUR
+	tx, payment := tstInjectCreditPaymentTransaction(t, id, 18500, "pending")
+
+	docs.When("when the attendee returns from the hosted payment page")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+
+	docs.Then("then they are redirected to the success page, with the reference id and outcome")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=paid&refid="+id+"&result=success", response.location)
+
+	docs.Then("and the transaction has been changed to valid by the status check")
+	tx.Status = "valid"
+	tx.Comment = "CC paymentId 42"
+	tstRequirePaymentServiceRecording(t, []paymentservice.Transaction{tx})
+
+	docs.Then("and the expected protocol entries have been written")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       payment.Id,
+		Kind:        "success",
+		Message:     "get-payment",
+		Details:     "",
+	}, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       payment.Id,
+		Kind:        "success",
+		Message:     "transaction updated successfully by status-check",
+		Details:     "amount=18500 currency=EUR upstream=OK",
+	})
+}
+
+func TestReturn_AlreadyValid(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a transaction that the webhook has already set to valid")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "valid")
+	nexiMock.Reset()
+
+	docs.When("when the attendee returns from the hosted payment page")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+
+	docs.Then("then they are redirected to the success page")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=paid&refid="+id+"&result=success", response.location)
+
+	docs.Then("and the payment has not been checked again, so no conflict is reported")
+	tstRequireNexiRecording(t)
+	tstRequireProtocolEntries(t)
+	tstRequireMailServiceRecording(t, nil)
+	tstRequirePaymentServiceRecording(t, nil)
+}
+
+func TestReturn_NotYetPaid(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a transaction in status pending and matching payment in status AUTHORIZED")
+	id := "EF1995-000001-230001-122218-5555" // set up in paygate mock as AUTHORIZED 390.00 EUR
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 39000, "pending")
+
+	docs.When("when the attendee returns from the hosted payment page")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+
+	docs.Then("then they are redirected to the failure page, which is told the payment is still pending")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=pending&refid="+id+"&result=failure", response.location)
+
+	docs.Then("and the transaction is unchanged")
+	tstRequirePaymentServiceRecording(t, nil)
+}
+
+func TestReturn_Deleted(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a transaction that has been deleted")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "deleted")
+	nexiMock.Reset()
+
+	docs.When("when the attendee returns from the hosted payment page")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+
+	docs.Then("then they are redirected to the failure page")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=failed&refid="+id+"&result=failure", response.location)
+
+	docs.Then("and the payment has not been checked")
+	tstRequireNexiRecording(t)
+}
+
+func TestReturn_PaySrvNotFound(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a reference id that the payment service does not know")
+	id := "EF1995-000001-230001-122218-5555"
+
+	docs.When("when someone calls the return url for it")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+
+	docs.Then("then they are redirected to the failure page, which is told the outcome is unknown")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=unknown&refid="+id+"&result=failure", response.location)
+}
+
+func TestReturn_RequestedPages(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a paid transaction whose paylink was created with its own return pages")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "valid")
+	query := url.Values{
+		"success": {"https://rooms.example.com/shop/paid?booking=17"},
+		"cancel":  {"https://rooms.example.com/shop/cancelled"},
+	}
+
+	docs.When("when the attendee returns from the hosted payment page")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id + "?" + query.Encode())
+
+	docs.Then("then they are redirected to the requested success page")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://rooms.example.com/shop/paid?booking=17&outcome=paid&refid="+id, response.location)
+}
+
+func TestReturn_RequestedPageNotAllowed(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a paid transaction")
+	id := "EF1995-000001-221216-122218-4132"
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 18500, "valid")
+
+	docs.When("when someone calls the return url with a success page that is not in the redirect allowlist")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id + "?success=" + url.QueryEscape("https://evil.example.com/phish"))
+
+	docs.Then("then they are redirected to the configured success page instead")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=paid&refid="+id+"&result=success", response.location)
+}
+
+func TestReturn_RateLimitedPerRefId(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a pending transaction")
+	id := "EF1995-000001-230001-122218-5555" // set up in paygate mock as AUTHORIZED 390.00 EUR
+	_, _ = tstInjectCreditPaymentTransaction(t, id, 39000, "pending")
+
+	docs.When("when someone calls its return url more often than the burst allows")
+	for i := 0; i < 3; i++ {
+		response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+		require.Equal(t, http.StatusSeeOther, response.status)
+	}
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/" + id)
+
+	docs.Then("then the request is rejected, and no further status check is made")
+	tstRequireErrorResponse(t, response, http.StatusTooManyRequests, "request.rate.limited", nil)
+	require.NotEmpty(t, response.retryAfter)
+	require.Len(t, nexiMock.Recording(), 4) // injecting the transaction queries the mock once
+
+	docs.Then("and returns for other reference ids are not affected")
+	response = tstPerformGetNoRedirect("/api/rest/v1/return/EF1995-000001-221216-122218-4132")
+	require.Equal(t, http.StatusSeeOther, response.status)
+}
+
+func TestReturn_InvalidId(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.When("when someone calls the return url with an invalid reference id")
+	response := tstPerformGetNoRedirect("/api/rest/v1/return/EF1995-%3Cscript%3E")
+
+	docs.Then("then they are redirected to the failure page, without the reference id")
+	require.Equal(t, http.StatusSeeOther, response.status)
+	require.Equal(t, "https://reg.example.com/register/payment?outcome=unknown&result=failure", response.location)
+
+	docs.Then("and no downstream requests have been made")
+	tstRequireNexiRecording(t)
+	tstRequirePaymentServiceRecording(t, nil)
+}
@@ -3,12 +3,14 @@ package acceptance
 import (
 	"context"
 	"net/http/httptest"
+	"os"
 	"time"
 
 	aulogging "github.com/StephanHCB/go-autumn-logging"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/attendeeservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/config"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/idp"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/nexi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
@@ -26,7 +28,17 @@ var (
 	nexiMock     nexi.Mock
 )
 
-const tstConfigFile = "../resources/testconfig.yaml"
+// tstConfigFile selects the database the acceptance tests run against.
+//
+// Set TEST_DATABASE=sqlite to run them against an sqlite database instead of the in-memory repository.
+var tstConfigFile = tstConfigFileForDatabase(os.Getenv("TEST_DATABASE"))
+
+func tstConfigFileForDatabase(use string) string {
+	if use == "sqlite" {
+		return "../resources/testconfig-sqlite.yaml"
+	}
+	return "../resources/testconfig.yaml"
+}
 
 const isoDateTimeFormat = "2006-01-02T15:04:05-07:00"
 
@@ -38,10 +50,12 @@ func tstMockNow() time.Time {
 func tstSetup(configFilePath string) {
 	tstSetupConfig(configFilePath)
 	database.Open()
+	_ = database.GetRepository().Migrate()
 	attendeeMock = attendeeservice.CreateMock()
 	mailMock = mailservice.CreateMock()
 	paymentMock = paymentservice.CreateMock()
 	nexiMock = nexi.CreateMock()
+	_ = idp.Create()
 	paymentlinksrv.NowFunc = tstMockNow
 	tstSetupHttpTestServer()
 }
@@ -0,0 +1,331 @@
+package acceptance
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
+	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_Success(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectStatsProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request the statistics for today")
+	today := time.Now().UTC().Format("2006-01-02")
+	response := tstPerformGet("/api/rest/v1/stats?from="+today+"&to="+today, token)
+
+	docs.Then("then the request is successful and the protocol is aggregated by day, payment method and currency")
+	require.Equal(t, http.StatusOK, response.status)
+	actual := nexiapi.PaymentStatsDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, nexiapi.PaymentStatsDto{
+		From:     today,
+		To:       today,
+		Timezone: "UTC",
+		Groups: []nexiapi.PaymentStatsGroupDto{
+			{
+				Day:      today,
+				Currency: "EUR",
+				Created:  nexiapi.PaymentStatsValueDto{Count: 3, Volume: 18500 + 18500 + 4000},
+			},
+			{
+				Day:           today,
+				PaymentMethod: "CARD",
+				Currency:      "EUR",
+				Confirmed:     nexiapi.PaymentStatsValueDto{Count: 2, Volume: 18500 + 18500},
+				Declined:      nexiapi.PaymentStatsValueDto{Count: 2, Volume: 4000 + 2500},
+				Refunded:      nexiapi.PaymentStatsValueDto{Count: 1, Volume: 18500},
+			},
+			{
+				Day:           today,
+				PaymentMethod: "PAYPAL",
+				Currency:      "EUR",
+				Pending:       nexiapi.PaymentStatsValueDto{Count: 1, Volume: 4000},
+			},
+		},
+	}, actual)
+}
+
+func TestStats_RefundAndCancel(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment that has been refunded and another one that has been cancelled through this adapter")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/refund", `{"amount":5000}`, tstValidAdminToken())
+	require.Equal(t, http.StatusNoContent, response.status)
+	response = tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-230001-122218-6666/cancel", "", tstValidAdminToken())
+	require.Equal(t, http.StatusNoContent, response.status)
+
+	docs.When("when the statistics for today are requested")
+	today := time.Now().UTC().Format("2006-01-02")
+	response = tstPerformGet("/api/rest/v1/stats?from="+today+"&to="+today, tstValidApiToken())
+
+	docs.Then("then the refund is counted as refunded and the cancellation as declined, the payment queries are not counted")
+	require.Equal(t, http.StatusOK, response.status)
+	actual := nexiapi.PaymentStatsDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, []nexiapi.PaymentStatsGroupDto{
+		{
+			Day:           today,
+			PaymentMethod: "CARD",
+			Currency:      "EUR",
+			Declined:      nexiapi.PaymentStatsValueDto{Count: 1, Volume: 39000},
+			Refunded:      nexiapi.PaymentStatsValueDto{Count: 1, Volume: 5000},
+		},
+	}, actual.Groups)
+}
+
+func TestStats_CancelConfirmedByWebhook(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment that has been cancelled through this adapter")
+	id := "EF1995-000001-230001-122218-6666"
+	response := tstPerformPost("/api/rest/v1/paylinks/"+id+"/cancel", "", tstValidAdminToken())
+	require.Equal(t, http.StatusNoContent, response.status)
+
+	docs.When("when paygate confirms the cancellation with a webhook, and the statistics for today are requested")
+	response = tstPerformPost("/api/rest/v1/webhook/demosecret", tstBuildValidWebhookRequest(t, id, "CANCELLED", 39000), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+	today := time.Now().UTC().Format("2006-01-02")
+	response = tstPerformGet("/api/rest/v1/stats?from="+today+"&to="+today, tstValidApiToken())
+
+	docs.Then("then the cancellation is only counted once")
+	require.Equal(t, http.StatusOK, response.status)
+	actual := nexiapi.PaymentStatsDto{}
+	tstParseJson(response.body, &actual)
+	require.Len(t, actual.Groups, 1)
+	require.Equal(t, nexiapi.PaymentStatsValueDto{Count: 1, Volume: 39000}, actual.Groups[0].Declined)
+
+	docs.Then("and no one has been notified about an unexpected webhook status")
+	tstRequireMailServiceRecording(t, []mailservice.MailSendDto{})
+}
+
+func TestStats_Empty(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+	tstInjectStatsProtocolEntries()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request the statistics for a range of days without activity")
+	response := tstPerformGet("/api/rest/v1/stats?from=2022-12-01&to=2022-12-31&timezone=Europe/Berlin", token)
+
+	docs.Then("then the request is successful and no groups are returned")
+	require.Equal(t, http.StatusOK, response.status)
+	actual := nexiapi.PaymentStatsDto{}
+	tstParseJson(response.body, &actual)
+	require.Equal(t, nexiapi.PaymentStatsDto{
+		From:     "2022-12-01",
+		To:       "2022-12-31",
+		Timezone: "Europe/Berlin",
+		Groups:   []nexiapi.PaymentStatsGroupDto{},
+	}, actual)
+}
+
+func TestStats_InvalidParameters(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request the statistics with an invalid date and time zone")
+	response := tstPerformGet("/api/rest/v1/stats?from=yesterday&timezone=Middle/Earth", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "stats.query.invalid", url.Values{
+		"from":     []string{"must be a date in format YYYY-MM-DD, e.g. 2025-08-01"},
+		"timezone": []string{"must be a time zone name, e.g. Europe/Berlin"},
+	})
+}
+
+func TestStats_InvalidRange(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request the statistics for a range that ends before it starts")
+	response := tstPerformGet("/api/rest/v1/stats?from=2022-12-31&to=2022-12-01", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "stats.query.invalid", url.Values{
+		"to": []string{"must not be before from"},
+	})
+}
+
+func TestStats_RangeTooLong(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a correct api token")
+	token := tstValidApiToken()
+
+	docs.When("when they request the statistics for more than a year")
+	response := tstPerformGet("/api/rest/v1/stats?from=2022-01-01&to=2023-01-02", token)
+
+	docs.Then("then the request is denied with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusBadRequest, "stats.query.invalid", url.Values{
+		"to": []string{"must not be more than 366 days including from"},
+	})
+}
+
+func TestStats_Anonymous(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an unauthenticated caller")
+	token := tstNoToken()
+
+	docs.When("when they attempt to request the statistics")
+	response := tstPerformGet("/api/rest/v1/stats", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "you must be logged in for this operation")
+}
+
+// --- helpers ---
+
+func tstInjectStatsProtocolEntries() {
+	db := database.GetRepository()
+	for _, e := range []entity.ProtocolEntry{
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			Kind:        entity.KindSuccess,
+			Message:     "create-pay-link",
+			Operation:   entity.OperationCreate,
+			Details:     "http://localhost:1111/some/paylink/EF1995-000001-221216-122218-4132",
+			Amount:      tstAmount(18500),
+			Currency:    "EUR",
+		},
+		{
+			ReferenceId: "EF1995-000001-221216-122218-4132",
+			Kind:        entity.KindRaw,
+			Message:     "webhook request",
+			Details:     `{"transId":"EF1995-000001-221216-122218-4132"}`,
+		},
+		{
+			ReferenceId:       "EF1995-000001-221216-122218-4132",
+			Kind:              entity.KindSuccess,
+			Message:           "transaction updated successfully",
+			Details:           "amount=18500 currency=EUR",
+			Amount:            tstAmount(18500),
+			Currency:          "EUR",
+			WebhookStatus:     "OK",
+			UpstreamStatus:    "OK",
+			TransactionStatus: "valid",
+			PaymentMethod:     "CARD",
+		},
+		{
+			ReferenceId: "EF1995-000002-221216-122218-4711",
+			Kind:        entity.KindSuccess,
+			Message:     "create-pay-link",
+			Operation:   entity.OperationCreate,
+			Details:     "http://localhost:1111/some/paylink/EF1995-000002-221216-122218-4711",
+			Amount:      tstAmount(18500),
+			Currency:    "EUR",
+		},
+		{
+			ReferenceId:       "EF1995-000002-221216-122218-4711",
+			Kind:              entity.KindSuccess,
+			Message:           "transaction updated successfully by status-check",
+			Amount:            tstAmount(18500),
+			Currency:          "EUR",
+			UpstreamStatus:    "OK",
+			TransactionStatus: "valid",
+			PaymentMethod:     "CARD",
+		},
+		{
+			ReferenceId:   "EF1995-000002-221216-122218-4711",
+			Kind:          entity.KindError,
+			Message:       "webhook REFUNDED unknown status",
+			Amount:        tstAmount(18500),
+			Currency:      "EUR",
+			WebhookStatus: "REFUNDED",
+			PaymentMethod: "CARD",
+		},
+		{
+			ReferenceId: "EF1995-000003-221216-122218-1234",
+			Kind:        entity.KindSuccess,
+			Message:     "create-pay-link",
+			Operation:   entity.OperationCreate,
+			Amount:      tstAmount(4000),
+			Currency:    "EUR",
+		},
+		{
+			ReferenceId:   "EF1995-000003-221216-122218-1234",
+			Kind:          entity.KindError,
+			Message:       "webhook FAILED unknown status",
+			Amount:        tstAmount(4000),
+			Currency:      "EUR",
+			WebhookStatus: "FAILED",
+			PaymentMethod: "CARD",
+		},
+		{
+			ReferenceId:       "EF1995-000004-221216-122218-5555",
+			Kind:              entity.KindPending,
+			Message:           "transaction updated to PENDING",
+			Details:           "amount=4000 currency=EUR",
+			Amount:            tstAmount(4000),
+			Currency:          "EUR",
+			WebhookStatus:     "OK",
+			UpstreamStatus:    "AUTHORIZED",
+			TransactionStatus: "pending",
+			PaymentMethod:     "PAYPAL",
+		},
+		{
+			ReferenceId: "EF1995-000004-221216-122218-5555",
+			Kind:        entity.KindError,
+			Message:     "get-payment failed",
+			Details:     "downstream unavailable - see log for details",
+		},
+		{
+			ReferenceId:       "EF1995-000005-221216-122218-6666",
+			Kind:              entity.KindWarning,
+			Message:           "verified status not OK",
+			Details:           "webhook=OK verified=FAILED",
+			WebhookStatus:     "OK",
+			UpstreamStatus:    "FAILED",
+			TransactionStatus: "tentative",
+			PaymentMethod:     "CARD",
+		},
+		{
+			ReferenceId:       "EF1995-000005-221216-122218-6666",
+			Kind:              entity.KindPending,
+			Message:           "transaction updated to PENDING",
+			Details:           "amount=2500 currency=EUR",
+			Amount:            tstAmount(2500),
+			Currency:          "EUR",
+			WebhookStatus:     "OK",
+			UpstreamStatus:    "FAILED",
+			TransactionStatus: "pending",
+			PaymentMethod:     "CARD",
+		},
+		{
+			ReferenceId:   "XX1995-000006-221216-122218-7777",
+			Kind:          entity.KindError,
+			Message:       "webhook OK ref-id-prefix wrong",
+			Details:       "expecting prefix EF",
+			WebhookStatus: "OK",
+			PaymentMethod: "CARD",
+		},
+	} {
+		_ = db.WriteProtocolEntry(context.TODO(), &e)
+	}
+}
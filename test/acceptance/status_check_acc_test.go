@@ -159,6 +159,117 @@ func TestStatusCheck_WrongToken(t *testing.T) {
 	tstRequireProtocolEntries(t)
 }
 
+func TestStatusCheck_AdminSuccess(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a transaction in status pending and matching payment in status OK")
+	id := "EF1995-000001-221216-122218-4132" // set up in paygate mock as OK 185.00 EUR
+	tx, payment := tstInjectCreditPaymentTransaction(t, id, 18500, "pending")
+
+	docs.When("when an admin triggers a status check using a bearer token")
+	response := tstTriggerStatusCheck(t, id, tstValidAdminToken())
+
+	docs.Then("then the request is successful")
+	tstRequirePaymentResponse(t, response, http.StatusOK, payment)
+
+	docs.Then("and the expected protocol entries have been written, recording the subject of the admin")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       payment.Id,
+		Kind:        "success",
+		Message:     "get-payment",
+		Details:     "",
+		Subject:     tstAdminSubject,
+	}, entity.ProtocolEntry{
+		ReferenceId: id,
+		ApiId:       payment.Id,
+		Kind:        "success",
+		Message:     "transaction updated successfully by status-check",
+		Details:     "amount=18500 currency=EUR upstream=OK",
+		Subject:     tstAdminSubject,
+	})
+
+	docs.Then("and the transaction has been changed to valid")
+	tx.Status = "valid"
+	tx.Comment = "CC paymentId 42"
+	tstRequirePaymentServiceRecording(t, []paymentservice.Transaction{tx})
+}
+
+func TestStatusCheck_MissingRole(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies a valid bearer token without a role that allows status checks")
+	token := tstValidAuditorToken()
+
+	docs.When("when they attempt to trigger a status check for an existing payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/status-check", "", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
+
+func TestStatusCheck_ClientMissingScope(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a client who supplies its own api key, which does not allow status checks")
+	token := tstValidAdminToolApiToken()
+
+	docs.When("when they attempt to trigger a status check for an existing payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/status-check", "", token)
+
+	docs.Then("then the request is denied as unauthorized (403) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", "you are not authorized for this operation")
+
+	docs.Then("and no requests to the payment provider have been made")
+	require.Empty(t, nexiMock.Recording())
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
+
+func TestStatusCheck_ExpiredBearerToken(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies an expired admin bearer token")
+	token := tstExpiredAdminToken()
+
+	docs.When("when they attempt to trigger a status check for an existing payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/status-check", "", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid bearer token")
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
+
+func TestStatusCheck_InvalidBearerSignature(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who supplies an admin bearer token with an invalid signature")
+	token := tstInvalidSignatureAdminToken()
+
+	docs.When("when they attempt to trigger a status check for an existing payment")
+	response := tstPerformPost("/api/rest/v1/paylinks/EF1995-000001-221216-122218-4132/status-check", "", token)
+
+	docs.Then("then the request is denied as unauthenticated (401) with the appropriate error message")
+	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", "invalid bearer token")
+
+	docs.Then("and no protocol entries have been written")
+	tstRequireProtocolEntries(t)
+}
+
 func TestStatusCheck_DownstreamError(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -1,6 +1,8 @@
 package acceptance
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,12 +10,14 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database"
-	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/inmemorydb"
+	"github.com/eurofurence/reg-paygate-adapter/internal/repository/database/dbrepo"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
 
@@ -30,6 +34,7 @@ type tstWebResponse struct {
 	body        string
 	contentType string
 	location    string
+	retryAfter  string
 }
 
 func tstWebResponseFromResponse(response *http.Response) tstWebResponse {
@@ -55,6 +60,7 @@ func tstWebResponseFromResponse(response *http.Response) tstWebResponse {
 		body:        string(body),
 		contentType: ct,
 		location:    loc,
+		retryAfter:  response.Header.Get(headers.RetryAfter),
 	}
 }
 
@@ -63,9 +69,7 @@ func tstPerformGet(relativeUrlWithLeadingSlash string, apiToken string) tstWebRe
 	if err != nil {
 		log.Fatal(err)
 	}
-	if apiToken != "" {
-		request.Header.Set(media.HeaderXApiKey, apiToken)
-	}
+	tstSetToken(request, apiToken)
 	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		log.Fatal(err)
@@ -73,15 +77,58 @@ func tstPerformGet(relativeUrlWithLeadingSlash string, apiToken string) tstWebRe
 	return tstWebResponseFromResponse(response)
 }
 
+func tstPerformGetNoRedirect(relativeUrlWithLeadingSlash string) tstWebResponse {
+	request, err := http.NewRequest(http.MethodGet, ts.URL+relativeUrlWithLeadingSlash, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tstWebResponseFromResponse(response)
+}
+
 func tstPerformPost(relativeUrlWithLeadingSlash string, requestBody string, apiToken string) tstWebResponse {
 	request, err := http.NewRequest(http.MethodPost, ts.URL+relativeUrlWithLeadingSlash, strings.NewReader(requestBody))
 	if err != nil {
 		log.Fatal(err)
 	}
-	if apiToken != "" {
-		request.Header.Set(media.HeaderXApiKey, apiToken)
+	tstSetToken(request, apiToken)
+	request.Header.Set(headers.ContentType, media.ContentTypeApplicationJson)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tstWebResponseFromResponse(response)
+}
+
+func tstPerformPostCsv(relativeUrlWithLeadingSlash string, requestBody string, apiToken string) tstWebResponse {
+	request, err := http.NewRequest(http.MethodPost, ts.URL+relativeUrlWithLeadingSlash, strings.NewReader(requestBody))
+	if err != nil {
+		log.Fatal(err)
+	}
+	tstSetToken(request, apiToken)
+	request.Header.Set(headers.ContentType, "text/csv")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tstWebResponseFromResponse(response)
+}
+
+func tstPerformPostForwardedFor(relativeUrlWithLeadingSlash string, requestBody string, forwardedFor string) tstWebResponse {
+	request, err := http.NewRequest(http.MethodPost, ts.URL+relativeUrlWithLeadingSlash, strings.NewReader(requestBody))
+	if err != nil {
+		log.Fatal(err)
 	}
 	request.Header.Set(headers.ContentType, media.ContentTypeApplicationJson)
+	request.Header.Set(headers.XForwardedFor, forwardedFor)
 	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		log.Fatal(err)
@@ -94,9 +141,7 @@ func tstPerformDelete(relativeUrlWithLeadingSlash string, apiToken string) tstWe
 	if err != nil {
 		log.Fatal(err)
 	}
-	if apiToken != "" {
-		request.Header.Set(media.HeaderXApiKey, apiToken)
-	}
+	tstSetToken(request, apiToken)
 	response, err := http.DefaultClient.Do(request)
 	if err != nil {
 		log.Fatal(err)
@@ -104,6 +149,87 @@ func tstPerformDelete(relativeUrlWithLeadingSlash string, apiToken string) tstWe
 	return tstWebResponseFromResponse(response)
 }
 
+// tstOpenEventStream opens a payment event stream and parses the events it receives, until closed.
+func tstOpenEventStream(t *testing.T, relativeUrlWithLeadingSlash string, apiToken string) (<-chan nexiapi.PaymentEventDto, func()) {
+	return tstResumeEventStream(t, relativeUrlWithLeadingSlash, apiToken, "")
+}
+
+// tstResumeEventStream is tstOpenEventStream, sending lastEventId like a reconnecting EventSource client.
+func tstResumeEventStream(t *testing.T, relativeUrlWithLeadingSlash string, apiToken string, lastEventId string) (<-chan nexiapi.PaymentEventDto, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+relativeUrlWithLeadingSlash, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tstSetToken(request, apiToken)
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, media.ContentTypeTextEventStream, response.Header.Get(headers.ContentType))
+
+	events := make(chan nexiapi.PaymentEventDto, 10)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(response.Body)
+		eventId, eventType, data := "", "", ""
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				eventId = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				eventType = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && eventType == "status":
+				event := nexiapi.PaymentEventDto{}
+				tstParseJson(data, &event)
+				if eventId != "" {
+					parsed, err := strconv.ParseUint(eventId, 10, 64)
+					require.Nil(t, err)
+					event.EventId = uint(parsed)
+				}
+				events <- event
+				eventId, eventType, data = "", "", ""
+			}
+		}
+	}()
+
+	return events, func() {
+		cancel()
+		_ = response.Body.Close()
+	}
+}
+
+// tstRequireNextEvent compares the next event to expected, and returns its event id, which must be set.
+func tstRequireNextEvent(t *testing.T, events <-chan nexiapi.PaymentEventDto, expected nexiapi.PaymentEventDto) uint {
+	select {
+	case actual, ok := <-events:
+		require.True(t, ok, "event stream closed unexpectedly")
+		require.NotZero(t, actual.EventId, "event must have an id to resume from")
+		expected.EventId = actual.EventId
+		require.Equal(t, expected, actual)
+		return actual.EventId
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no event received")
+		return 0
+	}
+}
+
+// tstSetToken sends bearer tokens in the Authorization header, and anything else as an api token.
+func tstSetToken(request *http.Request, token string) {
+	if strings.HasPrefix(token, "Bearer ") {
+		request.Header.Set(media.HeaderAuthorization, token)
+	} else if token != "" {
+		request.Header.Set(media.HeaderXApiKey, token)
+	}
+}
+
 func tstRenderJson(v interface{}) string {
 	representationBytes, err := json.Marshal(v)
 	if err != nil {
@@ -275,13 +401,12 @@ func tstExpectedMailNotification(operation string, status string) mailservice.Ma
 }
 
 func tstClearDatabase() {
-	db := database.GetRepository().(*inmemorydb.InMemoryRepository)
-	db.Clear()
+	_, _ = database.GetRepository().DeleteProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
 }
 
 func tstRequireProtocolEntries(t *testing.T, expectedProtocol ...entity.ProtocolEntry) {
-	db := database.GetRepository().(*inmemorydb.InMemoryRepository)
-	actualProtocol := db.ProtocolEntries()
+	actualProtocol, _, err := database.GetRepository().QueryProtocolEntries(context.TODO(), dbrepo.ProtocolQuery{})
+	require.Nil(t, err)
 	require.Equal(t, len(expectedProtocol), len(actualProtocol))
 	for i, expected := range expectedProtocol {
 		actual := *(actualProtocol[i])
@@ -290,5 +415,24 @@ func tstRequireProtocolEntries(t *testing.T, expectedProtocol ...entity.Protocol
 		require.Equal(t, expected.Kind, actual.Kind)
 		require.Equal(t, expected.Message, actual.Message)
 		require.Equal(t, expected.Details, actual.Details)
+		require.Equal(t, expected.Subject, actual.Subject)
+		if expected.Client != "" {
+			require.Equal(t, expected.Client, actual.Client)
+		}
+		if expected.Operation != "" {
+			require.Equal(t, expected.Operation, actual.Operation)
+		}
+		if expected.Amount != nil || expected.Currency != "" || expected.UpstreamStatus != "" || expected.WebhookStatus != "" || expected.TransactionStatus != "" {
+			// only compare structured data where the test sets expectations for it
+			require.Equal(t, expected.Amount, actual.Amount)
+			require.Equal(t, expected.Currency, actual.Currency)
+			require.Equal(t, expected.UpstreamStatus, actual.UpstreamStatus)
+			require.Equal(t, expected.WebhookStatus, actual.WebhookStatus)
+			require.Equal(t, expected.TransactionStatus, actual.TransactionStatus)
+		}
 	}
 }
+
+func tstAmount(value int64) *int64 {
+	return &value
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/eurofurence/reg-paygate-adapter/docs"
+	"github.com/eurofurence/reg-paygate-adapter/internal/api/v1/nexiapi"
 	"github.com/eurofurence/reg-paygate-adapter/internal/entity"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/mailservice"
 	"github.com/eurofurence/reg-paygate-adapter/internal/repository/paymentservice"
@@ -104,11 +105,16 @@ func TestWebhook_Success_TentativeNoDiff(t *testing.T) {
 		[]mailservice.MailSendDto{},
 		[]entity.ProtocolEntry{
 			{
-				ReferenceId: "EF1995-000001-221216-122218-4132",
-				ApiId:       "ef00000000000000000000000000cafe",
-				Kind:        "success",
-				Message:     "transaction updated successfully",
-				Details:     "amount=18500 currency=EUR",
+				ReferenceId:       "EF1995-000001-221216-122218-4132",
+				ApiId:             "ef00000000000000000000000000cafe",
+				Kind:              "success",
+				Message:           "transaction updated successfully",
+				Details:           "amount=18500 currency=EUR",
+				Amount:            tstAmount(18500),
+				Currency:          "EUR",
+				UpstreamStatus:    "OK",
+				WebhookStatus:     "OK",
+				TransactionStatus: "valid",
 			},
 		},
 	)
@@ -524,11 +530,44 @@ func TestWebhook_Error_Valid(t *testing.T) {
 				Kind:        "warning",
 				Message:     "webhook payment already in status valid",
 				Details:     "existing_amount=10500 ignored_amount=18500 existing_currency=USD ignored_currency=EUR webhook_status=OK upstream_status=OK",
+				// like all other entries, the structured amount is the one reported by Paygate
+				Amount:            tstAmount(18500),
+				Currency:          "EUR",
+				UpstreamStatus:    "OK",
+				WebhookStatus:     "OK",
+				TransactionStatus: "valid",
 			},
 		},
 	)
 }
 
+func TestWebhook_RepeatedValid(t *testing.T) {
+	docs.Description("webhook with status OK for a tx that is already valid with the same amount does nothing, it was repeated or the return check was faster")
+	tstWebhookSuccessCase(t,
+		"EF1995-000001-221216-122218-4132",
+		"OK",
+		18500,
+		paymentservice.Transaction{
+			DebitorID: 1,
+			ID:        "EF1995-000001-221216-122218-4132",
+			Type:      "payment",
+			Method:    "credit",
+			Amount: paymentservice.Amount{
+				Currency:  "EUR",
+				GrossCent: 18500,
+				VatRate:   19.0,
+			},
+			Comment:       "CC paymentId 42",
+			Status:        "valid",
+			EffectiveDate: "2022-12-16",
+			DueDate:       "2022-12-10",
+		},
+		[]paymentservice.Transaction{}, // NO update occurs!
+		[]mailservice.MailSendDto{},
+		[]entity.ProtocolEntry{},
+	)
+}
+
 func TestWebhook_FailureStatus(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -611,6 +650,192 @@ func TestWebhook_WrongSecret(t *testing.T) {
 	tstRequireErrorResponse(t, response, http.StatusUnauthorized, "auth.unauthorized", nil)
 }
 
+func TestWebhook_RateLimited(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous caller who has already used up the burst of webhook requests allowed for their ip")
+	url := "/api/rest/v1/webhook/wrongsecret"
+	request := tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "OK", 18500)
+	for i := 0; i < 5; i++ {
+		response := tstPerformPost(url, request, tstNoToken())
+		require.Equal(t, http.StatusUnauthorized, response.status)
+	}
+
+	docs.When("when they call our webhook endpoint again")
+	response := tstPerformPost(url, request, tstNoToken())
+
+	docs.Then("then the request is rejected with the appropriate error, telling them when to try again")
+	tstRequireErrorResponse(t, response, http.StatusTooManyRequests, "request.rate.limited", nil)
+	require.Equal(t, "2", response.retryAfter)
+
+	docs.Then("and other route groups are not affected")
+	response = tstPerformGet("/api/rest/v1/protocol", tstValidApiToken())
+	require.Equal(t, http.StatusOK, response.status)
+}
+
+func TestWebhook_AllowedSourceIp(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who knows the secret url and calls through our trusted proxy from an allowed ip range")
+	url := "/api/rest/v1/webhook/demosecret"
+
+	docs.When("when they call our webhook endpoint with an unknown transaction")
+	request := tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "FAILED", 18500)
+	response := tstPerformPostForwardedFor(url, request, "192.0.2.15")
+
+	docs.Then("then the request is processed")
+	require.Equal(t, http.StatusOK, response.status)
+}
+
+func TestWebhook_DisallowedSourceIp(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who knows the secret url, but calls from an ip outside the allowed ranges")
+	url := "/api/rest/v1/webhook/demosecret"
+
+	docs.When("when they attempt to trigger our webhook endpoint, even claiming an allowed ip further left in X-Forwarded-For")
+	request := tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "OK", 18500)
+	response := tstPerformPostForwardedFor(url, request, "192.0.2.15, 198.51.100.7")
+
+	docs.Then("then the request fails with the appropriate error")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", nil)
+
+	docs.Then("and the rejected call has been counted and recorded in the protocol")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		Kind:    "error",
+		Message: "webhook rejected, source ip not allowed",
+		Details: "ip=198.51.100.7 secret=valid",
+	})
+	metricsResponse := tstPerformGet("/metrics", tstNoToken())
+	require.Contains(t, metricsResponse.body, `paygate_webhooks_rejected_total{endpoint="webhook",secret="valid"}`)
+
+	docs.Then("and we have been notified that the secret is known to someone else")
+	tstRequireMailServiceRecording(t, []mailservice.MailSendDto{
+		{
+			CommonID: "payment-nexi-adapter-error",
+			Lang:     "en-US",
+			Variables: map[string]string{
+				"operation":   "webhook",
+				"referenceId": "",
+				"status":      "secret-used-from-disallowed-ip",
+			},
+			To:    []string{"errors@example.com"},
+			Async: true,
+		},
+	})
+
+	docs.Then("and no payment has been touched")
+	tstRequirePaymentServiceRecording(t, []paymentservice.Transaction{})
+}
+
+func TestWebhook_DisallowedSourceIpWrongSecret(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who does not know the secret url and calls from an ip outside the allowed ranges")
+	url := "/api/rest/v1/webhook/wrongsecret"
+
+	docs.When("when they attempt to trigger our webhook endpoint")
+	request := tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "OK", 18500)
+	response := tstPerformPostForwardedFor(url, request, "198.51.100.7")
+
+	docs.Then("then the request fails with the appropriate error")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", nil)
+
+	docs.Then("and the rejected call has been counted and recorded in the protocol")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		Kind:    "error",
+		Message: "webhook rejected, source ip not allowed",
+		Details: "ip=198.51.100.7 secret=invalid",
+	})
+	metricsResponse := tstPerformGet("/metrics", tstNoToken())
+	require.Contains(t, metricsResponse.body, `paygate_webhooks_rejected_total{endpoint="webhook",secret="invalid"}`)
+
+	docs.Then("and no notification emails have been sent")
+	tstRequireMailServiceRecording(t, []mailservice.MailSendDto{})
+}
+
+func TestWebhook_DisallowedSourceIpRepeated(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who knows the secret url, and has already been rejected because of their ip")
+	url := "/api/rest/v1/webhook/demosecret"
+	request := tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "OK", 18500)
+	response := tstPerformPostForwardedFor(url, request, "198.51.100.7")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", nil)
+
+	docs.When("when they attempt to trigger our webhook endpoint again from the same ip, and then from another one")
+	response = tstPerformPostForwardedFor(url, request, "198.51.100.7")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", nil)
+	response = tstPerformPostForwardedFor(url, request, "198.51.100.8")
+
+	docs.Then("then the request fails with the appropriate error")
+	tstRequireErrorResponse(t, response, http.StatusForbidden, "auth.forbidden", nil)
+
+	docs.Then("and the repeated call from the same ip has not been recorded again")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		Kind:    "error",
+		Message: "webhook rejected, source ip not allowed",
+		Details: "ip=198.51.100.7 secret=valid",
+	}, entity.ProtocolEntry{
+		Kind:    "error",
+		Message: "webhook rejected, source ip not allowed",
+		Details: "ip=198.51.100.8 secret=valid",
+	})
+
+	docs.Then("and we have been notified once per ip")
+	notification := mailservice.MailSendDto{
+		CommonID: "payment-nexi-adapter-error",
+		Lang:     "en-US",
+		Variables: map[string]string{
+			"operation":   "webhook",
+			"referenceId": "",
+			"status":      "secret-used-from-disallowed-ip",
+		},
+		To:    []string{"errors@example.com"},
+		Async: true,
+	}
+	tstRequireMailServiceRecording(t, []mailservice.MailSendDto{notification, notification})
+}
+
+func TestWebhook_PaylinkStatusVerified(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a payment link that has been created, whose payment is OK at paygate")
+	token := tstValidApiToken()
+	created := tstPerformPost("/api/rest/v1/paylinks", tstRenderJson(tstBuildValidPaymentLinkRequest()), token)
+	require.Equal(t, http.StatusCreated, created.status)
+	url := "/api/rest/v1/webhook/demosecret"
+
+	docs.When("when our webhook endpoint is called with a status that differs from paygate")
+	response := tstPerformPost(url, tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "FAILED", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("then the status from the webhook has not been stored for the payment link")
+	listed := tstPerformGet("/api/rest/v1/paylinks?status=FAILED", token)
+	require.Equal(t, http.StatusOK, listed.status)
+	actual := nexiapi.PaymentLinkListDto{}
+	tstParseJson(listed.body, &actual)
+	require.Empty(t, actual.Paylinks)
+
+	docs.When("when our webhook endpoint is called with a status that is then verified at paygate")
+	response = tstPerformPost(url, tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "AUTHORIZED", 18500), tstNoToken())
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("then the verified status has been stored for the payment link")
+	listed = tstPerformGet("/api/rest/v1/paylinks?status=OK", token)
+	require.Equal(t, http.StatusOK, listed.status)
+	actual = nexiapi.PaymentLinkListDto{}
+	tstParseJson(listed.body, &actual)
+	require.Len(t, actual.Paylinks, 1)
+	require.Equal(t, "42", actual.Paylinks[0].PaymentId)
+}
+
 func TestWebhook_PaySrvDownstreamError(t *testing.T) {
 	tstSetup(tstConfigFile)
 	defer tstShutdown()
@@ -807,3 +1032,76 @@ func TestWeblogger_Success(t *testing.T) {
 	docs.Then("and no notification emails have been sent")
 	tstRequireMailServiceRecording(t, []mailservice.MailSendDto{})
 }
+
+func TestWeblogger_DisallowedSourceIp(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given a caller who knows the secret url, but calls from an ip outside the allowed ranges")
+	url := "/api/rest/v1/weblogger/demosecret"
+
+	docs.When("when they trigger our weblogger endpoint")
+	request := tstBuildValidWebhookRequest(t, "EF1995-000001-221216-122218-4132", "OK", 18500)
+	response := tstPerformPostForwardedFor(url, request, "198.51.100.7")
+
+	docs.Then("then the request looks successful")
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("and the rejected call has been counted and recorded in the protocol")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		Kind:    "error",
+		Message: "weblogger rejected, source ip not allowed",
+		Details: "ip=198.51.100.7 secret=valid",
+	})
+	metricsResponse := tstPerformGet("/metrics", tstNoToken())
+	require.Contains(t, metricsResponse.body, `paygate_webhooks_rejected_total{endpoint="weblogger",secret="valid"}`)
+}
+
+func TestWeblogger_RedactsPersonalData(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous caller who knows the secret url")
+	url := "/api/rest/v1/weblogger/demosecret"
+
+	docs.When("when they trigger our weblogger endpoint with a payload that contains personal data")
+	request := `{"transId":"EF1995-000001-221216-122218-4132","customerInfo":{"firstName":"John","email":"jsquirrel_github_9a6d@packetloss.de"},` +
+		`"paymentMethods":{"type":"CARD","card":{"brand":"VISA","first6Digits":"411111","last4Digits":"1111","bin":{"accountBin":"41111111"}}}}`
+	response := tstPerformPost(url, request, tstNoToken())
+
+	docs.Then("then the request is successful")
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("and the personal data has been redacted in the protocol entry")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: "",
+		ApiId:       "",
+		Kind:        "raw",
+		Message:     "webhook request",
+		Details: `{"transId":"EF1995-000001-221216-122218-4132","customerInfo":{"firstName":"***","email":"***"},` +
+			`"paymentMethods":{"type":"CARD","card":{"brand":"VISA","first6Digits":"***","last4Digits":"***","bin":"***"}}}`,
+	})
+}
+
+func TestWeblogger_RedactsNonJsonPayload(t *testing.T) {
+	tstSetup(tstConfigFile)
+	defer tstShutdown()
+
+	docs.Given("given an anonymous caller who knows the secret url")
+	url := "/api/rest/v1/weblogger/demosecret"
+
+	docs.When("when they trigger our weblogger endpoint with a payload that is not json")
+	response := tstPerformPost(url, "email=jsquirrel_github_9a6d@packetloss.de", tstNoToken())
+
+	docs.Then("then the request is successful")
+	require.Equal(t, http.StatusOK, response.status)
+
+	docs.Then("and the payload has been replaced in the protocol entry")
+	tstRequireProtocolEntries(t, entity.ProtocolEntry{
+		ReferenceId: "",
+		ApiId:       "",
+		Kind:        "raw",
+		Message:     "webhook request",
+		Details:     "(redacted non-json payload of 41 bytes)",
+	})
+}